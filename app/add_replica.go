@@ -26,6 +26,7 @@ import (
 
 	"github.com/openebs/jiva/replica"
 	"github.com/openebs/jiva/sync"
+	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -54,7 +55,7 @@ func addReplica(c *cli.Context) error {
 }
 func AutoAddReplica(s *replica.Server, frontendIP string, replica string, replicaType string) error {
 	var err error
-	url := "http://" + frontendIP + ":9501"
+	url := util.ControllerURL(frontendIP)
 	task := sync.NewTask(url)
 	if replicaType == "quorum" {
 		err = task.AddQuorumReplica(replica, s)
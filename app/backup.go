@@ -49,7 +49,7 @@ func BackupCreateCmd() cli.Command {
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "dest",
-				Usage: "destination of backup if driver supports, would be url like s3://bucket@region/path/ or vfs:///path/",
+				Usage: "destination of backup if driver supports, would be url like vfs:///path/",
 			},
 		},
 		Action: func(c *cli.Context) {
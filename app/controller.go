@@ -26,7 +26,9 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/gorilla/handlers"
 	"github.com/openebs/jiva/backend/dynamic"
 	"github.com/openebs/jiva/backend/file"
@@ -36,18 +38,28 @@ import (
 	"github.com/openebs/jiva/rpc"
 	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
 var (
 	frontends = map[string]types.Frontend{}
+	// exportFrontends are the frontends the snapshots can be exported
+	// with, read only.
+	exportFrontends = map[string]controller.FrontendFactory{}
 )
 
+// addressSetter is implemented by the frontends whose listen address can
+// be set by the frontend-address flag.
+type addressSetter interface {
+	SetAddress(address string)
+}
+
 func ControllerCmd() cli.Command {
 	return cli.Command{
 		Name: "controller",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:  "listen",
 				Value: ":9501",
@@ -60,10 +72,83 @@ func ControllerCmd() cli.Command {
 				Name:  "frontendIP",
 				Value: "",
 			},
+			cli.StringFlag{
+				Name:  "frontend-address",
+				Value: "",
+				Usage: "Address the rest and nbd frontends listen on, host:port, or unix:///path for nbd",
+			},
 			cli.StringFlag{
 				Name:  "clusterIP",
 				Value: "",
 			},
+			cli.StringFlag{
+				Name:  "ack-policy",
+				Value: string(controller.AckAll),
+				Usage: "Replicas a write waits for: all, majority, or majority-async to rebuild the lagging replicas instead of waiting for them",
+			},
+			cli.StringFlag{
+				Name:  "read-policy",
+				Value: string(controller.ReadRoundRobin),
+				Usage: "Replica serving a read: round-robin, least-outstanding, latency or preferred",
+			},
+			cli.StringFlag{
+				Name:  "preferred-replica",
+				Value: "",
+				Usage: "Address or host of the replica read by the preferred read policy",
+			},
+			cli.DurationFlag{
+				Name:  "hedge-after",
+				Value: 0,
+				Usage: "Reissue the reads not completed after this duration to another replica, 0 to disable",
+			},
+			cli.Float64Flag{
+				Name:  "slow-replica-factor",
+				Value: 0,
+				Usage: "Stop reading from a replica whose latency is this many times the one of its peers, 0 to disable",
+			},
+			cli.DurationFlag{
+				Name:  "slow-replica-min-latency",
+				Value: 50 * time.Millisecond,
+				Usage: "Latency below which a replica is never considered slow",
+			},
+			cli.DurationFlag{
+				Name:  "slow-replica-interval",
+				Value: 10 * time.Second,
+				Usage: "Interval of the comparisons of the latencies of the replicas",
+			},
+			cli.IntFlag{
+				Name:  "slow-replica-checks",
+				Value: 3,
+				Usage: "Consecutive comparisons a replica must be slow to stop reading from it, and not be to read from it again",
+			},
+			cli.StringFlag{
+				Name:  "read-cache-size",
+				Value: "0",
+				Usage: "Size of the cache of the blocks read from the replicas, e.g. 64M, 0 to disable",
+			},
+			cli.Int64Flag{
+				Name:  "read-iops",
+				Usage: "Limit of the read IOPS of the volume, 0 for no limit",
+			},
+			cli.Int64Flag{
+				Name:  "write-iops",
+				Usage: "Limit of the write IOPS of the volume, 0 for no limit",
+			},
+			cli.StringFlag{
+				Name:  "read-bps",
+				Value: "0",
+				Usage: "Limit of the read bandwidth of the volume per second, e.g. 100M, 0 for no limit",
+			},
+			cli.StringFlag{
+				Name:  "write-bps",
+				Value: "0",
+				Usage: "Limit of the write bandwidth of the volume per second, e.g. 100M, 0 for no limit",
+			},
+			cli.DurationFlag{
+				Name:  "qos-burst",
+				Value: time.Second,
+				Usage: "Duration of I/Os at the limits allowed at once after being idle",
+			},
 			cli.StringSliceFlag{
 				Name:  "enable-backend",
 				Value: (*cli.StringSlice)(&[]string{"tcp"}),
@@ -71,7 +156,7 @@ func ControllerCmd() cli.Command {
 			cli.StringSliceFlag{
 				Name: "replica",
 			},
-		},
+		}, TLSFlags()...),
 		Action: func(c *cli.Context) {
 			if err := startController(c); err != nil {
 				logrus.Fatalf("Error running controller command: %v.", err)
@@ -115,17 +200,48 @@ func initializeFrontend(c *cli.Context) (types.Frontend, types.Target, error) {
 		frontend = f
 	}
 
+	if address := c.String("frontend-address"); address != "" && frontend != nil {
+		f, ok := frontend.(addressSetter)
+		if !ok {
+			return nil, types.Target{}, fmt.Errorf("Frontend %s doesn't support setting its address", frontendName)
+		}
+		f.SetAddress(address)
+	}
+
 	if frontend == nil {
 		return nil, target, fmt.Errorf("frontend is nil")
 	}
 	return frontend, target, nil
 }
 
+// qosLimits returns the QoS limits set by the flags of the controller
+func qosLimits(c *cli.Context) (controller.QoSLimits, error) {
+	limits := controller.QoSLimits{
+		ReadIOPS:  c.Int64("read-iops"),
+		WriteIOPS: c.Int64("write-iops"),
+		Burst:     c.Duration("qos-burst"),
+	}
+	var err error
+	if limits.ReadBPS, err = units.RAMInBytes(c.String("read-bps")); err != nil {
+		return limits, fmt.Errorf("Invalid read bandwidth %q, error: %v", c.String("read-bps"), err)
+	}
+	if limits.WriteBPS, err = units.RAMInBytes(c.String("write-bps")); err != nil {
+		return limits, fmt.Errorf("Invalid write bandwidth %q, error: %v", c.String("write-bps"), err)
+	}
+	if limits.ReadIOPS < 0 || limits.WriteIOPS < 0 || limits.ReadBPS < 0 || limits.WriteBPS < 0 || limits.Burst < 0 {
+		return limits, errors.New("QoS limits can't be negative")
+	}
+	return limits, nil
+}
+
 func startController(c *cli.Context) error {
 	if c.NArg() == 0 {
 		return errors.New("volume name is required")
 	}
 	name := c.Args()[0]
+	if err := SetupTLS(c); err != nil {
+		return err
+	}
 	rf := util.CheckReplicationFactor()
 	types.RPCReadTimeout = util.GetReadTimeout()
 	types.RPCWriteTimeout = util.GetWriteTimeout()
@@ -137,11 +253,41 @@ func startController(c *cli.Context) error {
 	}
 	controlListener := c.String("listen")
 	replicas := c.StringSlice("replica")
+	ackPolicy, err := controller.ParseAckPolicy(c.String("ack-policy"))
+	if err != nil {
+		return err
+	}
+	readPolicy, err := controller.ParseReadPolicy(c.String("read-policy"))
+	if err != nil {
+		return err
+	}
+	if readPolicy == controller.ReadPreferred && c.String("preferred-replica") == "" {
+		return errors.New("preferred-replica is required by the preferred read policy")
+	}
+	cacheSize, err := units.RAMInBytes(c.String("read-cache-size"))
+	if err != nil {
+		return fmt.Errorf("Invalid read cache size %q, error: %v", c.String("read-cache-size"), err)
+	}
+	qos, err := qosLimits(c)
+	if err != nil {
+		return err
+	}
+	slowReplicas := controller.SlowReplicaPolicy{
+		Factor:     c.Float64("slow-replica-factor"),
+		MinLatency: c.Duration("slow-replica-min-latency"),
+		Interval:   c.Duration("slow-replica-interval"),
+		Checks:     c.Int("slow-replica-checks"),
+	}
+	if slowReplicas.Factor < 0 || (slowReplicas.Factor > 0 && (slowReplicas.Interval <= 0 || slowReplicas.Checks < 1)) {
+		return fmt.Errorf("Invalid slow replica detection, factor: %v, interval: %v, checks: %v",
+			slowReplicas.Factor, slowReplicas.Interval, slowReplicas.Checks)
+	}
+
 	frontend, tgt, err := initializeFrontend(c)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("Starting controller with frontendIP: %v, and clusterIP: %v", tgt.FrontendIP, tgt.ClusterIP)
+	logrus.Infof("Starting controller with frontendIP: %v, clusterIP: %v and ack policy: %v", tgt.FrontendIP, tgt.ClusterIP, ackPolicy)
 
 	control := controller.
 		NewController(
@@ -150,7 +296,15 @@ func startController(c *cli.Context) error {
 			controller.WithBackend(dynamic.New(
 				initializeBackend(c))),
 			controller.WithFrontend(frontend, tgt.FrontendIP),
-			controller.WithRF(int(rf)))
+			controller.WithRF(int(rf)),
+			controller.WithAckPolicy(ackPolicy),
+			controller.WithReadPolicy(readPolicy, c.String("preferred-replica")),
+			controller.WithHedgedReads(c.Duration("hedge-after")),
+			controller.WithSlowReplicaPolicy(slowReplicas),
+			controller.WithReadCache(cacheSize),
+			controller.WithQoS(qos),
+			controller.WithExportFrontends(exportFrontends))
+	prometheus.MustRegister(controller.NewCollector(control))
 	server := rest.NewServer(control)
 	router := http.Handler(rest.NewRouter(server))
 
@@ -158,6 +312,7 @@ func startController(c *cli.Context) error {
 		"/v1/volumes":  {},
 		"/v1/replicas": {},
 		"/v1/stats":    {},
+		"/v1/events":   {},
 	}, os.Stdout, router)
 	router = handlers.ProxyHeaders(router)
 
@@ -173,7 +328,7 @@ func startController(c *cli.Context) error {
 	addShutdown(func() {
 		control.Shutdown()
 	})
-	return http.ListenAndServe(controlListener, router)
+	return util.ListenAndServeHTTP(controlListener, router)
 }
 
 func checkPrerequisites(c *controller.Controller, replicas []types.Replica) error {
@@ -25,4 +25,5 @@ import (
 
 func init() {
 	frontends["rest"] = rest.New()
+	exportFrontends["rest"] = rest.New
 }
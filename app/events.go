@@ -0,0 +1,85 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// EventsCmd prints the recent changes of the state of the volume, and the
+// new ones as they happen if following.
+func EventsCmd() cli.Command {
+	return cli.Command{
+		Name:  "events",
+		Usage: "Show the recent events of the volume, replica mode changes, RO/RW changes, checkpoints and rebuilds",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "follow, f",
+				Usage: "Keep printing the new events",
+			},
+			cli.Int64Flag{
+				Name:  "after",
+				Usage: "Print only the events whose ID is greater than this one",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the events as JSON, one per line",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := events(c); err != nil {
+				logrus.Fatalf("Error running events command: %v", err)
+			}
+		},
+	}
+}
+
+func events(c *cli.Context) error {
+	asJSON := c.Bool("json")
+	return getCli(c).Events(c.Int64("after"), c.Bool("follow"), func(e types.Event) error {
+		if asJSON {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		fmt.Println(formatEvent(e))
+		return nil
+	})
+}
+
+func formatEvent(e types.Event) string {
+	line := fmt.Sprintf("%d %s %s", e.ID, e.Time.Local().Format(time.RFC3339), e.Type)
+	if e.Replica != "" {
+		line += " replica=" + e.Replica
+	}
+	if e.Mode != "" {
+		line += " mode=" + e.Mode
+	}
+	if e.Type == types.EventCheckpoint {
+		line += fmt.Sprintf(" checkpoint=%q", e.Checkpoint)
+	}
+	return line
+}
@@ -70,7 +70,11 @@ func lsReplica(c *cli.Context) error {
 		if err == nil {
 			chain = chainList
 		}
-		fmt.Fprintf(tw, format, r.Address, r.Mode, chain)
+		mode := r.Mode
+		if r.Degraded {
+			mode += " (degraded)"
+		}
+		fmt.Fprintf(tw, format, r.Address, mode, chain)
 	}
 	tw.Flush()
 
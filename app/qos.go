@@ -0,0 +1,108 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+
+	"github.com/docker/go-units"
+	"github.com/openebs/jiva/controller/rest"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// QoSCmd shows or changes the limits of the I/Os of the volume, the
+// limits which aren't given are kept.
+func QoSCmd() cli.Command {
+	return cli.Command{
+		Name:  "qos",
+		Usage: "Show the QoS limits of the volume, or change the given ones",
+		Flags: []cli.Flag{
+			cli.Int64Flag{
+				Name:  "read-iops",
+				Usage: "Limit of the read IOPS, 0 for no limit",
+			},
+			cli.Int64Flag{
+				Name:  "write-iops",
+				Usage: "Limit of the write IOPS, 0 for no limit",
+			},
+			cli.StringFlag{
+				Name:  "read-bps",
+				Usage: "Limit of the read bandwidth per second, e.g. 100M, 0 for no limit",
+			},
+			cli.StringFlag{
+				Name:  "write-bps",
+				Usage: "Limit of the write bandwidth per second, e.g. 100M, 0 for no limit",
+			},
+			cli.StringFlag{
+				Name:  "burst",
+				Usage: "Duration of I/Os at the limits allowed at once after being idle, e.g. 2s",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := qos(c); err != nil {
+				logrus.Fatalf("Error running qos command: %v", err)
+			}
+		},
+	}
+}
+
+func qos(c *cli.Context) error {
+	controllerClient := getCli(c)
+
+	volume, err := controllerClient.GetVolume()
+	if err != nil {
+		return err
+	}
+	limits := volume.QoS
+	if c.NumFlags() == 0 {
+		printQoS(limits)
+		return nil
+	}
+
+	if c.IsSet("read-iops") {
+		limits.ReadIOPS = c.Int64("read-iops")
+	}
+	if c.IsSet("write-iops") {
+		limits.WriteIOPS = c.Int64("write-iops")
+	}
+	if c.IsSet("read-bps") {
+		if limits.ReadBPS, err = units.RAMInBytes(c.String("read-bps")); err != nil {
+			return fmt.Errorf("Invalid read bandwidth %q, error: %v", c.String("read-bps"), err)
+		}
+	}
+	if c.IsSet("write-bps") {
+		if limits.WriteBPS, err = units.RAMInBytes(c.String("write-bps")); err != nil {
+			return fmt.Errorf("Invalid write bandwidth %q, error: %v", c.String("write-bps"), err)
+		}
+	}
+	if c.IsSet("burst") {
+		limits.Burst = c.String("burst")
+	}
+
+	volume, err = controllerClient.SetQoS(limits)
+	if err != nil {
+		return err
+	}
+	printQoS(volume.QoS)
+	return nil
+}
+
+func printQoS(limits rest.QoS) {
+	fmt.Printf("Read IOPS: %d, write IOPS: %d, read bandwidth: %d B/s, write bandwidth: %d B/s, burst: %s\n",
+		limits.ReadIOPS, limits.WriteIOPS, limits.ReadBPS, limits.WriteBPS, limits.Burst)
+}
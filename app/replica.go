@@ -42,6 +42,7 @@ import (
 	"github.com/openebs/jiva/replica/rest"
 	"github.com/openebs/jiva/replica/rpc"
 	"github.com/openebs/jiva/util"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -59,7 +60,12 @@ func ReplicaCmd() cli.Command {
 	return cli.Command{
 		Name:      "replica",
 		UsageText: "longhorn controller DIRECTORY SIZE",
-		Flags: []cli.Flag{
+		Subcommands: []cli.Command{
+			ReplicaFormatCmd(),
+			ReplicaUpgradeCmd(),
+			ReplicaFsckCmd(),
+		},
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:  "listen",
 				Value: ":9502",
@@ -110,7 +116,7 @@ func ReplicaCmd() cli.Command {
 				Usage: "Max number of log files to keep while creating new log file once size of log exceeds to maxLogFileSize",
 				Value: defaultMaxBackups,
 			},
-		},
+		}, TLSFlags()...),
 		Action: func(c *cli.Context) {
 			if err := startReplica(c); err != nil {
 				logrus.Fatalf("Error running start replica command: %v", err)
@@ -120,7 +126,7 @@ func ReplicaCmd() cli.Command {
 }
 
 func CheckReplicaState(frontendIP string, replicaIP string) (string, error) {
-	url := "http://" + frontendIP + ":9501"
+	url := util.ControllerURL(frontendIP)
 	ControllerClient := client.NewControllerClient(url)
 	reps, err := ControllerClient.ListReplicas()
 	if err != nil {
@@ -176,7 +182,7 @@ checkagain:
 
 func CloneReplica(s *replica.Server, address string, cloneIP string, snapName string) error {
 	var err error
-	url := "http://" + cloneIP + ":9501"
+	url := util.ControllerURL(cloneIP)
 	task := sync.NewTask(url)
 	if err = task.CloneReplica(s, url, address, cloneIP, snapName); err != nil {
 		alertlog.Logger.Errorw("",
@@ -248,6 +254,10 @@ func startReplica(c *cli.Context) error {
 		return errors.New("directory name is required")
 	}
 
+	if err := SetupTLS(c); err != nil {
+		return err
+	}
+
 	types.MaxChainLength, _ = strconv.Atoi(os.Getenv("MAX_CHAIN_LENGTH"))
 	if types.MaxChainLength == 0 {
 		logrus.Infof("MAX_CHAIN_LENGTH env not set, default value is 512")
@@ -270,6 +280,13 @@ func startReplica(c *cli.Context) error {
 	address := c.String("listen")
 	s := replica.NewServer(address, dir, 512, replicaType)
 	go replica.CreateHoles()
+	addShutdown(func() {
+		// closing the replica saves its LUN map, so that the next start
+		// doesn't have to preload it
+		if err := s.Close(); err != nil {
+			logrus.Errorf("Failed to close replica on shutdown, error: %v", err)
+		}
+	})
 
 	frontendIP := c.String("frontendIP")
 	cloneIP := c.String("cloneIP")
@@ -317,6 +334,7 @@ func startReplica(c *cli.Context) error {
 	syncResp := make(chan error)
 	rpcResp := make(chan error)
 
+	prometheus.MustRegister(replica.NewCollector(s))
 	go func() {
 		server := rest.NewServer(s)
 		router := http.Handler(rest.NewRouter(server))
@@ -326,7 +344,7 @@ func startReplica(c *cli.Context) error {
 			"/v1/replicas/1/volusage": {},
 		}, os.Stdout, router)
 		logrus.Infof("Listening on control %s", controlAddress)
-		controlResp <- http.ListenAndServe(controlAddress, router)
+		controlResp <- util.ListenAndServeHTTP(controlAddress, router)
 	}()
 
 	go func() {
@@ -347,7 +365,8 @@ func startReplica(c *cli.Context) error {
 		}
 
 		go func() {
-			cmd := exec.Command(exe, "sync-agent", "--listen", syncAddress)
+			args := append([]string{"sync-agent", "--listen", syncAddress}, tlsArgs(c)...)
+			cmd := exec.Command(exe, args...)
 			cmd.SysProcAttr = &syscall.SysProcAttr{
 				Pdeathsig: syscall.SIGKILL,
 			}
@@ -0,0 +1,105 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/openebs/jiva/replica"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// ReplicaFormatCmd shows the format of the metadata of a replica directory
+func ReplicaFormatCmd() cli.Command {
+	return cli.Command{
+		Name:      "format",
+		Usage:     "Show the metadata format version of a replica directory",
+		ArgsUsage: "DIRECTORY",
+		Action: func(c *cli.Context) {
+			if err := showReplicaFormat(c); err != nil {
+				logrus.Fatalf("Error running replica format command: %v", err)
+			}
+		},
+	}
+}
+
+// ReplicaUpgradeCmd upgrades the metadata of a replica directory
+func ReplicaUpgradeCmd() cli.Command {
+	return cli.Command{
+		Name:      "upgrade",
+		Usage:     "Upgrade the metadata of a replica directory to the format of this binary, the replica must be stopped",
+		ArgsUsage: "DIRECTORY",
+		Action: func(c *cli.Context) {
+			if err := upgradeReplicaFormat(c); err != nil {
+				logrus.Fatalf("Error running replica upgrade command: %v", err)
+			}
+		},
+	}
+}
+
+func replicaDirArg(c *cli.Context) (string, error) {
+	if c.NArg() != 1 {
+		return "", errors.New("directory is required")
+	}
+	return c.Args()[0], nil
+}
+
+func showReplicaFormat(c *cli.Context) error {
+	dir, err := replicaDirArg(c)
+	if err != nil {
+		return err
+	}
+	format, err := replica.GetMetadataFormat(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Format version: %d\n", format.Version)
+	fmt.Printf("Supported version: %d\n", format.Supported)
+	switch {
+	case format.Version > format.Supported:
+		fmt.Println("Status: newer than supported")
+	case len(format.Pending) == 0:
+		fmt.Println("Status: up to date")
+	default:
+		fmt.Println("Status: upgrade required")
+		fmt.Println("Pending upgrades:")
+		for _, m := range format.Pending {
+			fmt.Printf("  %s\n", m)
+		}
+	}
+	return nil
+}
+
+func upgradeReplicaFormat(c *cli.Context) error {
+	dir, err := replicaDirArg(c)
+	if err != nil {
+		return err
+	}
+	from, err := replica.UpgradeMetadata(dir)
+	if err != nil {
+		return err
+	}
+	if from == replica.MetadataFormatVersion {
+		fmt.Printf("Metadata of %s is already at version %d\n", dir, from)
+		return nil
+	}
+	fmt.Printf("Upgraded metadata of %s from version %d to %d\n", dir, from, replica.MetadataFormatVersion)
+	return nil
+}
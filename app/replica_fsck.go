@@ -0,0 +1,92 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/openebs/jiva/replica"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// ReplicaFsckCmd checks the files of a replica directory offline
+func ReplicaFsckCmd() cli.Command {
+	return cli.Command{
+		Name:      "fsck",
+		Usage:     "Check the metadata and the chain of a stopped replica, without modifying it unless --repair is set",
+		ArgsUsage: "DIRECTORY",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair",
+				Usage: "Repair the issues which can be repaired safely",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := fsckReplica(c); err != nil {
+				logrus.Fatalf("Error running replica fsck command: %v", err)
+			}
+		},
+	}
+}
+
+func fsckReplica(c *cli.Context) error {
+	dir, err := replicaDirArg(c)
+	if err != nil {
+		return err
+	}
+	repair := c.Bool("repair")
+	report, err := replica.Fsck(dir, repair)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Head: %s\n", report.Head)
+	fmt.Printf("Chain: %d disks\n", len(report.Chain))
+	if len(report.Issues) == 0 {
+		fmt.Println("No issues found")
+		return nil
+	}
+
+	repairable := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 20, 1, ' ', 0)
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", "CHECK", "STATUS", "ISSUE", "REPAIR")
+	for _, issue := range report.Issues {
+		status := "error"
+		switch {
+		case issue.Repaired:
+			status = "repaired"
+		case issue.Warning:
+			status = "warning"
+		}
+		if issue.Repair != "" && !issue.Repaired {
+			repairable++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", issue.Check, status, issue.Message, issue.Repair)
+	}
+	tw.Flush()
+
+	if !repair && repairable > 0 {
+		fmt.Printf("%d issues can be repaired with --repair\n", repairable)
+	}
+	if n := report.Errors(); n > 0 {
+		return fmt.Errorf("%d errors left in %s", n, dir)
+	}
+	return nil
+}
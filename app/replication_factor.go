@@ -0,0 +1,65 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// ReplicationFactorCmd shows or changes the replication factor of the
+// volume.
+func ReplicationFactorCmd() cli.Command {
+	return cli.Command{
+		Name:      "replication-factor",
+		ShortName: "rf",
+		Usage:     "Show the replication factor of the volume, or change it to the given one",
+		ArgsUsage: "[REPLICATION_FACTOR]",
+		Action: func(c *cli.Context) {
+			if err := replicationFactor(c); err != nil {
+				logrus.Fatalf("Error running replication-factor command: %v", err)
+			}
+		},
+	}
+}
+
+func replicationFactor(c *cli.Context) error {
+	controllerClient := getCli(c)
+
+	if c.NArg() == 0 {
+		volume, err := controllerClient.GetVolume()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Replication factor: %d, replicas: %d\n", volume.ReplicationFactor, volume.ReplicaCount)
+		return nil
+	}
+
+	rf, err := strconv.Atoi(c.Args()[0])
+	if err != nil {
+		return fmt.Errorf("Invalid replication factor %q", c.Args()[0])
+	}
+	volume, err := controllerClient.SetReplicationFactor(rf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Replication factor: %d, replicas: %d\n", volume.ReplicationFactor, volume.ReplicaCount)
+	return nil
+}
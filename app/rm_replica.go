@@ -24,6 +24,7 @@ import (
 	"github.com/openebs/jiva/alertlog"
 
 	"github.com/openebs/jiva/controller/client"
+	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -65,7 +66,7 @@ func rmReplica(c *cli.Context) error {
 }
 
 func AutoRmReplica(frontendIP string, replica string) error {
-	url := "http://" + frontendIP + ":9501"
+	url := util.ControllerURL(frontendIP)
 	controllerClient := client.NewControllerClient(url)
 	_, err := controllerClient.DeleteReplica(replica)
 	if err != nil {
@@ -39,7 +39,7 @@ import (
 
 const VolumeHeadName = "volume-head"
 
-var validSubCommands = map[string]bool{"ls": true, "rm": true, "info": true}
+var validSubCommands = map[string]bool{"ls": true, "rm": true, "info": true, "attach": true, "detach": true}
 
 func isValidSubCommand(c *cli.Context) bool {
 	args := c.Args()
@@ -71,6 +71,8 @@ func SnapshotCmd() cli.Command {
 			SnapshotLsCmd(),
 			SnapshotRmCmd(),
 			SnapshotInfoCmd(),
+			SnapshotAttachCmd(),
+			SnapshotDetachCmd(),
 		},
 		Action: func(c *cli.Context) {
 			if !isValidSubCommand(c) {
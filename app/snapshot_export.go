@@ -0,0 +1,108 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/openebs/jiva/controller/rest"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// SnapshotAttachCmd serves a snapshot read only with a frontend, while the
+// volume keeps serving the writes.
+func SnapshotAttachCmd() cli.Command {
+	return cli.Command{
+		Name:      "attach",
+		Usage:     "Export a snapshot read only: attach [--frontend rest|nbd] [--address host:port] <snapshot>",
+		ArgsUsage: "<snapshot>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "frontend",
+				Value: "nbd",
+				Usage: "Frontend serving the snapshot, rest or nbd",
+			},
+			cli.StringFlag{
+				Name:  "address",
+				Usage: "Address the frontend listens on, host:port, or unix:///path for nbd",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := attachSnapshot(c); err != nil {
+				logrus.Fatalf("Error running snapshot attach command: %v", err)
+			}
+		},
+	}
+}
+
+// SnapshotDetachCmd stops serving a snapshot
+func SnapshotDetachCmd() cli.Command {
+	return cli.Command{
+		Name:      "detach",
+		Usage:     "Stop exporting a snapshot: detach <snapshot>",
+		ArgsUsage: "<snapshot>",
+		Action: func(c *cli.Context) {
+			if err := detachSnapshot(c); err != nil {
+				logrus.Fatalf("Error running snapshot detach command: %v", err)
+			}
+		},
+	}
+}
+
+func attachSnapshot(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return fmt.Errorf("snapshot name is required")
+	}
+	volume, err := getCli(c).AttachSnapshot(rest.SnapshotExport{
+		Snapshot: c.Args()[0],
+		Frontend: c.String("frontend"),
+		Address:  c.String("address"),
+	})
+	if err != nil {
+		return err
+	}
+	printExports(volume.Exports)
+	return nil
+}
+
+func detachSnapshot(c *cli.Context) error {
+	if len(c.Args()) != 1 {
+		return fmt.Errorf("snapshot name is required")
+	}
+	volume, err := getCli(c).DetachSnapshot(c.Args()[0])
+	if err != nil {
+		return err
+	}
+	printExports(volume.Exports)
+	return nil
+}
+
+func printExports(exports []rest.SnapshotExport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 20, 1, ' ', 0)
+	fmt.Fprintf(tw, "SNAPSHOT\tFRONTEND\tADDRESS\n")
+	for _, export := range exports {
+		address := export.Address
+		if address == "" {
+			address = "default"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", export.Snapshot, export.Frontend, address)
+	}
+	tw.Flush()
+}
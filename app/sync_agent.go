@@ -21,11 +21,11 @@ package app
 
 import (
 	"fmt"
-	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/openebs/jiva/sync/agent"
+	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -34,7 +34,7 @@ func SyncAgentCmd() cli.Command {
 	return cli.Command{
 		Name:      "sync-agent",
 		UsageText: "longhorn controller DIRECTORY SIZE",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:  "listen",
 				Value: "localhost:9504",
@@ -43,7 +43,7 @@ func SyncAgentCmd() cli.Command {
 				Name:  "listen-port-range",
 				Value: "9700-9800",
 			},
-		},
+		}, TLSFlags()...),
 		Action: func(c *cli.Context) {
 			if err := startSyncAgent(c); err != nil {
 				logrus.Fatalf("Error running sync-agent command: %v", err)
@@ -53,6 +53,10 @@ func SyncAgentCmd() cli.Command {
 }
 
 func startSyncAgent(c *cli.Context) error {
+	if err := SetupTLS(c); err != nil {
+		return err
+	}
+
 	listen := c.String("listen")
 	portRange := c.String("listen-port-range")
 
@@ -75,5 +79,5 @@ func startSyncAgent(c *cli.Context) error {
 	router := agent.NewRouter(server)
 	logrus.Infof("Listening on sync %s start: %d end: %d", listen, start, end)
 
-	return http.ListenAndServe(listen, router)
+	return util.ListenAndServeHTTP(listen, router)
 }
@@ -73,11 +73,9 @@ func getSyncInfo(c *cli.Context) error {
 		return nil
 	}
 
-	fmt.Fprintf(tw, "%v\t%v,\t%v\t%v\n", "DegradedReplica: ", strings.
-		TrimSuffix(strings.TrimPrefix(info.WOReplica, "http://"), ":9502/v1"),
+	fmt.Fprintf(tw, "%v\t%v,\t%v\t%v\n", "DegradedReplica: ", replicaHost(info.WOReplica),
 		"WOSnapshotsTotalSizeTobeSynced: ", info.WOSnapshotsTotalSize)
-	fmt.Fprintf(tw, "%v\t%v,\t%v\t%v\n", "HealthyReplica: ", strings.
-		TrimSuffix(strings.TrimPrefix(info.RWReplica, "http://"), ":9502/v1"),
+	fmt.Fprintf(tw, "%v\t%v,\t%v\t%v\n", "HealthyReplica: ", replicaHost(info.RWReplica),
 		"RWSnapshotsTotalSizeToBeSynced: ", info.RWSnapshotsTotalSize)
 	fmt.Fprintf(tw, "%s\n", "============================================================================")
 	fmt.Fprintf(tw, format, "Snapshot", "Status", "DegradedSize", "HealthySize")
@@ -89,6 +87,12 @@ func getSyncInfo(c *cli.Context) error {
 	return nil
 }
 
+// replicaHost strips the scheme and the API path from the replica URL
+func replicaHost(url string) string {
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "http://"), "https://")
+	return strings.TrimSuffix(url, ":9502/v1")
+}
+
 func getRebuildInfo(address string) (*types.SyncInfo, error) {
 	repClient, err := replicaClient.NewReplicaClient(address)
 	if err != nil {
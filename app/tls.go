@@ -0,0 +1,87 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"github.com/openebs/jiva/util"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+const (
+	tlsCAFlag   = "tls-ca"
+	tlsCertFlag = "tls-cert"
+	tlsKeyFlag  = "tls-key"
+)
+
+// TLSFlags are the flags enabling mutual TLS between the controller and
+// the replicas, they must be set on both sides. They are also global flags
+// for the commands connecting to the replicas directly.
+func TLSFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  tlsCAFlag,
+			Usage: "CA certificate used to authenticate the controller and the replicas",
+		},
+		cli.StringFlag{
+			Name:  tlsCertFlag,
+			Usage: "Certificate presented to the controller and the replicas",
+		},
+		cli.StringFlag{
+			Name:  tlsKeyFlag,
+			Usage: "Private key of the certificate",
+		},
+	}
+}
+
+// tlsFlag returns the value of the flag given to the command or else
+// to the app.
+func tlsFlag(c *cli.Context, name string) string {
+	if value := c.String(name); value != "" {
+		return value
+	}
+	return c.GlobalString(name)
+}
+
+// SetupTLS enables mutual TLS if the TLS flags are set
+func SetupTLS(c *cli.Context) error {
+	if util.TLSEnabled() {
+		return nil
+	}
+	if err := util.SetupTLS(util.TLSFiles{
+		CAFile:   tlsFlag(c, tlsCAFlag),
+		CertFile: tlsFlag(c, tlsCertFlag),
+		KeyFile:  tlsFlag(c, tlsKeyFlag),
+	}); err != nil {
+		return err
+	}
+	if util.TLSEnabled() {
+		logrus.Infof("Mutual TLS enabled, CA: %v, certificate: %v", tlsFlag(c, tlsCAFlag), tlsFlag(c, tlsCertFlag))
+	}
+	return nil
+}
+
+// tlsArgs returns the TLS flags to pass to a subcommand
+func tlsArgs(c *cli.Context) []string {
+	var args []string
+	for _, name := range []string{tlsCAFlag, tlsCertFlag, tlsKeyFlag} {
+		if value := tlsFlag(c, name); value != "" {
+			args = append(args, "--"+name, value)
+		}
+	}
+	return args
+}
@@ -0,0 +1,83 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// VerifyCmd compares the data of the RW replicas of the volume
+func VerifyCmd() cli.Command {
+	return cli.Command{
+		Name:  "verify",
+		Usage: "Compare the data of the RW replicas snapshot by snapshot and report the diverged ranges",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair",
+				Usage: "Rewrite the diverged blocks with the data of the majority of the replicas",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := verify(c); err != nil {
+				logrus.Fatalf("Error running verify command: %v", err)
+			}
+		},
+	}
+}
+
+func verify(c *cli.Context) error {
+	controllerClient := getCli(c)
+
+	output, err := controllerClient.Verify(c.Bool("repair"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replicas: %s\n", strings.Join(output.Replicas, ", "))
+	fmt.Printf("Snapshots: %d\n", len(output.Snapshots))
+	fmt.Printf("Verified: %d bytes\n", output.VerifiedBytes)
+	if len(output.Diverged) == 0 {
+		fmt.Println("No diverged ranges")
+		return nil
+	}
+
+	format := "%s\t%d\t%d\t%s\t%s\n"
+	tw := tabwriter.NewWriter(os.Stdout, 0, 20, 1, ' ', 0)
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", "SNAPSHOT", "OFFSET", "LENGTH", "REPLICAS", "STATUS")
+	for _, d := range output.Diverged {
+		snapshot := "head"
+		if d.Snapshot != "" {
+			snapshot = strings.TrimSuffix(strings.TrimPrefix(d.Snapshot, "volume-snap-"), ".img")
+		}
+		status := "diverged"
+		switch {
+		case d.Repaired:
+			status = "repaired"
+		case d.NoMajority:
+			status = "no majority"
+		}
+		fmt.Fprintf(tw, format, snapshot, d.Offset, d.Length, strings.Join(d.Replicas, ","), status)
+	}
+	tw.Flush()
+	return nil
+}
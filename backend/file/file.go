@@ -89,6 +89,23 @@ func (f *Wrapper) GetReplicaChain() ([]string, error) {
 	return nil, nil
 }
 
+// SetReplicationFactor does nothing, a file backend has no replication
+// factor to persist.
+func (f *Wrapper) SetReplicationFactor(rf int) error {
+	return nil
+}
+
+// GetEpoch returns 0, a file backend is never claimed by a controller.
+func (f *Wrapper) GetEpoch() (int64, error) {
+	return 0, nil
+}
+
+// Claim does nothing, a file backend is owned by the controller which
+// opened it.
+func (f *Wrapper) Claim(epoch int64, id string) error {
+	return nil
+}
+
 func (f *Wrapper) SetRevisionCounter(counter int64) error {
 	return nil
 }
@@ -59,6 +59,11 @@ type Remote struct {
 	httpClient  *http.Client
 	closeChan   chan struct{}
 	monitorChan types.MonitorChannel
+	client      *rpc.Client
+	// epoch and controllerID are sent with the actions once the
+	// replica has been claimed.
+	epoch        int64
+	controllerID string
 }
 
 func (r *Remote) Close() error {
@@ -115,6 +120,10 @@ func (r *Remote) doAction(action string, obj interface{}) error {
 	if obj != nil {
 		req.Header.Add("Content-Type", "application/json")
 	}
+	if r.epoch != 0 {
+		req.Header.Set(rest.EpochHeader, strconv.FormatInt(r.epoch, 10))
+		req.Header.Set(rest.ControllerHeader, r.controllerID)
+	}
 
 	client := r.httpClient
 	// timeout of zero means there is no timeout
@@ -232,6 +241,32 @@ func (r *Remote) SetCheckpoint(snapshotName string) error {
 	return r.doAction("setcheckpoint", &map[string]string{"snapshotName": snapshotName})
 }
 
+// SetReplicationFactor persists the replication factor of the volume on
+// the replica, over the REST API.
+func (r *Remote) SetReplicationFactor(rf int) error {
+	return r.doAction("setreplicationfactor", &map[string]int{"replicationFactor": rf})
+}
+
+// GetEpoch returns the epoch of the controller which claimed the replica
+// last.
+func (r *Remote) GetEpoch() (int64, error) {
+	replica, err := r.info()
+	if err != nil {
+		return 0, err
+	}
+	return replica.Epoch, nil
+}
+
+// Claim makes the controller with the given epoch and ID the owner of the
+// replica, over the data connection.
+func (r *Remote) Claim(epoch int64, id string) error {
+	if err := r.client.Handshake(epoch, id); err != nil {
+		return err
+	}
+	r.epoch, r.controllerID = epoch, id
+	return nil
+}
+
 func (r *Remote) SetRevisionCounter(counter int64) error {
 	logrus.Infof("Set revision counter of %s to : %v", r.Name, counter)
 	localRevCount := strconv.FormatInt(counter, 10)
@@ -281,11 +316,9 @@ func (rf *Factory) Create(address string) (types.Backend, error) {
 
 	r := &Remote{
 		Name:       address,
-		replicaURL: fmt.Sprintf("http://%s/v1/replicas/1", controlAddress),
-		pingURL:    fmt.Sprintf("http://%s/ping", controlAddress),
-		httpClient: &http.Client{
-			Timeout: timeout,
-		},
+		replicaURL: fmt.Sprintf("%s://%s/v1/replicas/1", util.HTTPScheme(), controlAddress),
+		pingURL:    fmt.Sprintf("%s://%s/ping", util.HTTPScheme(), controlAddress),
+		httpClient: util.NewHTTPClient(timeout),
 		// We don't want sender to wait for receiver, because receiver may
 		// has been already notified
 		closeChan:   make(chan struct{}, 5),
@@ -305,9 +338,20 @@ func (rf *Factory) Create(address string) (types.Backend, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config := util.ClientTLSConfig(); config != nil {
+		tlsConn, err := rpc.TLSClient(conn.(*net.TCPConn), config)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with replica %s failed: %v", address, err)
+		}
+		conn = tlsConn
+	}
 
-	remote := rpc.NewClient(conn, r.closeChan)
+	version := rpc.NegotiateVersion(replica.RPCVersion)
+	logrus.Infof("Using RPC protocol 0x%x with replica %s", version, address)
+	remote := rpc.NewClient(conn, r.closeChan, version)
 	r.IOs = remote
+	r.client = remote
 
 	if err := r.open(); err != nil {
 		logrus.Errorf("Failed to open replica, error: %v", err)
@@ -327,10 +371,8 @@ func (rf *Factory) SignalToAdd(address string, action string) error {
 	}
 	r := &Remote{
 		Name:       address,
-		replicaURL: fmt.Sprintf("http://%s/v1/replicas/1", controlAddress),
-		httpClient: &http.Client{
-			Timeout: timeout,
-		},
+		replicaURL: fmt.Sprintf("%s://%s/v1/replicas/1", util.HTTPScheme(), controlAddress),
+		httpClient: util.NewHTTPClient(timeout),
 	}
 	inject.AddTimeout()
 	return r.doAction("start", &map[string]string{"Action": action})
@@ -0,0 +1,238 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package backup implements the sbackup command launched by the sync
+// agent in the replica directory to create, restore and manage backups.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/openebs/jiva/backupstore"
+	"github.com/openebs/jiva/replica"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// Main is the entry point of the sbackup reexec command
+func Main() {
+	a := cli.NewApp()
+	a.Name = "sbackup"
+	a.Usage = "create and manage backups of the replica in the working directory"
+	a.Commands = []cli.Command{
+		createCmd(),
+		deleteCmd(),
+		restoreCmd(),
+		inspectCmd(),
+		listCmd(),
+	}
+
+	if err := a.Run(os.Args); err != nil {
+		logrus.Fatal("Error when executing command: ", err)
+	}
+}
+
+func createCmd() cli.Command {
+	return cli.Command{
+		Name:  "create",
+		Usage: "create a backup of a snapshot: create <snapshot> --dest <dest> --volume <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of backup, would be url like vfs:///path/",
+			},
+			cli.StringFlag{
+				Name:  "volume",
+				Usage: "name of the volume the snapshot belongs to",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := doBackupCreate(c); err != nil {
+				logrus.Fatalf("Error running create backup command: %v", err)
+			}
+		},
+	}
+}
+
+func deleteCmd() cli.Command {
+	return cli.Command{
+		Name:  "delete",
+		Usage: "delete a backup: delete <backup>",
+		Action: func(c *cli.Context) {
+			if err := doBackupDelete(c); err != nil {
+				logrus.Fatalf("Error running delete backup command: %v", err)
+			}
+		},
+	}
+}
+
+func restoreCmd() cli.Command {
+	return cli.Command{
+		Name:  "restore",
+		Usage: "restore a backup to a snapshot disk: restore <backup> --to <snapshot file>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "to",
+				Usage: "name of the snapshot disk to be created in the replica directory",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := doBackupRestore(c); err != nil {
+				logrus.Fatalf("Error running restore backup command: %v", err)
+			}
+		},
+	}
+}
+
+func inspectCmd() cli.Command {
+	return cli.Command{
+		Name:  "inspect",
+		Usage: "inspect a backup: inspect <backup>",
+		Action: func(c *cli.Context) {
+			if err := doBackupInspect(c); err != nil {
+				logrus.Fatalf("Error running inspect backup command: %v", err)
+			}
+		},
+	}
+}
+
+func listCmd() cli.Command {
+	return cli.Command{
+		Name:  "list",
+		Usage: "list the backups in a backup store: list <dest> [--volume <volume>]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume",
+				Usage: "only list the backups of this volume",
+			},
+		},
+		Action: func(c *cli.Context) {
+			if err := doBackupList(c); err != nil {
+				logrus.Fatalf("Error running list backup command: %v", err)
+			}
+		},
+	}
+}
+
+func doBackupCreate(c *cli.Context) error {
+	snapshot := c.Args().First()
+	if snapshot == "" {
+		return fmt.Errorf("Missing required parameter snapshot")
+	}
+	dest := c.String("dest")
+	if dest == "" {
+		return fmt.Errorf("Missing required parameter --dest")
+	}
+	volumeName := c.String("volume")
+	if volumeName == "" {
+		return fmt.Errorf("Missing required parameter --volume")
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Cannot get working directory: %v", err)
+	}
+	info, err := replica.ReadInfo(dir)
+	if err != nil {
+		return err
+	}
+
+	backupURL, err := backupstore.CreateDeltaBlockBackup(&backupstore.DeltaBackupConfig{
+		Volume: &backupstore.Volume{
+			Name: volumeName,
+			Size: info.Size,
+		},
+		Snapshot: &backupstore.Snapshot{
+			Name: snapshot,
+		},
+		DestURL:  dest,
+		DeltaOps: replica.NewBackup(nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(backupURL)
+	return nil
+}
+
+func doBackupDelete(c *cli.Context) error {
+	backupURL := c.Args().First()
+	if backupURL == "" {
+		return fmt.Errorf("Missing required parameter backup")
+	}
+	return backupstore.DeleteDeltaBlockBackup(backupURL)
+}
+
+func doBackupRestore(c *cli.Context) error {
+	backupURL := c.Args().First()
+	if backupURL == "" {
+		return fmt.Errorf("Missing required parameter backup")
+	}
+	toFile := c.String("to")
+	if toFile == "" {
+		return fmt.Errorf("Missing required parameter --to")
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("Cannot get working directory: %v", err)
+	}
+	if filepath.Base(toFile) != toFile {
+		return fmt.Errorf("Invalid snapshot file %v, must be a file name in the replica directory", toFile)
+	}
+
+	if err := backupstore.RestoreDeltaBlockBackup(backupURL, filepath.Join(dir, toFile)); err != nil {
+		return err
+	}
+	return replica.CreateRestoredDiskMetadata(dir, toFile)
+}
+
+func doBackupInspect(c *cli.Context) error {
+	backupURL := c.Args().First()
+	if backupURL == "" {
+		return fmt.Errorf("Missing required parameter backup")
+	}
+
+	info, err := backupstore.InspectBackup(backupURL)
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+func doBackupList(c *cli.Context) error {
+	destURL := c.Args().First()
+	if destURL == "" {
+		return fmt.Errorf("Missing required parameter <dest>")
+	}
+
+	volumes, err := backupstore.List(c.String("volume"), destURL)
+	if err != nil {
+		return err
+	}
+	return printJSON(volumes)
+}
+
+func printJSON(obj interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(obj)
+}
@@ -0,0 +1,234 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/openebs/jiva/util"
+)
+
+// Layout of a backup store:
+//
+//	backupstore/volumes/<volume>/volume.cfg
+//	backupstore/volumes/<volume>/backups/backup_<backup>.cfg
+//	backupstore/volumes/<volume>/blocks/<xx>/<yy>/<checksum>.blk
+//
+// Blocks are named after the sha256 checksum of their content, so a
+// block shared by several backups of a volume is stored only once.
+const (
+	backupstoreBase  = "backupstore"
+	volumeDirectory  = "volumes"
+	volumeConfigFile = "volume.cfg"
+	backupDirectory  = "backups"
+	backupCfgPrefix  = "backup_"
+	cfgSuffix        = ".cfg"
+	blockDirectory   = "blocks"
+	blockSuffix      = ".blk"
+	backupNamePrefix = "backup-"
+)
+
+// Mapping is a range of the snapshot that has data
+type Mapping struct {
+	Offset int64
+	Size   int64
+}
+
+// Mappings is the list of ranges of a snapshot which have to be backed up
+type Mappings struct {
+	Mappings  []Mapping
+	BlockSize int64
+}
+
+// DeltaBlockBackupOperations is implemented by the replica, it gives
+// access to the content of a snapshot and its changed blocks.
+type DeltaBlockBackupOperations interface {
+	HasSnapshot(id, volumeID string) bool
+	GetSnapshotInfo(id, volumeID string) (*Snapshot, error)
+	CompareSnapshot(id, compareID, volumeID string) (*Mappings, error)
+	OpenSnapshot(id, volumeID string) error
+	ReadSnapshot(id, volumeID string, start int64, data []byte) error
+	CloseSnapshot(id, volumeID string) error
+}
+
+// Volume is the configuration of a volume in the backup store
+type Volume struct {
+	Name           string
+	Size           int64
+	CreatedTime    string
+	LastBackupName string
+}
+
+// Snapshot is the snapshot a backup is taken from. CreatedTime and
+// RevisionCounter identify its content, they change if the snapshot is
+// recreated with the same name or if a child gets coalesced into it.
+type Snapshot struct {
+	Name            string
+	CreatedTime     string
+	RevisionCounter int64
+}
+
+// BlockMapping maps an offset of the volume to a stored block
+type BlockMapping struct {
+	Offset        int64
+	BlockChecksum string
+}
+
+// Backup is the manifest of a backup, it lists every block needed to
+// rebuild the snapshot. An incremental backup only uploads the blocks
+// changed since ParentName but still lists all of them, so deleting a
+// backup never breaks another one.
+type Backup struct {
+	Name                    string
+	ParentName              string
+	VolumeName              string
+	SnapshotName            string
+	SnapshotCreatedAt       string
+	SnapshotRevisionCounter int64
+	CreatedTime             string
+	Size                    int64
+	BlockSize               int64
+	DeltaBlockCount         int
+	DeltaSize               int64
+	Blocks                  []BlockMapping
+}
+
+func getVolumePath(volumeName string) string {
+	return filepath.Join(backupstoreBase, volumeDirectory, volumeName)
+}
+
+func getVolumeFilePath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), volumeConfigFile)
+}
+
+func getBackupPath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), backupDirectory)
+}
+
+func getBackupConfigName(backupName string) string {
+	return backupCfgPrefix + backupName + cfgSuffix
+}
+
+func getBackupConfigPath(backupName, volumeName string) string {
+	return filepath.Join(getBackupPath(volumeName), getBackupConfigName(backupName))
+}
+
+func getBlockPath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), blockDirectory)
+}
+
+func getBlockFilePath(volumeName, checksum string) string {
+	return filepath.Join(getBlockPath(volumeName), checksum[0:2], checksum[2:4], checksum+blockSuffix)
+}
+
+func generateBackupName() string {
+	return backupNamePrefix + strings.Replace(util.UUID(), "-", "", -1)[:16]
+}
+
+// EncodeBackupURL returns the URL used to refer to a backup, e.g.
+// vfs:///path?backup=backup-xxx&volume=vol
+func EncodeBackupURL(backupName, volumeName, destURL string) string {
+	v := url.Values{}
+	v.Add("volume", volumeName)
+	v.Add("backup", backupName)
+	return destURL + "?" + v.Encode()
+}
+
+// DecodeBackupURL splits a backup URL into the backup name, the volume
+// name and the URL of the backup store.
+func DecodeBackupURL(backupURL string) (string, string, string, error) {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	v := u.Query()
+	volumeName := v.Get("volume")
+	backupName := v.Get("backup")
+	if !util.ValidVolumeName(volumeName) {
+		return "", "", "", fmt.Errorf("Invalid volume name parsed, got %v", volumeName)
+	}
+	if backupName == "" {
+		return "", "", "", fmt.Errorf("Invalid backup name parsed, got %v", backupName)
+	}
+	u.RawQuery = ""
+	return backupName, volumeName, u.String(), nil
+}
+
+func loadConfigInStore(driver BackupStoreDriver, filePath string, v interface{}) error {
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+func saveConfigInStore(driver BackupStoreDriver, filePath string, v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return driver.Write(filePath, bytes.NewReader(j))
+}
+
+func volumeExists(driver BackupStoreDriver, volumeName string) bool {
+	return driver.FileExists(getVolumeFilePath(volumeName))
+}
+
+func loadVolume(driver BackupStoreDriver, volumeName string) (*Volume, error) {
+	v := &Volume{}
+	if err := loadConfigInStore(driver, getVolumeFilePath(volumeName), v); err != nil {
+		return nil, fmt.Errorf("Failed to load volume %v from backupstore: %v", volumeName, err)
+	}
+	return v, nil
+}
+
+func saveVolume(driver BackupStoreDriver, v *Volume) error {
+	return saveConfigInStore(driver, getVolumeFilePath(v.Name), v)
+}
+
+func loadBackup(driver BackupStoreDriver, backupName, volumeName string) (*Backup, error) {
+	b := &Backup{}
+	if err := loadConfigInStore(driver, getBackupConfigPath(backupName, volumeName), b); err != nil {
+		return nil, fmt.Errorf("Failed to load backup %v of volume %v from backupstore: %v", backupName, volumeName, err)
+	}
+	return b, nil
+}
+
+func saveBackup(driver BackupStoreDriver, b *Backup) error {
+	return saveConfigInStore(driver, getBackupConfigPath(b.Name, b.VolumeName), b)
+}
+
+func getBackupNamesForVolume(driver BackupStoreDriver, volumeName string) ([]string, error) {
+	files, err := driver.List(getBackupPath(volumeName))
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, f := range files {
+		if !strings.HasPrefix(f, backupCfgPrefix) || !strings.HasSuffix(f, cfgSuffix) {
+			continue
+		}
+		result = append(result, strings.TrimSuffix(strings.TrimPrefix(f, backupCfgPrefix), cfgSuffix))
+	}
+	return result, nil
+}
@@ -0,0 +1,392 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testBlockSize = 4096
+	testVolume    = "test-volume"
+)
+
+// memSnapshots implements DeltaBlockBackupOperations over in memory
+// snapshots, the blocks which differ from compareID are reported as
+// changed.
+type memSnapshots struct {
+	snapshots map[string][]byte
+	revisions map[string]int64
+	open      string
+}
+
+func (m *memSnapshots) HasSnapshot(id, volumeID string) bool {
+	_, ok := m.snapshots[id]
+	return ok
+}
+
+func (m *memSnapshots) GetSnapshotInfo(id, volumeID string) (*Snapshot, error) {
+	if !m.HasSnapshot(id, volumeID) {
+		return nil, fmt.Errorf("snapshot %s doesn't exist", id)
+	}
+	return &Snapshot{
+		Name:            id,
+		CreatedTime:     "2020-01-01T00:00:00Z",
+		RevisionCounter: m.revisions[id],
+	}, nil
+}
+
+func (m *memSnapshots) CompareSnapshot(id, compareID, volumeID string) (*Mappings, error) {
+	data := m.snapshots[id]
+	compare := make([]byte, len(data))
+	copy(compare, m.snapshots[compareID])
+
+	mappings := &Mappings{BlockSize: testBlockSize}
+	for offset := int64(0); offset < int64(len(data)); offset += testBlockSize {
+		if compareID != "" && bytes.Equal(data[offset:offset+testBlockSize], compare[offset:offset+testBlockSize]) {
+			continue
+		}
+		mappings.Mappings = append(mappings.Mappings, Mapping{
+			Offset: offset,
+			Size:   testBlockSize,
+		})
+	}
+	return mappings, nil
+}
+
+func (m *memSnapshots) OpenSnapshot(id, volumeID string) error {
+	if m.open != "" {
+		return fmt.Errorf("snapshot %s is already open", m.open)
+	}
+	m.open = id
+	return nil
+}
+
+func (m *memSnapshots) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	copy(data, m.snapshots[id][start:])
+	return nil
+}
+
+func (m *memSnapshots) CloseSnapshot(id, volumeID string) error {
+	m.open = ""
+	return nil
+}
+
+func fillBlock(data []byte, block int, val byte) {
+	for i := block * testBlockSize; i < (block+1)*testBlockSize; i++ {
+		data[i] = val
+	}
+}
+
+func countBlocks(t *testing.T, store string) int {
+	count := 0
+	err := filepath.Walk(filepath.Join(store, getBlockPath(testVolume)), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == blockSuffix {
+			count++
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func createBackup(t *testing.T, ops *memSnapshots, snapshot, destURL string) string {
+	backupURL, err := CreateDeltaBlockBackup(&DeltaBackupConfig{
+		Volume: &Volume{
+			Name: testVolume,
+			Size: int64(len(ops.snapshots[snapshot])),
+		},
+		Snapshot: &Snapshot{Name: snapshot},
+		DestURL:  destURL,
+		DeltaOps: ops,
+	})
+	if err != nil {
+		t.Fatalf("CreateDeltaBlockBackup() of %s failed: %v", snapshot, err)
+	}
+	return backupURL
+}
+
+func restoreAndCompare(t *testing.T, backupURL string, expected []byte) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "restored.img")
+	if err := RestoreDeltaBlockBackup(backupURL, file); err != nil {
+		t.Fatalf("RestoreDeltaBlockBackup() failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("restored data of %s doesn't match", backupURL)
+	}
+}
+
+func TestBackupURL(t *testing.T) {
+	url := EncodeBackupURL("backup-1234", testVolume, "vfs:///var/backups")
+	backup, volume, dest, err := DecodeBackupURL(url)
+	if err != nil {
+		t.Fatalf("DecodeBackupURL() failed: %v", err)
+	}
+	if backup != "backup-1234" || volume != testVolume || dest != "vfs:///var/backups" {
+		t.Errorf("DecodeBackupURL() = %v, %v, %v", backup, volume, dest)
+	}
+
+	if _, _, _, err := DecodeBackupURL("vfs:///var/backups?volume=" + testVolume); err == nil {
+		t.Errorf("DecodeBackupURL() should fail without backup name")
+	}
+}
+
+func TestGetBackupStoreDriver(t *testing.T) {
+	store, err := ioutil.TempDir("", "backupstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"vfs", "vfs://" + store, false},
+		{"vfs with host", "vfs://host" + store, true},
+		{"missing path", "vfs://" + store + "/missing", true},
+		{"unsupported", "s3://bucket@region/path", true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GetBackupStoreDriver(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetBackupStoreDriver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackupRestoreDelete(t *testing.T) {
+	store, err := ioutil.TempDir("", "backupstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	destURL := "vfs://" + store
+
+	// snap1: blocks 0 and 2 hold the same data, block 1 and 3 are empty
+	// snap2: block 2 changed, block 3 written
+	ops := &memSnapshots{snapshots: map[string][]byte{}, revisions: map[string]int64{}}
+	snap1 := make([]byte, 4*testBlockSize)
+	fillBlock(snap1, 0, 1)
+	fillBlock(snap1, 2, 1)
+	snap2 := make([]byte, 4*testBlockSize)
+	copy(snap2, snap1)
+	fillBlock(snap2, 2, 2)
+	fillBlock(snap2, 3, 3)
+	ops.snapshots["snap1"] = snap1
+	ops.snapshots["snap2"] = snap2
+
+	backup1 := createBackup(t, ops, "snap1", destURL)
+	if n := countBlocks(t, store); n != 1 {
+		t.Fatalf("expected 1 stored block after first backup, got %d", n)
+	}
+	backup2 := createBackup(t, ops, "snap2", destURL)
+	if n := countBlocks(t, store); n != 3 {
+		t.Fatalf("expected 3 stored blocks after second backup, got %d", n)
+	}
+
+	info, err := InspectBackup(backup2)
+	if err != nil {
+		t.Fatalf("InspectBackup() failed: %v", err)
+	}
+	if info.SnapshotName != "snap2" || info.BlockCount != 3 || info.VolumeSize != int64(len(snap2)) {
+		t.Errorf("InspectBackup() = %+v", info)
+	}
+
+	volumes, err := List("", destURL)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(volumes) != 1 || len(volumes[testVolume].Backups) != 2 {
+		t.Fatalf("List() = %+v", volumes)
+	}
+	if volumes[testVolume].LastBackupName != info.Name {
+		t.Errorf("last backup is %v, expected %v", volumes[testVolume].LastBackupName, info.Name)
+	}
+
+	restoreAndCompare(t, backup1, snap1)
+	restoreAndCompare(t, backup2, snap2)
+
+	// blocks only used by backup1 go away, the shared one stays
+	if err := DeleteDeltaBlockBackup(backup2); err != nil {
+		t.Fatalf("DeleteDeltaBlockBackup() failed: %v", err)
+	}
+	if n := countBlocks(t, store); n != 1 {
+		t.Fatalf("expected 1 stored block after deleting backup, got %d", n)
+	}
+	if _, err := InspectBackup(backup2); err == nil {
+		t.Errorf("InspectBackup() of a deleted backup should fail")
+	}
+	restoreAndCompare(t, backup1, snap1)
+
+	if err := DeleteDeltaBlockBackup(backup1); err != nil {
+		t.Fatalf("DeleteDeltaBlockBackup() failed: %v", err)
+	}
+	if n := countBlocks(t, store); n != 0 {
+		t.Fatalf("expected no stored block after deleting all backups, got %d", n)
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	store, err := ioutil.TempDir("", "backupstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+	destURL := "vfs://" + store
+
+	inspect := func(backupURL string) *BackupInfo {
+		info, err := InspectBackup(backupURL)
+		if err != nil {
+			t.Fatalf("InspectBackup() failed: %v", err)
+		}
+		return info
+	}
+
+	// snap1: blocks 0 and 2 written
+	// snap2: block 2 changed, block 3 written
+	// snap3: block 0 discarded
+	ops := &memSnapshots{snapshots: map[string][]byte{}, revisions: map[string]int64{}}
+	snap1 := make([]byte, 4*testBlockSize)
+	fillBlock(snap1, 0, 1)
+	fillBlock(snap1, 2, 1)
+	snap2 := make([]byte, 4*testBlockSize)
+	copy(snap2, snap1)
+	fillBlock(snap2, 2, 2)
+	fillBlock(snap2, 3, 3)
+	snap3 := make([]byte, 4*testBlockSize)
+	copy(snap3, snap2)
+	fillBlock(snap3, 0, 0)
+	ops.snapshots["snap1"] = snap1
+	ops.snapshots["snap2"] = snap2
+	ops.snapshots["snap3"] = snap3
+
+	info1 := inspect(createBackup(t, ops, "snap1", destURL))
+	if info1.Parent != "" || info1.DeltaBlockCount != 2 || info1.DeltaSize != 2*testBlockSize {
+		t.Errorf("first backup should be full, got %+v", info1)
+	}
+
+	backup2 := createBackup(t, ops, "snap2", destURL)
+	info2 := inspect(backup2)
+	if info2.Parent != info1.Name || info2.BlockCount != 3 || info2.DeltaBlockCount != 2 || info2.DeltaSize != 2*testBlockSize {
+		t.Errorf("second backup should be on top of %v, got %+v", info1.Name, info2)
+	}
+	restoreAndCompare(t, backup2, snap2)
+
+	backup3 := createBackup(t, ops, "snap3", destURL)
+	info3 := inspect(backup3)
+	if info3.Parent != info2.Name || info3.BlockCount != 2 || info3.DeltaBlockCount != 0 {
+		t.Errorf("third backup should be on top of %v, got %+v", info2.Name, info3)
+	}
+	restoreAndCompare(t, backup3, snap3)
+
+	// snap3 content changed under the same name, e.g. a child has been
+	// coalesced into it, so the next backup can't be taken on top of it
+	changed := make([]byte, 4*testBlockSize)
+	copy(changed, snap3)
+	fillBlock(changed, 1, 4)
+	ops.snapshots["snap3"] = changed
+	ops.revisions["snap3"] = 10
+	backup4 := createBackup(t, ops, "snap3", destURL)
+	info4 := inspect(backup4)
+	if info4.Parent != "" || info4.DeltaBlockCount != 3 {
+		t.Errorf("backup of a changed snapshot should be full, got %+v", info4)
+	}
+	restoreAndCompare(t, backup4, changed)
+
+	// the parent snapshot is gone
+	delete(ops.snapshots, "snap3")
+	backup5 := createBackup(t, ops, "snap2", destURL)
+	if info5 := inspect(backup5); info5.Parent != "" {
+		t.Errorf("backup without parent snapshot should be full, got %+v", info5)
+	}
+
+	// backups don't depend on each other
+	if err := DeleteDeltaBlockBackup(backup2); err != nil {
+		t.Fatalf("DeleteDeltaBlockBackup() failed: %v", err)
+	}
+	restoreAndCompare(t, backup3, snap3)
+
+	// deleting the last backup falls back to a remaining one
+	if err := DeleteDeltaBlockBackup(backup5); err != nil {
+		t.Fatalf("DeleteDeltaBlockBackup() failed: %v", err)
+	}
+	volumes, err := List(testVolume, destURL)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if _, ok := volumes[testVolume].Backups[volumes[testVolume].LastBackupName]; !ok {
+		t.Errorf("last backup %v isn't a remaining backup", volumes[testVolume].LastBackupName)
+	}
+}
+
+func TestRestoreCorruptedBlock(t *testing.T) {
+	store, err := ioutil.TempDir("", "backupstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+
+	ops := &memSnapshots{snapshots: map[string][]byte{}, revisions: map[string]int64{}}
+	snap := make([]byte, testBlockSize)
+	fillBlock(snap, 0, 7)
+	ops.snapshots["snap"] = snap
+	backupURL := createBackup(t, ops, "snap", "vfs://"+store)
+
+	blk := filepath.Join(store, getBlockFilePath(testVolume, checksum(snap)))
+	if err := ioutil.WriteFile(blk, make([]byte, testBlockSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "restored.img")
+	if err := RestoreDeltaBlockBackup(backupURL, file); err == nil {
+		t.Fatalf("RestoreDeltaBlockBackup() should fail on corrupted block")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("partially restored file should be removed, err: %v", err)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/openebs/jiva/util"
+	"github.com/sirupsen/logrus"
+)
+
+// DeltaBackupConfig holds the details of the backup to be created
+type DeltaBackupConfig struct {
+	Volume   *Volume
+	Snapshot *Snapshot
+	DestURL  string
+	DeltaOps DeltaBlockBackupOperations
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func isZeroBlock(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateDeltaBlockBackup splits the snapshot into blocks, uploads the
+// blocks which aren't in the store yet and saves the manifest of the
+// backup. It returns the URL of the backup.
+//
+// If the snapshot of the last backup of the volume is still in the chain
+// of the snapshot and its content didn't change, only the blocks written
+// since then are read, the others are taken from the last backup.
+func CreateDeltaBlockBackup(config *DeltaBackupConfig) (string, error) {
+	if config == nil || config.Volume == nil || config.Snapshot == nil || config.DeltaOps == nil {
+		return "", fmt.Errorf("Invalid empty config for backup")
+	}
+	volume := config.Volume
+	snapshot := config.Snapshot
+	deltaOps := config.DeltaOps
+
+	driver, err := GetBackupStoreDriver(config.DestURL)
+	if err != nil {
+		return "", err
+	}
+
+	if volumeExists(driver, volume.Name) {
+		v, err := loadVolume(driver, volume.Name)
+		if err != nil {
+			return "", err
+		}
+		v.Size = volume.Size
+		volume = v
+	} else {
+		volume.CreatedTime = util.Now()
+	}
+	if err := saveVolume(driver, volume); err != nil {
+		return "", err
+	}
+
+	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := deltaOps.CloseSnapshot(snapshot.Name, volume.Name); err != nil {
+			logrus.Errorf("Failed to close snapshot %v of volume %v: %v", snapshot.Name, volume.Name, err)
+		}
+	}()
+
+	if !deltaOps.HasSnapshot(snapshot.Name, volume.Name) {
+		return "", fmt.Errorf("Snapshot %v of volume %v doesn't exist", snapshot.Name, volume.Name)
+	}
+	snapshot, err = deltaOps.GetSnapshotInfo(snapshot.Name, volume.Name)
+	if err != nil {
+		return "", err
+	}
+
+	parent := getParentBackup(driver, deltaOps, volume)
+	compareName := ""
+	if parent != nil {
+		compareName = parent.SnapshotName
+		logrus.Infof("Start incremental backup of snapshot %v of volume %v on top of backup %v of snapshot %v",
+			snapshot.Name, volume.Name, parent.Name, parent.SnapshotName)
+	} else {
+		logrus.Infof("Start full backup of snapshot %v of volume %v", snapshot.Name, volume.Name)
+	}
+
+	mappings, err := deltaOps.CompareSnapshot(snapshot.Name, compareName, volume.Name)
+	if err != nil {
+		return "", err
+	}
+	if parent != nil && parent.BlockSize != mappings.BlockSize {
+		logrus.Warningf("Block size of backup %v is %v instead of %v, taking full backup",
+			parent.Name, parent.BlockSize, mappings.BlockSize)
+		parent = nil
+		if mappings, err = deltaOps.CompareSnapshot(snapshot.Name, "", volume.Name); err != nil {
+			return "", err
+		}
+	}
+
+	backup := &Backup{
+		Name:                    generateBackupName(),
+		VolumeName:              volume.Name,
+		SnapshotName:            snapshot.Name,
+		SnapshotCreatedAt:       snapshot.CreatedTime,
+		SnapshotRevisionCounter: snapshot.RevisionCounter,
+		Size:                    volume.Size,
+		BlockSize:               mappings.BlockSize,
+	}
+
+	// blocks maps the offset of every block of the snapshot to its
+	// checksum, starting from the blocks of the parent backup.
+	blocks := map[int64]string{}
+	if parent != nil {
+		backup.ParentName = parent.Name
+		for _, b := range parent.Blocks {
+			blocks[b.Offset] = b.BlockChecksum
+		}
+	}
+
+	newBlocks := 0
+	for _, m := range mappings.Mappings {
+		size := m.Size
+		if m.Offset+size > volume.Size {
+			size = volume.Size - m.Offset
+		}
+		if size <= 0 {
+			continue
+		}
+		block := make([]byte, size)
+		if err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, m.Offset, block); err != nil {
+			return "", err
+		}
+		if isZeroBlock(block) {
+			delete(blocks, m.Offset)
+			continue
+		}
+		blockChecksum := checksum(block)
+		blkFile := getBlockFilePath(volume.Name, blockChecksum)
+		if !driver.FileExists(blkFile) {
+			if err := driver.Write(blkFile, bytes.NewReader(block)); err != nil {
+				return "", err
+			}
+			newBlocks++
+		}
+		blocks[m.Offset] = blockChecksum
+		backup.DeltaBlockCount++
+		backup.DeltaSize += size
+	}
+
+	for offset, blockChecksum := range blocks {
+		backup.Blocks = append(backup.Blocks, BlockMapping{
+			Offset:        offset,
+			BlockChecksum: blockChecksum,
+		})
+	}
+	sort.Slice(backup.Blocks, func(i, j int) bool {
+		return backup.Blocks[i].Offset < backup.Blocks[j].Offset
+	})
+
+	backup.CreatedTime = util.Now()
+	if err := saveBackup(driver, backup); err != nil {
+		return "", err
+	}
+
+	volume.LastBackupName = backup.Name
+	if err := saveVolume(driver, volume); err != nil {
+		return "", err
+	}
+
+	logrus.Infof("Created backup %v of snapshot %v, %v blocks, %v changed, %v new",
+		backup.Name, snapshot.Name, len(backup.Blocks), backup.DeltaBlockCount, newBlocks)
+	return EncodeBackupURL(backup.Name, volume.Name, driver.GetURL()), nil
+}
+
+// getParentBackup returns the last backup of the volume if an incremental
+// backup can be taken on top of it, nil otherwise. The snapshot of that
+// backup must be in the chain of the snapshot being backed up and must
+// still have the same content, which isn't the case if it has been
+// recreated with the same name or if a deleted child has been coalesced
+// into it.
+func getParentBackup(driver BackupStoreDriver, deltaOps DeltaBlockBackupOperations, volume *Volume) *Backup {
+	if volume.LastBackupName == "" {
+		return nil
+	}
+	parent, err := loadBackup(driver, volume.LastBackupName, volume.Name)
+	if err != nil {
+		logrus.Warningf("Failed to load last backup, taking full backup: %v", err)
+		return nil
+	}
+	if parent.Size != volume.Size {
+		logrus.Infof("Volume %v has been resized since backup %v, taking full backup", volume.Name, parent.Name)
+		return nil
+	}
+	if !deltaOps.HasSnapshot(parent.SnapshotName, volume.Name) {
+		logrus.Infof("Snapshot %v of backup %v isn't in the chain, taking full backup", parent.SnapshotName, parent.Name)
+		return nil
+	}
+	snap, err := deltaOps.GetSnapshotInfo(parent.SnapshotName, volume.Name)
+	if err != nil {
+		logrus.Warningf("Failed to get snapshot %v of backup %v, taking full backup: %v", parent.SnapshotName, parent.Name, err)
+		return nil
+	}
+	if snap.CreatedTime != parent.SnapshotCreatedAt || snap.RevisionCounter != parent.SnapshotRevisionCounter {
+		logrus.Infof("Snapshot %v has changed since backup %v, taking full backup", parent.SnapshotName, parent.Name)
+		return nil
+	}
+	return parent
+}
+
+// RestoreDeltaBlockBackup writes the content of the backup to the sparse
+// file volDevName, which must not exist yet.
+func RestoreDeltaBlockBackup(backupURL, volDevName string) error {
+	backupName, volumeName, destURL, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	backup, err := loadBackup(driver, backupName, volumeName)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(volDevName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	restored := false
+	defer func() {
+		f.Close()
+		if !restored {
+			os.Remove(volDevName)
+		}
+	}()
+
+	if err := f.Truncate(backup.Size); err != nil {
+		return err
+	}
+
+	logrus.Infof("Start restoring backup %v of volume %v to %v", backupName, volumeName, volDevName)
+	for _, b := range backup.Blocks {
+		data, err := readBlock(driver, volumeName, b.BlockChecksum)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(data, b.Offset); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := util.SyncDir(filepath.Dir(volDevName)); err != nil {
+		return err
+	}
+	restored = true
+	logrus.Infof("Restored backup %v of volume %v to %v", backupName, volumeName, volDevName)
+	return nil
+}
+
+func readBlock(driver BackupStoreDriver, volumeName, blockChecksum string) ([]byte, error) {
+	rc, err := driver.Read(getBlockFilePath(volumeName, blockChecksum))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if checksum(data) != blockChecksum {
+		return nil, fmt.Errorf("Checksum mismatch for block %v of volume %v", blockChecksum, volumeName)
+	}
+	return data, nil
+}
+
+// DeleteDeltaBlockBackup removes the manifest of the backup and every
+// block which isn't referenced by the remaining backups of the volume.
+func DeleteDeltaBlockBackup(backupURL string) error {
+	backupName, volumeName, destURL, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return err
+	}
+
+	volume, err := loadVolume(driver, volumeName)
+	if err != nil {
+		return err
+	}
+	if _, err := loadBackup(driver, backupName, volumeName); err != nil {
+		return err
+	}
+
+	if err := driver.Remove(getBackupConfigPath(backupName, volumeName)); err != nil {
+		return err
+	}
+	logrus.Infof("Removed backup %v of volume %v", backupName, volumeName)
+
+	// The next backup is taken on top of the most recent remaining one
+	if volume.LastBackupName == backupName {
+		volume.LastBackupName, err = getLatestBackupName(driver, volumeName)
+		if err != nil {
+			return err
+		}
+		if err := saveVolume(driver, volume); err != nil {
+			return err
+		}
+	}
+
+	return removeUnusedBlocks(driver, volumeName)
+}
+
+func getLatestBackupName(driver BackupStoreDriver, volumeName string) (string, error) {
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return "", err
+	}
+
+	latest, latestCreated := "", ""
+	for _, name := range backupNames {
+		b, err := loadBackup(driver, name, volumeName)
+		if err != nil {
+			return "", err
+		}
+		// CreatedTime is in RFC3339 UTC, it sorts as a string
+		if b.CreatedTime > latestCreated {
+			latest, latestCreated = b.Name, b.CreatedTime
+		}
+	}
+	return latest, nil
+}
+
+func removeUnusedBlocks(driver BackupStoreDriver, volumeName string) error {
+	backupNames, err := getBackupNamesForVolume(driver, volumeName)
+	if err != nil {
+		return err
+	}
+
+	inUse := map[string]bool{}
+	for _, name := range backupNames {
+		b, err := loadBackup(driver, name, volumeName)
+		if err != nil {
+			return err
+		}
+		for _, blk := range b.Blocks {
+			inUse[blk.BlockChecksum] = true
+		}
+	}
+
+	var unused []string
+	blockPath := getBlockPath(volumeName)
+	l1, err := driver.List(blockPath)
+	if err != nil {
+		return err
+	}
+	for _, d1 := range l1 {
+		l2, err := driver.List(filepath.Join(blockPath, d1))
+		if err != nil {
+			return err
+		}
+		for _, d2 := range l2 {
+			blocks, err := driver.List(filepath.Join(blockPath, d1, d2))
+			if err != nil {
+				return err
+			}
+			for _, blk := range blocks {
+				if !strings.HasSuffix(blk, blockSuffix) {
+					continue
+				}
+				if !inUse[strings.TrimSuffix(blk, blockSuffix)] {
+					unused = append(unused, filepath.Join(blockPath, d1, d2, blk))
+				}
+			}
+		}
+	}
+
+	if err := driver.Remove(unused...); err != nil {
+		return err
+	}
+	logrus.Infof("Removed %v unused blocks of volume %v", len(unused), volumeName)
+	return nil
+}
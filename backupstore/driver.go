@@ -0,0 +1,70 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// BackupStoreDriver is the interface each kind of backup store has to
+// implement. All the paths are relative to the root of the store.
+type BackupStoreDriver interface {
+	Kind() string
+	GetURL() string
+	FileExists(filePath string) bool
+	FileSize(filePath string) int64
+	Read(src string) (io.ReadCloser, error)
+	Write(dst string, rs io.Reader) error
+	List(path string) ([]string, error)
+	Remove(names ...string) error
+}
+
+// InitFunc creates the driver for the given destination URL
+type InitFunc func(destURL string) (BackupStoreDriver, error)
+
+var (
+	initializers = map[string]InitFunc{}
+)
+
+// RegisterDriver registers the initializer of a backup store kind,
+// the kind is the scheme of the destination URL, e.g. vfs.
+func RegisterDriver(kind string, initFunc InitFunc) error {
+	if _, exists := initializers[kind]; exists {
+		return fmt.Errorf("%s has already been registered", kind)
+	}
+	initializers[kind] = initFunc
+	return nil
+}
+
+// GetBackupStoreDriver returns the driver registered for the scheme of
+// destURL.
+func GetBackupStoreDriver(destURL string) (BackupStoreDriver, error) {
+	if destURL == "" {
+		return nil, fmt.Errorf("Destination URL hasn't been specified")
+	}
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+	initFunc, exists := initializers[u.Scheme]
+	if !exists {
+		return nil, fmt.Errorf("Driver %v is not supported", u.Scheme)
+	}
+	return initFunc(destURL)
+}
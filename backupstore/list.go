@@ -0,0 +1,138 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"path/filepath"
+)
+
+// VolumeInfo is the summary of a volume in the backup store
+type VolumeInfo struct {
+	Name           string                 `json:"name"`
+	Size           int64                  `json:"size,string"`
+	Created        string                 `json:"created"`
+	LastBackupName string                 `json:"lastBackupName"`
+	Backups        map[string]*BackupInfo `json:"backups"`
+}
+
+// BackupInfo is the summary of a backup
+type BackupInfo struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	Parent          string `json:"parent"`
+	SnapshotName    string `json:"snapshotName"`
+	SnapshotCreated string `json:"snapshotCreated"`
+	Created         string `json:"created"`
+	Size            int64  `json:"size,string"`
+	BlockCount      int    `json:"blockCount"`
+	DeltaSize       int64  `json:"deltaSize,string"`
+	DeltaBlockCount int    `json:"deltaBlockCount"`
+	VolumeName      string `json:"volumeName"`
+	VolumeSize      int64  `json:"volumeSize,string"`
+	VolumeCreated   string `json:"volumeCreated"`
+}
+
+func fillBackupInfo(backup *Backup, volume *Volume, destURL string) *BackupInfo {
+	return &BackupInfo{
+		Name:            backup.Name,
+		URL:             EncodeBackupURL(backup.Name, backup.VolumeName, destURL),
+		Parent:          backup.ParentName,
+		SnapshotName:    backup.SnapshotName,
+		SnapshotCreated: backup.SnapshotCreatedAt,
+		Created:         backup.CreatedTime,
+		Size:            int64(len(backup.Blocks)) * backup.BlockSize,
+		BlockCount:      len(backup.Blocks),
+		DeltaSize:       backup.DeltaSize,
+		DeltaBlockCount: backup.DeltaBlockCount,
+		VolumeName:      volume.Name,
+		VolumeSize:      volume.Size,
+		VolumeCreated:   volume.CreatedTime,
+	}
+}
+
+// InspectBackup returns the details of the backup referred by backupURL
+func InspectBackup(backupURL string) (*BackupInfo, error) {
+	backupName, volumeName, destURL, err := DecodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := loadVolume(driver, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(driver, backupName, volumeName)
+	if err != nil {
+		return nil, err
+	}
+	return fillBackupInfo(backup, volume, driver.GetURL()), nil
+}
+
+// List returns the volumes in the backup store along with their backups,
+// only volumeName is listed if it's not empty.
+func List(volumeName, destURL string) (map[string]*VolumeInfo, error) {
+	driver, err := GetBackupStoreDriver(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeNames []string
+	if volumeName != "" {
+		if volumeExists(driver, volumeName) {
+			volumeNames = []string{volumeName}
+		}
+	} else {
+		volumeNames, err = driver.List(filepath.Join(backupstoreBase, volumeDirectory))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := map[string]*VolumeInfo{}
+	for _, name := range volumeNames {
+		volume, err := loadVolume(driver, name)
+		if err != nil {
+			return nil, err
+		}
+		info := &VolumeInfo{
+			Name:           volume.Name,
+			Size:           volume.Size,
+			Created:        volume.CreatedTime,
+			LastBackupName: volume.LastBackupName,
+			Backups:        map[string]*BackupInfo{},
+		}
+
+		backupNames, err := getBackupNamesForVolume(driver, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, backupName := range backupNames {
+			backup, err := loadBackup(driver, backupName, name)
+			if err != nil {
+				return nil, err
+			}
+			info.Backups[backupName] = fillBackupInfo(backup, volume, driver.GetURL())
+		}
+		result[name] = info
+	}
+	return result, nil
+}
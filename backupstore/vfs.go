@@ -0,0 +1,166 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backupstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/openebs/jiva/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	vfsKind = "vfs"
+)
+
+// VfsBackupStoreDriver stores the backups in a directory of the local
+// filesystem, usually a mounted NFS share, given as vfs:///path.
+type VfsBackupStoreDriver struct {
+	destURL string
+	path    string
+}
+
+func init() {
+	if err := RegisterDriver(vfsKind, initVfs); err != nil {
+		panic(err)
+	}
+}
+
+func initVfs(destURL string) (BackupStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != vfsKind {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, vfsKind)
+	}
+	if u.Host != "" {
+		return nil, fmt.Errorf("VFS path must follow: vfs:///path/ format")
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("Cannot find vfs path")
+	}
+
+	st, err := os.Stat(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot access vfs path %v: %v", u.Path, err)
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("VFS path %v is not a directory", u.Path)
+	}
+
+	return &VfsBackupStoreDriver{
+		destURL: vfsKind + "://" + u.Path,
+		path:    u.Path,
+	}, nil
+}
+
+func (v *VfsBackupStoreDriver) updatePath(path string) string {
+	return filepath.Join(v.path, path)
+}
+
+func (v *VfsBackupStoreDriver) Kind() string {
+	return vfsKind
+}
+
+func (v *VfsBackupStoreDriver) GetURL() string {
+	return v.destURL
+}
+
+func (v *VfsBackupStoreDriver) FileExists(filePath string) bool {
+	_, err := os.Stat(v.updatePath(filePath))
+	return err == nil
+}
+
+func (v *VfsBackupStoreDriver) FileSize(filePath string) int64 {
+	st, err := os.Stat(v.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return st.Size()
+}
+
+func (v *VfsBackupStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return os.Open(v.updatePath(src))
+}
+
+// Write writes the content of rs to dst. The file is written to a
+// temporary file first and renamed, so dst is either complete or absent.
+func (v *VfsBackupStoreDriver) Write(dst string, rs io.Reader) error {
+	path := v.updatePath(dst)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rs); err != nil {
+		if closeErr := f.Close(); closeErr != nil {
+			logrus.Errorf("Failed to close file: %v, err: %v", f.Name(), closeErr)
+		}
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		if closeErr := f.Close(); closeErr != nil {
+			logrus.Errorf("Failed to close file: %v, err: %v", f.Name(), closeErr)
+		}
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return util.SyncDir(dir)
+}
+
+// List returns the names of the entries in path, an absent path is
+// reported as an empty directory.
+func (v *VfsBackupStoreDriver) List(path string) ([]string, error) {
+	files, err := ioutil.ReadDir(v.updatePath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(files))
+	for _, f := range files {
+		result = append(result, f.Name())
+	}
+	return result, nil
+}
+
+func (v *VfsBackupStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		if err := os.RemoveAll(v.updatePath(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
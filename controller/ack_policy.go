@@ -0,0 +1,64 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AckPolicy is the number of replicas a write waits for before it is
+// acknowledged.
+type AckPolicy string
+
+const (
+	// AckAll acknowledges a write once every replica completed it
+	AckAll AckPolicy = "all"
+	// AckMajority acknowledges a write once a majority of the replicas
+	// completed it. The other replicas complete it in the background,
+	// a replica lagging by lagWindow writes makes the writes wait for it.
+	AckMajority AckPolicy = "majority"
+	// AckMajorityAsync is AckMajority, except that a replica lagging by
+	// lagWindow writes is set to ERR instead of being waited for. It is
+	// rebuilt from the regions written while it was out, see dirtyBitmap.
+	AckMajorityAsync AckPolicy = "majority-async"
+)
+
+// lagWindow is the number of writes in flight on a replica above which
+// it is lagging too much to acknowledge writes without it.
+const lagWindow = 64
+
+var errLagging = errors.New("Replica is lagging behind the other replicas")
+
+// ParseAckPolicy returns the AckPolicy of its name, the default is AckAll
+func ParseAckPolicy(name string) (AckPolicy, error) {
+	switch policy := AckPolicy(name); policy {
+	case "":
+		return AckAll, nil
+	case AckAll, AckMajority, AckMajorityAsync:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Invalid ack policy %q, expected %s, %s or %s",
+		name, AckAll, AckMajority, AckMajorityAsync)
+}
+
+// WithAckPolicy set the write acknowledgement policy of the volume
+func WithAckPolicy(policy AckPolicy) BuildOpts {
+	return func(c *Controller) {
+		c.AckPolicy = policy
+	}
+}
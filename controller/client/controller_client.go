@@ -20,6 +20,7 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -31,12 +32,14 @@ import (
 	"time"
 
 	"github.com/openebs/jiva/controller/rest"
+	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 )
 
 type ControllerClient struct {
 	controller string
+	httpClient *http.Client
 }
 
 func NewControllerClient(controller string) *ControllerClient {
@@ -45,6 +48,7 @@ func NewControllerClient(controller string) *ControllerClient {
 	}
 	return &ControllerClient{
 		controller: controller,
+		httpClient: util.NewHTTPClient(0),
 	}
 }
 
@@ -146,6 +150,122 @@ func (c *ControllerClient) SetLogging(lf util.LogToFile) error {
 	}, nil)
 }
 
+// Verify compares the data of the RW replicas, the diverged blocks are
+// rewritten with the data of the majority if repair is set.
+func (c *ControllerClient) Verify(repair bool) (*rest.VerifyOutput, error) {
+	volume, err := c.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+	if volume.Actions["verify"] == "" {
+		return nil, errors.New("Volume has no replicas to verify")
+	}
+
+	output := &rest.VerifyOutput{}
+	err = c.post(volume.Actions["verify"], &rest.VerifyInput{
+		Repair: repair,
+	}, output)
+	return output, err
+}
+
+// SetReplicationFactor changes the replication factor of the volume
+func (c *ControllerClient) SetReplicationFactor(rf int) (*rest.Volume, error) {
+	volume, err := c.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &rest.Volume{}
+	err = c.post(volume.Actions["setreplicationfactor"], &rest.ReplicationFactorInput{
+		ReplicationFactor: rf,
+	}, output)
+	return output, err
+}
+
+// SetQoS changes the limits of the I/Os of the volume
+func (c *ControllerClient) SetQoS(qos rest.QoS) (*rest.Volume, error) {
+	volume, err := c.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &rest.Volume{}
+	err = c.post(volume.Actions["setqos"], &rest.QoSInput{
+		QoS: qos,
+	}, output)
+	return output, err
+}
+
+// AttachSnapshot serves the snapshot read only with the frontend,
+// listening on address if it isn't empty.
+func (c *ControllerClient) AttachSnapshot(export rest.SnapshotExport) (*rest.Volume, error) {
+	volume, err := c.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &rest.Volume{}
+	err = c.post(volume.Actions["attachsnapshot"], &rest.SnapshotExportInput{
+		SnapshotExport: export,
+	}, output)
+	return output, err
+}
+
+// DetachSnapshot stops serving the snapshot
+func (c *ControllerClient) DetachSnapshot(name string) (*rest.Volume, error) {
+	volume, err := c.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &rest.Volume{}
+	err = c.post(volume.Actions["detachsnapshot"], &rest.SnapshotInput{
+		Name: name,
+	}, output)
+	return output, err
+}
+
+// Events calls fn with the events of the volume whose ID is greater than
+// after, the recent ones first. If follow is set, it then waits for the
+// new events until fn returns an error or the stream is closed.
+func (c *ControllerClient) Events(after int64, follow bool, fn func(types.Event) error) error {
+	resp, err := c.httpClient.Get(fmt.Sprintf("%s/events?after=%d&follow=%v", c.controller, after, follow))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Bad response: %d %s: %s", resp.StatusCode, resp.Status, content)
+	}
+
+	var data []byte
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:"))...)
+		case line == "" && len(data) > 0:
+			var e types.Event
+			if err := json.Unmarshal(data, &e); err != nil {
+				return fmt.Errorf("Invalid event %q, error: %v", data, err)
+			}
+			data = data[:0]
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if follow {
+		return errors.New("Event stream closed by the controller")
+	}
+	return nil
+}
+
 // DeleteSnapshot ...
 func (c *ControllerClient) DeleteSnapshot(name string) error {
 	volume, err := c.GetVolume()
@@ -169,7 +289,7 @@ func (c *ControllerClient) DeleteReplica(address string) (*rest.Replica, error)
 			if err != nil {
 				return nil, err
 			}
-			httpResp, err := http.DefaultClient.Do(httpReq)
+			httpResp, err := c.httpClient.Do(httpReq)
 			if err != nil {
 				return nil, err
 			}
@@ -216,6 +336,20 @@ func (c *ControllerClient) PrepareRebuild(address string) (*rest.PrepareRebuildO
 	return &output, err
 }
 
+// DirtyRegions returns the regions written since the replica left RW mode
+func (c *ControllerClient) DirtyRegions(address string) (*rest.DirtyRegionsOutput, error) {
+	var output rest.DirtyRegionsOutput
+	replica, err := c.GetReplica(address)
+	if err != nil {
+		return nil, err
+	}
+	if replica.Actions["dirtyregions"] == "" {
+		return nil, errors.New("Controller doesn't support tracking the regions written for a replica")
+	}
+	err = c.post(replica.Actions["dirtyregions"], &replica, &output)
+	return &output, err
+}
+
 func (c *ControllerClient) GetVolume() (*rest.Volume, error) {
 	var volumes rest.VolumeCollection
 
@@ -249,13 +383,15 @@ func (c *ControllerClient) GetCheckpoint() (string, error) {
 	return checkpoint.Snapshot, nil
 }
 
-func (c *ControllerClient) Register(address string, revisionCount int64, replicaType string, upTime time.Duration, state string) error {
+func (c *ControllerClient) Register(address string, revisionCount int64, replicaType string, upTime time.Duration, state string, rf int, epoch int64) error {
 	err := c.post("/register", &rest.RegReplica{
-		Address:  address,
-		RevCount: strconv.FormatInt(revisionCount, 10),
-		RepType:  replicaType,
-		UpTime:   upTime,
-		RepState: state,
+		Address:           address,
+		RevCount:          strconv.FormatInt(revisionCount, 10),
+		RepType:           replicaType,
+		UpTime:            upTime,
+		RepState:          state,
+		ReplicationFactor: rf,
+		Epoch:             epoch,
 	}, nil)
 	return err
 }
@@ -291,7 +427,7 @@ func (c *ControllerClient) do(method, path string, req, resp interface{}) error
 	}
 	httpReq.Header.Set("Content-Type", bodyType)
 
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	httpResp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -310,7 +446,7 @@ func (c *ControllerClient) do(method, path string, req, resp interface{}) error
 }
 
 func (c *ControllerClient) get(path string, obj interface{}) error {
-	resp, err := http.Get(c.controller + path)
+	resp, err := c.httpClient.Get(c.controller + path)
 	if err != nil {
 		return err
 	}
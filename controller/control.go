@@ -29,7 +29,6 @@ import (
 	units "github.com/docker/go-units"
 	"github.com/openebs/jiva/alertlog"
 	"github.com/openebs/jiva/replica"
-	replicaClient "github.com/openebs/jiva/replica/client"
 	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
@@ -44,6 +43,12 @@ type Controller struct {
 	sectorSize               int64
 	replicas                 []types.Replica
 	ReplicationFactor        int
+	AckPolicy                AckPolicy
+	ReadPolicy               ReadPolicy
+	preferredReplica         string
+	hedgeAfter               time.Duration
+	slowReplicaPolicy        SlowReplicaPolicy
+	slowReplicaStop          chan struct{}
 	RWReplicaCount           int
 	quorumReplicas           []types.Replica
 	quorumReplicaCount       int
@@ -59,6 +64,34 @@ type Controller struct {
 	SnapshotName             string
 	IsSnapDeletionInProgress bool
 	Checkpoint               string
+	ioLock                   *rangeLock
+	verifyInProgress         bool
+	// dirty tracks the regions written since a replica left RW mode,
+	// until it is rebuilt.
+	dirtyLock sync.Mutex
+	dirty     map[string]*dirtyBitmap
+	// quorumRF is the replication factor the quorum is computed from,
+	// it catches up with a raised ReplicationFactor as replicas turn RW.
+	quorumRF int
+	// cache holds the recently read blocks if the read cache is enabled
+	cache *readCache
+	// Epoch is the one of the controller on the replicas it claims, it
+	// is fixed once the first replica is claimed.
+	epochLock    sync.Mutex
+	Epoch        int64
+	epochClaimed bool
+	controllerID string
+	// limiter delays the I/Os exceeding the QoS limits
+	limiter *ioLimiter
+	// exports are the snapshots served read only, by disk name
+	exportLock      sync.Mutex
+	exportFrontends map[string]FrontendFactory
+	exports         map[string]*snapshotExport
+	// events are the changes of the state of the volume sent to the
+	// subscribers
+	events eventBroker
+	// ioCounters count the I/Os of the frontend for the metrics
+	ioCounters ioCounters
 }
 
 func max(x int, y int) int {
@@ -124,13 +157,21 @@ func NewController(opts ...BuildOpts) *Controller {
 		RegisteredQuorumReplicas: map[string]types.RegReplica{},
 		StartTime:                time.Now(),
 		ReadOnly:                 true,
+		AckPolicy:                AckAll,
+		ReadPolicy:               ReadRoundRobin,
+		ioLock:                   newRangeLock(),
+		controllerID:             util.UUID(),
+		limiter:                  &ioLimiter{},
 		//StartAutoSnapDeletion:    ch,
 	}
+	c.limiter.set(QoSLimits{})
 
 	for _, o := range opts {
 		o(c)
 	}
+	c.quorumRF = c.ReplicationFactor
 	c.reset()
+	c.startSlowReplicaMonitor()
 	return c
 }
 
@@ -143,18 +184,29 @@ func (c *Controller) UpdateVolStatus() {
 		}
 	}
 
+	if rwReplicaCount > c.quorumRF {
+		c.quorumRF = min(rwReplicaCount, c.ReplicationFactor)
+	}
+
 	for _, replica := range c.quorumReplicas {
 		if replica.Mode == "RW" {
 			rwReplicaCount++
 		}
 	}
 
-	if rwReplicaCount >= (((c.ReplicationFactor + c.quorumReplicaCount) / 2) + 1) {
+	if rwReplicaCount >= (((c.quorumRF + c.quorumReplicaCount) / 2) + 1) {
 		c.ReadOnly = false
 	} else {
 		c.ReadOnly = true
 	}
 	c.RWReplicaCount = rwReplicaCount
+	if prevState != c.ReadOnly {
+		mode := "RW"
+		if c.ReadOnly {
+			mode = "RO"
+		}
+		c.events.publish(types.Event{Type: types.EventVolumeMode, Mode: mode})
+	}
 
 	logrus.Infof("Previously Volume RO: %v, Currently: %v, Total Replicas: %v, RW replicas: %v, Total backends: %v",
 		prevState, c.ReadOnly, len(c.replicas), rwReplicaCount, len(c.backend.backends))
@@ -192,6 +244,9 @@ func (c *Controller) UpdateCheckpoint() {
 		logrus.Error(err)
 	}
 	c.Checkpoint = checkpoint
+	if prevCheckpoint != checkpoint {
+		c.events.publish(types.Event{Type: types.EventCheckpoint, Checkpoint: checkpoint})
+	}
 
 	logrus.Infof("prevCheckpoint: %v, currCheckpoint: %v", prevCheckpoint, c.Checkpoint)
 }
@@ -233,7 +288,7 @@ func (c *Controller) hasGreaterRevisionCount(woReplica, newReplica string) (bool
 		woRevCnt = c.RegisteredReplicas[woReplica].RevCount
 	}
 
-	repClient, err := replicaClient.NewReplicaClient(newReplica)
+	repClient, err := c.NewReplicaClient(newReplica)
 	if err != nil {
 		return false, err
 	}
@@ -320,6 +375,10 @@ func (c *Controller) addQuorumReplica(address string, snapshot bool) error {
 		logrus.Infof("remote creation addquorum failed %v", err)
 		return err
 	}
+	if err := c.claim(address, newBackend); err != nil {
+		newBackend.Close()
+		return err
+	}
 
 	c.Lock()
 	defer c.Unlock()
@@ -358,11 +417,11 @@ func (c *Controller) addQuorumReplica(address string, snapshot bool) error {
 }
 
 func (c *Controller) verifyReplicationFactor() error {
-	replicationFactor := util.CheckReplicationFactor()
+	replicationFactor := c.ReplicationFactor
 	if replicationFactor == 0 {
 		return fmt.Errorf("REPLICATION_FACTOR not set")
 	}
-	if replicationFactor == len(c.replicas) {
+	if replicationFactor <= len(c.replicas) {
 		return fmt.Errorf("replication factor: %v, added replicas: %v", replicationFactor, len(c.replicas))
 	}
 	return nil
@@ -385,6 +444,10 @@ func (c *Controller) addReplica(address string, snapshot bool) error {
 		logrus.Infof("remote creation addreplica failed %v", err)
 		return err
 	}
+	if err := c.claim(address, newBackend); err != nil {
+		newBackend.Close()
+		return err
+	}
 
 	c.Lock()
 	defer c.Unlock()
@@ -418,6 +481,7 @@ func (c *Controller) registerReplica(register types.RegReplica) error {
 		}
 	}
 
+	c.observeEpoch(register.Epoch)
 	if register.RepType == "quorum" {
 		c.RegisteredQuorumReplicas[register.Address] = register
 		return nil
@@ -455,6 +519,7 @@ func (c *Controller) registerReplica(register types.RegReplica) error {
 	if c.RegisteredReplicas[c.MaxRevReplica].RevCount < register.RevCount {
 		c.MaxRevReplica = register.Address
 	}
+	c.adoptReplicationFactor(c.RegisteredReplicas[c.MaxRevReplica])
 
 	if (len(c.RegisteredReplicas) >= ((c.ReplicationFactor / 2) + 1)) &&
 		((len(c.RegisteredReplicas) + len(c.RegisteredQuorumReplicas)) >= (((c.quorumReplicaCount + c.ReplicationFactor) / 2) + 1)) {
@@ -578,6 +643,8 @@ func (c *Controller) Resize(name string, size string) error {
 		}
 	}
 	c.size = sizeInBytes
+	c.cache.clear()
+	c.dropDirty("volume has been resized")
 	return nil
 }
 
@@ -610,6 +677,7 @@ func (c *Controller) addQuorumReplicaNoLock(newBackend types.Backend, address st
 		Address: address,
 		Mode:    types.WO,
 	})
+	c.publishReplicaEvent(types.EventReplicaAdded, address, types.WO)
 	c.quorumReplicaCount++
 
 	c.backend.AddQuorumBackend(address, newBackend)
@@ -659,6 +727,7 @@ func (c *Controller) addReplicaNoLock(newBackend types.Backend, address string,
 		Address: address,
 		Mode:    types.WO,
 	})
+	c.publishReplicaEvent(types.EventReplicaAdded, address, types.WO)
 
 	c.backend.AddBackend(address, newBackend)
 
@@ -723,8 +792,12 @@ func (c *Controller) RemoveReplicaNoLock(address string) error {
 				//replicas
 				logrus.Infof("RemoveReplica %v not found in registered replicas", address)
 			}
+			if r.Mode == types.RW {
+				c.trackDirty(address)
+			}
 			c.replicas = append(c.replicas[:i], c.replicas[i+1:]...)
 			c.backend.RemoveBackend(r.Address)
+			c.publishReplicaEvent(types.EventReplicaRemoved, address, r.Mode)
 			break
 		}
 	}
@@ -745,6 +818,7 @@ func (c *Controller) RemoveReplicaNoLock(address string) error {
 			}
 			c.quorumReplicas = append(c.quorumReplicas[:i], c.quorumReplicas[i+1:]...)
 			c.backend.RemoveBackend(r.Address)
+			c.publishReplicaEvent(types.EventReplicaRemoved, address, r.Mode)
 			break
 		}
 	}
@@ -793,6 +867,15 @@ func (c *Controller) setReplicaModeNoLock(address string, mode types.Mode) {
 			found = found + 1
 			if r.Mode != types.ERR {
 				logrus.Infof("Set replica %v to mode %v", address, mode)
+				switch {
+				case r.Mode == types.RW && mode == types.ERR:
+					c.trackDirty(address)
+				case mode == types.RW:
+					c.untrackDirty(address)
+				}
+				if r.Mode != mode {
+					c.publishReplicaEvent(types.EventReplicaMode, address, mode)
+				}
 				r.Mode = mode
 				c.replicas[i] = r
 				c.backend.SetMode(address, mode)
@@ -807,6 +890,9 @@ func (c *Controller) setReplicaModeNoLock(address string, mode types.Mode) {
 			found = found + 1
 			if r.Mode != types.ERR {
 				logrus.Infof("Set replica %v to mode %v", address, mode)
+				if r.Mode != mode {
+					c.publishReplicaEvent(types.EventReplicaMode, address, mode)
+				}
 				r.Mode = mode
 				c.quorumReplicas[i] = r
 				c.backend.SetMode(address, mode)
@@ -850,6 +936,11 @@ func (c *Controller) addReplicaDuringStartNoLock(address string) error {
 		c.rmReplicaFromRegisteredReplicas(address)
 		return err
 	}
+	if err := c.claim(address, newBackend); err != nil {
+		newBackend.Close()
+		c.rmReplicaFromRegisteredReplicas(address)
+		return err
+	}
 
 	newSize, err := newBackend.Size()
 	if err != nil {
@@ -924,6 +1015,7 @@ func (c *Controller) Start(addresses ...string) error {
 	}
 
 	c.reset()
+	c.startSlowReplicaMonitor()
 
 	defer c.startFrontend()
 
@@ -985,6 +1077,11 @@ func (c *Controller) Start(addresses ...string) error {
 	logrus.Info("Update volume status")
 	c.UpdateVolStatus()
 	c.UpdateCheckpoint()
+	for _, r := range c.replicas {
+		if r.Mode == types.RW {
+			c.persistReplicationFactor(r.Address)
+		}
+	}
 
 	return nil
 }
@@ -995,29 +1092,35 @@ func (c *Controller) Start(addresses ...string) error {
 // are not being served.
 // Above approach can hold the the app only for small amount of time based
 // on the app.
+//
+// I/Os only hold the controller lock for read, so they run concurrently
+// while replica add/remove and mode changes, which take it for write,
+// wait for the in-flight I/Os and block the new ones. Overlapping writes
+// and unmaps are serialized by ioLock.
 func (c *Controller) WriteAt(b []byte, off int64) (int, error) {
-	c.Lock()
-	if c.ReadOnly == true {
-		err := fmt.Errorf("Mode: ReadOnly")
-		c.Unlock()
-		time.Sleep(1 * time.Second)
-		return 0, err
+	// the writes rejected are neither throttled nor counted
+	c.RLock()
+	err := c.checkWrite("Write", off, int64(len(b)))
+	c.RUnlock()
+	if err != nil {
+		return 0, rejectIO(err)
 	}
-	defer c.Unlock()
-	if off < 0 || off+int64(len(b)) > c.size {
-		err := fmt.Errorf("EOF: Write of %v bytes at offset %v is beyond volume size %v", len(b), off, c.size)
-		return 0, err
+	c.limiter.wait(true, int64(len(b)))
+	c.ioCounters.add(true, int64(len(b)))
+	c.RLock()
+	// the volume may have changed while the write was throttled
+	if err := c.checkWrite("Write", off, int64(len(b))); err != nil {
+		c.RUnlock()
+		return 0, rejectIO(err)
 	}
+	unlock := c.ioLock.lock(off, int64(len(b)), true)
 	n, err := c.backend.WriteAt(b, off)
+	c.cache.invalidate(off, int64(len(b)))
+	unlock()
+	c.markDirty(err, off, int64(len(b)), true)
+	c.RUnlock()
 	if err != nil {
-		errh := c.handleErrorNoLock(err)
-		if bErr, ok := err.(*BackendError); ok {
-			if len(bErr.Errors) > 0 {
-				for address := range bErr.Errors {
-					_ = c.RemoveReplicaNoLock(address)
-				}
-			}
-		}
+		errh := c.handleIOError(err)
 		if n == len(b) && errh == nil {
 			return n, nil
 		}
@@ -1027,24 +1130,17 @@ func (c *Controller) WriteAt(b []byte, off int64) (int, error) {
 }
 
 func (c *Controller) Sync() (int, error) {
-	c.Lock()
+	c.RLock()
 	if c.ReadOnly == true {
 		err := fmt.Errorf("Mode: ReadOnly")
-		c.Unlock()
+		c.RUnlock()
 		time.Sleep(1 * time.Second)
 		return -1, err
 	}
-	defer c.Unlock()
 	n, err := c.backend.Sync()
+	c.RUnlock()
 	if err != nil {
-		errh := c.handleErrorNoLock(err)
-		if bErr, ok := err.(*BackendError); ok {
-			if len(bErr.Errors) > 0 {
-				for address := range bErr.Errors {
-					_ = c.RemoveReplicaNoLock(address)
-				}
-			}
-		}
+		errh := c.handleIOError(err)
 		if n == -1 {
 			return -1, fmt.Errorf("Sync Failed")
 		}
@@ -1054,24 +1150,19 @@ func (c *Controller) Sync() (int, error) {
 }
 
 func (c *Controller) Unmap(offset int64, length int64) (int, error) {
-	c.Lock()
-	if c.ReadOnly == true {
-		err := fmt.Errorf("Mode: ReadOnly")
-		c.Unlock()
-		time.Sleep(1 * time.Second)
-		return -1, err
+	c.RLock()
+	if err := c.checkWrite("Unmap", offset, length); err != nil {
+		c.RUnlock()
+		return -1, rejectIO(err)
 	}
-	defer c.Unlock()
+	unlock := c.ioLock.lock(offset, length, true)
 	n, err := c.backend.Unmap(offset, length)
+	c.cache.invalidate(offset, length)
+	unlock()
+	c.markDirty(err, offset, length, false)
+	c.RUnlock()
 	if err != nil {
-		errh := c.handleErrorNoLock(err)
-		if bErr, ok := err.(*BackendError); ok {
-			if len(bErr.Errors) > 0 {
-				for address := range bErr.Errors {
-					_ = c.RemoveReplicaNoLock(address)
-				}
-			}
-		}
+		errh := c.handleIOError(err)
 		if n == -1 {
 			return -1, fmt.Errorf("Unmap Failed")
 		}
@@ -1081,35 +1172,157 @@ func (c *Controller) Unmap(offset int64, length int64) (int, error) {
 }
 
 func (c *Controller) ReadAt(b []byte, off int64) (int, error) {
-	c.Lock()
-	defer c.Unlock()
-	if off < 0 || off+int64(len(b)) > c.size {
-		err := fmt.Errorf("EOF: Read of %v bytes at offset %v is beyond volume size %v", len(b), off, c.size)
+	// the reads rejected are neither throttled nor counted
+	c.RLock()
+	err := c.checkRead(off, int64(len(b)))
+	c.RUnlock()
+	if err != nil {
 		return 0, err
 	}
+	c.limiter.wait(false, int64(len(b)))
+	c.ioCounters.add(false, int64(len(b)))
+	c.RLock()
+	// the volume may have changed while the read was throttled
+	if err := c.checkRead(off, int64(len(b))); err != nil {
+		c.RUnlock()
+		return 0, err
+	}
+
+	unlock := c.ioLock.lock(off, int64(len(b)), false)
+	if c.cache.read(b, off) {
+		unlock()
+		c.RUnlock()
+		return len(b), nil
+	}
+	n, err := c.backend.ReadAt(b, off)
+	if err == nil {
+		c.cache.fill(b, off)
+	}
+	unlock()
+	c.RUnlock()
+	if err != nil {
+		if isChecksumError(err) && c.readRepair(b, off) {
+			return len(b), nil
+		}
+		return n, c.handleIOError(err)
+	}
+	return n, err
+}
+
+// errReadOnly is returned for the writes and the unmaps of a read only
+// volume.
+var errReadOnly = fmt.Errorf("Mode: ReadOnly")
+
+// checkWrite returns an error if the write or the unmap op can't be sent
+// to the replicas, the controller must be locked for read.
+func (c *Controller) checkWrite(op string, off, length int64) error {
+	if c.ReadOnly {
+		return errReadOnly
+	}
+	if off < 0 || off+length > c.size {
+		return fmt.Errorf("EOF: %s of %v bytes at offset %v is beyond volume size %v", op, length, off, c.size)
+	}
+	return nil
+}
+
+// checkRead returns an error if the read can't be sent to the replicas,
+// the controller must be locked for read.
+func (c *Controller) checkRead(off, length int64) error {
+	if off < 0 || off+length > c.size {
+		return fmt.Errorf("EOF: Read of %v bytes at offset %v is beyond volume size %v", length, off, c.size)
+	}
 	if len(c.replicas) == 0 {
-		return 0, fmt.Errorf("No backends available")
+		return fmt.Errorf("No backends available")
+	}
+	if len(c.replicas) == 1 && c.replicas[0].Mode == "WO" {
+		return fmt.Errorf("only WO replica available")
+	}
+	return nil
+}
+
+// rejectIO returns the error of a rejected write or unmap, delaying the
+// retries of the I/Os of a read only volume.
+func rejectIO(err error) error {
+	if err == errReadOnly {
+		time.Sleep(1 * time.Second)
+	}
+	return err
+}
+
+// isChecksumError returns true if all the replicas which failed the read
+// found corrupted data.
+func isChecksumError(err error) bool {
+	bErr, ok := err.(*BackendError)
+	if !ok || len(bErr.Errors) == 0 {
+		return false
 	}
-	if len(c.replicas) == 1 {
-		r := c.replicas[0]
-		if r.Mode == "WO" {
-			return 0, fmt.Errorf("only WO replica available")
+	for _, e := range bErr.Errors {
+		if !types.IsChecksumError(e) {
+			return false
 		}
 	}
+	return true
+}
 
-	n, err := c.backend.ReadAt(b, off)
+// readRepair reads the range again from a replica whose data isn't
+// corrupted and writes it to all the replicas, which repairs the replicas
+// whose data was found corrupted instead of evicting them.
+func (c *Controller) readRepair(b []byte, off int64) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if c.ReadOnly {
+		return false
+	}
+
+	unlock := c.ioLock.lock(off, int64(len(b)), true)
+	defer unlock()
+	buf := make([]byte, len(b))
+	_, err := c.backend.ReadAt(buf, off)
+	if bErr, ok := err.(*BackendError); ok && len(bErr.Errors) < len(c.backend.readers) {
+		// one of the replicas returned the data
+		err = nil
+	}
 	if err != nil {
-		errh := c.handleErrorNoLock(err)
-		if bErr, ok := err.(*BackendError); ok {
-			if len(bErr.Errors) > 0 {
-				for address := range bErr.Errors {
-					_ = c.RemoveReplicaNoLock(address)
-				}
-			}
+		logrus.Errorf("Read repair of %d bytes at offset %d failed, error: %v", len(b), off, err)
+		return false
+	}
+	_, err = c.backend.WriteAt(buf, off)
+	c.cache.invalidate(off, int64(len(buf)))
+	c.markDirty(err, off, int64(len(buf)), true)
+	if err != nil {
+		logrus.Errorf("Read repair of %d bytes at offset %d failed, error: %v", len(b), off, err)
+		return false
+	}
+	logrus.Warningf("Repaired corrupted data, %d bytes at offset %d", len(b), off)
+	copy(b, buf)
+	return true
+}
+
+// handleIOError sets the replicas which failed an I/O to ERR and removes
+// them. The I/O paths only hold the controller lock for read, so it is
+// released before calling this.
+func (c *Controller) handleIOError(err error) error {
+	c.Lock()
+	defer c.Unlock()
+	errh := c.handleErrorNoLock(err)
+	if bErr, ok := err.(*BackendError); ok {
+		for address := range bErr.Errors {
+			_ = c.RemoveReplicaNoLock(address)
 		}
-		return n, errh
 	}
-	return n, err
+	return errh
+}
+
+// handleLateWriteError handles a write failed by a replica after it was
+// acknowledged, the region is marked dirty before the replica is removed
+// as the write isn't tracked anymore.
+func (c *Controller) handleLateWriteError(address string, off, length int64, err error) {
+	logrus.Errorf("Replica %s failed a write of %d bytes at offset %d after it was acknowledged", address, length, off)
+	bErr := &BackendError{Errors: map[string]error{address: err}}
+	c.RLock()
+	c.markDirty(bErr, off, length, false)
+	c.RUnlock()
+	_ = c.handleIOError(bErr)
 }
 
 func (c *Controller) handleErrorNoLock(err error) error {
@@ -1145,7 +1358,15 @@ func (c *Controller) reset() {
 	logrus.Infof("resetting controller")
 	c.replicas = []types.Replica{}
 	c.quorumReplicas = []types.Replica{}
-	c.backend = &replicator{}
+	c.backend = &replicator{
+		policy:           c.AckPolicy,
+		onLateError:      c.handleLateWriteError,
+		readPolicy:       c.ReadPolicy,
+		preferredReplica: c.preferredReplica,
+		hedgeAfter:       c.hedgeAfter,
+	}
+	c.dirty = map[string]*dirtyBitmap{}
+	c.cache.clear()
 }
 
 func (c *Controller) Close() error {
@@ -1204,6 +1425,8 @@ func (c *Controller) Shutdown() error {
 		the final piece of data to backend
 	*/
 	logrus.Info("Stopping controller")
+	c.stopSlowReplicaMonitor()
+	c.detachSnapshots()
 	err := c.shutdownFrontend()
 	if err != nil {
 		logrus.Error("Error when shutting down frontend:", err)
@@ -1251,7 +1474,7 @@ func (c *Controller) monitoring(address string, backend types.Backend) {
 }
 
 func (c *Controller) IsReplicaRW(replicaInController *types.Replica) error {
-	repClient, err := replicaClient.NewReplicaClient(replicaInController.Address)
+	repClient, err := c.NewReplicaClient(replicaInController.Address)
 	if err != nil {
 		return err
 	}
@@ -1272,6 +1495,10 @@ func (c *Controller) IsReplicaRW(replicaInController *types.Replica) error {
 func (c *Controller) DeleteSnapshot(snapshot string, replicas []types.Replica) error {
 	var err error
 
+	if c.isExported(snapshot) {
+		return fmt.Errorf("Can't delete snapshot %s, it is exported", snapshot)
+	}
+
 	for _, r := range replicas {
 		replica := r // pin it
 		_, err = c.prepareRemoveSnapshot(&replica, snapshot)
@@ -1284,7 +1511,7 @@ func (c *Controller) DeleteSnapshot(snapshot string, replicas []types.Replica) e
 }
 
 func (c *Controller) rmDisk(replicaInController *types.Replica, disk string) error {
-	repClient, err := replicaClient.NewReplicaClient(replicaInController.Address)
+	repClient, err := c.NewReplicaClient(replicaInController.Address)
 	if err != nil {
 		return err
 	}
@@ -1293,7 +1520,7 @@ func (c *Controller) rmDisk(replicaInController *types.Replica, disk string) err
 }
 
 func (c *Controller) replaceDisk(replicaInController *types.Replica, target, source string) error {
-	repClient, err := replicaClient.NewReplicaClient(replicaInController.Address)
+	repClient, err := c.NewReplicaClient(replicaInController.Address)
 	if err != nil {
 		return err
 	}
@@ -1302,7 +1529,7 @@ func (c *Controller) replaceDisk(replicaInController *types.Replica, target, sou
 }
 
 func (c *Controller) prepareRemoveSnapshot(replicaInController *types.Replica, snapshot string) ([]replica.PrepareRemoveAction, error) {
-	repClient, err := replicaClient.NewReplicaClient(replicaInController.Address)
+	repClient, err := c.NewReplicaClient(replicaInController.Address)
 	if err != nil {
 		return nil, err
 	}
@@ -1320,7 +1547,7 @@ func (c *Controller) processRemoveSnapshot(replicaInController *types.Replica, s
 		return nil
 	}
 
-	repClient, err := replicaClient.NewReplicaClient(replicaInController.Address)
+	repClient, err := c.NewReplicaClient(replicaInController.Address)
 	if err != nil {
 		return err
 	}
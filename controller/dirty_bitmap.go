@@ -0,0 +1,162 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+// dirtyRegionSize is the size of the regions of the volume tracked by a
+// dirty bitmap, a write marks all the regions it overlaps.
+const dirtyRegionSize = 64 << 10
+
+// dirtyBitmap tracks the regions of the volume written while a replica is
+// degraded, so that its rebuild only copies these regions instead of the
+// whole snapshots.
+type dirtyBitmap struct {
+	size   int64
+	bits   []uint64
+	writes int64
+}
+
+func newDirtyBitmap(size int64) *dirtyBitmap {
+	regions := (size + dirtyRegionSize - 1) / dirtyRegionSize
+	return &dirtyBitmap{
+		size: size,
+		bits: make([]uint64, (regions+63)/64),
+	}
+}
+
+func (d *dirtyBitmap) mark(offset, length int64) {
+	if offset < 0 || length <= 0 || offset >= d.size {
+		return
+	}
+	if offset+length > d.size {
+		length = d.size - offset
+	}
+	for region := offset / dirtyRegionSize; region <= (offset+length-1)/dirtyRegionSize; region++ {
+		d.bits[region/64] |= 1 << uint(region%64)
+	}
+}
+
+func (d *dirtyBitmap) isDirty(region int64) bool {
+	return d.bits[region/64]&(1<<uint(region%64)) != 0
+}
+
+// regions returns the dirty regions, contiguous regions are merged
+func (d *dirtyBitmap) regions() []types.Region {
+	var regions []types.Region
+	count := (d.size + dirtyRegionSize - 1) / dirtyRegionSize
+	for region := int64(0); region < count; region++ {
+		if d.bits[region/64] == 0 {
+			region += 63 - region%64
+			continue
+		}
+		if !d.isDirty(region) {
+			continue
+		}
+		offset := region * dirtyRegionSize
+		length := int64(dirtyRegionSize)
+		if offset+length > d.size {
+			length = d.size - offset
+		}
+		if n := len(regions); n > 0 && regions[n-1].Offset+regions[n-1].Length == offset {
+			regions[n-1].Length += length
+			continue
+		}
+		regions = append(regions, types.Region{Offset: offset, Length: length})
+	}
+	return regions
+}
+
+// trackDirtyLocked starts tracking the regions written for the replica, if
+// they aren't tracked yet. It is called with dirtyLock held.
+func (c *Controller) trackDirtyLocked(address string) {
+	if _, ok := c.dirty[address]; ok {
+		return
+	}
+	logrus.Infof("Tracking the regions written while replica %v is degraded", address)
+	c.dirty[address] = newDirtyBitmap(c.size)
+}
+
+// trackDirty is called when a replica leaves RW mode, the writes it misses
+// from now on are tracked until it is rebuilt.
+func (c *Controller) trackDirty(address string) {
+	c.dirtyLock.Lock()
+	defer c.dirtyLock.Unlock()
+	c.trackDirtyLocked(address)
+}
+
+func (c *Controller) untrackDirty(address string) {
+	c.dirtyLock.Lock()
+	defer c.dirtyLock.Unlock()
+	if _, ok := c.dirty[address]; ok {
+		logrus.Infof("Stop tracking the regions written for replica %v", address)
+		delete(c.dirty, address)
+	}
+}
+
+// dropDirty forgets the regions tracked for all the replicas, they will
+// be fully rebuilt.
+func (c *Controller) dropDirty(reason string) {
+	c.dirtyLock.Lock()
+	defer c.dirtyLock.Unlock()
+	for address := range c.dirty {
+		logrus.Warningf("Dropping the regions tracked for replica %v, %s", address, reason)
+	}
+	c.dirty = map[string]*dirtyBitmap{}
+}
+
+// markDirty marks the range written in the bitmaps of the degraded
+// replicas. The RW replicas which failed the write are degraded from now
+// on, they are going to be removed. It is called with the controller lock
+// held for read, before releasing it.
+func (c *Controller) markDirty(err error, offset, length int64, write bool) {
+	c.dirtyLock.Lock()
+	defer c.dirtyLock.Unlock()
+	if bErr, ok := err.(*BackendError); ok {
+		for _, r := range c.replicas {
+			if _, failed := bErr.Errors[r.Address]; failed && r.Mode == types.RW {
+				c.trackDirtyLocked(r.Address)
+			}
+		}
+	}
+	for _, d := range c.dirty {
+		d.mark(offset, length)
+		if write {
+			d.writes++
+		}
+	}
+}
+
+// DirtyRegions returns the regions written since the replica left RW mode
+func (c *Controller) DirtyRegions(address string) (*types.DirtyRegions, error) {
+	c.dirtyLock.Lock()
+	defer c.dirtyLock.Unlock()
+	d, ok := c.dirty[address]
+	if !ok {
+		return nil, fmt.Errorf("Regions written for replica %v are not tracked", address)
+	}
+	return &types.DirtyRegions{
+		RegionSize: dirtyRegionSize,
+		Writes:     d.writes,
+		Regions:    d.regions(),
+	}, nil
+}
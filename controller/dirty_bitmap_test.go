@@ -0,0 +1,83 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openebs/jiva/types"
+)
+
+func TestDirtyBitmap(t *testing.T) {
+	const r = dirtyRegionSize
+	tests := []struct {
+		name     string
+		size     int64
+		writes   [][2]int64
+		expected []types.Region
+	}{
+		{
+			name: "clean",
+			size: 100 * r,
+		},
+		{
+			name:   "write inside a region",
+			size:   100 * r,
+			writes: [][2]int64{{r + 10, 100}},
+			expected: []types.Region{
+				{Offset: r, Length: r},
+			},
+		},
+		{
+			name:   "write across regions",
+			size:   100 * r,
+			writes: [][2]int64{{2*r - 1, 2}},
+			expected: []types.Region{
+				{Offset: r, Length: 2 * r},
+			},
+		},
+		{
+			name:   "contiguous writes are merged",
+			size:   100 * r,
+			writes: [][2]int64{{70 * r, r}, {63 * r, 7 * r}, {5 * r, 4096}},
+			expected: []types.Region{
+				{Offset: 5 * r, Length: r},
+				{Offset: 63 * r, Length: 8 * r},
+			},
+		},
+		{
+			name:   "last region is partial",
+			size:   10*r + 4096,
+			writes: [][2]int64{{10 * r, 8192}, {-1, 10}, {11 * r, 10}},
+			expected: []types.Region{
+				{Offset: 10 * r, Length: 4096},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDirtyBitmap(tt.size)
+			for _, w := range tt.writes {
+				d.mark(w[0], w[1])
+			}
+			if got := d.regions(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("regions() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	replicaClient "github.com/openebs/jiva/replica/client"
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+// observeEpoch raises the epoch of the controller above the one of a
+// replica, until the controller claims its first replica. It doesn't
+// change afterwards, so that a controller replaced by a newer one can't
+// claim the replicas back.
+func (c *Controller) observeEpoch(epoch int64) {
+	c.epochLock.Lock()
+	defer c.epochLock.Unlock()
+	if !c.epochClaimed && epoch >= c.Epoch {
+		c.Epoch = epoch + 1
+	}
+}
+
+// GetEpoch returns the epoch of the controller, 0 until it is known
+func (c *Controller) GetEpoch() int64 {
+	c.epochLock.Lock()
+	defer c.epochLock.Unlock()
+	return c.Epoch
+}
+
+// ClaimedEpoch returns the epoch and the ID the controller claimed the
+// replicas with, the ID is empty until it has claimed one.
+func (c *Controller) ClaimedEpoch() (int64, string) {
+	c.epochLock.Lock()
+	defer c.epochLock.Unlock()
+	if !c.epochClaimed {
+		return 0, ""
+	}
+	return c.Epoch, c.controllerID
+}
+
+// claim makes the controller the owner of the replica with its epoch, the
+// replica rejects the I/Os and the actions of the previous owner from
+// then on.
+func (c *Controller) claim(address string, backend types.Backend) error {
+	epoch, err := backend.GetEpoch()
+	if err != nil {
+		return fmt.Errorf("Failed to get the epoch of replica %s, error: %v", address, err)
+	}
+	c.observeEpoch(epoch)
+
+	c.epochLock.Lock()
+	defer c.epochLock.Unlock()
+	if err := backend.Claim(c.Epoch, c.controllerID); err != nil {
+		return fmt.Errorf("Failed to claim replica %s with epoch %d, error: %v", address, c.Epoch, err)
+	}
+	if !c.epochClaimed {
+		logrus.Infof("Controller %s claimed replica %s with epoch %d", c.controllerID, address, c.Epoch)
+		c.epochClaimed = true
+	}
+	return nil
+}
+
+// NewReplicaClient returns a client of the replica sending the epoch of
+// the controller with its requests.
+func (c *Controller) NewReplicaClient(address string) (*replicaClient.ReplicaClient, error) {
+	client, err := replicaClient.NewReplicaClient(address)
+	if err != nil {
+		return nil, err
+	}
+	c.epochLock.Lock()
+	if c.epochClaimed {
+		client.SetEpoch(c.Epoch, c.controllerID)
+	}
+	c.epochLock.Unlock()
+	return client, nil
+}
@@ -0,0 +1,101 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// eventBufferSize is the number of events a subscriber can lag
+	// behind before it is dropped.
+	eventBufferSize = 256
+	// eventHistorySize is the number of past events kept for the
+	// subscribers catching up.
+	eventHistorySize = 128
+)
+
+// eventBroker sends the events of the volume to the subscribers, it never
+// blocks the controller: the subscribers not keeping up are dropped.
+type eventBroker struct {
+	sync.Mutex
+	lastID      int64
+	history     []types.Event
+	subscribers map[chan types.Event]struct{}
+}
+
+func (b *eventBroker) publish(e types.Event) {
+	b.Lock()
+	defer b.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	e.Time = time.Now().UTC()
+	if len(b.history) == eventHistorySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, e)
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			logrus.Warningf("Dropping events subscriber lagging behind by %d events", len(ch))
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) subscribe(after int64) ([]types.Event, <-chan types.Event, func()) {
+	ch := make(chan types.Event, eventBufferSize)
+	b.Lock()
+	var past []types.Event
+	for _, e := range b.history {
+		if e.ID > after {
+			past = append(past, e)
+		}
+	}
+	if b.subscribers == nil {
+		b.subscribers = map[chan types.Event]struct{}{}
+	}
+	b.subscribers[ch] = struct{}{}
+	b.Unlock()
+
+	return past, ch, func() {
+		b.Lock()
+		defer b.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the recent events of the volume whose ID is greater
+// than after, the channel of the events from now on and a function to
+// stop receiving them. The channel is closed if the subscriber doesn't
+// keep up with the events.
+func (c *Controller) Subscribe(after int64) ([]types.Event, <-chan types.Event, func()) {
+	return c.events.subscribe(after)
+}
+
+func (c *Controller) publishReplicaEvent(t types.EventType, address string, mode types.Mode) {
+	c.events.publish(types.Event{Type: t, Replica: address, Mode: string(mode)})
+}
@@ -0,0 +1,61 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/openebs/jiva/types"
+)
+
+func TestEventBroker(t *testing.T) {
+	b := &eventBroker{}
+	for i := 0; i < eventHistorySize+2; i++ {
+		b.publish(types.Event{Type: types.EventCheckpoint})
+	}
+
+	past, events, unsubscribe := b.subscribe(0)
+	if len(past) != eventHistorySize || past[0].ID != 3 {
+		t.Fatalf("got %d past events from %d, expected %d from 3", len(past), past[0].ID, eventHistorySize)
+	}
+	if past, _, stop := b.subscribe(eventHistorySize + 1); len(past) != 1 {
+		t.Errorf("got %d past events after %d, expected 1", len(past), eventHistorySize+1)
+	} else {
+		stop()
+	}
+
+	b.publish(types.Event{Type: types.EventReplicaMode, Replica: "tcp://a:9502", Mode: "RW"})
+	if e := <-events; e.ID != eventHistorySize+3 || e.Type != types.EventReplicaMode || e.Time.IsZero() {
+		t.Errorf("got event %+v", e)
+	}
+
+	// a subscriber not keeping up is dropped instead of blocking
+	for i := 0; i <= eventBufferSize; i++ {
+		b.publish(types.Event{Type: types.EventCheckpoint})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Errorf("got %d events before being dropped, expected %d", n, eventBufferSize)
+	}
+	unsubscribe()
+	if len(b.subscribers) != 0 {
+		t.Errorf("%d subscribers left", len(b.subscribers))
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openebs/jiva/replica"
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// exportBlockSize is the alignment of the ranges of the snapshots
+	// read from the replicas
+	exportBlockSize = 4096
+	// exportReadLength is the largest range read at once from a replica
+	exportReadLength = 4 << 20
+)
+
+// FrontendFactory creates a frontend serving a snapshot export
+type FrontendFactory func() types.Frontend
+
+// SnapshotExport is a read only view of a snapshot served by a frontend,
+// while the volume keeps serving the I/Os of its own frontend.
+type SnapshotExport struct {
+	Snapshot string
+	Frontend string
+	Address  string
+}
+
+type snapshotExport struct {
+	SnapshotExport
+	frontend types.Frontend
+}
+
+// addressSetter is implemented by the frontends whose listen address can
+// be set.
+type addressSetter interface {
+	SetAddress(address string)
+}
+
+// WithExportFrontends sets the frontends the snapshots can be exported
+// with, by name.
+func WithExportFrontends(factories map[string]FrontendFactory) BuildOpts {
+	return func(c *Controller) {
+		c.exportFrontends = factories
+	}
+}
+
+// snapshotDiskName returns the disk of the snapshot, the name may be
+// either the one of the snapshot or of its disk.
+func snapshotDiskName(name string) string {
+	if strings.HasPrefix(name, "volume-snap-") && strings.HasSuffix(name, ".img") {
+		return name
+	}
+	return replica.GenerateSnapshotDiskName(name)
+}
+
+// AttachSnapshot starts a frontend serving the data of the snapshot read
+// only, on address if it isn't empty.
+func (c *Controller) AttachSnapshot(snapshot, frontendName, address string) (SnapshotExport, error) {
+	disk := snapshotDiskName(snapshot)
+	export := SnapshotExport{
+		Snapshot: strings.TrimSuffix(strings.TrimPrefix(disk, "volume-snap-"), ".img"),
+		Frontend: frontendName,
+		Address:  address,
+	}
+
+	factory, ok := c.exportFrontends[frontendName]
+	if !ok {
+		return export, fmt.Errorf("Frontend %s can't export snapshots", frontendName)
+	}
+	frontend := factory()
+	if address != "" {
+		f, ok := frontend.(addressSetter)
+		if !ok {
+			return export, fmt.Errorf("Frontend %s doesn't support setting its address", frontendName)
+		}
+		f.SetAddress(address)
+	}
+
+	c.RLock()
+	name, frontendIP, clusterIP := c.Name, c.frontendIP, c.clusterIP
+	size, sectorSize := c.size, c.sectorSize
+	c.RUnlock()
+	r := &snapshotReader{c: c, disk: disk}
+	if err := r.check(); err != nil {
+		return export, err
+	}
+
+	c.exportLock.Lock()
+	defer c.exportLock.Unlock()
+	if _, ok := c.exports[disk]; ok {
+		return export, fmt.Errorf("Snapshot %s is already exported", export.Snapshot)
+	}
+	if err := frontend.Startup(name+"-"+export.Snapshot, frontendIP, clusterIP, size, sectorSize, r); err != nil {
+		return export, fmt.Errorf("Failed to export snapshot %s with frontend %s, error: %v", export.Snapshot, frontendName, err)
+	}
+	if c.exports == nil {
+		c.exports = map[string]*snapshotExport{}
+	}
+	c.exports[disk] = &snapshotExport{SnapshotExport: export, frontend: frontend}
+	logrus.Infof("Exported snapshot %s read only with frontend %s at %q", export.Snapshot, frontendName, address)
+	return export, nil
+}
+
+// DetachSnapshot stops the frontend serving the snapshot
+func (c *Controller) DetachSnapshot(snapshot string) error {
+	disk := snapshotDiskName(snapshot)
+
+	c.exportLock.Lock()
+	defer c.exportLock.Unlock()
+	export, ok := c.exports[disk]
+	if !ok {
+		return fmt.Errorf("Snapshot %s is not exported", snapshot)
+	}
+	if err := export.frontend.Shutdown(); err != nil {
+		return fmt.Errorf("Failed to stop the export of snapshot %s, error: %v", export.Snapshot, err)
+	}
+	delete(c.exports, disk)
+	logrus.Infof("Stopped the export of snapshot %s", export.Snapshot)
+	return nil
+}
+
+// SnapshotExports returns the exported snapshots sorted by name
+func (c *Controller) SnapshotExports() []SnapshotExport {
+	c.exportLock.Lock()
+	defer c.exportLock.Unlock()
+	exports := make([]SnapshotExport, 0, len(c.exports))
+	for _, export := range c.exports {
+		exports = append(exports, export.SnapshotExport)
+	}
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].Snapshot < exports[j].Snapshot
+	})
+	return exports
+}
+
+func (c *Controller) isExported(snapshot string) bool {
+	c.exportLock.Lock()
+	defer c.exportLock.Unlock()
+	_, ok := c.exports[snapshotDiskName(snapshot)]
+	return ok
+}
+
+// detachSnapshots stops all the exports, the volume is shutting down
+func (c *Controller) detachSnapshots() {
+	c.exportLock.Lock()
+	defer c.exportLock.Unlock()
+	for disk, export := range c.exports {
+		if err := export.frontend.Shutdown(); err != nil {
+			logrus.Warningf("Failed to stop the export of snapshot %s, error: %v", export.Snapshot, err)
+		}
+		delete(c.exports, disk)
+	}
+}
+
+// snapshotReader reads a snapshot from the RW replicas, it is the backend
+// of the frontend exporting the snapshot and rejects the writes.
+type snapshotReader struct {
+	c    *Controller
+	disk string
+}
+
+// addresses returns the RW replicas the snapshot can be read from
+func (r *snapshotReader) addresses() []string {
+	r.c.RLock()
+	defer r.c.RUnlock()
+	var addresses []string
+	for _, rep := range r.c.replicas {
+		if rep.Mode == types.RW && strings.HasPrefix(rep.Address, "tcp://") {
+			addresses = append(addresses, rep.Address)
+		}
+	}
+	return addresses
+}
+
+// check returns an error if the snapshot isn't in the chain of a RW
+// replica.
+func (r *snapshotReader) check() error {
+	addresses := r.addresses()
+	if len(addresses) == 0 {
+		return fmt.Errorf("Can't export snapshot %s, no RW replica", r.disk)
+	}
+	for _, address := range addresses {
+		client, err := r.c.NewReplicaClient(address)
+		if err != nil {
+			return err
+		}
+		rep, err := client.GetReplica()
+		if err != nil {
+			logrus.Warningf("Failed to get replica %s, error: %v", address, err)
+			continue
+		}
+		for i, disk := range rep.Chain {
+			if i > 0 && disk == r.disk {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Failed to find snapshot %s in the chain of the RW replicas", r.disk)
+}
+
+// read reads an aligned range of the snapshot from the first RW replica
+// serving it.
+func (r *snapshotReader) read(offset, length int64) ([]byte, error) {
+	err := fmt.Errorf("Can't read snapshot %s, no RW replica", r.disk)
+	for _, address := range r.addresses() {
+		client, cerr := r.c.NewReplicaClient(address)
+		if cerr != nil {
+			err = cerr
+			continue
+		}
+		data, rerr := client.ReadSnapshot(r.disk, offset, length)
+		if rerr == nil {
+			return data, nil
+		}
+		logrus.Warningf("Failed to read %d bytes at offset %d of snapshot %s from %s, error: %v",
+			length, offset, r.disk, address, rerr)
+		err = rerr
+	}
+	return nil, err
+}
+
+func (r *snapshotReader) ReadAt(buf []byte, off int64) (int, error) {
+	end := off + int64(len(buf))
+	start := off - off%exportBlockSize
+	if rem := end % exportBlockSize; rem != 0 {
+		end += exportBlockSize - rem
+	}
+	for pos := start; pos < end; pos += exportReadLength {
+		length := end - pos
+		if length > exportReadLength {
+			length = exportReadLength
+		}
+		data, err := r.read(pos, length)
+		if err != nil {
+			return 0, err
+		}
+		// copy the part of the range overlapping buf
+		from, to := pos, pos+length
+		if from < off {
+			from = off
+		}
+		if to > off+int64(len(buf)) {
+			to = off + int64(len(buf))
+		}
+		copy(buf[from-off:to-off], data[from-pos:to-pos])
+	}
+	return len(buf), nil
+}
+
+func (r *snapshotReader) WriteAt(buf []byte, off int64) (int, error) {
+	return 0, fmt.Errorf("Snapshot %s is exported read only", r.disk)
+}
+
+func (r *snapshotReader) Unmap(off int64, length int64) (int, error) {
+	return 0, fmt.Errorf("Snapshot %s is exported read only", r.disk)
+}
+
+func (r *snapshotReader) Sync() (int, error) {
+	return 0, nil
+}
+
+func (r *snapshotReader) Close() error {
+	return nil
+}
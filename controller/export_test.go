@@ -0,0 +1,93 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/openebs/jiva/replica"
+	"github.com/openebs/jiva/replica/rest"
+	"github.com/openebs/jiva/types"
+	"github.com/openebs/jiva/util"
+)
+
+func TestSnapshotReaderDuplicateBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := replica.NewServer("tcp://127.0.0.1:9502", dir, 512, "Backend")
+	if err := s.Create(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	// Server.Close waits for the holes to be punched by CreateHoles, which
+	// isn't running
+	defer s.Replica().Close()
+	if err := s.SetReplicaMode("RW"); err != nil {
+		t.Fatal(err)
+	}
+	// the block 0 is written in two auto-created snapshots, and in the
+	// head
+	for i, name := range []string{"000", "001", ""} {
+		if _, err := s.WriteAt(bytes.Repeat([]byte{byte(i + 1)}, exportBlockSize), 0); err != nil {
+			t.Fatal(err)
+		}
+		if name == "" {
+			break
+		}
+		if err := s.Snapshot(name, false, util.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	punchHoles := types.ShouldPunchHoles
+	types.ShouldPunchHoles = true
+	defer func() { types.ShouldPunchHoles = punchHoles }()
+	holes := len(replica.HoleCreatorChan)
+
+	srv := httptest.NewServer(rest.NewRouter(rest.NewServer(s)))
+	defer srv.Close()
+	c := &Controller{
+		replicas: []types.Replica{{Address: "tcp://" + strings.TrimPrefix(srv.URL, "http://"), Mode: types.RW}},
+	}
+	r := &snapshotReader{c: c, disk: snapshotDiskName("001")}
+	if err := r.check(); err != nil {
+		t.Fatalf("check() failed: %v", err)
+	}
+	buf := make([]byte, 2*exportBlockSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt() failed: %v", err)
+	}
+	expected := append(bytes.Repeat([]byte{2}, exportBlockSize), make([]byte, exportBlockSize)...)
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("exported snapshot differs from the data written")
+	}
+	// the export doesn't punch the files of the replica
+	if n := len(replica.HoleCreatorChan); n != holes {
+		t.Errorf("exporting the snapshot queued %d holes", n-holes)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/openebs/jiva/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ioCounters count the I/Os issued by the frontend and their bytes, the
+// counters are indexed by the direction, 0 for reads and 1 for writes.
+type ioCounters struct {
+	ops   [2]int64
+	bytes [2]int64
+}
+
+func (s *ioCounters) add(write bool, length int64) {
+	i := 0
+	if write {
+		i = 1
+	}
+	atomic.AddInt64(&s.ops[i], 1)
+	atomic.AddInt64(&s.bytes[i], length)
+}
+
+// replicaModes are the modes reported for each replica, the one the
+// replica is in is set to 1 and the others to 0.
+var replicaModes = []types.Mode{types.RW, types.WO, types.ERR}
+
+// collector exports the state of the volume to Prometheus, it is read
+// from the controller on each scrape.
+type collector struct {
+	c *Controller
+
+	readOnly        *prometheus.Desc
+	size            *prometheus.Desc
+	replicaMode     *prometheus.Desc
+	rwReplicas      *prometheus.Desc
+	degraded        *prometheus.Desc
+	replicaLatency  *prometheus.Desc
+	ios             *prometheus.Desc
+	ioBytes         *prometheus.Desc
+	cacheHits       *prometheus.Desc
+	cacheMisses     *prometheus.Desc
+	cachedBytes     *prometheus.Desc
+	throttledIOs    *prometheus.Desc
+	throttleSeconds *prometheus.Desc
+	exports         *prometheus.Desc
+}
+
+// NewCollector returns the collector of the metrics of the volume served
+// by the controller.
+func NewCollector(c *Controller) prometheus.Collector {
+	volume := []string{"volume"}
+	return &collector{
+		c: c,
+		readOnly: prometheus.NewDesc("openebs_jiva_volume_read_only",
+			"1 if the volume is read only, not having a quorum of RW replicas.", volume, nil),
+		size: prometheus.NewDesc("openebs_jiva_volume_size_bytes",
+			"Size of the volume.", volume, nil),
+		replicaMode: prometheus.NewDesc("openebs_jiva_volume_replica_mode",
+			"1 for the mode the replica is in, RW, WO while rebuilding or ERR.", []string{"volume", "replica", "mode"}, nil),
+		rwReplicas: prometheus.NewDesc("openebs_jiva_volume_rw_replicas",
+			"Number of the RW replicas, quorum replicas included.", volume, nil),
+		degraded: prometheus.NewDesc("openebs_jiva_volume_replica_degraded",
+			"1 if the replica is removed from the read set for being slower than its peers.", []string{"volume", "replica"}, nil),
+		replicaLatency: prometheus.NewDesc("openebs_jiva_volume_replica_latency_seconds",
+			"90th percentile of the latency of the I/Os of the replica at the last check of the slow replicas.", []string{"volume", "replica"}, nil),
+		ios: prometheus.NewDesc("openebs_jiva_volume_ios_total",
+			"Number of the I/Os issued to the volume.", []string{"volume", "op"}, nil),
+		ioBytes: prometheus.NewDesc("openebs_jiva_volume_io_bytes_total",
+			"Bytes read from and written to the volume.", []string{"volume", "op"}, nil),
+		cacheHits: prometheus.NewDesc("openebs_jiva_volume_read_cache_hits_total",
+			"Number of the reads served by the read cache.", volume, nil),
+		cacheMisses: prometheus.NewDesc("openebs_jiva_volume_read_cache_misses_total",
+			"Number of the reads not served by the read cache.", volume, nil),
+		cachedBytes: prometheus.NewDesc("openebs_jiva_volume_read_cache_bytes",
+			"Bytes held by the read cache.", volume, nil),
+		throttledIOs: prometheus.NewDesc("openebs_jiva_volume_throttled_ios_total",
+			"Number of the I/Os delayed by the QoS limits.", []string{"volume", "op"}, nil),
+		throttleSeconds: prometheus.NewDesc("openebs_jiva_volume_throttle_seconds_total",
+			"Time the I/Os were delayed by the QoS limits.", []string{"volume", "op"}, nil),
+		exports: prometheus.NewDesc("openebs_jiva_volume_snapshot_exports",
+			"Number of the snapshots exported read only.", volume, nil),
+	}
+}
+
+func (m *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.readOnly
+	ch <- m.size
+	ch <- m.replicaMode
+	ch <- m.rwReplicas
+	ch <- m.degraded
+	ch <- m.replicaLatency
+	ch <- m.ios
+	ch <- m.ioBytes
+	ch <- m.cacheHits
+	ch <- m.cacheMisses
+	ch <- m.cachedBytes
+	ch <- m.throttledIOs
+	ch <- m.throttleSeconds
+	ch <- m.exports
+}
+
+func (m *collector) Collect(ch chan<- prometheus.Metric) {
+	c := m.c
+	c.RLock()
+	name, readOnly, size, rwReplicas := c.Name, c.ReadOnly, c.size, c.RWReplicaCount
+	replicas := append(append([]types.Replica{}, c.replicas...), c.quorumReplicas...)
+	degraded := map[string]bool{}
+	latencies := map[string]time.Duration{}
+	for _, r := range c.replicas {
+		degraded[r.Address] = c.IsDegraded(r.Address)
+		latencies[r.Address] = c.replicaLatency(r.Address)
+	}
+	c.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(m.readOnly, prometheus.GaugeValue, boolValue(readOnly), name)
+	ch <- prometheus.MustNewConstMetric(m.size, prometheus.GaugeValue, float64(size), name)
+	ch <- prometheus.MustNewConstMetric(m.rwReplicas, prometheus.GaugeValue, float64(rwReplicas), name)
+	for _, r := range replicas {
+		for _, mode := range replicaModes {
+			ch <- prometheus.MustNewConstMetric(m.replicaMode, prometheus.GaugeValue,
+				boolValue(r.Mode == mode), name, r.Address, string(mode))
+		}
+	}
+	for address, d := range degraded {
+		ch <- prometheus.MustNewConstMetric(m.degraded, prometheus.GaugeValue, boolValue(d), name, address)
+		if c.slowReplicaPolicy.Factor > 0 {
+			ch <- prometheus.MustNewConstMetric(m.replicaLatency, prometheus.GaugeValue,
+				latencies[address].Seconds(), name, address)
+		}
+	}
+
+	for i, op := range []string{"read", "write"} {
+		ch <- prometheus.MustNewConstMetric(m.ios, prometheus.CounterValue,
+			float64(atomic.LoadInt64(&c.ioCounters.ops[i])), name, op)
+		ch <- prometheus.MustNewConstMetric(m.ioBytes, prometheus.CounterValue,
+			float64(atomic.LoadInt64(&c.ioCounters.bytes[i])), name, op)
+	}
+
+	hits, misses, cached := c.CacheStats()
+	ch <- prometheus.MustNewConstMetric(m.cacheHits, prometheus.CounterValue, float64(hits), name)
+	ch <- prometheus.MustNewConstMetric(m.cacheMisses, prometheus.CounterValue, float64(misses), name)
+	ch <- prometheus.MustNewConstMetric(m.cachedBytes, prometheus.GaugeValue, float64(cached), name)
+
+	qos := c.QoSStats()
+	ch <- prometheus.MustNewConstMetric(m.throttledIOs, prometheus.CounterValue, float64(qos.ThrottledReads), name, "read")
+	ch <- prometheus.MustNewConstMetric(m.throttledIOs, prometheus.CounterValue, float64(qos.ThrottledWrites), name, "write")
+	ch <- prometheus.MustNewConstMetric(m.throttleSeconds, prometheus.CounterValue, qos.ReadThrottleTime.Seconds(), name, "read")
+	ch <- prometheus.MustNewConstMetric(m.throttleSeconds, prometheus.CounterValue, qos.WriteThrottleTime.Seconds(), name, "write")
+
+	ch <- prometheus.MustNewConstMetric(m.exports, prometheus.GaugeValue, float64(len(c.SnapshotExports())), name)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
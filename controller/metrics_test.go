@@ -0,0 +1,63 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/openebs/jiva/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewController(WithName("vol"))
+	c.size = 1 << 20
+	c.replicas = []types.Replica{
+		{Address: "tcp://a:9502", Mode: types.RW},
+		{Address: "tcp://b:9502", Mode: types.WO},
+	}
+	c.ioCounters.add(false, 4096)
+	c.ioCounters.add(true, 512)
+	c.ioCounters.add(true, 512)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewCollector(c))
+	expected := `
+# HELP openebs_jiva_volume_io_bytes_total Bytes read from and written to the volume.
+# TYPE openebs_jiva_volume_io_bytes_total counter
+openebs_jiva_volume_io_bytes_total{op="read",volume="vol"} 4096
+openebs_jiva_volume_io_bytes_total{op="write",volume="vol"} 1024
+# HELP openebs_jiva_volume_read_only 1 if the volume is read only, not having a quorum of RW replicas.
+# TYPE openebs_jiva_volume_read_only gauge
+openebs_jiva_volume_read_only{volume="vol"} 1
+# HELP openebs_jiva_volume_replica_mode 1 for the mode the replica is in, RW, WO while rebuilding or ERR.
+# TYPE openebs_jiva_volume_replica_mode gauge
+openebs_jiva_volume_replica_mode{mode="ERR",replica="tcp://a:9502",volume="vol"} 0
+openebs_jiva_volume_replica_mode{mode="ERR",replica="tcp://b:9502",volume="vol"} 0
+openebs_jiva_volume_replica_mode{mode="RW",replica="tcp://a:9502",volume="vol"} 1
+openebs_jiva_volume_replica_mode{mode="RW",replica="tcp://b:9502",volume="vol"} 0
+openebs_jiva_volume_replica_mode{mode="WO",replica="tcp://a:9502",volume="vol"} 0
+openebs_jiva_volume_replica_mode{mode="WO",replica="tcp://b:9502",volume="vol"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"openebs_jiva_volume_io_bytes_total", "openebs_jiva_volume_read_only", "openebs_jiva_volume_replica_mode")
+	if err != nil {
+		t.Error(err)
+	}
+}
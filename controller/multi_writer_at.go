@@ -20,14 +20,33 @@
 package controller
 
 import (
-	"io"
+	"container/list"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type MultiWriterAt struct {
 	writers  []Writer
 	updaters []Writer
+	// queues order the writes and unmaps of each writer, a write
+	// acknowledged before a writer completed it stays in its queue
+	// until it is completed.
+	queues []*rangeLock
+	// latencies record the latency of the writes of each writer, if set
+	latencies []*latencyWindow
+	policy    AckPolicy
+	// lateError is called with the index of a writer which failed a
+	// write after it was acknowledged.
+	lateError func(index int, off, length int64, err error)
+}
+
+type writeResult struct {
+	index   int
+	updater bool
+	err     error
 }
 
 type MultiWriterError struct {
@@ -68,37 +87,85 @@ func (m *MultiWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
 	quorumErrCount := 0
 	quorumErrored := false
 	replicaErrored := false
-	wg := sync.WaitGroup{}
 	var errors MultiWriterError
-	var multiWriterMtx sync.Mutex
+
+	data := p
+	if m.policy != AckAll {
+		// the caller may reuse p once the write is acknowledged
+		data = make([]byte, len(p))
+		copy(data, p)
+	}
+	length := int64(len(p))
+	results := make(chan writeResult, len(m.writers)+len(m.updaters))
+	pending := len(m.updaters)
+	// required are the writers lagging too much to acknowledge the
+	// write without them
+	required := make([]bool, len(m.writers))
+	requiredCount := 0
 
 	for i, w := range m.writers {
-		wg.Add(1)
-		go func(index int, w io.WriterAt) {
-			_, err := w.WriteAt(p, off)
-			if err != nil {
-				multiWriterMtx.Lock()
-				replicaErrored = true
-				replicaErrs[index] = err
-				multiWriterMtx.Unlock()
+		if m.policy != AckAll && m.queues[i].pending() >= lagWindow {
+			if m.policy == AckMajorityAsync {
+				replicaErrs[i] = errLagging
+				continue
 			}
-			wg.Done()
-		}(i, w)
+			required[i] = true
+			requiredCount++
+		}
+		pending++
+		elem := m.queues[i].add(off, length, true)
+		go func(index int, w Writer, elem *list.Element) {
+			m.queues[index].wait(elem)
+			start := time.Now()
+			_, err := w.WriteAt(data, off)
+			if err == nil && m.latencies != nil {
+				m.latencies[index].add(time.Since(start))
+			}
+			m.queues[index].release(elem)
+			results <- writeResult{index: index, err: err}
+		}(i, w, elem)
 	}
 	for i, w := range m.updaters {
-		wg.Add(1)
 		go func(index int, w Writer) {
 			_, err := w.WriteAt(nil, 0)
-			if err != nil {
-				multiWriterMtx.Lock()
-				quorumErrored = true
-				quorumErrs[index] = err
-				multiWriterMtx.Unlock()
-			}
-			wg.Done()
+			results <- writeResult{index: index, updater: true, err: err}
 		}(i, w)
 	}
-	wg.Wait()
+
+	replicaDone, quorumDone := 0, 0
+	for ; pending > 0; pending-- {
+		if m.policy != AckAll && requiredCount == 0 && m.isMajority(replicaDone, quorumDone) {
+			break
+		}
+		res := <-results
+		switch {
+		case res.updater && res.err != nil:
+			quorumErrs[res.index] = res.err
+		case res.updater:
+			quorumDone++
+		case res.err != nil:
+			replicaErrs[res.index] = res.err
+		default:
+			replicaDone++
+		}
+		if !res.updater && required[res.index] {
+			requiredCount--
+		}
+	}
+	if pending > 0 {
+		go m.collectLate(results, pending, off, length)
+	}
+
+	for _, err1 := range replicaErrs {
+		if err1 != nil {
+			replicaErrored = true
+		}
+	}
+	for _, err1 := range quorumErrs {
+		if err1 != nil {
+			quorumErrored = true
+		}
+	}
 	if replicaErrored {
 		errors.Writers = m.writers
 		errors.ReplicaErrors = replicaErrs
@@ -130,6 +197,29 @@ func (m *MultiWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
 	return len(p), nil
 }
 
+// isMajority returns true if the writes completed by the writers and the
+// updaters are enough to acknowledge a write.
+func (m *MultiWriterAt) isMajority(replicaDone, quorumDone int) bool {
+	return replicaDone > len(m.writers)/2 &&
+		replicaDone+quorumDone > (len(m.writers)+len(m.updaters))/2
+}
+
+// collectLate waits for the writes still in flight once a write has been
+// acknowledged, and reports their errors.
+func (m *MultiWriterAt) collectLate(results <-chan writeResult, pending int, off, length int64) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		switch {
+		case res.err == nil:
+		case res.updater:
+			logrus.Errorf("Quorum replica failed a write of %d bytes at offset %d after it was acknowledged, error: %v",
+				length, off, res.err)
+		case m.lateError != nil:
+			m.lateError(res.index, off, length, res.err)
+		}
+	}
+}
+
 func (m *MultiWriterAt) Sync() (int, error) {
 	replicaErrs := make([]error, len(m.writers))
 	replicaErrCount := 0
@@ -184,8 +274,11 @@ func (m *MultiWriterAt) Unmap(offset int64, length int64) (int, error) {
 
 	for i, w := range m.writers {
 		wg.Add(1)
-		go func(index int, w Writer) {
+		elem := m.queues[i].add(offset, length, true)
+		go func(index int, w Writer, elem *list.Element) {
+			m.queues[index].wait(elem)
 			_, err := w.Unmap(offset, length)
+			m.queues[index].release(elem)
 			if err != nil {
 				multiWriterMtx.Lock()
 				replicaErrored = true
@@ -193,7 +286,7 @@ func (m *MultiWriterAt) Unmap(offset int64, length int64) (int, error) {
 				multiWriterMtx.Unlock()
 			}
 			wg.Done()
-		}(i, w)
+		}(i, w, elem)
 	}
 	wg.Wait()
 	if replicaErrored {
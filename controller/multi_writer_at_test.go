@@ -0,0 +1,153 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedWriter records the writes, which wait for the gate to be closed if
+// it is set.
+type gatedWriter struct {
+	sync.Mutex
+	gate   chan struct{}
+	err    error
+	writes []byte
+}
+
+func (w *gatedWriter) WriteAt(p []byte, off int64) (int, error) {
+	if w.gate != nil {
+		<-w.gate
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, p[0])
+	return len(p), nil
+}
+
+func (w *gatedWriter) Sync() (int, error) {
+	return 0, nil
+}
+
+func (w *gatedWriter) Unmap(off, length int64) (int, error) {
+	return 0, nil
+}
+
+func (w *gatedWriter) written() []byte {
+	w.Lock()
+	defer w.Unlock()
+	return append([]byte(nil), w.writes...)
+}
+
+func newMultiWriter(policy AckPolicy, writers ...*gatedWriter) *MultiWriterAt {
+	m := &MultiWriterAt{policy: policy}
+	for _, w := range writers {
+		m.writers = append(m.writers, w)
+		m.queues = append(m.queues, newRangeLock())
+	}
+	return m
+}
+
+func TestAckPolicy(t *testing.T) {
+	tests := []struct {
+		policy AckPolicy
+		// acked is true if the write is acknowledged while the slow
+		// writer hasn't completed it
+		acked bool
+	}{
+		{policy: AckAll, acked: false},
+		{policy: AckMajority, acked: true},
+		{policy: AckMajorityAsync, acked: true},
+	}
+	for _, tt := range tests {
+		slow := &gatedWriter{gate: make(chan struct{})}
+		m := newMultiWriter(tt.policy, &gatedWriter{}, &gatedWriter{}, slow)
+		done := make(chan error, 1)
+		go func() {
+			_, err := m.WriteAt([]byte{1}, 0)
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if !tt.acked || err != nil {
+				t.Fatalf("%s: write returned before the slow writer completed it, error: %v", tt.policy, err)
+			}
+			close(slow.gate)
+		case <-time.After(lockWait):
+			if tt.acked {
+				t.Fatalf("%s: write waited for the slow writer", tt.policy)
+			}
+			close(slow.gate)
+			if err := <-done; err != nil {
+				t.Fatalf("%s: write failed: %v", tt.policy, err)
+			}
+		}
+		m.queues[2].lock(0, 1, false)()
+		if got := slow.written(); len(got) != 1 {
+			t.Fatalf("%s: slow writer got %v", tt.policy, got)
+		}
+	}
+}
+
+func TestLaggingWriter(t *testing.T) {
+	slow := &gatedWriter{gate: make(chan struct{}), err: errors.New("failed")}
+	m := newMultiWriter(AckMajority, &gatedWriter{}, &gatedWriter{}, slow)
+	lateErrors := make(chan int, lagWindow)
+	m.lateError = func(index int, off, length int64, err error) {
+		lateErrors <- index
+	}
+
+	for i := 0; i < lagWindow; i++ {
+		if n, err := m.WriteAt([]byte{byte(i)}, 0); n != 1 || err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+	if pending := m.queues[2].pending(); pending != lagWindow {
+		t.Fatalf("Slow writer has %d writes in flight, expected %d", pending, lagWindow)
+	}
+
+	// the window of the slow writer is full
+	async := newMultiWriter(AckMajorityAsync, m.writers[0].(*gatedWriter), m.writers[1].(*gatedWriter), slow)
+	async.queues = m.queues
+	n, err := async.WriteAt([]byte{0}, 0)
+	mErr, ok := err.(*MultiWriterError)
+	if n != 1 || !ok || mErr.ReplicaErrors[2] != errLagging {
+		t.Fatalf("Write with a lagging writer returned %d, %v", n, err)
+	}
+
+	close(slow.gate)
+	for i := 0; i < lagWindow; i++ {
+		if index := <-lateErrors; index != 2 {
+			t.Fatalf("Late error of writer %d, expected 2", index)
+		}
+	}
+	for _, w := range m.writers[:2] {
+		got := w.(*gatedWriter).written()
+		for i := 0; i < lagWindow; i++ {
+			if got[i] != byte(i) {
+				t.Fatalf("Writes completed out of order: %v", got)
+			}
+		}
+	}
+}
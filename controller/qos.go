@@ -0,0 +1,164 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultQoSBurst is the burst of the limits if it isn't set
+const defaultQoSBurst = time.Second
+
+// QoSLimits are the limits of the I/Os of the volume, 0 is unlimited
+type QoSLimits struct {
+	ReadIOPS  int64
+	WriteIOPS int64
+	// ReadBPS and WriteBPS are in bytes per second
+	ReadBPS  int64
+	WriteBPS int64
+	// Burst is the duration of I/Os at the limits which can be issued at
+	// once after being idle.
+	Burst time.Duration
+}
+
+// QoSStats are the I/Os delayed by the limits and for how long
+type QoSStats struct {
+	ThrottledReads    int64
+	ThrottledWrites   int64
+	ReadThrottleTime  time.Duration
+	WriteThrottleTime time.Duration
+}
+
+// WithQoS limits the I/Os of the volume
+func WithQoS(limits QoSLimits) BuildOpts {
+	return func(c *Controller) {
+		c.limiter.set(limits)
+	}
+}
+
+// tokenBucket allows rate tokens per second, up to size at once. The
+// tokens may go below 0 so that an I/O larger than the bucket is delayed
+// instead of being rejected.
+type tokenBucket struct {
+	rate   float64
+	size   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64, burst time.Duration) tokenBucket {
+	size := math.Max(float64(rate)*burst.Seconds(), 1)
+	return tokenBucket{rate: float64(rate), size: size, tokens: size}
+}
+
+// reserve takes n tokens and returns how long to wait until they are
+// available.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate == 0 {
+		return 0
+	}
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.size, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// ioLimiter delays the reads and the writes exceeding the limits, the
+// buckets are indexed by the direction, 0 for reads and 1 for writes.
+type ioLimiter struct {
+	sync.Mutex
+	limits       QoSLimits
+	iops         [2]tokenBucket
+	bps          [2]tokenBucket
+	throttled    [2]int64
+	throttleTime [2]time.Duration
+}
+
+func (l *ioLimiter) set(limits QoSLimits) {
+	if limits.Burst == 0 {
+		limits.Burst = defaultQoSBurst
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.limits = limits
+	l.iops[0] = newTokenBucket(limits.ReadIOPS, limits.Burst)
+	l.iops[1] = newTokenBucket(limits.WriteIOPS, limits.Burst)
+	l.bps[0] = newTokenBucket(limits.ReadBPS, limits.Burst)
+	l.bps[1] = newTokenBucket(limits.WriteBPS, limits.Burst)
+}
+
+// wait returns once an I/O of length bytes is within the limits
+func (l *ioLimiter) wait(write bool, length int64) {
+	i := 0
+	if write {
+		i = 1
+	}
+	l.Lock()
+	now := time.Now()
+	wait := l.iops[i].reserve(1, now)
+	if w := l.bps[i].reserve(float64(length), now); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		l.throttled[i]++
+		l.throttleTime[i] += wait
+	}
+	l.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// SetQoS changes the limits of the I/Os of the volume
+func (c *Controller) SetQoS(limits QoSLimits) error {
+	if limits.ReadIOPS < 0 || limits.WriteIOPS < 0 || limits.ReadBPS < 0 ||
+		limits.WriteBPS < 0 || limits.Burst < 0 {
+		return fmt.Errorf("Invalid QoS limits %+v, they can't be negative", limits)
+	}
+	logrus.Infof("Setting QoS limits to %+v", limits)
+	c.limiter.set(limits)
+	return nil
+}
+
+// QoS returns the limits of the I/Os of the volume
+func (c *Controller) QoS() QoSLimits {
+	c.limiter.Lock()
+	defer c.limiter.Unlock()
+	return c.limiter.limits
+}
+
+// QoSStats returns the I/Os delayed by the limits
+func (c *Controller) QoSStats() QoSStats {
+	c.limiter.Lock()
+	defer c.limiter.Unlock()
+	return QoSStats{
+		ThrottledReads:    c.limiter.throttled[0],
+		ThrottledWrites:   c.limiter.throttled[1],
+		ReadThrottleTime:  c.limiter.throttleTime[0],
+		WriteThrottleTime: c.limiter.throttleTime[1],
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	type reserve struct {
+		// at is the time of the reservation since the first one
+		at   time.Duration
+		n    float64
+		wait time.Duration
+	}
+	tests := []struct {
+		name     string
+		rate     int64
+		burst    time.Duration
+		reserves []reserve
+	}{
+		{name: "unlimited", rate: 0, burst: time.Second, reserves: []reserve{{n: 1 << 30}}},
+		{name: "burst", rate: 10, burst: time.Second, reserves: []reserve{
+			{n: 10}, {n: 1, wait: 100 * time.Millisecond}, {n: 1, wait: 200 * time.Millisecond},
+		}},
+		{name: "refilled", rate: 10, burst: time.Second, reserves: []reserve{
+			{n: 10}, {at: 500 * time.Millisecond, n: 5}, {at: 500 * time.Millisecond, n: 1, wait: 100 * time.Millisecond},
+		}},
+		{name: "refilled up to the burst", rate: 10, burst: 2 * time.Second, reserves: []reserve{
+			{n: 20}, {at: time.Hour, n: 20}, {at: time.Hour, n: 10, wait: time.Second},
+		}},
+		{name: "larger than the bucket", rate: 1000, burst: time.Second, reserves: []reserve{
+			{n: 3000, wait: 2 * time.Second},
+		}},
+	}
+	start := time.Now()
+	for _, tt := range tests {
+		b := newTokenBucket(tt.rate, tt.burst)
+		for i, r := range tt.reserves {
+			if wait := b.reserve(r.n, start.Add(r.at)); wait != r.wait {
+				t.Errorf("%s: reservation %d waits %v, expected %v", tt.name, i, wait, r.wait)
+			}
+		}
+	}
+}
+
+func TestRejectedIOsNotThrottled(t *testing.T) {
+	c := NewController(WithQoS(QoSLimits{ReadIOPS: 1, WriteIOPS: 1}))
+	c.ReadOnly = false
+	c.size = 4096
+	buf := make([]byte, 4096)
+	for i := 0; i < 3; i++ {
+		if _, err := c.WriteAt(buf, 4096); err == nil {
+			t.Fatalf("write beyond the volume size succeeded")
+		}
+		if _, err := c.ReadAt(buf, 4096); err == nil {
+			t.Fatalf("read beyond the volume size succeeded")
+		}
+		// no replica to read from
+		if _, err := c.ReadAt(buf, 0); err == nil {
+			t.Fatalf("read without replicas succeeded")
+		}
+	}
+	if stats := c.QoSStats(); stats != (QoSStats{}) {
+		t.Errorf("rejected I/Os were throttled: %+v", stats)
+	}
+	if c.ioCounters != (ioCounters{}) {
+		t.Errorf("rejected I/Os were counted: %+v", c.ioCounters)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"container/list"
+	"sync"
+)
+
+// rangeLock serializes the I/Os on overlapping ranges of the volume.
+// A range locked exclusively (write, unmap) excludes any other I/O on
+// it, while shared ranges (read) can be locked concurrently.
+//
+// Requests are granted in arrival order: a request waits for every
+// earlier request it conflicts with, even if that one is still waiting.
+// This keeps writes from being starved by reads and makes overlapping
+// writes reach every replica in the same order.
+type rangeLock struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	requests *list.List
+}
+
+type rangeRequest struct {
+	start     int64
+	end       int64
+	exclusive bool
+}
+
+func newRangeLock() *rangeLock {
+	l := &rangeLock{
+		requests: list.New(),
+	}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+func (r *rangeRequest) conflicts(o *rangeRequest) bool {
+	if !r.exclusive && !o.exclusive {
+		return false
+	}
+	return r.start < o.end && o.start < r.end
+}
+
+// lock waits until [off, off+length) can be accessed and returns the
+// function releasing it.
+func (l *rangeLock) lock(off, length int64, exclusive bool) func() {
+	elem := l.add(off, length, exclusive)
+	l.wait(elem)
+	return func() {
+		l.release(elem)
+	}
+}
+
+// add queues a request for [off, off+length) without waiting for it, the
+// order of the requests is the order of the calls to add.
+func (l *rangeLock) add(off, length int64, exclusive bool) *list.Element {
+	req := &rangeRequest{
+		start:     off,
+		end:       off + length,
+		exclusive: exclusive,
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.requests.PushBack(req)
+}
+
+// wait waits until the request queued by add is granted
+func (l *rangeLock) wait(elem *list.Element) {
+	l.mutex.Lock()
+	for l.isBlocked(elem) {
+		l.cond.Wait()
+	}
+	l.mutex.Unlock()
+}
+
+func (l *rangeLock) release(elem *list.Element) {
+	l.mutex.Lock()
+	l.requests.Remove(elem)
+	l.cond.Broadcast()
+	l.mutex.Unlock()
+}
+
+// pending returns the number of requests queued or granted
+func (l *rangeLock) pending() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.requests.Len()
+}
+
+// conflicts returns true if a request queued or granted conflicts with
+// [off, off+length).
+func (l *rangeLock) conflicts(off, length int64, exclusive bool) bool {
+	req := &rangeRequest{
+		start:     off,
+		end:       off + length,
+		exclusive: exclusive,
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for e := l.requests.Front(); e != nil; e = e.Next() {
+		if req.conflicts(e.Value.(*rangeRequest)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlocked returns true if a request queued before elem conflicts with
+// it, it must be called with the mutex held.
+func (l *rangeLock) isBlocked(elem *list.Element) bool {
+	req := elem.Value.(*rangeRequest)
+	for e := elem.Prev(); e != nil; e = e.Prev() {
+		if req.conflicts(e.Value.(*rangeRequest)) {
+			return true
+		}
+	}
+	return false
+}
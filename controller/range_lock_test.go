@@ -0,0 +1,107 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+const lockWait = 100 * time.Millisecond
+
+// lockAsync locks the range in a goroutine and returns a channel which
+// receives the unlock function once the range is granted.
+func lockAsync(l *rangeLock, off, length int64, exclusive bool) <-chan func() {
+	ch := make(chan func(), 1)
+	go func() {
+		ch <- l.lock(off, length, exclusive)
+	}()
+	return ch
+}
+
+func granted(t *testing.T, ch <-chan func()) func() {
+	t.Helper()
+	select {
+	case unlock := <-ch:
+		return unlock
+	case <-time.After(lockWait):
+		t.Fatalf("range not granted")
+	}
+	return nil
+}
+
+func notGranted(t *testing.T, ch <-chan func()) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("range granted while a conflicting one is held")
+	case <-time.After(lockWait):
+	}
+}
+
+func TestRangeLock(t *testing.T) {
+	tests := []struct {
+		name          string
+		held          [3]int64 // offset, length, exclusive
+		next          [3]int64
+		wantConflicts bool
+	}{
+		{"overlapping reads", [3]int64{0, 10, 0}, [3]int64{5, 10, 0}, false},
+		{"overlapping writes", [3]int64{0, 10, 1}, [3]int64{5, 10, 1}, true},
+		{"read within write", [3]int64{0, 10, 1}, [3]int64{2, 2, 0}, true},
+		{"write within read", [3]int64{0, 10, 0}, [3]int64{2, 2, 1}, true},
+		{"adjacent writes", [3]int64{0, 10, 1}, [3]int64{10, 10, 1}, false},
+		{"disjoint writes", [3]int64{0, 10, 1}, [3]int64{100, 10, 1}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRangeLock()
+			unlock := l.lock(tt.held[0], tt.held[1], tt.held[2] == 1)
+			ch := lockAsync(l, tt.next[0], tt.next[1], tt.next[2] == 1)
+			if tt.wantConflicts {
+				notGranted(t, ch)
+				unlock()
+				granted(t, ch)()
+				return
+			}
+			granted(t, ch)()
+			unlock()
+		})
+	}
+}
+
+func TestRangeLockOrder(t *testing.T) {
+	l := newRangeLock()
+
+	// a read queued behind a waiting write doesn't overtake it
+	unlockRead := l.lock(0, 10, false)
+	write := lockAsync(l, 0, 10, true)
+	notGranted(t, write)
+	read := lockAsync(l, 0, 10, false)
+	notGranted(t, read)
+
+	unlockRead()
+	unlockWrite := granted(t, write)
+	notGranted(t, read)
+	unlockWrite()
+	granted(t, read)()
+
+	if l.requests.Len() != 0 {
+		t.Errorf("%d requests left after unlocking all of them", l.requests.Len())
+	}
+}
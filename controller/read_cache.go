@@ -0,0 +1,190 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cacheBlockSize is the granularity of the read cache
+const cacheBlockSize = 4096
+
+// WithReadCache caches up to size bytes of the data read from the
+// replicas in the controller, 0 disables the cache.
+func WithReadCache(size int64) BuildOpts {
+	return func(c *Controller) {
+		c.cache = newReadCache(size)
+	}
+}
+
+type cacheEntry struct {
+	block int64
+	data  []byte
+}
+
+// readCache is a bounded LRU cache of the blocks of the volume. Only the
+// blocks fully covered by a read are cached. The reads fill it and the
+// writes invalidate it while holding the range lock of the controller,
+// so that a block being written is never cached with stale data.
+// The methods of a nil readCache are no-ops, it is disabled.
+type readCache struct {
+	sync.Mutex
+	capacity int
+	blocks   map[int64]*list.Element
+	lru      *list.List
+	hits     int64
+	misses   int64
+}
+
+// newReadCache returns a cache of size bytes, or nil if it is smaller
+// than a block.
+func newReadCache(size int64) *readCache {
+	if size < cacheBlockSize {
+		return nil
+	}
+	return &readCache{
+		capacity: int(size / cacheBlockSize),
+		blocks:   map[int64]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// read fills buf with the data at off and returns true if all of it is
+// cached.
+func (c *readCache) read(buf []byte, off int64) bool {
+	if c == nil || len(buf) == 0 {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	end := off + int64(len(buf))
+	first, last := off/cacheBlockSize, (end-1)/cacheBlockSize
+	for block := first; block <= last; block++ {
+		if _, ok := c.blocks[block]; !ok {
+			c.misses++
+			return false
+		}
+	}
+	for block := first; block <= last; block++ {
+		elem := c.blocks[block]
+		c.lru.MoveToFront(elem)
+		data := elem.Value.(*cacheEntry).data
+		start := block * cacheBlockSize
+		if start < off {
+			data = data[off-start:]
+			start = off
+		}
+		copy(buf[start-off:], data)
+	}
+	c.hits++
+	return true
+}
+
+// fill caches the blocks fully covered by the data read at off, evicting
+// the least recently used ones.
+func (c *readCache) fill(buf []byte, off int64) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	end := off + int64(len(buf))
+	for block := (off + cacheBlockSize - 1) / cacheBlockSize; (block+1)*cacheBlockSize <= end; block++ {
+		start := block*cacheBlockSize - off
+		if elem, ok := c.blocks[block]; ok {
+			copy(elem.Value.(*cacheEntry).data, buf[start:])
+			c.lru.MoveToFront(elem)
+			continue
+		}
+		var entry *cacheEntry
+		if c.lru.Len() >= c.capacity {
+			// reuse the buffer of the evicted block
+			elem := c.lru.Back()
+			entry = c.lru.Remove(elem).(*cacheEntry)
+			delete(c.blocks, entry.block)
+		} else {
+			entry = &cacheEntry{data: make([]byte, cacheBlockSize)}
+		}
+		entry.block = block
+		copy(entry.data, buf[start:])
+		c.blocks[block] = c.lru.PushFront(entry)
+	}
+}
+
+// invalidate drops the blocks overlapping the range from the cache
+func (c *readCache) invalidate(off, length int64) {
+	if c == nil || length <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	first, last := off/cacheBlockSize, (off+length-1)/cacheBlockSize
+	if last-first+1 > int64(len(c.blocks)) {
+		// large unmaps, e.g. of the whole volume
+		for block, elem := range c.blocks {
+			if block >= first && block <= last {
+				c.lru.Remove(elem)
+				delete(c.blocks, block)
+			}
+		}
+		return
+	}
+	for block := first; block <= last; block++ {
+		if elem, ok := c.blocks[block]; ok {
+			c.lru.Remove(elem)
+			delete(c.blocks, block)
+		}
+	}
+}
+
+// clear drops all the blocks, e.g. when the data of the volume is
+// reverted.
+func (c *readCache) clear() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.blocks = map[int64]*list.Element{}
+	c.lru.Init()
+}
+
+// stats returns the hits and misses of the reads, and the bytes cached
+func (c *readCache) stats() (int64, int64, int64) {
+	if c == nil {
+		return 0, 0, 0
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.hits, c.misses, int64(c.lru.Len()) * cacheBlockSize
+}
+
+// CacheStats returns the hits and misses of the reads in the read cache,
+// and the bytes it holds.
+func (c *Controller) CacheStats() (int64, int64, int64) {
+	return c.cache.stats()
+}
+
+// invalidateCache drops the range from the read cache once the reads in
+// flight to it are completed, for the data written outside of the I/O
+// path.
+func (c *Controller) invalidateCache(off, length int64) {
+	unlock := c.ioLock.lock(off, length, true)
+	c.cache.invalidate(off, length)
+	unlock()
+}
@@ -0,0 +1,77 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"testing"
+)
+
+func pattern(off int64, length int) []byte {
+	buf := make([]byte, length)
+	for i := range buf {
+		buf[i] = byte((off + int64(i)) / 512)
+	}
+	return buf
+}
+
+func TestReadCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// fill the cache with these reads
+		fills [][2]int64
+		// and then invalidate this range, if its length isn't 0
+		invalidate [2]int64
+		read       [2]int64
+		hit        bool
+	}{
+		{name: "aligned", fills: [][2]int64{{0, 8192}}, read: [2]int64{4096, 4096}, hit: true},
+		{name: "unaligned read", fills: [][2]int64{{0, 8192}}, read: [2]int64{1024, 4096}, hit: true},
+		{name: "partial block not cached", fills: [][2]int64{{1024, 8192}}, read: [2]int64{0, 4096}, hit: false},
+		{name: "covered block cached", fills: [][2]int64{{1024, 8192}}, read: [2]int64{4096, 4096}, hit: true},
+		{name: "invalidated", fills: [][2]int64{{0, 8192}}, invalidate: [2]int64{6000, 512}, read: [2]int64{0, 8192}, hit: false},
+		{name: "not invalidated", fills: [][2]int64{{0, 8192}}, invalidate: [2]int64{8192, 512}, read: [2]int64{0, 8192}, hit: true},
+		{name: "large invalidate", fills: [][2]int64{{0, 8192}}, invalidate: [2]int64{0, 1 << 40}, read: [2]int64{4096, 512}, hit: false},
+		// 4 blocks of capacity, the first block is evicted
+		{name: "evicted", fills: [][2]int64{{0, 4096}, {4096, 16384}}, read: [2]int64{0, 512}, hit: false},
+		{name: "recently used", fills: [][2]int64{{0, 4096}, {4096, 8192}, {0, 4096}, {12288, 8192}}, read: [2]int64{0, 512}, hit: true},
+	}
+	for _, tt := range tests {
+		c := newReadCache(4 * cacheBlockSize)
+		for _, f := range tt.fills {
+			c.fill(pattern(f[0], int(f[1])), f[0])
+		}
+		c.invalidate(tt.invalidate[0], tt.invalidate[1])
+		buf := make([]byte, tt.read[1])
+		hit := c.read(buf, tt.read[0])
+		if hit != tt.hit {
+			t.Errorf("%s: read hit %v, expected %v", tt.name, hit, tt.hit)
+		}
+		if hit && !bytes.Equal(buf, pattern(tt.read[0], len(buf))) {
+			t.Errorf("%s: read wrong data", tt.name)
+		}
+		if hits, misses, _ := c.stats(); (hits == 1) != tt.hit || hits+misses != 1 {
+			t.Errorf("%s: %d hits and %d misses", tt.name, hits, misses)
+		}
+	}
+
+	var disabled *readCache
+	disabled.fill(make([]byte, 4096), 0)
+	if disabled.read(make([]byte, 4096), 0) {
+		t.Errorf("Disabled cache returned data")
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadPolicy selects the replica serving a read
+type ReadPolicy string
+
+const (
+	// ReadRoundRobin spreads the reads evenly across the replicas
+	ReadRoundRobin ReadPolicy = "round-robin"
+	// ReadLeastOutstanding reads from the replica with the fewest reads
+	// in flight.
+	ReadLeastOutstanding ReadPolicy = "least-outstanding"
+	// ReadLatency reads from the replica with the lowest moving average
+	// of the read latency, weighted by its reads in flight.
+	ReadLatency ReadPolicy = "latency"
+	// ReadPreferred reads from the preferred replica, e.g. the one on the
+	// node of the controller, and round robin if it isn't available.
+	ReadPreferred ReadPolicy = "preferred"
+)
+
+const (
+	// latencyProbeInterval is the interval of the reads scheduled round
+	// robin by ReadLatency, to keep measuring the slower replicas.
+	latencyProbeInterval = 64
+	// latencyWeight is the inverse of the weight of a read in the moving
+	// average of the latency.
+	latencyWeight = 8
+)
+
+// ParseReadPolicy returns the ReadPolicy of its name, the default is
+// ReadRoundRobin.
+func ParseReadPolicy(name string) (ReadPolicy, error) {
+	switch policy := ReadPolicy(name); policy {
+	case "":
+		return ReadRoundRobin, nil
+	case ReadRoundRobin, ReadLeastOutstanding, ReadLatency, ReadPreferred:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Invalid read policy %q, expected %s, %s, %s or %s",
+		name, ReadRoundRobin, ReadLeastOutstanding, ReadLatency, ReadPreferred)
+}
+
+// WithReadPolicy set the read policy of the volume, preferred is the
+// address or the host of the replica preferred by ReadPreferred.
+func WithReadPolicy(policy ReadPolicy, preferred string) BuildOpts {
+	return func(c *Controller) {
+		c.ReadPolicy = policy
+		c.preferredReplica = preferred
+	}
+}
+
+// WithHedgedReads reissues the reads not completed after the given
+// duration to another replica, 0 disables it.
+func WithHedgedReads(after time.Duration) BuildOpts {
+	return func(c *Controller) {
+		c.hedgeAfter = after
+	}
+}
+
+// readStats are the statistics of the reads of a replica used to
+// schedule the reads.
+type readStats struct {
+	outstanding int64
+	mutex       sync.Mutex
+	latency     time.Duration
+}
+
+func (s *readStats) start() time.Time {
+	atomic.AddInt64(&s.outstanding, 1)
+	return time.Now()
+}
+
+func (s *readStats) done(start time.Time, err error) {
+	atomic.AddInt64(&s.outstanding, -1)
+	if err != nil {
+		return
+	}
+	sample := time.Since(start)
+	s.mutex.Lock()
+	if s.latency == 0 {
+		s.latency = sample
+	} else {
+		s.latency += (sample - s.latency) / latencyWeight
+	}
+	s.mutex.Unlock()
+}
+
+func (s *readStats) pending() int64 {
+	return atomic.LoadInt64(&s.outstanding)
+}
+
+// cost is the expected latency of a new read
+func (s *readStats) cost() time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.latency * time.Duration(s.pending()+1)
+}
+
+// isPreferred returns true if the reader is the preferred replica, given
+// by its address or its host.
+func isPreferred(address, preferred string) bool {
+	if preferred == "" {
+		return false
+	}
+	if address == preferred {
+		return true
+	}
+	host, _, err := net.SplitHostPort(strings.TrimPrefix(address, "tcp://"))
+	return err == nil && host == preferred
+}
+
+// readOrder returns the indexes of the readers in the order the read is
+// tried.
+func (r *replicator) readOrder(off, length int64) []int {
+	readersLen := len(r.readers)
+	next := int(atomic.AddUint32(&r.next, 1))
+	order := make([]int, readersLen)
+	for i := range order {
+		order[i] = (next + i) % readersLen
+	}
+
+	// the metrics change while sorting, sort a copy of them
+	keys := make([]int64, readersLen)
+	switch r.readPolicy {
+	case ReadLeastOutstanding:
+		for i := range keys {
+			keys[i] = r.readerStats[i].pending()
+		}
+	case ReadLatency:
+		if next%latencyProbeInterval != 0 {
+			for i := range keys {
+				keys[i] = int64(r.readerStats[i].cost())
+			}
+		}
+	case ReadPreferred:
+		for i := range keys {
+			if !isPreferred(r.readerIndex[i], r.preferredReplica) {
+				keys[i] = 1
+			}
+		}
+	}
+	// the replicas which haven't completed an acknowledged write to the
+	// range yet would return stale data, they are tried last
+	for i := range keys {
+		if r.readerQueues[i].conflicts(off, length, false) {
+			keys[i] = 1<<63 - 1
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]] < keys[order[j]]
+	})
+	return order
+}
+
+// readFrom reads from a reader once the writes in flight to the range
+// are completed.
+func (r *replicator) readFrom(index int, buf []byte, off int64) (int, error) {
+	r.readerQueues[index].lock(off, int64(len(buf)), false)()
+	stats := r.readerStats[index]
+	start := stats.start()
+	n, err := r.readers[index].ReadAt(buf, off)
+	stats.done(start, err)
+	if err == nil {
+		r.readerLatencies[index].add(time.Since(start))
+	}
+	return n, err
+}
+
+// hedgedRead reads from the first reader, and from the second one too if
+// the first doesn't complete the read in hedgeAfter. The data of the
+// first successful read is returned, along with the errors of the reads
+// completed before it. The reads use their own buffer as the slower one
+// completes after returning.
+func (r *replicator) hedgedRead(first, second int, buf []byte, off int64) (int, bool, map[int]error) {
+	type result struct {
+		index int
+		n     int
+		data  []byte
+		err   error
+	}
+	results := make(chan result, 2)
+	read := func(index int) {
+		data := make([]byte, len(buf))
+		n, err := r.readFrom(index, data, off)
+		results <- result{index: index, n: n, data: data, err: err}
+	}
+
+	go read(first)
+	timer := time.NewTimer(r.hedgeAfter)
+	defer timer.Stop()
+	hedge := timer.C
+	pending := 1
+	errs := map[int]error{}
+	for pending > 0 {
+		select {
+		case <-hedge:
+			hedge = nil
+			pending++
+			go read(second)
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				copy(buf, res.data[:res.n])
+				return res.n, true, errs
+			}
+			errs[res.index] = res.err
+			if hedge != nil {
+				// failed before hedging, the second reader is
+				// tried next
+				return 0, false, errs
+			}
+		}
+	}
+	return 0, false, errs
+}
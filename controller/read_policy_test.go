@@ -0,0 +1,118 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// byteReader fills the reads with its byte, after waiting for the gate
+// to be closed if it is set.
+type byteReader struct {
+	b    byte
+	gate chan struct{}
+}
+
+func (r *byteReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.gate != nil {
+		<-r.gate
+	}
+	for i := range p {
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+func newReplicator(readers ...*byteReader) *replicator {
+	r := &replicator{
+		backendsAvailable: true,
+		readerIndex:       map[int]string{},
+	}
+	for i, reader := range readers {
+		r.readerIndex[i] = fmt.Sprintf("tcp://127.0.0.%d:9502", i+1)
+		r.readers = append(r.readers, io.ReaderAt(reader))
+		r.readerQueues = append(r.readerQueues, newRangeLock())
+		r.readerStats = append(r.readerStats, &readStats{})
+		r.readerLatencies = append(r.readerLatencies, &latencyWindow{})
+	}
+	return r
+}
+
+func TestReadOrder(t *testing.T) {
+	tests := []struct {
+		policy      ReadPolicy
+		preferred   string
+		outstanding [3]int64
+		latency     [3]time.Duration
+		lagging     int
+		first       int
+	}{
+		{policy: ReadLeastOutstanding, outstanding: [3]int64{3, 1, 2}, lagging: -1, first: 1},
+		{policy: ReadLatency, latency: [3]time.Duration{3, 2, 1}, lagging: -1, first: 2},
+		// 3 * 2 reads in flight is more than 4 * 1
+		{policy: ReadLatency, latency: [3]time.Duration{4, 9, 3}, outstanding: [3]int64{0, 0, 1}, lagging: -1, first: 0},
+		{policy: ReadPreferred, preferred: "127.0.0.2", lagging: -1, first: 1},
+		{policy: ReadPreferred, preferred: "tcp://127.0.0.3:9502", lagging: -1, first: 2},
+		// a replica writing the range is tried last
+		{policy: ReadPreferred, preferred: "127.0.0.2", lagging: 1, first: 2},
+	}
+	for _, tt := range tests {
+		r := newReplicator(&byteReader{}, &byteReader{}, &byteReader{})
+		r.readPolicy = tt.policy
+		r.preferredReplica = tt.preferred
+		for i, s := range r.readerStats {
+			s.outstanding = tt.outstanding[i]
+			s.latency = tt.latency[i]
+		}
+		if tt.lagging >= 0 {
+			r.readerQueues[tt.lagging].add(0, 4096, true)
+		}
+		// the latency policy reads round robin once in a while
+		r.next = 1
+		order := r.readOrder(0, 512)
+		if order[0] != tt.first || (tt.lagging >= 0 && order[2] != tt.lagging) {
+			t.Errorf("%s: read order %v, expected %d first", tt.policy, order, tt.first)
+		}
+	}
+}
+
+func TestHedgedRead(t *testing.T) {
+	slow := &byteReader{b: 1, gate: make(chan struct{})}
+	defer close(slow.gate)
+	r := newReplicator(slow, &byteReader{b: 2})
+	r.readPolicy = ReadPreferred
+	r.preferredReplica = "127.0.0.1"
+	r.hedgeAfter = 10 * time.Millisecond
+
+	buf := make([]byte, 512)
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.ReadAt(buf, 0)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil || buf[0] != 2 {
+			t.Fatalf("Hedged read returned %d, error: %v", buf[0], err)
+		}
+	case <-time.After(lockWait):
+		t.Fatalf("Read waited for the slow replica")
+	}
+}
@@ -157,8 +157,10 @@ func (c *Controller) VerifyRebuildReplica(address string) error {
 	if err := c.backend.SetRevisionCounter(address, counter); err != nil {
 		return fmt.Errorf("Fail to set revision counter for %v: %v", address, err)
 	}
+	c.persistReplicationFactor(address)
 	logrus.Infof("WO replica %v's chain verified, update replica mode to RW", address)
 	c.setReplicaModeNoLock(address, types.RW)
+	c.publishReplicaEvent(types.EventRebuildCompleted, address, types.RW)
 	if len(c.quorumReplicas) > c.quorumReplicaCount {
 		c.quorumReplicaCount = len(c.quorumReplicas)
 	}
@@ -228,5 +230,6 @@ func (c *Controller) PrepareRebuildReplica(address string) ([]string, error) {
 		return nil, err
 	}
 
+	c.publishReplicaEvent(types.EventRebuildStarted, address, types.WO)
 	return rwChain[1:], nil
 }
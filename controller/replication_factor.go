@@ -0,0 +1,107 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SetReplicationFactor changes the replication factor of the live volume
+// and persists it on the replicas. Lowering it below the replica count
+// removes the replicas which aren't RW first, then the last added ones.
+// Raising it lets new replicas be added, the quorum only grows as they
+// turn RW, so that the volume stays writable while they are rebuilt.
+func (c *Controller) SetReplicationFactor(rf int) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if rf <= 0 {
+		return fmt.Errorf("Invalid replication factor %d", rf)
+	}
+	if rf == c.ReplicationFactor {
+		return nil
+	}
+	logrus.Infof("Changing replication factor from %d to %d", c.ReplicationFactor, rf)
+
+	for _, address := range c.excessReplicas(len(c.replicas) - rf) {
+		logrus.Infof("Removing replica %s above replication factor %d", address, rf)
+		if err := c.RemoveReplicaNoLock(address); err != nil {
+			return fmt.Errorf("Failed to remove replica %s, error: %v", address, err)
+		}
+	}
+
+	c.ReplicationFactor = rf
+	if c.quorumRF > rf {
+		c.quorumRF = rf
+	}
+	c.UpdateVolStatus()
+	c.UpdateCheckpoint()
+
+	for _, r := range c.replicas {
+		if r.Mode != types.ERR {
+			c.persistReplicationFactor(r.Address)
+		}
+	}
+	return nil
+}
+
+// excessReplicas returns the count replicas to remove to lower the
+// replication factor.
+func (c *Controller) excessReplicas(count int) []string {
+	var excess []string
+	for _, r := range c.replicas {
+		if len(excess) < count && r.Mode != types.RW {
+			excess = append(excess, r.Address)
+		}
+	}
+	for i := len(c.replicas) - 1; i >= 0 && len(excess) < count; i-- {
+		if c.replicas[i].Mode == types.RW {
+			excess = append(excess, c.replicas[i].Address)
+		}
+	}
+	return excess
+}
+
+// persistReplicationFactor writes the replication factor to the metadata
+// of a replica. A failure only means that a restarted controller may
+// use the previous one, it isn't returned.
+func (c *Controller) persistReplicationFactor(address string) {
+	if c.ReplicationFactor <= 0 {
+		return
+	}
+	if err := c.backend.SetReplicationFactor(address, c.ReplicationFactor); err != nil {
+		logrus.Warningf("Failed to persist replication factor %d on %s, error: %v",
+			c.ReplicationFactor, address, err)
+	}
+}
+
+// adoptReplicationFactor uses the replication factor persisted by the
+// registered replica, if any, as it may have been changed since the
+// controller was configured.
+func (c *Controller) adoptReplicationFactor(register types.RegReplica) {
+	rf := register.ReplicationFactor
+	if rf <= 0 || rf == c.ReplicationFactor {
+		return
+	}
+	logrus.Infof("Using replication factor %d persisted by %s instead of %d",
+		rf, register.Address, c.ReplicationFactor)
+	c.ReplicationFactor = rf
+	c.quorumRF = rf
+}
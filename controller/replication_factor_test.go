@@ -0,0 +1,75 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openebs/jiva/types"
+)
+
+func replicasWithModes(modes ...types.Mode) []types.Replica {
+	replicas := []types.Replica{}
+	for i, mode := range modes {
+		replicas = append(replicas, types.Replica{Address: string(rune('a' + i)), Mode: mode})
+	}
+	return replicas
+}
+
+func TestExcessReplicas(t *testing.T) {
+	tests := []struct {
+		modes    []types.Mode
+		count    int
+		expected []string
+	}{
+		{modes: []types.Mode{types.RW, types.RW}, count: 0},
+		{modes: []types.Mode{types.RW, types.RW, types.RW}, count: 2, expected: []string{"c", "b"}},
+		{modes: []types.Mode{types.RW, types.WO, types.RW}, count: 1, expected: []string{"b"}},
+		{modes: []types.Mode{types.ERR, types.RW, types.WO}, count: 3, expected: []string{"a", "c", "b"}},
+	}
+	for _, tt := range tests {
+		c := &Controller{replicas: replicasWithModes(tt.modes...)}
+		if got := c.excessReplicas(tt.count); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("excessReplicas(%d) of %v = %v, expected %v", tt.count, tt.modes, got, tt.expected)
+		}
+	}
+}
+
+func TestRaisedReplicationFactorQuorum(t *testing.T) {
+	// raised from 1 to 3, the new replicas are rebuilt one after the other
+	c := &Controller{ReplicationFactor: 3, quorumRF: 1, backend: &replicator{}}
+	steps := []struct {
+		modes    []types.Mode
+		quorumRF int
+		readOnly bool
+	}{
+		{modes: []types.Mode{types.RW, types.WO}, quorumRF: 1, readOnly: false},
+		{modes: []types.Mode{types.RW, types.RW, types.WO}, quorumRF: 2, readOnly: false},
+		{modes: []types.Mode{types.RW, types.ERR, types.RW}, quorumRF: 2, readOnly: false},
+		{modes: []types.Mode{types.RW, types.RW, types.RW}, quorumRF: 3, readOnly: false},
+		{modes: []types.Mode{types.RW, types.ERR, types.ERR}, quorumRF: 3, readOnly: true},
+	}
+	for i, step := range steps {
+		c.replicas = replicasWithModes(step.modes...)
+		c.UpdateVolStatus()
+		if c.quorumRF != step.quorumRF || c.ReadOnly != step.readOnly {
+			t.Fatalf("Step %d: quorum replication factor %d, read only %v, expected %d, %v",
+				i, c.quorumRF, c.ReadOnly, step.quorumRF, step.readOnly)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/openebs/jiva/backend/remote"
 	"github.com/openebs/jiva/types"
@@ -44,8 +45,20 @@ type replicator struct {
 	updaterIndex      map[int]string
 	readerIndex       map[int]string
 	readers           []io.ReaderAt
+	readerQueues      []*rangeLock
+	readerStats       []*readStats
+	readerLatencies   []*latencyWindow
 	writer            Writer
-	next              int
+	policy            AckPolicy
+	readPolicy        ReadPolicy
+	preferredReplica  string
+	hedgeAfter        time.Duration
+	// onLateError is called when a replica fails a write after it was
+	// acknowledged.
+	onLateError func(address string, off, length int64, err error)
+	// next is the index of the last reader used, it is updated
+	// atomically as reads run concurrently
+	next uint32
 }
 
 type Writer interface {
@@ -113,6 +126,9 @@ func (r *replicator) AddBackend(address string, backend types.Backend) {
 	r.backends[address] = backendWrapper{
 		backend: backend,
 		mode:    types.WO,
+		queue:   newRangeLock(),
+		stats:   &readStats{},
+		health:  &replicaHealth{},
 	}
 
 	r.buildReadWriters()
@@ -145,22 +161,35 @@ func (r *replicator) ReadAt(buf []byte, off int64) (int, error) {
 		return 0, ErrNoBackend
 	}
 
-	readersLen := len(r.readers)
-	r.next = (r.next + 1) % readersLen
-	index := r.next
 	retError := &BackendError{
 		Errors: map[string]error{},
 	}
-	for i := 0; i < readersLen; i++ {
-		reader := r.readers[index]
-		n, err = reader.ReadAt(buf, off)
-		if err == nil {
+	order := r.readOrder(off, int64(len(buf)))
+	for i := 0; i < len(order); i++ {
+		var (
+			ok   bool
+			errs map[int]error
+		)
+		if r.hedgeAfter > 0 && i+1 < len(order) {
+			n, ok, errs = r.hedgedRead(order[i], order[i+1], buf, off)
+			if len(errs) == 2 {
+				i++
+			}
+		} else {
+			n, err = r.readFrom(order[i], buf, off)
+			ok = err == nil
+			if err != nil {
+				errs = map[int]error{order[i]: err}
+			}
+		}
+		for index, err := range errs {
+			//TODO Update this log
+			logrus.Error("Replicator.ReadAt:", index, err)
+			retError.Errors[r.readerIndex[index]] = err
+		}
+		if ok {
 			break
 		}
-		//TODO Update this log
-		logrus.Error("Replicator.ReadAt:", index, err)
-		retError.Errors[r.readerIndex[index]] = err
-		index = (index + 1) % readersLen
 	}
 	if len(retError.Errors) != 0 {
 		return n, retError
@@ -232,17 +261,44 @@ func (r *replicator) buildReadWriters() {
 	r.reset(false)
 
 	readers := []io.ReaderAt{}
+	readerQueues := []*rangeLock{}
+	readerStats := []*readStats{}
+	readerLatencies := []*latencyWindow{}
 	writers := []Writer{}
+	queues := []*rangeLock{}
+	latencies := []*latencyWindow{}
+	degraded := []string{}
 	updaters := []Writer{}
 
 	for address, b := range r.backends {
 		if b.mode != types.ERR {
 			r.writerIndex[len(writers)] = address
 			writers = append(writers, b.backend)
+			queues = append(queues, b.queue)
+			latencies = append(latencies, &b.health.latency)
+		}
+		if b.mode == types.RW && b.health.degraded {
+			degraded = append(degraded, address)
+			continue
 		}
 		if b.mode == types.RW {
 			r.readerIndex[len(readers)] = address
 			readers = append(readers, b.backend)
+			readerQueues = append(readerQueues, b.queue)
+			readerStats = append(readerStats, b.stats)
+			readerLatencies = append(readerLatencies, &b.health.latency)
+		}
+	}
+	// the degraded replicas are read from again rather than failing the
+	// reads when no other replica is left
+	if len(readers) == 0 {
+		for _, address := range degraded {
+			b := r.backends[address]
+			r.readerIndex[len(readers)] = address
+			readers = append(readers, b.backend)
+			readerQueues = append(readerQueues, b.queue)
+			readerStats = append(readerStats, b.stats)
+			readerLatencies = append(readerLatencies, &b.health.latency)
 		}
 	}
 	for address, b := range r.quorumBackends {
@@ -260,11 +316,23 @@ func (r *replicator) buildReadWriters() {
 		prevwriters = 0
 	}
 	prevReaders := len(r.readers)
+	writerIndex := r.writerIndex
 	r.writer = &MultiWriterAt{
-		writers:  writers,
-		updaters: updaters,
+		writers:   writers,
+		updaters:  updaters,
+		queues:    queues,
+		latencies: latencies,
+		policy:    r.policy,
+		lateError: func(index int, off, length int64, err error) {
+			if r.onLateError != nil {
+				r.onLateError(writerIndex[index], off, length, err)
+			}
+		},
 	}
 	r.readers = readers
+	r.readerQueues = readerQueues
+	r.readerStats = readerStats
+	r.readerLatencies = readerLatencies
 	multiwriter := r.writer.(*MultiWriterAt)
 
 	if len(r.readers) > 0 {
@@ -293,7 +361,18 @@ func (r *replicator) SetMode(address string, mode types.Mode) {
 	r.buildReadWriters()
 }
 
+// waitWrites waits for the writes to [off, off+length) acknowledged
+// before all the replicas completed them.
+func (r *replicator) waitWrites(off, length int64) {
+	for _, b := range r.backends {
+		b.queue.lock(off, length, false)()
+	}
+}
+
 func (r *replicator) Snapshot(name string, userCreated bool, created string) error {
+	// the writes acknowledged before the snapshot belong to it
+	r.waitWrites(0, math.MaxInt64)
+
 	retErrorLock := sync.Mutex{}
 	retError := &BackendError{
 		Errors: map[string]error{},
@@ -508,6 +587,12 @@ func (r *replicator) GetVolUsage() (types.VolUsage, error) {
 type backendWrapper struct {
 	backend types.Backend
 	mode    types.Mode
+	// queue holds the writes in flight on the backend, see MultiWriterAt
+	queue *rangeLock
+	// stats are the statistics of the reads used by the read policy
+	stats *readStats
+	// health tracks whether the replica is slower than its peers
+	health *replicaHealth
 }
 
 func (r *replicator) RemainSnapshots() (int, error) {
@@ -568,6 +653,21 @@ func (r *replicator) SetRevisionCounter(address string, counter int64) error {
 	return nil
 }
 
+func (r *replicator) SetReplicationFactor(address string, rf int) error {
+	backend, ok := r.backends[address]
+	if !ok {
+		return fmt.Errorf("Cannot find backend %v", address)
+	}
+
+	if err := backend.backend.SetReplicationFactor(rf); err != nil {
+		return err
+	}
+
+	logrus.Infof("Set backend %s replication factor to %v", address, rf)
+
+	return nil
+}
+
 func (r *replicator) SetQuorumRevisionCounter(address string, counter int64) error {
 	backend, ok := r.quorumBackends[address]
 	if !ok {
@@ -24,8 +24,6 @@ import (
 	"net/http"
 	"sync"
 
-	replicaClient "github.com/openebs/jiva/replica/client"
-	"github.com/openebs/jiva/util"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
 	"github.com/sirupsen/logrus"
@@ -76,7 +74,7 @@ func (s *Server) delete(replicas *DeletedReplicas, wg *sync.WaitGroup) {
 		addr := replica.Address
 		go func(addr string) {
 			defer wg.Done()
-			repClient, err := replicaClient.NewReplicaClient(addr)
+			repClient, err := s.c.NewReplicaClient(addr)
 			if err != nil {
 				logrus.Infof("Error in delete operation of replica %v , error %v", addr, err)
 				replicas.appendDeletedReplicas(err.Error(), addr, repClientErr)
@@ -110,7 +108,7 @@ func (s *Server) DeleteVolume(rw http.ResponseWriter, req *http.Request) error {
 		apiContext.Write(SetDeleteReplicaOutput(replicas))
 		return nil
 	}
-	replicationFactor := util.CheckReplicationFactor()
+	replicationFactor := s.c.ReplicationFactor
 	if replicaCount != replicationFactor {
 		replicationFactorErr := fmt.Sprintf("Replication factor: %d is not equal to replica count: %d",
 			replicationFactor, replicaCount)
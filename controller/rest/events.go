@@ -0,0 +1,94 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openebs/jiva/types"
+)
+
+// eventsKeepAlive is the interval of the comments sent on an idle event
+// stream, so that the proxies don't close it.
+const eventsKeepAlive = 15 * time.Second
+
+// StreamEvents sends the events of the volume as server-sent events, each
+// event is a JSON encoded types.Event. The recent events are sent first,
+// the ones after the Last-Event-ID header or the after query parameter if
+// set. The stream then goes on until the client disconnects, unless the
+// follow query parameter is false.
+func (s *Server) StreamEvents(rw http.ResponseWriter, req *http.Request) error {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("Streaming events is not supported")
+	}
+	after := req.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = req.URL.Query().Get("after")
+	}
+	var afterID int64
+	if after != "" {
+		var err error
+		if afterID, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return fmt.Errorf("Invalid event ID %q", after)
+		}
+	}
+	follow := req.URL.Query().Get("follow") != "false"
+
+	past, events, unsubscribe := s.c.Subscribe(afterID)
+	defer unsubscribe()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, ": subscribed to the events of %s\n\n", s.c.Name)
+	for _, e := range past {
+		writeEvent(rw, e)
+	}
+	flusher.Flush()
+	if !follow {
+		return nil
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-keepAlive.C:
+			fmt.Fprintf(rw, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				// the client didn't keep up, it has to subscribe again
+				return nil
+			}
+			writeEvent(rw, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(rw http.ResponseWriter, e types.Event) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
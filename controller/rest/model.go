@@ -43,15 +43,24 @@ type DeleteReplicaOutput struct {
 
 type Replica struct {
 	client.Resource
-	Address string `json:"address"`
-	Mode    string `json:"mode"`
+	Address  string `json:"address"`
+	Mode     string `json:"mode"`
+	Degraded bool   `json:"degraded"`
 }
 
 type Volume struct {
 	client.Resource
-	Name         string `json:"name"`
-	ReplicaCount int    `json:"replicaCount"`
-	ReadOnly     string `json:"readOnly"`
+	Name              string `json:"name"`
+	ReplicaCount      int    `json:"replicaCount"`
+	ReplicationFactor int    `json:"replicationFactor"`
+	ReadOnly          string `json:"readOnly"`
+	AckPolicy         string `json:"ackPolicy"`
+	ReadPolicy        string `json:"readPolicy"`
+	Epoch             int64  `json:"epoch"`
+	ControllerID      string `json:"controllerId"`
+	QoS               QoS    `json:"qos"`
+	// Exports are the snapshots served read only
+	Exports []SnapshotExport `json:"exports"`
 }
 
 type VolumeCollection struct {
@@ -99,6 +108,16 @@ type VolumeStats struct {
 	TotalWriteTime       string `json:"TotalWriteTime"`
 	TotalWriteBlockCount string `json:"TotalWriteBlockCount"`
 
+	CacheHits   string `json:"CacheHits"`
+	CacheMisses string `json:"CacheMisses"`
+	CachedBytes string `json:"CachedBytes"`
+
+	// ReadThrottleTime and WriteThrottleTime are in nanoseconds
+	ThrottledReads    string `json:"ThrottledReads"`
+	ThrottledWrites   string `json:"ThrottledWrites"`
+	ReadThrottleTime  string `json:"ReadThrottleTime"`
+	WriteThrottleTime string `json:"WriteThrottleTime"`
+
 	UsedLogicalBlocks string              `json:"UsedLogicalBlocks"`
 	UsedBlocks        string              `json:"UsedBlocks"`
 	SectorSize        string              `json:"SectorSize"`
@@ -120,6 +139,40 @@ type RevertInput struct {
 	Name string `json:"name"`
 }
 
+type ReplicationFactorInput struct {
+	client.Resource
+	ReplicationFactor int `json:"replicationFactor"`
+}
+
+// QoS are the limits of the I/Os of the volume, 0 is unlimited. The
+// bandwidths are in bytes per second and the burst is a duration.
+type QoS struct {
+	ReadIOPS  int64  `json:"readIOPS"`
+	WriteIOPS int64  `json:"writeIOPS"`
+	ReadBPS   int64  `json:"readBPS"`
+	WriteBPS  int64  `json:"writeBPS"`
+	Burst     string `json:"burst"`
+}
+
+type QoSInput struct {
+	client.Resource
+	QoS
+}
+
+// SnapshotExport is a snapshot served read only by a frontend listening
+// on the address, or on the default address of the frontend if it is
+// empty.
+type SnapshotExport struct {
+	Snapshot string `json:"snapshot"`
+	Frontend string `json:"frontend"`
+	Address  string `json:"address"`
+}
+
+type SnapshotExportInput struct {
+	client.Resource
+	SnapshotExport
+}
+
 type ResizeInput struct {
 	client.Resource
 	Name string `json:"name"`
@@ -136,22 +189,41 @@ type JournalInput struct {
 	Limit int `json:"limit"`
 }
 
+// VerifyInput selects whether the diverged blocks are repaired
+type VerifyInput struct {
+	client.Resource
+	Repair bool `json:"repair"`
+}
+
+// VerifyOutput is the result of the verification of the replicas
+type VerifyOutput struct {
+	client.Resource
+	types.VerifyResult
+}
+
 type PrepareRebuildOutput struct {
 	client.Resource
 	Disks []string `json:"disks"`
 }
 
+type DirtyRegionsOutput struct {
+	client.Resource
+	types.DirtyRegions
+}
+
 type RegReplica struct {
 	client.Resource
-	Address  string        `json:"Address"`
-	RevCount string        `json:"RevCount"`
-	RepType  string        `json:"RepType"`
-	RepState string        `json:"RepState"`
-	UpTime   time.Duration `json:"UpTime"`
+	Address           string        `json:"Address"`
+	RevCount          string        `json:"RevCount"`
+	RepType           string        `json:"RepType"`
+	RepState          string        `json:"RepState"`
+	UpTime            time.Duration `json:"UpTime"`
+	ReplicationFactor int           `json:"ReplicationFactor"`
+	Epoch             int64         `json:"Epoch"`
 }
 
 // NewVolume ...
-func NewVolume(context *api.ApiContext, name string, readOnly bool, replicas int) *Volume {
+func NewVolume(context *api.ApiContext, name string, readOnly bool, replicas, rf int, ackPolicy, readPolicy string) *Volume {
 	var ReadOnly string
 	if readOnly {
 		ReadOnly = "true"
@@ -164,10 +236,15 @@ func NewVolume(context *api.ApiContext, name string, readOnly bool, replicas int
 			Type:    "volume",
 			Actions: map[string]string{},
 		},
-		Name:         name,
-		ReplicaCount: replicas,
-		ReadOnly:     ReadOnly,
+		Name:              name,
+		ReplicaCount:      replicas,
+		ReplicationFactor: rf,
+		ReadOnly:          ReadOnly,
+		AckPolicy:         ackPolicy,
+		ReadPolicy:        readPolicy,
 	}
+	v.Actions["setreplicationfactor"] = context.UrlBuilder.ActionLink(v.Resource, "setreplicationfactor")
+	v.Actions["setqos"] = context.UrlBuilder.ActionLink(v.Resource, "setqos")
 
 	if replicas == 0 {
 		v.Actions["start"] = context.UrlBuilder.ActionLink(v.Resource, "start")
@@ -178,6 +255,9 @@ func NewVolume(context *api.ApiContext, name string, readOnly bool, replicas int
 		v.Actions["deleteSnapshot"] = context.UrlBuilder.ActionLink(v.Resource, "deleteSnapshot")
 		v.Actions["resize"] = context.UrlBuilder.ActionLink(v.Resource, "resize")
 		v.Actions["setlogging"] = context.UrlBuilder.ActionLink(v.Resource, "setlogging")
+		v.Actions["verify"] = context.UrlBuilder.ActionLink(v.Resource, "verify")
+		v.Actions["attachsnapshot"] = context.UrlBuilder.ActionLink(v.Resource, "attachsnapshot")
+		v.Actions["detachsnapshot"] = context.UrlBuilder.ActionLink(v.Resource, "detachsnapshot")
 	}
 	return v
 }
@@ -195,6 +275,7 @@ func NewReplica(context *api.ApiContext, rep types.Replica) *Replica {
 	}
 	r.Actions["preparerebuild"] = context.UrlBuilder.ActionLink(r.Resource, "preparerebuild")
 	r.Actions["verifyrebuild"] = context.UrlBuilder.ActionLink(r.Resource, "verifyrebuild")
+	r.Actions["dirtyregions"] = context.UrlBuilder.ActionLink(r.Resource, "dirtyregions")
 	return r
 }
 
@@ -223,6 +304,12 @@ func NewSchema() *client.Schemas {
 	schemas.AddType("revertInput", RevertInput{})
 	schemas.AddType("journalInput", JournalInput{})
 	schemas.AddType("prepareRebuildOutput", PrepareRebuildOutput{})
+	schemas.AddType("verifyInput", VerifyInput{})
+	schemas.AddType("verifyOutput", VerifyOutput{})
+	schemas.AddType("dirtyRegionsOutput", DirtyRegionsOutput{})
+	schemas.AddType("replicationFactorInput", ReplicationFactorInput{})
+	schemas.AddType("qosInput", QoSInput{})
+	schemas.AddType("snapshotExportInput", SnapshotExportInput{})
 
 	replica := schemas.AddType("replica", Replica{})
 	replica.CollectionMethods = []string{"GET", "POST"}
@@ -231,6 +318,9 @@ func NewSchema() *client.Schemas {
 		"preparerebuild": {
 			Output: "prepareRebuildOutput",
 		},
+		"dirtyregions": {
+			Output: "dirtyRegionsOutput",
+		},
 	}
 
 	f := replica.ResourceFields["address"]
@@ -274,6 +364,26 @@ func NewSchema() *client.Schemas {
 		"setlogging": {
 			Input: "loggingInput",
 		},
+		"verify": {
+			Input:  "verifyInput",
+			Output: "verifyOutput",
+		},
+		"setreplicationfactor": {
+			Input:  "replicationFactorInput",
+			Output: "volume",
+		},
+		"setqos": {
+			Input:  "qosInput",
+			Output: "volume",
+		},
+		"attachsnapshot": {
+			Input:  "snapshotExportInput",
+			Output: "volume",
+		},
+		"detachsnapshot": {
+			Input:  "snapshotInput",
+			Output: "volume",
+		},
 	}
 
 	deleteReplica := schemas.AddType("delete", DeleteReplicaOutput{})
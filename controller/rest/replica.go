@@ -73,7 +73,9 @@ func (s *Server) ListReplicas(rw http.ResponseWriter, req *http.Request) error {
 	resp := client.GenericCollection{}
 	s.c.Lock()
 	for _, r := range s.c.ListReplicas() {
-		resp.Data = append(resp.Data, NewReplica(apiContext, r))
+		replica := NewReplica(apiContext, r)
+		replica.Degraded = s.c.IsDegraded(r.Address)
+		resp.Data = append(resp.Data, replica)
 	}
 	s.c.Unlock()
 
@@ -125,11 +127,13 @@ func (s *Server) RegisterReplica(rw http.ResponseWriter, req *http.Request) erro
 
 	localRevCount, _ = strconv.ParseInt(regReplica.RevCount, 10, 64)
 	local := types.RegReplica{
-		Address:  regReplica.Address,
-		RevCount: localRevCount,
-		RepType:  regReplica.RepType,
-		UpTime:   regReplica.UpTime,
-		RepState: regReplica.RepState,
+		Address:           regReplica.Address,
+		RevCount:          localRevCount,
+		RepType:           regReplica.RepType,
+		UpTime:            regReplica.UpTime,
+		RepState:          regReplica.RepState,
+		ReplicationFactor: regReplica.ReplicationFactor,
+		Epoch:             regReplica.Epoch,
 	}
 	code = http.StatusOK
 	rw.WriteHeader(code)
@@ -205,7 +209,9 @@ func (s *Server) getReplica(context *api.ApiContext, id string) *Replica {
 	defer s.c.Unlock()
 	for _, r := range s.c.ListReplicas() {
 		if r.Address == id {
-			return NewReplica(context, r)
+			replica := NewReplica(context, r)
+			replica.Degraded = s.c.IsDegraded(r.Address)
+			return replica
 		}
 	}
 	return nil
@@ -302,3 +308,28 @@ func (s *Server) VerifyRebuildReplica(rw http.ResponseWriter, req *http.Request)
 
 	return s.GetReplica(rw, req)
 }
+
+func (s *Server) DirtyRegions(rw http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	id, err := DencodeID(vars["id"])
+	if err != nil {
+		logrus.Errorf("Error %v in getting id while getting dirty regions", err)
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	regions, err := s.c.DirtyRegions(id)
+	if err != nil {
+		return err
+	}
+
+	apiContext := api.GetApiContext(req)
+	apiContext.Write(&DirtyRegionsOutput{
+		Resource: client.Resource{
+			Id:   id,
+			Type: "dirtyRegionsOutput",
+		},
+		DirtyRegions: *regions,
+	})
+	return nil
+}
@@ -45,12 +45,18 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods("GET").Path("/v1/volumes/{id}").Handler(f(schemas, s.GetVolume))
 	router.Methods("GET").Path("/v1/stats").Handler(f(schemas, s.GetVolumeStats))
 	router.Methods("GET").Path("/v1/checkpoint").Handler(f(schemas, s.GetCheckpoint))
+	router.Methods("GET").Path("/v1/events").Handler(f(schemas, s.StreamEvents))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "start").Handler(f(schemas, s.StartVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "shutdown").Handler(f(schemas, s.ShutdownVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "snapshot").Handler(f(schemas, s.SnapshotVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "revert").Handler(f(schemas, s.RevertVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "resize").Handler(f(schemas, s.ResizeVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "setlogging").Handler(f(schemas, s.SetLogging))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "verify").Handler(f(schemas, s.VerifyVolume))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "setreplicationfactor").Handler(f(schemas, s.SetReplicationFactor))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "setqos").Handler(f(schemas, s.SetQoS))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "attachsnapshot").Handler(f(schemas, s.AttachSnapshot))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "detachsnapshot").Handler(f(schemas, s.DetachSnapshot))
 	router.Methods("DELETE").Path("/v1/volumes/{id}").Queries("action", "deleteSnapshot").Handler(f(schemas, s.DeleteSnapshot))
 	// Replicas
 	router.Methods("GET").Path("/v1/replicas").Handler(f(schemas, s.ListReplicas))
@@ -60,6 +66,7 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods("POST").Path("/v1/quorumreplicas").Handler(f(schemas, s.CreateQuorumReplica))
 	router.Methods("POST").Path("/v1/replicas/{id}").Queries("action", "preparerebuild").Handler(f(schemas, s.PrepareRebuildReplica))
 	router.Methods("POST").Path("/v1/replicas/{id}").Queries("action", "verifyrebuild").Handler(f(schemas, s.VerifyRebuildReplica))
+	router.Methods("POST").Path("/v1/replicas/{id}").Queries("action", "dirtyregions").Handler(f(schemas, s.DirtyRegions))
 	router.Methods("DELETE").Path("/v1/replicas/{id}").Handler(f(schemas, s.DeleteReplica))
 	router.Methods("PUT").Path("/v1/replicas/{id}").Handler(f(schemas, s.UpdateReplica))
 	router.Handle("/metrics", promhttp.Handler())
@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/openebs/jiva/controller"
 	replicaClient "github.com/openebs/jiva/replica/client"
 	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
@@ -106,7 +107,7 @@ func (s *Server) PostSetLoggingRequest(replicas []types.Replica, lf util.LogToFi
 		addr := replica.Address
 		go func(addr string) {
 			defer wg.Done()
-			repClient, err := replicaClient.NewReplicaClient(addr)
+			repClient, err := s.c.NewReplicaClient(addr)
 			if err != nil {
 				appendError(errList, err, errLock)
 				return
@@ -130,6 +131,8 @@ func (s *Server) GetVolumeStats(rw http.ResponseWriter, req *http.Request) error
 	)
 	apiContext := api.GetApiContext(req)
 	stats, _ := s.c.Stats()
+	cacheHits, cacheMisses, cachedBytes := s.c.CacheStats()
+	qosStats := s.c.QoSStats()
 	s.c.RLock()
 	replicas = append(replicas, s.c.ListReplicas()...)
 	s.c.RUnlock()
@@ -156,6 +159,15 @@ func (s *Server) GetVolumeStats(rw http.ResponseWriter, req *http.Request) error
 		TotalWriteTime:       strconv.FormatInt(stats.TotalWriteTime, 10),
 		TotalWriteBlockCount: strconv.FormatInt(stats.TotalWriteBlockCount, 10),
 
+		CacheHits:   strconv.FormatInt(cacheHits, 10),
+		CacheMisses: strconv.FormatInt(cacheMisses, 10),
+		CachedBytes: strconv.FormatInt(cachedBytes, 10),
+
+		ThrottledReads:    strconv.FormatInt(qosStats.ThrottledReads, 10),
+		ThrottledWrites:   strconv.FormatInt(qosStats.ThrottledWrites, 10),
+		ReadThrottleTime:  strconv.FormatInt(int64(qosStats.ReadThrottleTime), 10),
+		WriteThrottleTime: strconv.FormatInt(int64(qosStats.WriteThrottleTime), 10),
+
 		UsedLogicalBlocks: strconv.FormatInt(stats.UsedLogicalBlocks, 10),
 		UsedBlocks:        strconv.FormatInt(stats.UsedBlocks, 10),
 		SectorSize:        strconv.FormatInt(stats.SectorSize, 10),
@@ -279,6 +291,117 @@ func (s *Server) ResizeVolume(rw http.ResponseWriter, req *http.Request) error {
 	return s.GetVolume(rw, req)
 }
 
+// SetReplicationFactor changes the replication factor of the volume
+func (s *Server) SetReplicationFactor(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	v := s.getVolume(apiContext, id)
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var input ReplicationFactorInput
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+
+	logrus.Infof("Set replication factor to %d", input.ReplicationFactor)
+	if err := s.c.SetReplicationFactor(input.ReplicationFactor); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+// SetQoS changes the limits of the I/Os of the volume
+func (s *Server) SetQoS(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	v := s.getVolume(apiContext, id)
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var input QoSInput
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+
+	limits := controller.QoSLimits{
+		ReadIOPS:  input.ReadIOPS,
+		WriteIOPS: input.WriteIOPS,
+		ReadBPS:   input.ReadBPS,
+		WriteBPS:  input.WriteBPS,
+	}
+	if input.Burst != "" {
+		burst, err := time.ParseDuration(input.Burst)
+		if err != nil {
+			return fmt.Errorf("Invalid QoS burst %q", input.Burst)
+		}
+		limits.Burst = burst
+	}
+	if err := s.c.SetQoS(limits); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+// AttachSnapshot serves a snapshot read only with a frontend
+func (s *Server) AttachSnapshot(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	v := s.getVolume(apiContext, id)
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var input SnapshotExportInput
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	if input.Snapshot == "" {
+		return fmt.Errorf("Snapshot to export is not set")
+	}
+	if _, err := s.c.AttachSnapshot(input.Snapshot, input.Frontend, input.Address); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	return s.GetVolume(rw, req)
+}
+
+// DetachSnapshot stops serving a snapshot
+func (s *Server) DetachSnapshot(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	v := s.getVolume(apiContext, id)
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var input SnapshotInput
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	if err := s.c.DetachSnapshot(input.Name); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	return s.GetVolume(rw, req)
+}
+
 func (s *Server) SnapshotVolume(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["id"]
@@ -309,6 +432,39 @@ func (s *Server) SnapshotVolume(rw http.ResponseWriter, req *http.Request) error
 	return nil
 }
 
+// VerifyVolume compares the data of the RW replicas and optionally
+// repairs the diverged blocks.
+func (s *Server) VerifyVolume(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+
+	v := s.getVolume(apiContext, id)
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var input VerifyInput
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+
+	logrus.Infof("Verify replicas, repair: %v", input.Repair)
+	result, err := s.c.Verify(input.Repair)
+	if err != nil {
+		logrus.Errorf("Failed to verify replicas, error: %v", err)
+		return err
+	}
+	apiContext.Write(&VerifyOutput{
+		Resource: client.Resource{
+			Id:   id,
+			Type: "verifyOutput",
+		},
+		VerifyResult: *result,
+	})
+	return nil
+}
+
 func (s *Server) StartVolume(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["id"]
@@ -332,9 +488,27 @@ func (s *Server) StartVolume(rw http.ResponseWriter, req *http.Request) error {
 }
 
 func (s *Server) listVolumes(context *api.ApiContext) []*Volume {
-	return []*Volume{
-		NewVolume(context, s.c.Name, s.c.ReadOnly, len(s.c.ListReplicas())),
-	}
+	v := NewVolume(context, s.c.Name, s.c.ReadOnly, len(s.c.ListReplicas()), s.c.ReplicationFactor,
+		string(s.c.AckPolicy), string(s.c.ReadPolicy))
+	v.Epoch = s.c.GetEpoch()
+	_, v.ControllerID = s.c.ClaimedEpoch()
+	qos := s.c.QoS()
+	v.QoS = QoS{
+		ReadIOPS:  qos.ReadIOPS,
+		WriteIOPS: qos.WriteIOPS,
+		ReadBPS:   qos.ReadBPS,
+		WriteBPS:  qos.WriteBPS,
+		Burst:     qos.Burst.String(),
+	}
+	v.Exports = []SnapshotExport{}
+	for _, export := range s.c.SnapshotExports() {
+		v.Exports = append(v.Exports, SnapshotExport{
+			Snapshot: export.Snapshot,
+			Frontend: export.Frontend,
+			Address:  export.Address,
+		})
+	}
+	return []*Volume{v}
 }
 
 func (s *Server) getVolume(context *api.ApiContext, id string) *Volume {
@@ -74,6 +74,8 @@ func (c *Controller) Revert(name string) error {
 		}
 	}
 
+	// the cached data is the one of the head before the revert
+	c.cache.clear()
 	if !minimalSuccess {
 		return fmt.Errorf("Fail to revert to %v on all replicas", name)
 	}
@@ -96,7 +98,7 @@ func (c *Controller) clientsAndSnapshot(name string) (map[string]*client.Replica
 			return nil, "", fmt.Errorf("Backend %s does not support revert", replica.Address)
 		}
 
-		repClient, err := client.NewReplicaClient(replica.Address)
+		repClient, err := c.NewReplicaClient(replica.Address)
 		if err != nil {
 			return nil, "", err
 		}
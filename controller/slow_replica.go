@@ -0,0 +1,235 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// latencyWindowSize is the number of the latest samples of the
+	// latency of a replica kept between two checks.
+	latencyWindowSize = 512
+	// minLatencySamples is the number of samples below which the latency
+	// of a replica isn't compared during a check.
+	minLatencySamples = 16
+	// outlierPercentile is the percentile of the latency compared
+	outlierPercentile = 0.9
+)
+
+// SlowReplicaPolicy selects the replicas removed from the read set as
+// their latency is an outlier compared to the one of their peers. They
+// are still written to, and read again once their latency recovers.
+type SlowReplicaPolicy struct {
+	// Factor is how many times the latency of a replica must exceed the
+	// median of the ones of its peers to be an outlier, 0 disables it.
+	Factor float64
+	// MinLatency is the latency below which a replica is never an
+	// outlier.
+	MinLatency time.Duration
+	// Interval is the interval of the checks of the latencies
+	Interval time.Duration
+	// Checks is the number of consecutive checks a replica must be an
+	// outlier to be degraded, and not be one to be reinstated.
+	Checks int
+}
+
+// WithSlowReplicaPolicy degrades the replicas consistently slower than
+// their peers.
+func WithSlowReplicaPolicy(policy SlowReplicaPolicy) BuildOpts {
+	return func(c *Controller) {
+		c.slowReplicaPolicy = policy
+	}
+}
+
+// latencyWindow holds the latest samples of the latency of the I/Os of a
+// replica since the last check.
+type latencyWindow struct {
+	sync.Mutex
+	samples [latencyWindowSize]time.Duration
+	count   int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.Lock()
+	w.samples[w.count%latencyWindowSize] = d
+	w.count++
+	w.Unlock()
+}
+
+// take returns the percentile p of the samples and their number, and
+// empties the window.
+func (w *latencyWindow) take(p float64) (time.Duration, int) {
+	w.Lock()
+	n := w.count
+	if n > latencyWindowSize {
+		n = latencyWindowSize
+	}
+	samples := append([]time.Duration{}, w.samples[:n]...)
+	w.count = 0
+	w.Unlock()
+
+	if n == 0 {
+		return 0, 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[int(p*float64(n-1))], n
+}
+
+// replicaHealth tracks whether a replica is an outlier among its peers
+type replicaHealth struct {
+	latency latencyWindow
+	// the following fields are accessed with the controller locked
+	degraded  bool
+	outliers  int
+	recovered int
+	// percentile is the one of the latency at the last check
+	percentile time.Duration
+}
+
+// checkSlowReplicas compares the latencies of the RW replicas, and
+// returns the replicas degraded and reinstated by the check.
+func (r *replicator) checkSlowReplicas(policy SlowReplicaPolicy) (degraded, reinstated []string) {
+	type sample struct {
+		address string
+		health  *replicaHealth
+		latency time.Duration
+	}
+	var samples []sample
+	readers := 0
+	for address, b := range r.backends {
+		if b.mode != types.RW {
+			continue
+		}
+		if !b.health.degraded {
+			readers++
+		}
+		latency, n := b.health.latency.take(outlierPercentile)
+		if n < minLatencySamples {
+			continue
+		}
+		b.health.percentile = latency
+		samples = append(samples, sample{address: address, health: b.health, latency: latency})
+	}
+
+	for i, s := range samples {
+		var peers []time.Duration
+		for j, peer := range samples {
+			if j != i {
+				peers = append(peers, peer.latency)
+			}
+		}
+		if len(peers) == 0 {
+			continue
+		}
+		sort.Slice(peers, func(i, j int) bool { return peers[i] < peers[j] })
+		median := peers[len(peers)/2]
+
+		h := s.health
+		if s.latency > policy.MinLatency && float64(s.latency) > policy.Factor*float64(median) {
+			h.outliers++
+			h.recovered = 0
+		} else {
+			h.recovered++
+			h.outliers = 0
+		}
+		switch {
+		case !h.degraded && h.outliers >= policy.Checks && readers > 1:
+			logrus.Warningf("Replica %s is degraded, its latency %v is above %.1f times the one of its peers %v",
+				s.address, s.latency, policy.Factor, median)
+			h.degraded = true
+			readers--
+			degraded = append(degraded, s.address)
+		case h.degraded && h.recovered >= policy.Checks:
+			logrus.Infof("Replica %s is reinstated, its latency %v is back in line with the one of its peers %v",
+				s.address, s.latency, median)
+			h.degraded = false
+			readers++
+			reinstated = append(reinstated, s.address)
+		}
+	}
+	if len(degraded) > 0 || len(reinstated) > 0 {
+		r.buildReadWriters()
+	}
+	return degraded, reinstated
+}
+
+// startSlowReplicaMonitor starts the checks of the latencies of the
+// replicas if the policy is enabled and they aren't running yet.
+func (c *Controller) startSlowReplicaMonitor() {
+	if c.slowReplicaPolicy.Factor <= 0 || c.slowReplicaStop != nil {
+		return
+	}
+	c.slowReplicaStop = make(chan struct{})
+	go c.monitorSlowReplicas(c.slowReplicaStop)
+}
+
+// stopSlowReplicaMonitor stops the checks of the latencies of the
+// replicas, the volume is shutting down.
+func (c *Controller) stopSlowReplicaMonitor() {
+	c.Lock()
+	defer c.Unlock()
+	if c.slowReplicaStop != nil {
+		close(c.slowReplicaStop)
+		c.slowReplicaStop = nil
+	}
+}
+
+// monitorSlowReplicas checks the latencies of the replicas periodically
+// until stop is closed.
+func (c *Controller) monitorSlowReplicas(stop chan struct{}) {
+	ticker := time.NewTicker(c.slowReplicaPolicy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		c.Lock()
+		degraded, reinstated := c.backend.checkSlowReplicas(c.slowReplicaPolicy)
+		c.Unlock()
+		for _, address := range degraded {
+			c.events.publish(types.Event{Type: types.EventReplicaDegraded, Replica: address})
+		}
+		for _, address := range reinstated {
+			c.events.publish(types.Event{Type: types.EventReplicaReinstated, Replica: address})
+		}
+	}
+}
+
+// IsDegraded returns true if the replica is removed from the read set as
+// it is slower than its peers, the controller must be locked.
+func (c *Controller) IsDegraded(address string) bool {
+	b, ok := c.backend.backends[address]
+	return ok && b.health.degraded
+}
+
+// replicaLatency returns the percentile of the latency of the replica at
+// the last check, 0 if it wasn't measured. The controller must be locked.
+func (c *Controller) replicaLatency(address string) time.Duration {
+	b, ok := c.backend.backends[address]
+	if !ok {
+		return 0
+	}
+	return b.health.percentile
+}
@@ -0,0 +1,95 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openebs/jiva/types"
+)
+
+func TestCheckSlowReplicas(t *testing.T) {
+	policy := SlowReplicaPolicy{Factor: 4, MinLatency: 10 * time.Millisecond, Checks: 2}
+	replicas := []string{"tcp://127.0.0.1:9502", "tcp://127.0.0.2:9502", "tcp://127.0.0.3:9502"}
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		// latencies are the ones of each replica at each check, 0 for
+		// no samples
+		latencies  [][]time.Duration
+		degraded   []string
+		reinstated []string
+		readers    int
+	}{
+		{
+			name:      "consistent outlier",
+			latencies: [][]time.Duration{{1 * ms, 2 * ms, 50 * ms}, {1 * ms, 2 * ms, 50 * ms}},
+			degraded:  replicas[2:],
+			readers:   2,
+		},
+		{
+			name:      "transient outlier",
+			latencies: [][]time.Duration{{1 * ms, 2 * ms, 50 * ms}, {1 * ms, 2 * ms, 3 * ms}, {1 * ms, 2 * ms, 50 * ms}},
+			readers:   3,
+		},
+		{
+			name:      "below the minimum latency",
+			latencies: [][]time.Duration{{1 * ms, 1 * ms, 9 * ms}, {1 * ms, 1 * ms, 9 * ms}},
+			readers:   3,
+		},
+		{
+			name:      "not enough samples",
+			latencies: [][]time.Duration{{1 * ms, 2 * ms, 0}, {1 * ms, 2 * ms, 0}},
+			readers:   3,
+		},
+		{
+			name: "recovered",
+			latencies: [][]time.Duration{{1 * ms, 2 * ms, 50 * ms}, {1 * ms, 2 * ms, 50 * ms},
+				{1 * ms, 2 * ms, 3 * ms}, {1 * ms, 2 * ms, 3 * ms}},
+			degraded:   replicas[2:],
+			reinstated: replicas[2:],
+			readers:    3,
+		},
+	}
+	for _, test := range tests {
+		r := &replicator{}
+		for _, address := range replicas {
+			r.AddBackend(address, nil)
+			r.SetMode(address, types.RW)
+		}
+		var degraded, reinstated []string
+		for _, latencies := range test.latencies {
+			for i, latency := range latencies {
+				for j := 0; latency > 0 && j < minLatencySamples; j++ {
+					r.backends[replicas[i]].health.latency.add(latency)
+				}
+			}
+			d, rs := r.checkSlowReplicas(policy)
+			degraded = append(degraded, d...)
+			reinstated = append(reinstated, rs...)
+		}
+		if !reflect.DeepEqual(degraded, test.degraded) || !reflect.DeepEqual(reinstated, test.reinstated) {
+			t.Errorf("%s: degraded %v and reinstated %v, expected %v and %v",
+				test.name, degraded, reinstated, test.degraded, test.reinstated)
+		}
+		if len(r.readers) != test.readers {
+			t.Errorf("%s: %d readers, expected %d", test.name, len(r.readers), test.readers)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/openebs/jiva/replica"
+	replicaClient "github.com/openebs/jiva/replica/client"
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// verifyChunkSize is the size of the blocks whose hashes are compared
+	// first, the blocks of verifyBlockSize of the chunks which differ are
+	// then compared to find the diverged ranges.
+	verifyChunkSize = 1 << 20
+	verifyBlockSize = 4096
+	// verifyBatchSize is the size of the ranges hashed at once, the
+	// writes to the range of the head being verified wait for it.
+	verifyBatchSize = replica.MaxVerifyLength
+)
+
+// divergence is a range of blocks which diverge the same way, replicas
+// are indexes in the replicas being verified.
+type divergence struct {
+	offset   int64
+	length   int64
+	diverged []int
+	// majority is the index of a replica holding the data of the
+	// majority, -1 if there is no majority.
+	majority int
+}
+
+// compareHashes compares the hashes of the blocks of each replica, an
+// empty hash is a block which couldn't be read and never matches.
+func compareHashes(hashes [][]string, offset, blockSize int64) []divergence {
+	var result []divergence
+	if len(hashes) == 0 {
+		return nil
+	}
+	for b := range hashes[0] {
+		votes := map[string]int{}
+		for _, h := range hashes {
+			if h[b] != "" {
+				votes[h[b]]++
+			}
+		}
+		majority := -1
+		var diverged []int
+		for i, h := range hashes {
+			if h[b] != "" && votes[h[b]]*2 > len(hashes) {
+				if majority == -1 {
+					majority = i
+				}
+				continue
+			}
+			diverged = append(diverged, i)
+		}
+		// without majority all the replicas are diverged
+		if majority != -1 && len(diverged) == 0 {
+			continue
+		}
+
+		off := offset + int64(b)*blockSize
+		if n := len(result); n > 0 {
+			last := &result[n-1]
+			if last.offset+last.length == off && last.majority == majority &&
+				reflect.DeepEqual(last.diverged, diverged) {
+				last.length += blockSize
+				continue
+			}
+		}
+		result = append(result, divergence{
+			offset:   off,
+			length:   blockSize,
+			diverged: diverged,
+			majority: majority,
+		})
+	}
+	return result
+}
+
+type verifier struct {
+	c         *Controller
+	repair    bool
+	addresses []string
+	clients   []*replicaClient.ReplicaClient
+	result    types.VerifyResult
+}
+
+// Verify compares the hashes of the data of the RW replicas, snapshot by
+// snapshot from the oldest one and then the head, and reports the ranges
+// whose data diverged. If repair is set, the diverged blocks are rewritten
+// with the data of the majority of the replicas.
+func (c *Controller) Verify(repair bool) (*types.VerifyResult, error) {
+	c.Lock()
+	if c.verifyInProgress {
+		c.Unlock()
+		return nil, fmt.Errorf("Verify already in progress")
+	}
+	if c.IsSnapDeletionInProgress {
+		c.Unlock()
+		return nil, fmt.Errorf("Can't verify replicas, snapshot deletion is in progress")
+	}
+	v := &verifier{
+		c:      c,
+		repair: repair,
+	}
+	for _, r := range c.replicas {
+		if r.Mode == types.RW {
+			v.addresses = append(v.addresses, r.Address)
+		}
+	}
+	size := c.size - c.size%verifyBlockSize
+	if len(v.addresses) < 2 {
+		c.Unlock()
+		return nil, fmt.Errorf("Can't verify replicas, RW replica count: %d", len(v.addresses))
+	}
+	c.verifyInProgress = true
+	c.Unlock()
+
+	defer func() {
+		c.Lock()
+		c.verifyInProgress = false
+		c.Unlock()
+	}()
+
+	v.result.Replicas = v.addresses
+	for _, address := range v.addresses {
+		client, err := c.NewReplicaClient(address)
+		if err != nil {
+			return nil, err
+		}
+		v.clients = append(v.clients, client)
+	}
+
+	snapshots, err := v.commonSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	v.result.Snapshots = snapshots
+
+	logrus.Infof("Verifying replicas %v, snapshots: %v, repair: %v", v.addresses, snapshots, repair)
+	for _, snapshot := range snapshots {
+		for off := int64(0); off < size; off += verifyBatchSize {
+			if err := v.verifySnapshot(snapshot, off, batchLength(off, size)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for off := int64(0); off < size; off += verifyBatchSize {
+		if err := v.verifyHead(off, batchLength(off, size)); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Infof("Verified %d bytes of replicas %v, diverged ranges: %d",
+		v.result.VerifiedBytes, v.addresses, len(v.result.Diverged))
+	return &v.result, nil
+}
+
+func batchLength(offset, size int64) int64 {
+	if size-offset < verifyBatchSize {
+		return size - offset
+	}
+	return verifyBatchSize
+}
+
+// commonSnapshots returns the snapshots found in the chain of all the
+// replicas, from the oldest one. The snapshots being removed are skipped.
+func (v *verifier) commonSnapshots() ([]string, error) {
+	var chain []string
+	count := map[string]int{}
+	for i, client := range v.clients {
+		rep, err := client.GetReplica()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get replica %s, error: %v", v.addresses[i], err)
+		}
+		if len(rep.Chain) == 0 {
+			return nil, fmt.Errorf("Failed to get the chain of replica %s", v.addresses[i])
+		}
+		if i == 0 {
+			chain = rep.Chain
+		}
+		for _, disk := range rep.Chain[1:] {
+			if d, ok := rep.Disks[disk]; !ok || !d.Removed {
+				count[disk]++
+			}
+		}
+	}
+
+	var snapshots []string
+	for i := len(chain) - 1; i > 0; i-- {
+		if count[chain[i]] == len(v.clients) {
+			snapshots = append(snapshots, chain[i])
+		}
+	}
+	return snapshots, nil
+}
+
+// hash returns the hashes of the blocks of the range on each replica
+func (v *verifier) hash(snapshot string, offset, length, blockSize int64) ([][]string, error) {
+	hashes := make([][]string, len(v.clients))
+	for i, client := range v.clients {
+		h, err := client.HashData(snapshot, offset, length, blockSize)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to hash %d bytes at offset %d of %q on %s, error: %v",
+				length, offset, snapshot, v.addresses[i], err)
+		}
+		if int64(len(h)) != length/blockSize {
+			return nil, fmt.Errorf("Got %d hashes from %s, expected %d", len(h), v.addresses[i], length/blockSize)
+		}
+		hashes[i] = h
+	}
+	return hashes, nil
+}
+
+// compare returns the diverged ranges of the range, the chunks which
+// differ are compared again by blocks.
+func (v *verifier) compare(snapshot string, offset, length int64) ([]divergence, error) {
+	chunkSize := int64(verifyChunkSize)
+	if length%chunkSize != 0 {
+		chunkSize = verifyBlockSize
+	}
+	hashes, err := v.hash(snapshot, offset, length, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	chunks := compareHashes(hashes, offset, chunkSize)
+	if chunkSize == verifyBlockSize {
+		return chunks, nil
+	}
+
+	var result []divergence
+	for _, chunk := range chunks {
+		for off := chunk.offset; off < chunk.offset+chunk.length; off += chunkSize {
+			hashes, err := v.hash(snapshot, off, chunkSize, verifyBlockSize)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, compareHashes(hashes, off, verifyBlockSize)...)
+		}
+	}
+	return result, nil
+}
+
+// verifySnapshot compares a range of a snapshot, the diverged blocks are
+// repaired by writing the data of the majority in the snapshot of the
+// other replicas.
+func (v *verifier) verifySnapshot(snapshot string, offset, length int64) error {
+	diverged, err := v.compare(snapshot, offset, length)
+	if err != nil {
+		return err
+	}
+	for _, d := range diverged {
+		repaired := false
+		if v.repair && d.majority != -1 {
+			data, err := v.read(snapshot, d)
+			if err != nil {
+				return err
+			}
+			for _, i := range d.diverged {
+				logrus.Infof("Repairing %d bytes at offset %d of %s on %s", d.length, d.offset, snapshot, v.addresses[i])
+				if err := v.clients[i].WriteSnapshot(snapshot, d.offset, data); err != nil {
+					return fmt.Errorf("Failed to repair %d bytes at offset %d of %s on %s, error: %v",
+						d.length, d.offset, snapshot, v.addresses[i], err)
+				}
+			}
+			// the head may read the repaired snapshot
+			v.c.invalidateCache(d.offset, d.length)
+			repaired = true
+		}
+		v.add(snapshot, d, repaired)
+	}
+	v.result.VerifiedBytes += length
+	return nil
+}
+
+// verifyHead compares a range of the head, the writes to the range wait
+// for it. The diverged blocks are repaired by writing the data of the
+// majority to all the replicas.
+func (v *verifier) verifyHead(offset, length int64) error {
+	c := v.c
+	c.RLock()
+	unlock := c.ioLock.lock(offset, length, true)
+	c.backend.waitWrites(offset, length)
+	diverged, err := v.compare("", offset, length)
+	var repaired []bool
+	if err == nil && v.repair {
+		repaired, err = v.repairHead(diverged)
+		c.cache.invalidate(offset, length)
+	}
+	unlock()
+	c.RUnlock()
+
+	if bErr, ok := err.(*BackendError); ok {
+		_ = c.handleIOError(bErr)
+	}
+	if err != nil {
+		return err
+	}
+	for i, d := range diverged {
+		v.add("", d, i < len(repaired) && repaired[i])
+	}
+	v.result.VerifiedBytes += length
+	return nil
+}
+
+func (v *verifier) repairHead(diverged []divergence) ([]bool, error) {
+	c := v.c
+	if c.ReadOnly {
+		return nil, fmt.Errorf("Can't repair replicas, volume is in read only mode")
+	}
+	repaired := make([]bool, len(diverged))
+	for i, d := range diverged {
+		if d.majority == -1 {
+			continue
+		}
+		data, err := v.read("", d)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Repairing %d bytes at offset %d of the head", d.length, d.offset)
+		_, err = c.backend.WriteAt(data, d.offset)
+		c.markDirty(err, d.offset, d.length, true)
+		if err != nil {
+			return nil, err
+		}
+		repaired[i] = true
+	}
+	return repaired, nil
+}
+
+// read returns the data of the majority of the diverged range
+func (v *verifier) read(snapshot string, d divergence) ([]byte, error) {
+	data, err := v.clients[d.majority].ReadSnapshot(snapshot, d.offset, d.length)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %d bytes at offset %d of %q on %s, error: %v",
+			d.length, d.offset, snapshot, v.addresses[d.majority], err)
+	}
+	return data, nil
+}
+
+// add reports the diverged range, merging it with the previous one if
+// they are contiguous and diverged the same way.
+func (v *verifier) add(snapshot string, d divergence, repaired bool) {
+	var replicas []string
+	for _, i := range d.diverged {
+		replicas = append(replicas, v.addresses[i])
+	}
+	logrus.Warningf("Data of %s diverged at offset %d, length %d, on replicas %v, repaired: %v",
+		describeSnapshot(snapshot), d.offset, d.length, replicas, repaired)
+
+	if n := len(v.result.Diverged); n > 0 {
+		last := &v.result.Diverged[n-1]
+		if last.Snapshot == snapshot && last.Offset+last.Length == d.offset &&
+			last.NoMajority == (d.majority == -1) && last.Repaired == repaired &&
+			reflect.DeepEqual(last.Replicas, replicas) {
+			last.Length += d.length
+			return
+		}
+	}
+	v.result.Diverged = append(v.result.Diverged, types.DivergedRange{
+		Snapshot:   snapshot,
+		Offset:     d.offset,
+		Length:     d.length,
+		Replicas:   replicas,
+		NoMajority: d.majority == -1,
+		Repaired:   repaired,
+	})
+}
+
+func describeSnapshot(snapshot string) string {
+	if snapshot == "" {
+		return "head"
+	}
+	return strings.TrimSuffix(snapshot, ".img")
+}
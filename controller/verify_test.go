@@ -0,0 +1,91 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareHashes(t *testing.T) {
+	tests := []struct {
+		name     string
+		hashes   [][]string
+		expected []divergence
+	}{
+		{
+			name: "identical",
+			hashes: [][]string{
+				{"a", "b", "c"},
+				{"a", "b", "c"},
+				{"a", "b", "c"},
+			},
+		},
+		{
+			name: "one replica diverged",
+			hashes: [][]string{
+				{"a", "b", "c", "d"},
+				{"a", "x", "y", "d"},
+				{"a", "b", "c", "d"},
+			},
+			expected: []divergence{
+				{offset: 110, length: 20, diverged: []int{1}, majority: 0},
+			},
+		},
+		{
+			name: "unreadable block",
+			hashes: [][]string{
+				{"a", ""},
+				{"a", "b"},
+				{"a", "b"},
+			},
+			expected: []divergence{
+				{offset: 110, length: 10, diverged: []int{0}, majority: 1},
+			},
+		},
+		{
+			name: "no majority",
+			hashes: [][]string{
+				{"a", "b", "c"},
+				{"a", "x", "y"},
+			},
+			expected: []divergence{
+				{offset: 110, length: 20, diverged: []int{0, 1}, majority: -1},
+			},
+		},
+		{
+			name: "different replicas diverged",
+			hashes: [][]string{
+				{"x", "b", ""},
+				{"a", "y", ""},
+				{"a", "b", ""},
+			},
+			expected: []divergence{
+				{offset: 100, length: 10, diverged: []int{0}, majority: 1},
+				{offset: 110, length: 10, diverged: []int{1}, majority: 0},
+				{offset: 120, length: 10, diverged: []int{0, 1, 2}, majority: -1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got := compareHashes(tt.hashes, 100, 10)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: compareHashes() = %+v, expected %+v", tt.name, got, tt.expected)
+		}
+	}
+}
@@ -49,3 +49,6 @@ var UpdateLUNMapTimeoutTriggered bool
 
 // AddUpdateLUNMapTimeout adds delay during UpdateLUNMap
 func AddUpdateLUNMapTimeout() {}
+
+// CrashAt is used for crashing the replica in the middle of an operation
+func CrashAt(point string) {}
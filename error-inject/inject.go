@@ -21,14 +21,15 @@ package inject
 import (
 	"os"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	pingTimeout = true
-)
+// pingTimeoutDone is set once the ping timeout has been added, it is
+// accessed atomically as both the rpc client and server add it.
+var pingTimeoutDone int32
 
 var Envs map[string](map[string]bool)
 
@@ -50,11 +51,10 @@ func AddTimeout() {
 
 // AddPingTimeout add delay in ping response
 func AddPingTimeout() {
-	if pingTimeout {
+	if atomic.CompareAndSwapInt32(&pingTimeoutDone, 0, 1) {
 		timeout, _ := strconv.Atoi(os.Getenv("RPC_PING_TIMEOUT"))
 		logrus.Infof("Add ping timeout of %vs for debug build", timeout)
 		time.Sleep(time.Duration(timeout) * time.Second)
-		pingTimeout = false
 	}
 }
 
@@ -98,3 +98,13 @@ func AddUpdateLUNMapTimeout() {
 	UpdateLUNMapTimeoutTriggered = true
 	time.Sleep(time.Duration(timeout) * time.Second)
 }
+
+// CrashAt exits the replica at the crash point named by the CRASH_POINT
+// env without running the deferred calls, to test the recovery of the
+// operations interrupted by a crash.
+func CrashAt(point string) {
+	if os.Getenv("CRASH_POINT") == point {
+		logrus.Errorf("Crash replica at %s for debug build", point)
+		os.Exit(2)
+	}
+}
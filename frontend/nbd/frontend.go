@@ -0,0 +1,231 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package nbd serves the volume over the NBD protocol, it can be used
+// with nbd-client or qemu on the hosts without an iSCSI initiator.
+package nbd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openebs/jiva/types"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "nbd-frontend"})
+)
+
+// DefaultAddress is the address the NBD server listens on if none is set
+const DefaultAddress = "localhost:10809"
+
+type Device struct {
+	// size is accessed atomically, it is kept first to be aligned
+	size int64
+
+	sync.Mutex
+	Name string
+	// Address is either a TCP address, host:port or tcp://host:port, or
+	// the path of a Unix socket, unix:///path.
+	Address    string
+	SectorSize int64
+
+	backend  types.IOs
+	listener net.Listener
+	conns    map[net.Conn]bool
+	isUp     bool
+	stats    types.Stats
+}
+
+func New() types.Frontend {
+	return &Device{}
+}
+
+// SetAddress sets the address the NBD server listens on
+func (d *Device) SetAddress(address string) {
+	d.Address = address
+}
+
+func (d *Device) Startup(name string, frontendIP string, clusterIP string, size, sectorSize int64, rw types.IOs) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.listener != nil {
+		_ = d.stop()
+	}
+
+	network, address, err := parseAddress(d.Address)
+	if err != nil {
+		return err
+	}
+	if network == "unix" {
+		// remove the socket left by a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s, error: %v", d.Address, err)
+	}
+
+	d.Name = name
+	d.SectorSize = sectorSize
+	d.backend = rw
+	atomic.StoreInt64(&d.size, size)
+	d.listener = l
+	d.conns = map[net.Conn]bool{}
+	d.isUp = true
+
+	log.Infof("NBD frontend listening on %s://%s", network, address)
+	go d.serve(l)
+	return nil
+}
+
+func parseAddress(address string) (string, string, error) {
+	switch {
+	case address == "":
+		return "tcp", DefaultAddress, nil
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.Contains(address, "://"):
+		return "", "", fmt.Errorf("Invalid NBD address %s", address)
+	}
+	return "tcp", address, nil
+}
+
+func (d *Device) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			d.Lock()
+			closed := d.listener != l
+			d.Unlock()
+			if !closed {
+				log.Errorf("Failed to accept NBD connection, error: %v", err)
+			}
+			return
+		}
+		if !d.track(l, conn) {
+			conn.Close()
+			return
+		}
+		go func() {
+			log.Infof("NBD client connected from %v", conn.RemoteAddr())
+			s := &session{d: d, conn: conn}
+			if err := s.run(); err != nil {
+				log.Errorf("NBD connection from %v failed, error: %v", conn.RemoteAddr(), err)
+			} else {
+				log.Infof("NBD client disconnected from %v", conn.RemoteAddr())
+			}
+			d.untrack(conn)
+		}()
+	}
+}
+
+// track adds the connection to the connections closed on shutdown, it
+// returns false if the listener it comes from has been closed.
+func (d *Device) track(l net.Listener, conn net.Conn) bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.listener != l {
+		return false
+	}
+	d.conns[conn] = true
+	d.stats.IsClientConnected = true
+	return true
+}
+
+func (d *Device) untrack(conn net.Conn) {
+	d.Lock()
+	defer d.Unlock()
+	conn.Close()
+	delete(d.conns, conn)
+	d.stats.IsClientConnected = len(d.conns) > 0
+}
+
+// Shutdown closes the listener and the connections, the I/Os in flight are
+// not waited for as they may wait for the controller which is shutting
+// down the frontend.
+func (d *Device) Shutdown() error {
+	d.Lock()
+	defer d.Unlock()
+	return d.stop()
+}
+
+func (d *Device) stop() error {
+	if d.listener == nil {
+		return nil
+	}
+	err := d.listener.Close()
+	d.listener = nil
+	for conn := range d.conns {
+		conn.Close()
+		delete(d.conns, conn)
+	}
+	d.stats.IsClientConnected = false
+	d.isUp = false
+	log.Infof("NBD frontend stopped")
+	return err
+}
+
+func (d *Device) State() types.State {
+	d.Lock()
+	defer d.Unlock()
+	if d.isUp {
+		return types.StateUp
+	}
+	return types.StateDown
+}
+
+func (d *Device) Stats() types.Stats {
+	d.Lock()
+	defer d.Unlock()
+	return d.stats
+}
+
+// Resize sets the size of the volume, it is exported to the clients
+// connecting from now on.
+func (d *Device) Resize(size uint64) error {
+	atomic.StoreInt64(&d.size, int64(size))
+	return nil
+}
+
+func (d *Device) getSize() int64 {
+	return atomic.LoadInt64(&d.size)
+}
+
+func (d *Device) addStats(cmd uint16, length uint32, start time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	switch cmd {
+	case cmdRead:
+		d.stats.ReadIOPS++
+		d.stats.TotalReadTime += int64(time.Since(start))
+		d.stats.TotalReadBlockCount += int64(length)
+	case cmdWrite, cmdWriteZeroes:
+		d.stats.WriteIOPS++
+		d.stats.TotalWriteTime += int64(time.Since(start))
+		d.stats.TotalWriteBlockCount += int64(length)
+	}
+}
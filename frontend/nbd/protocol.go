@@ -0,0 +1,123 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package nbd
+
+// Constants of the NBD protocol, see
+// https://github.com/NetworkBlockDevice/nbd/blob/master/doc/proto.md
+const (
+	nbdMagic        = 0x4e42444d41474943 // "NBDMAGIC"
+	optMagic        = 0x49484156454f5054 // "IHAVEOPT"
+	optReplyMagic   = 0x3e889045565a9
+	requestMagic    = 0x25609513
+	simpleMagic     = 0x67446698
+	structuredMagic = 0x668e33ef
+
+	// handshake flags
+	flagFixedNewstyle = 1 << 0
+	flagNoZeroes      = 1 << 1
+
+	// client flags
+	flagClientFixedNewstyle = 1 << 0
+	flagClientNoZeroes      = 1 << 1
+
+	// transmission flags
+	flagHasFlags        = 1 << 0
+	flagSendFlush       = 1 << 2
+	flagSendFUA         = 1 << 3
+	flagSendTrim        = 1 << 5
+	flagSendWriteZeroes = 1 << 6
+	flagSendDF          = 1 << 7
+	flagCanMultiConn    = 1 << 8
+
+	// options
+	optExportName      = 1
+	optAbort           = 2
+	optList            = 3
+	optInfo            = 6
+	optGo              = 7
+	optStructuredReply = 8
+
+	// option replies
+	repAck        = 1
+	repServer     = 2
+	repInfo       = 3
+	repErrUnsup   = 1<<31 + 1
+	repErrInvalid = 1<<31 + 3
+	repErrUnknown = 1<<31 + 6
+
+	// information types
+	infoExport    = 0
+	infoBlockSize = 3
+
+	// commands
+	cmdRead        = 0
+	cmdWrite       = 1
+	cmdDisc        = 2
+	cmdFlush       = 3
+	cmdTrim        = 4
+	cmdWriteZeroes = 6
+
+	// command flags
+	cmdFlagFUA = 1 << 0
+
+	// structured reply flags and types
+	replyFlagDone       = 1 << 0
+	replyTypeNone       = 0
+	replyTypeOffsetData = 1
+	replyTypeError      = 1<<15 + 1
+
+	// errors
+	errIO    = 5
+	errInval = 22
+	errNoSpc = 28
+)
+
+type optionHeader struct {
+	Magic  uint64
+	Option uint32
+	Length uint32
+}
+
+type optionReplyHeader struct {
+	Magic  uint64
+	Option uint32
+	Type   uint32
+	Length uint32
+}
+
+type request struct {
+	Magic  uint32
+	Flags  uint16
+	Type   uint16
+	Handle uint64
+	Offset uint64
+	Length uint32
+}
+
+type simpleReply struct {
+	Magic  uint32
+	Error  uint32
+	Handle uint64
+}
+
+type structuredReply struct {
+	Magic  uint32
+	Flags  uint16
+	Type   uint16
+	Handle uint64
+	Length uint32
+}
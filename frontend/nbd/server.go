@@ -0,0 +1,396 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package nbd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRequestSize is the largest read or write accepted, a larger
+	// write closes the connection as its data can't be skipped safely.
+	maxRequestSize = 32 << 20
+	// maxOptionSize is the largest option data accepted while
+	// negotiating.
+	maxOptionSize = 64 << 10
+	// maxInflight is the number of requests of a connection served
+	// concurrently.
+	maxInflight = 64
+	// zeroesSize is the size of the writes of zeroes
+	zeroesSize = 1 << 20
+)
+
+var errAborted = errors.New("Client aborted the negotiation")
+
+// session is a connection of a client, from the negotiation of the export
+// to the disconnection.
+type session struct {
+	d          *Device
+	conn       net.Conn
+	structured bool
+
+	// writeLock serializes the replies, the requests are served
+	// concurrently and their replies may be sent in any order.
+	writeLock sync.Mutex
+}
+
+func (s *session) run() error {
+	if err := s.negotiate(); err != nil {
+		if err == errAborted {
+			return nil
+		}
+		return err
+	}
+	return s.transmit()
+}
+
+func (s *session) write(v ...interface{}) error {
+	var buf bytes.Buffer
+	for _, data := range v {
+		if b, ok := data.([]byte); ok {
+			buf.Write(b)
+			continue
+		}
+		if err := binary.Write(&buf, binary.BigEndian, data); err != nil {
+			return err
+		}
+	}
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// negotiate runs the fixed newstyle handshake, until the client selects
+// the export.
+func (s *session) negotiate() error {
+	if err := s.write(uint64(nbdMagic), uint64(optMagic), uint16(flagFixedNewstyle|flagNoZeroes)); err != nil {
+		return err
+	}
+	var clientFlags uint32
+	if err := binary.Read(s.conn, binary.BigEndian, &clientFlags); err != nil {
+		return err
+	}
+	if clientFlags&^uint32(flagClientFixedNewstyle|flagClientNoZeroes) != 0 {
+		return fmt.Errorf("Unknown client flags %#x", clientFlags)
+	}
+	noZeroes := clientFlags&flagClientNoZeroes != 0
+
+	for {
+		var hdr optionHeader
+		if err := binary.Read(s.conn, binary.BigEndian, &hdr); err != nil {
+			return err
+		}
+		if hdr.Magic != optMagic {
+			return fmt.Errorf("Invalid option magic %#x", hdr.Magic)
+		}
+		if hdr.Length > maxOptionSize {
+			return fmt.Errorf("Option %d of %d bytes is too large", hdr.Option, hdr.Length)
+		}
+		data := make([]byte, hdr.Length)
+		if _, err := io.ReadFull(s.conn, data); err != nil {
+			return err
+		}
+
+		switch hdr.Option {
+		case optExportName:
+			if name := string(data); name != "" && name != s.d.Name {
+				// this option can't fail, the connection is closed
+				return fmt.Errorf("Unknown export %q", name)
+			}
+			reply := []interface{}{uint64(s.d.getSize()), s.transmissionFlags()}
+			if !noZeroes {
+				reply = append(reply, make([]byte, 124))
+			}
+			return s.write(reply...)
+		case optAbort:
+			_ = s.optionReply(hdr.Option, repAck, nil)
+			return errAborted
+		case optList:
+			if len(data) != 0 {
+				if err := s.optionReply(hdr.Option, repErrInvalid, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			var name bytes.Buffer
+			_ = binary.Write(&name, binary.BigEndian, uint32(len(s.d.Name)))
+			name.WriteString(s.d.Name)
+			if err := s.optionReply(hdr.Option, repServer, name.Bytes()); err != nil {
+				return err
+			}
+			if err := s.optionReply(hdr.Option, repAck, nil); err != nil {
+				return err
+			}
+		case optStructuredReply:
+			if len(data) != 0 {
+				if err := s.optionReply(hdr.Option, repErrInvalid, nil); err != nil {
+					return err
+				}
+				continue
+			}
+			s.structured = true
+			if err := s.optionReply(hdr.Option, repAck, nil); err != nil {
+				return err
+			}
+		case optInfo, optGo:
+			done, err := s.info(hdr.Option, data)
+			if err != nil || done {
+				return err
+			}
+		default:
+			if err := s.optionReply(hdr.Option, repErrUnsup, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *session) optionReply(option, replyType uint32, data []byte) error {
+	return s.write(optionReplyHeader{
+		Magic:  optReplyMagic,
+		Option: option,
+		Type:   replyType,
+		Length: uint32(len(data)),
+	}, data)
+}
+
+// info replies to NBD_OPT_INFO and NBD_OPT_GO, it returns true if the
+// transmission starts.
+func (s *session) info(option uint32, data []byte) (bool, error) {
+	var blockSize bool
+	r := bytes.NewReader(data)
+	var nameLength uint32
+	err := binary.Read(r, binary.BigEndian, &nameLength)
+	if err == nil && nameLength > uint32(r.Len()) {
+		err = fmt.Errorf("Invalid name length %d", nameLength)
+	}
+	var name []byte
+	var count uint16
+	if err == nil {
+		name = make([]byte, nameLength)
+		_, _ = r.Read(name)
+		err = binary.Read(r, binary.BigEndian, &count)
+	}
+	for i := 0; err == nil && i < int(count); i++ {
+		var info uint16
+		if err = binary.Read(r, binary.BigEndian, &info); err == nil && info == infoBlockSize {
+			blockSize = true
+		}
+	}
+	if err != nil || r.Len() != 0 {
+		return false, s.optionReply(option, repErrInvalid, nil)
+	}
+	if len(name) != 0 && string(name) != s.d.Name {
+		return false, s.optionReply(option, repErrUnknown, nil)
+	}
+
+	var export bytes.Buffer
+	_ = binary.Write(&export, binary.BigEndian, uint16(infoExport))
+	_ = binary.Write(&export, binary.BigEndian, uint64(s.d.getSize()))
+	_ = binary.Write(&export, binary.BigEndian, s.transmissionFlags())
+	if err := s.optionReply(option, repInfo, export.Bytes()); err != nil {
+		return false, err
+	}
+	if blockSize {
+		var sizes bytes.Buffer
+		_ = binary.Write(&sizes, binary.BigEndian, uint16(infoBlockSize))
+		_ = binary.Write(&sizes, binary.BigEndian, []uint32{1, uint32(s.preferredBlockSize()), maxRequestSize})
+		if err := s.optionReply(option, repInfo, sizes.Bytes()); err != nil {
+			return false, err
+		}
+	}
+	if err := s.optionReply(option, repAck, nil); err != nil {
+		return false, err
+	}
+	return option == optGo, nil
+}
+
+func (s *session) preferredBlockSize() int64 {
+	if s.d.SectorSize > 0 {
+		return s.d.SectorSize
+	}
+	return 4096
+}
+
+func (s *session) transmissionFlags() uint16 {
+	flags := uint16(flagHasFlags | flagSendFlush | flagSendFUA | flagSendTrim |
+		flagSendWriteZeroes | flagCanMultiConn)
+	if s.structured {
+		flags |= flagSendDF
+	}
+	return flags
+}
+
+// transmit serves the requests until the client disconnects, the requests
+// in flight are completed before returning.
+func (s *session) transmit() error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	inflight := make(chan struct{}, maxInflight)
+
+	for {
+		var req request
+		if err := binary.Read(s.conn, binary.BigEndian, &req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if req.Magic != requestMagic {
+			return fmt.Errorf("Invalid request magic %#x", req.Magic)
+		}
+
+		var data []byte
+		switch req.Type {
+		case cmdDisc:
+			return nil
+		case cmdWrite:
+			if req.Length > maxRequestSize {
+				return fmt.Errorf("Write of %d bytes is too large", req.Length)
+			}
+			data = make([]byte, req.Length)
+			if _, err := io.ReadFull(s.conn, data); err != nil {
+				return err
+			}
+		}
+
+		inflight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(req, data)
+			<-inflight
+		}()
+	}
+}
+
+func (s *session) handle(req request, data []byte) {
+	start := time.Now()
+	var (
+		buf   []byte
+		errno uint32
+		err   error
+	)
+	switch req.Type {
+	case cmdRead:
+		if errno = s.checkRange(req, errInval); errno == 0 {
+			buf = make([]byte, req.Length)
+			_, err = s.d.backend.ReadAt(buf, int64(req.Offset))
+		}
+	case cmdWrite:
+		if errno = s.checkRange(req, errNoSpc); errno == 0 {
+			_, err = s.d.backend.WriteAt(data, int64(req.Offset))
+		}
+	case cmdWriteZeroes:
+		if errno = s.checkRange(req, errNoSpc); errno == 0 {
+			err = s.writeZeroes(int64(req.Offset), int64(req.Length))
+		}
+	case cmdTrim:
+		if errno = s.checkRange(req, errNoSpc); errno == 0 {
+			_, err = s.d.backend.Unmap(int64(req.Offset), int64(req.Length))
+		}
+	case cmdFlush:
+		_, err = s.d.backend.Sync()
+	default:
+		errno = errInval
+		err = fmt.Errorf("Unsupported command %d", req.Type)
+	}
+	if err == nil && errno == 0 && req.Flags&cmdFlagFUA != 0 && req.Type != cmdFlush && req.Type != cmdRead {
+		_, err = s.d.backend.Sync()
+	}
+	if err != nil && errno == 0 {
+		errno = errIO
+	}
+	if err != nil {
+		log.Errorf("NBD command %d of %d bytes at offset %d failed, error: %v", req.Type, req.Length, req.Offset, err)
+	}
+	if errno == 0 {
+		s.d.addStats(req.Type, req.Length, start)
+	}
+
+	if err := s.reply(req, errno, buf); err != nil {
+		log.Errorf("Failed to reply to NBD command %d, error: %v", req.Type, err)
+		s.conn.Close()
+	}
+}
+
+// checkRange returns the error of a request beyond the volume size
+func (s *session) checkRange(req request, errno uint32) uint32 {
+	if req.Type == cmdRead && req.Length > maxRequestSize {
+		return errInval
+	}
+	if int64(req.Offset)+int64(req.Length) > s.d.getSize() {
+		return errno
+	}
+	return 0
+}
+
+func (s *session) writeZeroes(offset, length int64) error {
+	zeroes := make([]byte, zeroesSize)
+	for length > 0 {
+		n := length
+		if n > zeroesSize {
+			n = zeroesSize
+		}
+		if _, err := s.d.backend.WriteAt(zeroes[:n], offset); err != nil {
+			return err
+		}
+		offset += n
+		length -= n
+	}
+	return nil
+}
+
+func (s *session) reply(req request, errno uint32, data []byte) error {
+	if !s.structured {
+		if errno != 0 {
+			data = nil
+		}
+		return s.write(simpleReply{
+			Magic:  simpleMagic,
+			Error:  errno,
+			Handle: req.Handle,
+		}, data)
+	}
+
+	hdr := structuredReply{
+		Magic:  structuredMagic,
+		Flags:  replyFlagDone,
+		Type:   replyTypeNone,
+		Handle: req.Handle,
+	}
+	switch {
+	case errno != 0:
+		// the error has no message
+		hdr.Type = replyTypeError
+		hdr.Length = 6
+		return s.write(hdr, errno, uint16(0))
+	case req.Type == cmdRead:
+		hdr.Type = replyTypeOffsetData
+		hdr.Length = 8 + uint32(len(data))
+		return s.write(hdr, req.Offset, data)
+	}
+	return s.write(hdr)
+}
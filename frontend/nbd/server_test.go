@@ -0,0 +1,271 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package nbd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+type memBackend struct {
+	sync.Mutex
+	data   []byte
+	syncs  int
+	unmaps int
+}
+
+func (m *memBackend) ReadAt(b []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(b, m.data[off:]), nil
+}
+
+func (m *memBackend) WriteAt(b []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(m.data[off:], b), nil
+}
+
+func (m *memBackend) Close() error {
+	return nil
+}
+
+func (m *memBackend) Sync() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.syncs++
+	return 0, nil
+}
+
+func (m *memBackend) Unmap(off, length int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.unmaps++
+	copy(m.data[off:off+length], make([]byte, length))
+	return 0, nil
+}
+
+// testClient speaks the client side of the protocol
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func startSession(t *testing.T, size int64) (*testClient, *memBackend, chan error) {
+	backend := &memBackend{data: make([]byte, size)}
+	d := &Device{Name: "vol1", backend: backend, size: size}
+	server, client := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		s := &session{d: d, conn: server}
+		done <- s.run()
+		server.Close()
+	}()
+	return &testClient{t: t, conn: client}, backend, done
+}
+
+func (c *testClient) send(v ...interface{}) {
+	for _, data := range v {
+		// an empty write on a pipe blocks until it is read
+		if b, ok := data.([]byte); ok && len(b) == 0 {
+			continue
+		}
+		if err := binary.Write(c.conn, binary.BigEndian, data); err != nil {
+			c.t.Fatalf("Failed to send %v: %v", data, err)
+		}
+	}
+}
+
+func (c *testClient) recv(v ...interface{}) {
+	for _, data := range v {
+		if err := binary.Read(c.conn, binary.BigEndian, data); err != nil {
+			c.t.Fatalf("Failed to receive: %v", err)
+		}
+	}
+}
+
+func (c *testClient) handshake(flags uint32) {
+	var magic, opt uint64
+	var serverFlags uint16
+	c.recv(&magic, &opt, &serverFlags)
+	if magic != nbdMagic || opt != optMagic || serverFlags != flagFixedNewstyle|flagNoZeroes {
+		c.t.Fatalf("Unexpected handshake %#x %#x %#x", magic, opt, serverFlags)
+	}
+	c.send(flags)
+}
+
+func (c *testClient) option(option uint32, data []byte) {
+	c.send(optionHeader{Magic: optMagic, Option: option, Length: uint32(len(data))}, data)
+}
+
+func (c *testClient) optionReply(option, replyType uint32) []byte {
+	var hdr optionReplyHeader
+	c.recv(&hdr)
+	if hdr.Magic != optReplyMagic || hdr.Option != option || hdr.Type != replyType {
+		c.t.Fatalf("Unexpected reply %+v to option %d, expected type %#x", hdr, option, replyType)
+	}
+	data := make([]byte, hdr.Length)
+	c.recv(data)
+	return data
+}
+
+func (c *testClient) request(cmd uint16, flags uint16, offset uint64, length uint32, data []byte) {
+	c.send(request{
+		Magic:  requestMagic,
+		Flags:  flags,
+		Type:   cmd,
+		Handle: uint64(cmd) + 100,
+		Offset: offset,
+		Length: length,
+	}, data)
+}
+
+// structuredReply returns the error and the data of a structured reply
+func (c *testClient) structuredReply(cmd uint16) (uint32, []byte) {
+	var hdr structuredReply
+	c.recv(&hdr)
+	if hdr.Magic != structuredMagic || hdr.Handle != uint64(cmd)+100 || hdr.Flags != replyFlagDone {
+		c.t.Fatalf("Unexpected reply %+v to command %d", hdr, cmd)
+	}
+	payload := make([]byte, hdr.Length)
+	c.recv(payload)
+	switch hdr.Type {
+	case replyTypeError:
+		return binary.BigEndian.Uint32(payload), nil
+	case replyTypeOffsetData:
+		return 0, payload[8:]
+	}
+	return 0, nil
+}
+
+func (c *testClient) simpleReply(cmd uint16, length int) (uint32, []byte) {
+	var hdr simpleReply
+	c.recv(&hdr)
+	if hdr.Magic != simpleMagic || hdr.Handle != uint64(cmd)+100 {
+		c.t.Fatalf("Unexpected reply %+v to command %d", hdr, cmd)
+	}
+	if hdr.Error != 0 {
+		return hdr.Error, nil
+	}
+	data := make([]byte, length)
+	c.recv(data)
+	return 0, data
+}
+
+func TestStructuredReplies(t *testing.T) {
+	size := int64(1 << 20)
+	c, backend, done := startSession(t, size)
+	c.handshake(flagClientFixedNewstyle | flagClientNoZeroes)
+
+	c.option(optList, nil)
+	if name := c.optionReply(optList, repServer); string(name[4:]) != "vol1" {
+		t.Fatalf("Unexpected export %q", name)
+	}
+	c.optionReply(optList, repAck)
+	c.option(optStructuredReply, nil)
+	c.optionReply(optStructuredReply, repAck)
+	c.option(42, nil)
+	c.optionReply(42, repErrUnsup)
+
+	// unknown export
+	c.option(optInfo, []byte{0, 0, 0, 1, 'x', 0, 0})
+	c.optionReply(optInfo, repErrUnknown)
+
+	c.option(optGo, []byte{0, 0, 0, 4, 'v', 'o', 'l', '1', 0, 1, 0, infoBlockSize})
+	export := c.optionReply(optGo, repInfo)
+	if got := binary.BigEndian.Uint64(export[2:]); got != uint64(size) {
+		t.Fatalf("Exported size %d, expected %d", got, size)
+	}
+	if flags := binary.BigEndian.Uint16(export[10:]); flags&flagSendDF == 0 || flags&flagSendTrim == 0 {
+		t.Fatalf("Unexpected transmission flags %#x", flags)
+	}
+	c.optionReply(optGo, repInfo)
+	c.optionReply(optGo, repAck)
+
+	data := bytes.Repeat([]byte{7}, 8192)
+	c.request(cmdWrite, cmdFlagFUA, 4096, 8192, data)
+	if errno, _ := c.structuredReply(cmdWrite); errno != 0 || backend.syncs != 1 {
+		t.Fatalf("Write failed with %d, syncs: %d", errno, backend.syncs)
+	}
+	c.request(cmdRead, 0, 4096, 8192, nil)
+	if errno, got := c.structuredReply(cmdRead); errno != 0 || !bytes.Equal(got, data) {
+		t.Fatalf("Read failed with %d", errno)
+	}
+
+	c.request(cmdTrim, 0, 4096, 4096, nil)
+	c.structuredReply(cmdTrim)
+	c.request(cmdWriteZeroes, 0, 8192, 1024, nil)
+	c.structuredReply(cmdWriteZeroes)
+	c.request(cmdFlush, 0, 0, 0, nil)
+	c.structuredReply(cmdFlush)
+	c.request(cmdRead, 0, 4096, 8192, nil)
+	expected := append(make([]byte, 5120), data[5120:]...)
+	if _, got := c.structuredReply(cmdRead); !bytes.Equal(got, expected) || backend.unmaps != 1 || backend.syncs != 2 {
+		t.Fatalf("Unexpected data after trim and write zeroes, unmaps: %d, syncs: %d", backend.unmaps, backend.syncs)
+	}
+
+	c.request(cmdRead, 0, uint64(size)-512, 1024, nil)
+	if errno, _ := c.structuredReply(cmdRead); errno != errInval {
+		t.Fatalf("Read beyond the end failed with %d, expected %d", errno, errInval)
+	}
+	c.request(cmdWrite, 0, uint64(size), 512, make([]byte, 512))
+	if errno, _ := c.structuredReply(cmdWrite); errno != errNoSpc {
+		t.Fatalf("Write beyond the end failed with %d, expected %d", errno, errNoSpc)
+	}
+
+	c.request(cmdDisc, 0, 0, 0, nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Session failed: %v", err)
+	}
+}
+
+func TestExportNameSimpleReplies(t *testing.T) {
+	size := int64(1 << 20)
+	c, _, done := startSession(t, size)
+	c.handshake(flagClientFixedNewstyle)
+
+	c.option(optExportName, []byte("vol1"))
+	var exportSize uint64
+	var flags uint16
+	zeroes := make([]byte, 124)
+	c.recv(&exportSize, &flags, zeroes)
+	if exportSize != uint64(size) || flags&flagSendDF != 0 || !bytes.Equal(zeroes, make([]byte, 124)) {
+		t.Fatalf("Unexpected export size %d, flags %#x", exportSize, flags)
+	}
+
+	data := bytes.Repeat([]byte{9}, 512)
+	c.request(cmdWrite, 0, 512, 512, data)
+	c.simpleReply(cmdWrite, 0)
+	c.request(cmdRead, 0, 0, 1024, nil)
+	if errno, got := c.simpleReply(cmdRead, 1024); errno != 0 || !bytes.Equal(got[512:], data) {
+		t.Fatalf("Read failed with %d", errno)
+	}
+	c.request(99, 0, 0, 0, nil)
+	if errno, _ := c.simpleReply(99, 0); errno != errInval {
+		t.Fatalf("Unknown command failed with %d, expected %d", errno, errInval)
+	}
+
+	// the session ends when the client closes the connection
+	c.conn.Close()
+	if err := <-done; err != nil && err != io.ErrClosedPipe {
+		t.Fatalf("Session failed: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/go-rancher/api"
+)
+
+// dataChunkSize is the size of the I/Os done while streaming the data of
+// the volume.
+const dataChunkSize = 1 << 20
+
+// parseRange parses a single byte range of a Range header, multiple
+// ranges aren't supported.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("Unsupported range %q", header)
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid range %q", header)
+	}
+
+	var start, end int64
+	var err error
+	switch {
+	case parts[0] == "":
+		// the last bytes of the volume
+		var n int64
+		if n, err = strconv.ParseInt(parts[1], 10, 64); err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("Invalid range %q", header)
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+	default:
+		if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("Invalid range %q", header)
+		}
+		end = size - 1
+		if parts[1] != "" {
+			if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+				return 0, 0, fmt.Errorf("Invalid range %q", header)
+			}
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("Range %q is beyond volume size %d", header, size)
+	}
+	return start, end - start + 1, nil
+}
+
+// ReadData streams the data of the volume as octet-stream, a single range
+// can be read with a Range header.
+func (s *Server) ReadData(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	if s.getVolume(apiContext, mux.Vars(req)["id"]) == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	size := s.d.getSize()
+	offset, length := int64(0), size
+	status := http.StatusOK
+	if header := req.Header.Get("Range"); header != "" {
+		var err error
+		if offset, length, err = parseRange(header, size); err != nil {
+			rw.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(rw, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		status = http.StatusPartialContent
+	}
+	rw.Header().Set("Accept-Ranges", "bytes")
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if req.Method == "HEAD" {
+		rw.WriteHeader(status)
+		return nil
+	}
+
+	buf := make([]byte, dataChunkSize)
+	for off := offset; off < offset+length; off += dataChunkSize {
+		n := offset + length - off
+		if n > dataChunkSize {
+			n = dataChunkSize
+		}
+		start := time.Now()
+		if _, err := s.d.backend.ReadAt(buf[:n], off); err != nil {
+			log.Errorln("read failed: ", err.Error())
+			if off == offset {
+				return fmt.Errorf("read failed: %v", err)
+			}
+			// the status is sent, the client gets a short body
+			return nil
+		}
+		s.d.addReadStats(n, start)
+		if off == offset {
+			rw.WriteHeader(status)
+		}
+		if _, err := rw.Write(buf[:n]); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// WriteData writes the octet-stream body of the request at the offset set
+// by the offset query parameter, 0 by default.
+func (s *Server) WriteData(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	if s.getVolume(apiContext, mux.Vars(req)["id"]) == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	var offset int64
+	if value := req.URL.Query().Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("Invalid offset %q", value)
+		}
+	}
+	if req.ContentLength >= 0 {
+		if err := s.checkRange(offset, req.ContentLength); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, dataChunkSize)
+	for off := offset; ; {
+		n, err := io.ReadFull(req.Body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Failed to read the data to write at offset %d, error: %v", off, err)
+		}
+		if n == 0 {
+			break
+		}
+		if err := s.checkRange(off, int64(n)); err != nil {
+			return err
+		}
+		start := time.Now()
+		if _, err := s.d.backend.WriteAt(buf[:n], off); err != nil {
+			log.Errorln("write failed: ", err.Error())
+			return fmt.Errorf("write failed: %v", err)
+		}
+		s.d.addWriteStats(int64(n), start)
+		off += int64(n)
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return nil
+}
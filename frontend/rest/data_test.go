@@ -0,0 +1,148 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memBackend struct {
+	sync.Mutex
+	data  []byte
+	syncs int
+}
+
+func (m *memBackend) ReadAt(b []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(b, m.data[off:]), nil
+}
+
+func (m *memBackend) WriteAt(b []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(m.data[off:], b), nil
+}
+
+func (m *memBackend) Close() error {
+	return nil
+}
+
+func (m *memBackend) Sync() (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.syncs++
+	return 0, nil
+}
+
+func (m *memBackend) Unmap(off, length int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	copy(m.data[off:off+length], make([]byte, length))
+	return 0, nil
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header         string
+		offset, length int64
+		fail           bool
+	}{
+		{header: "bytes=0-99", offset: 0, length: 100},
+		{header: "bytes=100-", offset: 100, length: 900},
+		{header: "bytes=-10", offset: 990, length: 10},
+		{header: "bytes=-2000", offset: 0, length: 1000},
+		{header: "bytes=900-5000", offset: 900, length: 100},
+		{header: "bytes=1000-", fail: true},
+		{header: "bytes=10-5", fail: true},
+		{header: "bytes=0-1,5-6", fail: true},
+		{header: "items=0-1", fail: true},
+	}
+	for _, tt := range tests {
+		offset, length, err := parseRange(tt.header, 1000)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("parseRange(%q) didn't fail", tt.header)
+			}
+			continue
+		}
+		if err != nil || offset != tt.offset || length != tt.length {
+			t.Errorf("parseRange(%q) = %d, %d, %v, expected %d, %d", tt.header, offset, length, err, tt.offset, tt.length)
+		}
+	}
+}
+
+func TestData(t *testing.T) {
+	backend := &memBackend{data: make([]byte, 3*dataChunkSize)}
+	d := &Device{Name: "vol1", Size: int64(len(backend.data)), backend: backend}
+	server := httptest.NewServer(NewRouter(NewServer(d)))
+	defer server.Close()
+	url := server.URL + "/v1/volumes/" + EncodeID("vol1")
+
+	data := bytes.Repeat([]byte("jiva"), dataChunkSize/2)
+	req, _ := http.NewRequest("PUT", url+"/data?offset=4096", bytes.NewReader(data))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Write failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("GET", url+"/data", nil)
+	req.Header.Set("Range", "bytes=4096-")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Read failed: %v %v", err, resp)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || !bytes.Equal(got[:len(data)], data) || len(got) != len(backend.data)-4096 {
+		t.Fatalf("Read %d bytes, error: %v", len(got), err)
+	}
+
+	// writes beyond the volume size are rejected before writing
+	req, _ = http.NewRequest("PUT", url+"/data?offset=4096", bytes.NewReader(backend.data))
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode < 400 {
+		t.Fatalf("Write beyond the end didn't fail: %v %v", err, resp)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(url+"?action=unmap", "application/json", strings.NewReader(`{"offset":4096,"length":4096}`))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unmap failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(url+"?action=flush", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Flush failed: %v %v", err, resp)
+	}
+	resp.Body.Close()
+	if !bytes.Equal(backend.data[4096:8192], make([]byte, 4096)) || backend.syncs != 1 {
+		t.Fatalf("Unexpected data after unmap, syncs: %d", backend.syncs)
+	}
+
+	stats := d.Stats()
+	if stats.WriteIOPS != 2 || stats.TotalWriteBlockCount != int64(len(data)) ||
+		stats.ReadIOPS != 3 || stats.TotalReadBlockCount != int64(len(backend.data)-4096) {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -20,8 +20,13 @@ This file was originally authored by Rancher Labs
 package rest
 
 import (
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/openebs/jiva/types"
@@ -32,23 +37,44 @@ var (
 	log = logrus.WithFields(logrus.Fields{"pkg": "rest-frontend"})
 )
 
+// DefaultAddress is the address the REST frontend listens on if none is set
+const DefaultAddress = "localhost:9414"
+
 type Device struct {
+	// Size is accessed atomically as the volume can be resized while
+	// serving I/Os, it is kept first to be aligned.
+	Size int64
+
+	sync.Mutex
 	Name       string
-	Size       int64
+	Address    string
 	SectorSize int64
 
 	isUp    bool
-	backend types.ReaderWriterAt
+	backend types.IOs
+	server  *http.Server
+	stats   types.Stats
 }
 
 func New() types.Frontend {
 	return &Device{}
 }
 
+// SetAddress sets the address the REST frontend listens on
+func (d *Device) SetAddress(address string) {
+	d.Address = address
+}
+
 func (d *Device) Startup(name string, frontendIP string, clusterIP string, size, sectorSize int64, rw types.IOs) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.server != nil {
+		_ = d.stop()
+	}
+
 	d.Name = name
 	d.backend = rw
-	d.Size = size
+	atomic.StoreInt64(&d.Size, size)
 	d.SectorSize = sectorSize
 
 	if err := d.start(); err != nil {
@@ -60,30 +86,52 @@ func (d *Device) Startup(name string, frontendIP string, clusterIP string, size,
 }
 
 func (d *Device) Shutdown() error {
+	d.Lock()
+	defer d.Unlock()
 	return d.stop()
 }
 
 func (d *Device) start() error {
-	listen := "localhost:9414"
+	listen := d.Address
+	if listen == "" {
+		listen = DefaultAddress
+	}
 	server := NewServer(d)
 	router := http.Handler(NewRouter(server))
 	router = handlers.LoggingHandler(os.Stdout, router)
 	router = handlers.ProxyHeaders(router)
 
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s, error: %v", listen, err)
+	}
 	log.Infof("Rest Frontend listening on %s", listen)
 
-	go func() {
-		http.ListenAndServe(listen, router)
-	}()
+	d.server = &http.Server{Handler: router}
+	go func(srv *http.Server) {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Rest Frontend stopped, error: %v", err)
+		}
+	}(d.server)
 	return nil
 }
 
+// stop closes the listener and the connections, the I/Os in flight are
+// not waited for as they may wait for the controller which is shutting
+// down the frontend.
 func (d *Device) stop() error {
 	d.isUp = false
-	return nil
+	if d.server == nil {
+		return nil
+	}
+	err := d.server.Close()
+	d.server = nil
+	return err
 }
 
 func (d *Device) State() types.State {
+	d.Lock()
+	defer d.Unlock()
 	if d.isUp {
 		return types.StateUp
 	}
@@ -91,9 +139,33 @@ func (d *Device) State() types.State {
 }
 
 func (d *Device) Stats() types.Stats {
-	return types.Stats{}
+	d.Lock()
+	defer d.Unlock()
+	return d.stats
 }
 
+// Resize sets the size of the volume, the I/Os are checked against it
 func (d *Device) Resize(size uint64) error {
+	atomic.StoreInt64(&d.Size, int64(size))
 	return nil
 }
+
+func (d *Device) getSize() int64 {
+	return atomic.LoadInt64(&d.Size)
+}
+
+func (d *Device) addReadStats(length int64, start time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.stats.ReadIOPS++
+	d.stats.TotalReadTime += int64(time.Since(start))
+	d.stats.TotalReadBlockCount += length
+}
+
+func (d *Device) addWriteStats(length int64, start time.Time) {
+	d.Lock()
+	defer d.Unlock()
+	d.stats.WriteIOPS++
+	d.stats.TotalWriteTime += int64(time.Since(start))
+	d.stats.TotalWriteBlockCount += length
+}
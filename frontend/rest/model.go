@@ -26,7 +26,9 @@ import (
 
 type Volume struct {
 	client.Resource
-	Name string `json:"name"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	SectorSize int64  `json:"sectorSize"`
 }
 
 type ReadInput struct {
@@ -51,18 +53,31 @@ type WriteOutput struct {
 	client.Resource
 }
 
-func NewVolume(context *api.ApiContext, name string) *Volume {
+type UnmapInput struct {
+	client.Resource
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+func NewVolume(context *api.ApiContext, name string, size, sectorSize int64) *Volume {
 	v := &Volume{
 		Resource: client.Resource{
 			Id:      EncodeID(name),
 			Type:    "volume",
 			Actions: map[string]string{},
+			Links:   map[string]string{},
 		},
-		Name: name,
+		Name:       name,
+		Size:       size,
+		SectorSize: sectorSize,
 	}
 
 	v.Actions["readat"] = context.UrlBuilder.ActionLink(v.Resource, "readat")
 	v.Actions["writeat"] = context.UrlBuilder.ActionLink(v.Resource, "writeat")
+	v.Actions["flush"] = context.UrlBuilder.ActionLink(v.Resource, "flush")
+	v.Actions["unmap"] = context.UrlBuilder.ActionLink(v.Resource, "unmap")
+	// the data of the volume is read and written as octet-stream
+	v.Links["data"] = context.UrlBuilder.Link(v.Resource, "data")
 	return v
 }
 
@@ -99,6 +114,7 @@ func NewSchema() *client.Schemas {
 	schemas.AddType("readOutput", ReadOutput{})
 	schemas.AddType("writeInput", WriteInput{})
 	schemas.AddType("writeOutput", WriteOutput{})
+	schemas.AddType("unmapInput", UnmapInput{})
 
 	volumes := schemas.AddType("volume", Volume{})
 	volumes.ResourceActions = map[string]client.Action{
@@ -110,6 +126,13 @@ func NewSchema() *client.Schemas {
 			Input:  "writeInput",
 			Output: "writeOutput",
 		},
+		"flush": {
+			Output: "volume",
+		},
+		"unmap": {
+			Input:  "unmapInput",
+			Output: "volume",
+		},
 	}
 
 	return schemas
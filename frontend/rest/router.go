@@ -50,6 +50,10 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods("GET").Path("/v1/volumes/{id}").Handler(f(schemas, s.GetVolume))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "readat").Handler(f(schemas, s.ReadAt))
 	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "writeat").Handler(f(schemas, s.WriteAt))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "flush").Handler(f(schemas, s.Flush))
+	router.Methods("POST").Path("/v1/volumes/{id}").Queries("action", "unmap").Handler(f(schemas, s.Unmap))
+	router.Methods("GET", "HEAD").Path("/v1/volumes/{id}/data").Handler(f(schemas, s.ReadData))
+	router.Methods("PUT").Path("/v1/volumes/{id}/data").Handler(f(schemas, s.WriteData))
 
 	return router
 }
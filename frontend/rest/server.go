@@ -20,6 +20,7 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/rancher/go-rancher/api"
@@ -66,12 +67,17 @@ func (s *Server) ReadAt(rw http.ResponseWriter, req *http.Request) error {
 		return err
 	}
 
+	if err := s.checkRange(input.Offset, input.Length); err != nil {
+		return err
+	}
+	start := time.Now()
 	buf := make([]byte, input.Length)
 	_, err := s.d.backend.ReadAt(buf, input.Offset)
 	if err != nil {
 		log.Errorln("read failed: ", err.Error())
 		return fmt.Errorf("read failed: %v", err.Error())
 	}
+	s.d.addReadStats(input.Length, start)
 
 	data := EncodeData(buf)
 	apiContext.Write(&ReadOutput{
@@ -107,10 +113,15 @@ func (s *Server) WriteAt(rw http.ResponseWriter, req *http.Request) error {
 		return fmt.Errorf("Inconsistent length in request")
 	}
 
+	if err := s.checkRange(input.Offset, int64(len(buf))); err != nil {
+		return err
+	}
+	start := time.Now()
 	if _, err := s.d.backend.WriteAt(buf, input.Offset); err != nil {
 		log.Errorln("write failed: ", err.Error())
 		return err
 	}
+	s.d.addWriteStats(int64(len(buf)), start)
 	apiContext.Write(&WriteOutput{
 		Resource: client.Resource{
 			Type: "writeOutput",
@@ -119,9 +130,58 @@ func (s *Server) WriteAt(rw http.ResponseWriter, req *http.Request) error {
 	return nil
 }
 
+// Flush flushes the data written to the replicas
+func (s *Server) Flush(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	v := s.getVolume(apiContext, mux.Vars(req)["id"])
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if _, err := s.d.backend.Sync(); err != nil {
+		log.Errorln("flush failed: ", err.Error())
+		return fmt.Errorf("flush failed: %v", err)
+	}
+	apiContext.Write(v)
+	return nil
+}
+
+// Unmap discards a range of the volume
+func (s *Server) Unmap(rw http.ResponseWriter, req *http.Request) error {
+	var input UnmapInput
+
+	apiContext := api.GetApiContext(req)
+	v := s.getVolume(apiContext, mux.Vars(req)["id"])
+	if v == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	if err := s.checkRange(input.Offset, input.Length); err != nil {
+		return err
+	}
+	if _, err := s.d.backend.Unmap(input.Offset, input.Length); err != nil {
+		log.Errorln("unmap failed: ", err.Error())
+		return fmt.Errorf("unmap failed: %v", err)
+	}
+	apiContext.Write(v)
+	return nil
+}
+
+func (s *Server) checkRange(offset, length int64) error {
+	if size := s.d.getSize(); offset < 0 || length < 0 || offset+length > size {
+		return fmt.Errorf("Range of %d bytes at offset %d is beyond volume size %d", length, offset, size)
+	}
+	return nil
+}
+
 func (s *Server) listVolumes(context *api.ApiContext) []*Volume {
 	return []*Volume{
-		NewVolume(context, s.d.Name),
+		NewVolume(context, s.d.Name, s.d.getSize(), s.d.SectorSize),
 	}
 }
 
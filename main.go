@@ -29,6 +29,8 @@ import (
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/openebs/jiva/app"
+	"github.com/openebs/jiva/backup"
+	"github.com/openebs/jiva/util"
 	"github.com/openebs/sparse-tools/cli/sfold"
 	"github.com/openebs/sparse-tools/cli/ssync"
 	"github.com/sirupsen/logrus"
@@ -39,6 +41,7 @@ func main() {
 	defer cleanup()
 	reexec.Register("ssync", ssync.Main)
 	reexec.Register("sfold", sfold.Main)
+	reexec.Register("sbackup", backup.Main)
 
 	if !reexec.Init() {
 		longhornCli()
@@ -95,9 +98,15 @@ func longhornCli() {
 		if c.GlobalBool("debug") {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
+		if err := app.SetupTLS(c); err != nil {
+			return err
+		}
+		if util.TLSEnabled() && !c.IsSet("url") {
+			return c.Set("url", util.ControllerURL("localhost"))
+		}
 		return nil
 	}
-	a.Flags = []cli.Flag{
+	a.Flags = append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "url",
 			Value: "http://localhost:9501",
@@ -105,7 +114,7 @@ func longhornCli() {
 		cli.BoolFlag{
 			Name: "debug",
 		},
-	}
+	}, app.TLSFlags()...)
 	a.Commands = []cli.Command{
 		app.ControllerCmd(),
 		app.ReplicaCmd(),
@@ -118,6 +127,10 @@ func longhornCli() {
 		app.SyncInfoCmd(),
 		app.BackupCmd(),
 		app.Journal(),
+		app.VerifyCmd(),
+		app.ReplicationFactorCmd(),
+		app.QoSCmd(),
+		app.EventsCmd(),
 	}
 	a.CommandNotFound = cmdNotFound
 	a.OnUsageError = onUsageError
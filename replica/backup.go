@@ -21,12 +21,14 @@ package replica
 
 import (
 	"fmt"
-	"syscall"
+	"os"
+	"path"
 	"time"
 
+	"github.com/openebs/jiva/backupstore"
 	inject "github.com/openebs/jiva/error-inject"
 	"github.com/openebs/jiva/types"
-	"github.com/openebs/sparse-tools/sparse"
+	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 )
 
@@ -34,16 +36,8 @@ const (
 	snapBlockSize = 2 << 20 // 2MiB
 )
 
-/*
-type DeltaBlockBackupOperations interface {
-	HasSnapshot(id, volumeID string) bool
-	CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error)
-	OpenSnapshot(id, volumeID string) error
-	ReadSnapshot(id, volumeID string, start int64, data []byte) error
-	CloseSnapshot(id, volumeID string) error
-}
-*/
-/*
+// Backup implements backupstore.DeltaBlockBackupOperations on top of a
+// read-only view of the replica chain found in the working directory.
 type Backup struct {
 	backingFile *BackingFile
 	replica     *Replica
@@ -51,23 +45,43 @@ type Backup struct {
 	snapshotID  string
 }
 
+var _ backupstore.DeltaBlockBackupOperations = (*Backup)(nil)
+
 func NewBackup(backingFile *BackingFile) *Backup {
 	return &Backup{
 		backingFile: backingFile,
 	}
 }
 
+// HasSnapshot reports if id is part of the chain of the snapshot open
+// for volumeID, i.e. if it can be used by CompareSnapshot.
 func (rb *Backup) HasSnapshot(id, volumeID string) bool {
-	//TODO Check current in the volume directory of volumeID
-	if err := rb.assertOpen(id, volumeID); err != nil {
+	if id == "" || rb.replica == nil || rb.volumeID != volumeID {
 		return false
 	}
 
-	to := rb.findIndex(id)
-	if to < 0 {
-		return false
+	return rb.findIndex(id) > 0
+}
+
+// GetSnapshotInfo returns the metadata of snapshot id in the chain of the
+// snapshot open for volumeID.
+func (rb *Backup) GetSnapshotInfo(id, volumeID string) (*backupstore.Snapshot, error) {
+	if !rb.HasSnapshot(id, volumeID) {
+		return nil, fmt.Errorf("Failed to find snapshot %s of volume %s in chain", id, volumeID)
+	}
+
+	rb.replica.RLock()
+	defer rb.replica.RUnlock()
+
+	d, ok := rb.replica.diskData[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed to find metadata of snapshot %s", id)
 	}
-	return true
+	return &backupstore.Snapshot{
+		Name:            d.Name,
+		CreatedTime:     d.Created,
+		RevisionCounter: d.RevisionCounter,
+	}, nil
 }
 
 func (rb *Backup) OpenSnapshot(id, volumeID string) error {
@@ -83,7 +97,7 @@ func (rb *Backup) OpenSnapshot(id, volumeID string) error {
 	if err != nil {
 		return fmt.Errorf("Cannot get working directory: %v", err)
 	}
-	r, err := NewReadOnly(dir, id, rb.backingFile)
+	r, err := NewReadOnly(false, dir, id, rb.backingFile)
 	if err != nil {
 		return err
 	}
@@ -107,10 +121,6 @@ func (rb *Backup) ReadSnapshot(id, volumeID string, start int64, data []byte) er
 		return err
 	}
 
-	if rb.snapshotID != id && rb.volumeID != volumeID {
-		return fmt.Errorf("Snapshot %s and volume %s are not open", id, volumeID)
-	}
-
 	_, err := rb.replica.ReadAt(data, start)
 	return err
 }
@@ -132,6 +142,9 @@ func (rb *Backup) CloseSnapshot(id, volumeID string) error {
 	return err
 }
 
+// CompareSnapshot returns the snapBlockSize aligned blocks written in the
+// disks after compareID up to and including id. An empty compareID
+// compares against the base of the chain.
 func (rb *Backup) CompareSnapshot(id, compareID, volumeID string) (*backupstore.Mappings, error) {
 	if err := rb.assertOpen(id, volumeID); err != nil {
 		return nil, err
@@ -157,23 +170,32 @@ func (rb *Backup) CompareSnapshot(id, compareID, volumeID string) (*backupstore.
 		Offset: -1,
 	}
 
+	// lookup() fills the sectors it can't find in the chain with the
+	// index of the base disk while reading, start from a clean map so
+	// only the sectors actually written are reported.
+	rb.replica.volume.location.clear()
 	if err := preload(&rb.replica.volume); err != nil {
 		return nil, err
 	}
 
-	for i, val := range rb.replica.volume.location {
-		if val <= uint16(from) && val > uint16(to) {
-			offset := int64(i) * rb.replica.volume.sectorSize
+	location := rb.replica.volume.location
+	for sector := int64(0); sector < location.len(); {
+		val, next := location.extent(sector)
+		if val <= uint32(from) && val > uint32(to) {
+			offset := sector * rb.replica.volume.sectorSize
 			// align
 			offset -= (offset % snapBlockSize)
-			if mapping.Offset != offset {
-				mapping = backupstore.Mapping{
-					Offset: offset,
-					Size:   snapBlockSize,
+			for ; offset < next*rb.replica.volume.sectorSize; offset += snapBlockSize {
+				if mapping.Offset != offset {
+					mapping = backupstore.Mapping{
+						Offset: offset,
+						Size:   snapBlockSize,
+					}
+					mappings.Mappings = append(mappings.Mappings, mapping)
 				}
-				mappings.Mappings = append(mappings.Mappings, mapping)
 			}
 		}
+		sector = next
 	}
 
 	return mappings, nil
@@ -197,7 +219,21 @@ func (rb *Backup) findIndex(id string) int {
 	}
 	return -1
 }
-*/
+
+// CreateRestoredDiskMetadata writes the metadata of a snapshot disk that
+// has been restored from a backup into dir. The disk has no parent, so
+// reverting to it starts a fresh chain.
+func CreateRestoredDiskMetadata(dir, name string) error {
+	if _, err := os.Stat(path.Join(dir, name)); err != nil {
+		return err
+	}
+	r := &Replica{dir: dir}
+	return r.encodeToFile(&disk{
+		Name:        name,
+		UserCreated: true,
+		Created:     util.Now(),
+	}, name+metadataSuffix)
+}
 
 // Hole holds the fd, len and offset for fallocate operation
 type Hole struct {
@@ -233,9 +269,6 @@ func drainHoleCreatorChan() {
 
 //CreateHoles removes the offsets from corresponding sparse files
 func CreateHoles() {
-	var (
-		fd uintptr
-	)
 	retryCount := 0
 	for {
 		hole := <-HoleCreatorChan
@@ -248,11 +281,8 @@ func CreateHoles() {
 		if (Hole{}) == hole {
 			continue
 		}
-		fd = hole.f.Fd()
 	retry:
-		if err := syscall.Fallocate(int(fd),
-			sparse.FALLOC_FL_KEEP_SIZE|sparse.FALLOC_FL_PUNCH_HOLE,
-			hole.offset, hole.len); err != nil {
+		if err := punchHole(hole.f, hole.offset, hole.len); err != nil {
 			logrus.Errorf("ERROR in creating hole: %v, Retry_Count: %v", err, retryCount)
 			time.Sleep(1)
 			retryCount++
@@ -289,36 +319,50 @@ func preload(d *diffDisk) error {
 	var file types.DiffDisk
 	var length int64
 	var lOffset int64
-	var fileIndx uint16
+	var fileIndx uint32
 	// userCreatedSnapIndx represents the index of the latest User Created
 	// snapshot traversed till this point. This value is used instead of
 	// d.SnapIndx because this will also aid in removing duplicate blocks
 	// in auto-created snapshots between 2 user created snapshots
-	var userCreatedSnapIndx uint16
+	var userCreatedSnapIndx uint32
+	// the files of a read only replica may be closed before the holes
+	// are punched, and are punched by the live replica anyway
+	punchHoles := shouldCreateHoles() && !d.readOnly
 	for i, f := range d.files {
 		if i == 0 {
 			continue
 		}
 		if d.UserCreatedSnap[i] {
-			userCreatedSnapIndx = uint16(i)
+			userCreatedSnapIndx = uint32(i)
 		}
+		// the contiguous sectors of the file are set at once, the
+		// sectors of a file are distinct so the ones of the run aren't
+		// read before it is set
+		var runStart, runLength int64
 		generator := newGenerator(d, f)
 		for offset := range generator.Generate() {
-			if d.location[offset] != 0 {
+			if offset != runStart+runLength {
+				if runLength > 0 {
+					d.location.set(runStart, runLength, uint32(i))
+				}
+				runStart, runLength = offset, 0
+			}
+			runLength++
+			if val := d.location.get(offset); val != 0 {
 				// d.UsedBlocks is being incremented and decremented to accomodate user
 				// created snapshots
 				// We are looking for continuous blocks over here.
 				// If the file of the next block is changed, we punch a hole
 				// for the previous unpunched blocks, and reset the file and
 				// fileIndx pointed to by this block
-				if d.files[d.location[offset]] != file ||
+				if d.files[val] != file ||
 					offset != lOffset+length {
-					if file != nil && fileIndx > userCreatedSnapIndx && shouldCreateHoles() {
+					if file != nil && fileIndx > userCreatedSnapIndx && punchHoles {
 						d.UsedBlocks -= length
 						sendToCreateHole(file, lOffset*d.sectorSize, length*d.sectorSize)
 					}
-					file = d.files[d.location[offset]]
-					fileIndx = d.location[offset]
+					file = d.files[val]
+					fileIndx = val
 					length = 1
 					lOffset = offset
 				} else {
@@ -329,12 +373,14 @@ func preload(d *diffDisk) error {
 			} else {
 				d.UsedLogicalBlocks++
 			}
-			d.location[offset] = uint16(i)
 			d.UsedBlocks++
 		}
+		if runLength > 0 {
+			d.location.set(runStart, runLength, uint32(i))
+		}
 		// This will take care of the case when the last call in the above loop
 		// enters else case
-		if file != nil && fileIndx > userCreatedSnapIndx && shouldCreateHoles() {
+		if file != nil && fileIndx > userCreatedSnapIndx && punchHoles {
 			d.UsedBlocks -= length
 			sendToCreateHole(file, lOffset*d.sectorSize, length*d.sectorSize)
 		}
@@ -342,7 +388,7 @@ func preload(d *diffDisk) error {
 		fileIndx = 0
 
 		if generator.Err() != nil {
-			fmt.Println("GENERATOR ERROR")
+			logrus.Errorf("Failed to read extents of disk %d, error: %v", i, generator.Err())
 			return generator.Err()
 		}
 	}
@@ -20,23 +20,31 @@
 package replica
 
 import (
-	_ "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/openebs/jiva/backupstore"
+	"github.com/openebs/jiva/util"
+	. "gopkg.in/check.v1"
 )
 
 const (
 	mb = 1 << 20
 )
 
-/*
 func (s *TestSuite) TestBackup(c *C) {
 	dir, err := ioutil.TempDir("", "replica")
 	c.Assert(err, IsNil)
 	defer os.RemoveAll(dir)
 
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(wd)
 	err = os.Chdir(dir)
 	c.Assert(err, IsNil)
 
-	r, err := New(10*mb, bs, dir, nil, "Backend")
+	r, err := New(false, 10*mb, bs, dir, nil, "Backend")
 	c.Assert(err, IsNil)
 	defer r.Close()
 	err = r.SetReplicaMode("RW")
@@ -63,6 +71,9 @@ func (s *TestSuite) TestBackup(c *C) {
 	c.Assert(mappings.Mappings[0].Size, Equals, int64(2*mb))
 	c.Assert(mappings.Mappings[1].Offset, Equals, int64(2*mb))
 	c.Assert(mappings.Mappings[1].Size, Equals, int64(2*mb))
+
+	err = rb.CloseSnapshot(chain[0], volume)
+	c.Assert(err, IsNil)
 }
 
 func (s *TestSuite) TestBackupWithBackups(c *C) {
@@ -97,11 +108,14 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	c.Assert(err, IsNil)
 	defer os.RemoveAll(dir)
 
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(wd)
 	err = os.Chdir(dir)
 	c.Assert(err, IsNil)
 	volume := "test"
 
-	r, err := New(10*mb, bs, dir, backingFile, "Backend")
+	r, err := New(false, 10*mb, bs, dir, backingFile, "Backend")
 	c.Assert(err, IsNil)
 	defer r.Close()
 	err = r.SetReplicaMode("RW")
@@ -119,9 +133,7 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	_, err = r.WriteAt(buf, mb)
 	c.Assert(err, IsNil)
 
-	createdTime1 := util.Now()
-	err = r.Snapshot("001", true, createdTime1)
-
+	err = r.Snapshot("001", true, util.Now())
 	c.Assert(err, IsNil)
 	fill(buf, 2)
 	_, err = r.WriteAt(buf, 0)
@@ -129,18 +141,15 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	_, err = r.WriteAt(buf, 4*mb)
 	c.Assert(err, IsNil)
 
-	createdTime2 := util.Now()
-	err = r.Snapshot("002", true, createdTime2)
-
+	err = r.Snapshot("002", true, util.Now())
 	c.Assert(err, IsNil)
 	fill(buf, 3)
 	_, err = r.WriteAt(buf, 4*mb)
 	c.Assert(err, IsNil)
 	_, err = r.WriteAt(buf, 8*mb)
 	c.Assert(err, IsNil)
-	createdTime3 := util.Now()
-	err = r.Snapshot("003", true, createdTime3)
 
+	err = r.Snapshot("003", true, util.Now())
 	c.Assert(err, IsNil)
 	buf = make([]byte, 10*mb)
 	fill(buf, 4)
@@ -148,6 +157,7 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	c.Assert(err, IsNil)
 
 	chain, err := r.Chain()
+	c.Assert(err, IsNil)
 
 	rb := NewBackup(backingFile)
 
@@ -188,6 +198,7 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 
 	// Test 003 -> 002
 	err = rb.OpenSnapshot(chain[1], volume)
+	c.Assert(err, IsNil)
 	mappings, err = rb.CompareSnapshot(chain[1], chain[2], volume)
 	c.Assert(err, IsNil)
 	c.Assert(len(mappings.Mappings), Equals, 2)
@@ -197,6 +208,7 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	c.Assert(mappings.Mappings[1].Offset, Equals, int64(8*mb))
 	c.Assert(mappings.Mappings[1].Size, Equals, int64(2*mb))
 	err = rb.CloseSnapshot(chain[1], volume)
+	c.Assert(err, IsNil)
 
 	// Test 002 -> 001
 	err = rb.OpenSnapshot(chain[2], volume)
@@ -269,5 +281,161 @@ func (s *TestSuite) testBackupWithBackups(c *C, backingFile *BackingFile) {
 	c.Assert(mappings.Mappings[1].Size, Equals, int64(2*mb))
 	err = rb.CloseSnapshot(chain[3], volume)
 	c.Assert(err, IsNil)
+
+	// The live chain must be untouched by the read-only access
+	newChain, err := r.Chain()
+	c.Assert(err, IsNil)
+	c.Assert(newChain, DeepEquals, chain)
+}
+
+func (s *TestSuite) TestBackupRestore(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	store, err := ioutil.TempDir("", "backupstore")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(store)
+	dest := "vfs://" + store
+
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	c.Assert(err, IsNil)
+
+	r, err := New(false, 10*mb, bs, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	expected := make([]byte, 10*mb)
+	fill(expected[:mb], 2)
+	fill(expected[2*mb:4*mb], 1)
+	fill(expected[6*mb:8*mb], 1)
+	_, err = r.WriteAt(expected, 0)
+	c.Assert(err, IsNil)
+	err = r.Snapshot("001", true, util.Now())
+	c.Assert(err, IsNil)
+
+	chain, err := r.Chain()
+	c.Assert(err, IsNil)
+
+	backupURL, err := backupstore.CreateDeltaBlockBackup(&backupstore.DeltaBackupConfig{
+		Volume:   &backupstore.Volume{Name: "test", Size: 10 * mb},
+		Snapshot: &backupstore.Snapshot{Name: chain[1]},
+		DestURL:  dest,
+		DeltaOps: NewBackup(nil),
+	})
+	c.Assert(err, IsNil)
+
+	info, err := backupstore.InspectBackup(backupURL)
+	c.Assert(err, IsNil)
+	c.Assert(info.SnapshotName, Equals, chain[1])
+	// the zero filled blocks at 4MiB and 8MiB are not part of the backup
+	c.Assert(info.BlockCount, Equals, 3)
+
+	// Restore into a fresh replica and revert to the restored disk
+	restoreDir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(restoreDir)
+
+	r2, err := New(false, 10*mb, bs, restoreDir, nil, "Backend")
+	c.Assert(err, IsNil)
+	err = r2.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	restored := GenerateSnapshotDiskName("restored")
+	err = backupstore.RestoreDeltaBlockBackup(backupURL, path.Join(restoreDir, restored))
+	c.Assert(err, IsNil)
+	err = CreateRestoredDiskMetadata(restoreDir, restored)
+	c.Assert(err, IsNil)
+
+	r2, err = r2.Revert(restored, util.Now())
+	c.Assert(err, IsNil)
+	defer r2.Close()
+
+	restoredChain, err := r2.Chain()
+	c.Assert(err, IsNil)
+	c.Assert(len(restoredChain), Equals, 2)
+	c.Assert(restoredChain[1], Equals, restored)
+
+	readBuf := make([]byte, 10*mb)
+	_, err = r2.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	md5Equals(c, readBuf, expected)
+}
+
+func (s *TestSuite) TestIncrementalBackup(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	store, err := ioutil.TempDir("", "backupstore")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(store)
+	dest := "vfs://" + store
+
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	c.Assert(err, IsNil)
+
+	r, err := New(false, 10*mb, bs, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	backup := func(snapshot string) *backupstore.BackupInfo {
+		backupURL, err := backupstore.CreateDeltaBlockBackup(&backupstore.DeltaBackupConfig{
+			Volume:   &backupstore.Volume{Name: "test", Size: 10 * mb},
+			Snapshot: &backupstore.Snapshot{Name: GenerateSnapshotDiskName(snapshot)},
+			DestURL:  dest,
+			DeltaOps: NewBackup(nil),
+		})
+		c.Assert(err, IsNil)
+		info, err := backupstore.InspectBackup(backupURL)
+		c.Assert(err, IsNil)
+		return info
+	}
+
+	expected := make([]byte, 10*mb)
+	fill(expected[:4*mb], 1)
+	_, err = r.WriteAt(expected, 0)
+	c.Assert(err, IsNil)
+	err = r.Snapshot("001", true, util.Now())
+	c.Assert(err, IsNil)
+
+	info1 := backup("001")
+	c.Assert(info1.Parent, Equals, "")
+	c.Assert(info1.BlockCount, Equals, 2)
+	c.Assert(info1.DeltaBlockCount, Equals, 2)
+
+	// only the block at 6MiB changes
+	buf := make([]byte, mb)
+	fill(buf, 2)
+	_, err = r.WriteAt(buf, 6*mb)
+	c.Assert(err, IsNil)
+	copy(expected[6*mb:], buf)
+	err = r.Snapshot("002", true, util.Now())
+	c.Assert(err, IsNil)
+
+	info2 := backup("002")
+	c.Assert(info2.Parent, Equals, info1.Name)
+	c.Assert(info2.BlockCount, Equals, 3)
+	c.Assert(info2.DeltaBlockCount, Equals, 1)
+	c.Assert(info2.DeltaSize, Equals, int64(2*mb))
+
+	restoreDir, err := ioutil.TempDir("", "restore")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(restoreDir)
+	restored := path.Join(restoreDir, "restored.img")
+	err = backupstore.RestoreDeltaBlockBackup(info2.URL, restored)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(restored)
+	c.Assert(err, IsNil)
+	md5Equals(c, data, expected)
 }
-*/
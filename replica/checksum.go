@@ -0,0 +1,369 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	fibmap "github.com/frostschutz/go-fibmap"
+	"github.com/openebs/jiva/types"
+	"github.com/openebs/jiva/util"
+	"github.com/openebs/sparse-tools/sparse"
+)
+
+const (
+	// checksumSuffix is the suffix of the checksum file of a disk,
+	// it holds the CRC32C of each 4K block of the disk, the checksum
+	// of block n being stored at offset n*checksumSize.
+	checksumSuffix    = ".checksum"
+	checksumBlockSize = defaultSectorSize
+	checksumSize      = 4
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockChecksum returns the checksum of a block, a stored checksum of 0
+// means that the checksum of the block is unknown (blocks written before
+// the checksum file existed, holes...) so the rare blocks whose CRC is 0
+// aren't verified.
+func blockChecksum(block []byte) uint32 {
+	return crc32.Checksum(block, crc32cTable)
+}
+
+// checksumDisk is a disk of the chain whose blocks are checksummed, the
+// checksums are written along with the data and verified when reading
+// whole blocks.
+type checksumDisk struct {
+	types.DiffDisk
+	name string
+	// sums is nil if the disk has no checksum file, which is only
+	// possible for disks opened read only.
+	sums *os.File
+}
+
+// openChecksumDisk opens the checksum file of the disk, it is created
+// unless readOnly is set and truncated with the disk if flag has
+// os.O_TRUNC.
+func openChecksumDisk(disk types.DiffDisk, path string, flag int, readOnly bool) (*checksumDisk, error) {
+	c := &checksumDisk{
+		DiffDisk: disk,
+		name:     path,
+	}
+
+	var err error
+	if readOnly {
+		c.sums, err = os.Open(path + checksumSuffix)
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+	} else {
+		c.sums, err = os.OpenFile(path+checksumSuffix, os.O_RDWR|os.O_CREATE|(flag&os.O_TRUNC), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func isBlockAligned(offset int64, length int) bool {
+	return offset%checksumBlockSize == 0 && int64(length)%checksumBlockSize == 0
+}
+
+func (c *checksumDisk) WriteAt(buf []byte, offset int64) (int, error) {
+	n, err := c.DiffDisk.WriteAt(buf, offset)
+	if c.sums == nil {
+		return n, err
+	}
+	if err != nil || !isBlockAligned(offset, len(buf)) {
+		if invalidateErr := c.invalidate(offset, int64(len(buf))); invalidateErr != nil && err == nil {
+			err = invalidateErr
+		}
+		return n, err
+	}
+
+	return n, c.writeSums(buf, offset)
+}
+
+// writeSums stores the checksums of the blocks written at offset
+func (c *checksumDisk) writeSums(buf []byte, offset int64) error {
+	blocks := len(buf) / checksumBlockSize
+	sums := make([]byte, blocks*checksumSize)
+	for i := 0; i < blocks; i++ {
+		binary.LittleEndian.PutUint32(sums[i*checksumSize:],
+			blockChecksum(buf[i*checksumBlockSize:(i+1)*checksumBlockSize]))
+	}
+	if _, err := c.sums.WriteAt(sums, offset/checksumBlockSize*checksumSize); err != nil {
+		return fmt.Errorf("Failed to write checksums of %v at offset %d, error: %v", c.name, offset, err)
+	}
+	return nil
+}
+
+func (c *checksumDisk) ReadAt(buf []byte, offset int64) (int, error) {
+	n, err := c.DiffDisk.ReadAt(buf, offset)
+	if err != nil || c.sums == nil || !isBlockAligned(offset, len(buf)) {
+		return n, err
+	}
+
+	mismatches, _, err := c.verify(buf[:n-n%checksumBlockSize], offset)
+	if err != nil {
+		return n, err
+	}
+	if len(mismatches) != 0 {
+		return n, &types.ChecksumError{
+			Msg: fmt.Sprintf("Checksum mismatch in %v at offset %d", c.name, mismatches[0]),
+		}
+	}
+	return n, nil
+}
+
+// readSums returns the checksums of the given blocks, blocks past the end
+// of the checksum file have an unknown checksum.
+func (c *checksumDisk) readSums(offset int64, blocks int) ([]byte, error) {
+	sums := make([]byte, blocks*checksumSize)
+	n, err := c.sums.ReadAt(sums, offset/checksumBlockSize*checksumSize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Failed to read checksums of %v at offset %d, error: %v", c.name, offset, err)
+	}
+	for i := n; i < len(sums); i++ {
+		sums[i] = 0
+	}
+	return sums, nil
+}
+
+// verify checks the blocks read at offset against their checksums, it
+// returns the offsets of the corrupted blocks and the number of blocks
+// which couldn't be verified.
+func (c *checksumDisk) verify(buf []byte, offset int64) ([]int64, int64, error) {
+	var (
+		mismatches []int64
+		unchecked  int64
+	)
+	blocks := len(buf) / checksumBlockSize
+	if c.sums == nil {
+		return nil, int64(blocks), nil
+	}
+	sums, err := c.readSums(offset, blocks)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := 0; i < blocks; i++ {
+		sum := binary.LittleEndian.Uint32(sums[i*checksumSize:])
+		if sum == 0 {
+			unchecked++
+			continue
+		}
+		if sum != blockChecksum(buf[i*checksumBlockSize:(i+1)*checksumBlockSize]) {
+			mismatches = append(mismatches, offset+int64(i*checksumBlockSize))
+		}
+	}
+	return mismatches, unchecked, nil
+}
+
+// invalidate forgets the checksums of the blocks overlapping the range,
+// it is used when the data of the range isn't known, such as after
+// punching holes or failed writes.
+func (c *checksumDisk) invalidate(offset, length int64) error {
+	if c.sums == nil || length <= 0 {
+		return nil
+	}
+	start := offset / checksumBlockSize
+	end := (offset + length + checksumBlockSize - 1) / checksumBlockSize
+	if err := syscall.Fallocate(int(c.sums.Fd()),
+		sparse.FALLOC_FL_KEEP_SIZE|sparse.FALLOC_FL_PUNCH_HOLE,
+		start*checksumSize, (end-start)*checksumSize); err != nil {
+		return fmt.Errorf("Failed to invalidate checksums of %v at offset %d, error: %v", c.name, offset, err)
+	}
+	return nil
+}
+
+// punchHole punches a hole in the disk, invalidating the checksums of the
+// range first for the disks which are checksummed, so that a crash in
+// between leaves them unknown rather than wrong.
+func punchHole(disk types.DiffDisk, offset, length int64) error {
+	if c, ok := disk.(*checksumDisk); ok {
+		if err := c.invalidate(offset, length); err != nil {
+			return err
+		}
+	}
+	return syscall.Fallocate(int(disk.Fd()),
+		sparse.FALLOC_FL_KEEP_SIZE|sparse.FALLOC_FL_PUNCH_HOLE,
+		offset, length)
+}
+
+// syncSums flushes the checksums, the data is flushed by the caller
+// using Fd().
+func (c *checksumDisk) syncSums() error {
+	if c.sums == nil {
+		return nil
+	}
+	return c.sums.Sync()
+}
+
+func (c *checksumDisk) Close() error {
+	if c.sums != nil {
+		if err := c.sums.Close(); err != nil {
+			c.DiffDisk.Close()
+			return err
+		}
+	}
+	return c.DiffDisk.Close()
+}
+
+// walkExtents calls fn with the offset and length of each extent
+// of the file, rounded to blocks.
+func walkExtents(f *os.File, fn func(offset, length int64) error) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start := uint64(0)
+	end := uint64(stat.Size())
+	for start < end {
+		extents, errno := fibmap.Fiemap(f.Fd(), start, end-start, 1024)
+		if errno != 0 {
+			return errno
+		}
+		if len(extents) == 0 {
+			return nil
+		}
+		for _, extent := range extents {
+			offset := int64(extent.Logical) / checksumBlockSize * checksumBlockSize
+			length := int64(extent.Logical+extent.Length) - offset
+			if length%checksumBlockSize != 0 {
+				length += checksumBlockSize - length%checksumBlockSize
+			}
+			if err := fn(offset, length); err != nil {
+				return err
+			}
+			start = extent.Logical + extent.Length
+			if extent.Flags&fibmap.FIEMAP_EXTENT_LAST != 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// BuildChecksums computes the checksums of the blocks of a disk file and
+// replaces its checksum file. It is used for the disks which are written
+// without going through the replica, such as the snapshots received
+// while rebuilding.
+func BuildChecksums(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmpPath := path + checksumSuffix + ".tmp"
+	sums, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	c := &checksumDisk{name: path, sums: sums}
+
+	buf := make([]byte, 256*checksumBlockSize)
+	err = walkExtents(f, func(offset, length int64) error {
+		for length > 0 {
+			size := int64(len(buf))
+			if length < size {
+				size = length
+			}
+			n, err := f.ReadAt(buf[:size], offset)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			n -= n % checksumBlockSize
+			if n == 0 {
+				return nil
+			}
+			if err := c.writeSums(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += int64(n)
+			length -= int64(n)
+		}
+		return nil
+	})
+	if err != nil {
+		sums.Close()
+		return fmt.Errorf("Failed to build checksums of %v, error: %v", path, err)
+	}
+	if err := sums.Sync(); err != nil {
+		sums.Close()
+		return err
+	}
+	if err := sums.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path+checksumSuffix); err != nil {
+		return err
+	}
+	return util.SyncDir(filepath.Dir(path))
+}
+
+// FoldChecksums copies the checksums of the blocks of the disk from into
+// its parent to, once the data of from has been folded into to by sfold.
+func FoldChecksums(from, to string) error {
+	toSums, err := os.OpenFile(to+checksumSuffix, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer toSums.Close()
+	parent := &checksumDisk{name: to, sums: toSums}
+
+	fromFile, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer fromFile.Close()
+	child := &checksumDisk{name: from}
+	child.sums, err = os.Open(from + checksumSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if child.sums != nil {
+		defer child.sums.Close()
+	}
+
+	err = walkExtents(fromFile, func(offset, length int64) error {
+		if child.sums == nil {
+			// the data folded into the parent isn't checksummed
+			return parent.invalidate(offset, length)
+		}
+		sums, err := child.readSums(offset, int(length/checksumBlockSize))
+		if err != nil {
+			return err
+		}
+		_, err = toSums.WriteAt(sums, offset/checksumBlockSize*checksumSize)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to fold checksums of %v into %v, error: %v", from, to, err)
+	}
+	return toSums.Sync()
+}
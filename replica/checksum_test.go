@@ -0,0 +1,244 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/openebs/jiva/types"
+	. "gopkg.in/check.v1"
+)
+
+// corruptDisk flips a byte of a disk file behind the replica's back
+func corruptDisk(c *C, file string, offset int64) {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	buf := make([]byte, 1)
+	_, err = f.ReadAt(buf, offset)
+	c.Assert(err, IsNil)
+	buf[0] ^= 0xff
+	_, err = f.WriteAt(buf, offset)
+	c.Assert(err, IsNil)
+	c.Assert(f.Sync(), IsNil)
+}
+
+func scrubReplica(c *C, r *Replica) types.ScrubStatus {
+	sc := &scrubber{}
+	c.Assert(sc.start(), IsNil)
+	sc.finish(r.scrub(sc))
+	return sc.get()
+}
+
+func (s *TestSuite) TestChecksum(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer func() {
+		r.Close()
+	}()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 2*b)
+	fill(buf, 1)
+	_, err = r.WriteAt(buf, b)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("000", true, getNow()), IsNil)
+
+	// partial writes are checksummed through read-modify-write
+	fill(buf, 2)
+	_, err = r.WriteAt(buf[:bs], 5*b+bs)
+	c.Assert(err, IsNil)
+
+	readBuf := make([]byte, 10*b)
+	_, err = r.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+
+	status := scrubReplica(c, r)
+	c.Assert(status.State, Equals, types.ScrubCompleted)
+	c.Assert(status.CheckedBlocks, Equals, int64(3))
+	c.Assert(status.MismatchedBlocks, Equals, int64(0))
+
+	corruptDisk(c, path.Join(dir, "volume-snap-000.img"), 2*b+10)
+	corruptDisk(c, path.Join(dir, "volume-head-001.img"), 5*b)
+
+	_, err = r.ReadAt(readBuf[:b], 2*b)
+	c.Assert(err, ErrorMatches, "Checksum mismatch in .*volume-snap-000.img at offset 8192")
+	_, err = r.ReadAt(readBuf[:b], b)
+	c.Assert(err, IsNil)
+
+	status = scrubReplica(c, r)
+	c.Assert(status.State, Equals, types.ScrubCompleted)
+	c.Assert(status.CheckedBlocks, Equals, int64(3))
+	c.Assert(status.MismatchedBlocks, Equals, int64(2))
+	c.Assert(status.Mismatches, DeepEquals, []types.ScrubMismatch{
+		{Disk: "volume-snap-000.img", Offset: 2 * b},
+		{Disk: "volume-head-001.img", Offset: 5 * b},
+	})
+
+	// the checksums are kept across restarts
+	c.Assert(r.Close(), IsNil)
+	r, err = New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	_, err = r.ReadAt(readBuf[:b], 5*b)
+	c.Assert(err, ErrorMatches, "Checksum mismatch in .*volume-head-001.img at offset 20480")
+}
+
+func (s *TestSuite) TestChecksumUnmap(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 4*b)
+	fill(buf, 3)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+
+	// the checksums of the punched blocks are forgotten
+	_, err = r.Unmap(b+bs, 2*b)
+	c.Assert(err, IsNil)
+	readBuf := make([]byte, 4*b)
+	_, err = r.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+
+	status := scrubReplica(c, r)
+	c.Assert(status.MismatchedBlocks, Equals, int64(0))
+}
+
+func (s *TestSuite) TestChecksumPunchHole(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 2*b)
+	fill(buf, 6)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("000", false, getNow()), IsNil)
+
+	// the holes punched in the snapshots hidden by newer data are read
+	// as zeros by the backups, not as corrupted blocks
+	snap := r.volume.files[1]
+	c.Assert(punchHole(snap, 0, b), IsNil)
+	readBuf := make([]byte, 2*b)
+	_, err = snap.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(readBuf[:b], DeepEquals, make([]byte, b))
+}
+
+func (s *TestSuite) TestChecksumDirtyOpen(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+	buf := make([]byte, 2*b)
+	fill(buf, 7)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+	head := r.Info().Head
+	c.Assert(r.Close(), IsNil)
+
+	// tear the checksum of a block and mark the replica dirty as left
+	// by a crash in the middle of the write
+	corruptDisk(c, path.Join(dir, head+checksumSuffix), checksumSize)
+	info, err := ReadInfo(dir)
+	c.Assert(err, IsNil)
+	info.Dirty = true
+	c.Assert((&Replica{dir: dir}).encodeToFile(&info, volumeMetaData), IsNil)
+
+	r, err = New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	readBuf := make([]byte, 2*b)
+	_, err = r.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(readBuf, DeepEquals, buf)
+	status := scrubReplica(c, r)
+	c.Assert(status.CheckedBlocks, Equals, int64(2))
+	c.Assert(status.MismatchedBlocks, Equals, int64(0))
+}
+
+func (s *TestSuite) TestBuildAndFoldChecksums(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 2*b)
+	fill(buf, 4)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("000", false, getNow()), IsNil)
+	fill(buf, 5)
+	_, err = r.WriteAt(buf, b)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("001", false, getNow()), IsNil)
+
+	parent := path.Join(dir, "volume-snap-000.img")
+	child := path.Join(dir, "volume-snap-001.img")
+	sums, err := ioutil.ReadFile(parent + checksumSuffix)
+	c.Assert(err, IsNil)
+
+	// rebuilding the checksums from the data gives the same checksums
+	c.Assert(os.Remove(parent+checksumSuffix), IsNil)
+	c.Assert(BuildChecksums(parent), IsNil)
+	built, err := ioutil.ReadFile(parent + checksumSuffix)
+	c.Assert(err, IsNil)
+	c.Assert(built, DeepEquals, sums)
+
+	// fold the data of the child into the parent as sfold does
+	f, err := os.OpenFile(parent, os.O_RDWR, 0)
+	c.Assert(err, IsNil)
+	_, err = f.WriteAt(buf, b)
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	c.Assert(FoldChecksums(child, parent), IsNil)
+	folded, err := ioutil.ReadFile(parent + checksumSuffix)
+	c.Assert(err, IsNil)
+	c.Assert(BuildChecksums(parent), IsNil)
+	built, err = ioutil.ReadFile(parent + checksumSuffix)
+	c.Assert(err, IsNil)
+	c.Assert(folded, DeepEquals, built)
+}
@@ -52,6 +52,10 @@ type ReplicaClient struct {
 	syncAgent  string
 	host       string
 	httpClient *http.Client
+	// epoch and controllerID identify the controller sending the
+	// requests, 0 if they aren't sent by a controller.
+	epoch        int64
+	controllerID string
 }
 
 // GetAddress is used to get the address of replica client
@@ -65,7 +69,7 @@ func NewReplicaClient(address string) (*ReplicaClient, error) {
 	}
 
 	if !strings.HasPrefix(address, "http") {
-		address = "http://" + address
+		address = util.HTTPScheme() + "://" + address
 	}
 
 	if !strings.HasSuffix(address, "/v1") {
@@ -90,9 +94,7 @@ func NewReplicaClient(address string) (*ReplicaClient, error) {
 	syncAgent := strings.Replace(address, fmt.Sprintf(":%d", port), fmt.Sprintf(":%d", port+2), -1)
 
 	timeout := time.Duration(30 * time.Second)
-	client := &http.Client{
-		Timeout: timeout,
-	}
+	client := util.NewHTTPClient(timeout)
 
 	return &ReplicaClient{
 		host:       parts[0],
@@ -102,6 +104,20 @@ func NewReplicaClient(address string) (*ReplicaClient, error) {
 	}, nil
 }
 
+// SetEpoch sends the epoch and the ID of the controller with the requests
+// changing the replica, the replica rejects them once another controller
+// has claimed it.
+func (c *ReplicaClient) SetEpoch(epoch int64, id string) {
+	c.epoch, c.controllerID = epoch, id
+}
+
+func (c *ReplicaClient) setEpochHeaders(req *http.Request) {
+	if c.epoch != 0 {
+		req.Header.Set(rest.EpochHeader, strconv.FormatInt(c.epoch, 10))
+		req.Header.Set(rest.ControllerHeader, c.controllerID)
+	}
+}
+
 // SetTimeout override the timeout of the client for a request
 // Ignore setting timeout if httpClient is nil as ReplicaClient may not be
 // initialized.
@@ -132,6 +148,7 @@ func (c *ReplicaClient) Delete(path string) error {
 	if err != nil {
 		return err
 	}
+	c.setEpochHeaders(req)
 	_, err = c.httpClient.Do(req)
 	if err != nil {
 		return err
@@ -263,6 +280,46 @@ func (c *ReplicaClient) ReloadReplica() (rest.Replica, error) {
 }
 
 // UpdateCloneInfo update the snapname and revision count
+// HashData returns the hashes of the blocks of blockSize bytes of a range
+// of the snapshot, or of the head if snapshot is empty.
+func (c *ReplicaClient) HashData(snapshot string, offset, length, blockSize int64) ([]string, error) {
+	var output rest.HashOutput
+
+	err := c.post(c.address+"/replicas/1?action=hash", &rest.HashInput{
+		Snapshot:  snapshot,
+		Offset:    offset,
+		Length:    length,
+		BlockSize: blockSize,
+	}, &output)
+	return output.Hashes, err
+}
+
+// ReadSnapshot reads a range of the snapshot, or of the head if snapshot
+// is empty.
+func (c *ReplicaClient) ReadSnapshot(snapshot string, offset, length int64) ([]byte, error) {
+	var output rest.SnapshotData
+
+	err := c.post(c.address+"/replicas/1?action=readsnapshot", &rest.SnapshotData{
+		Snapshot: snapshot,
+		Offset:   offset,
+		Length:   length,
+	}, &output)
+	if err == nil && int64(len(output.Data)) != length {
+		err = fmt.Errorf("Read %d bytes of %s at offset %d, expected %d", len(output.Data), snapshot, offset, length)
+	}
+	return output.Data, err
+}
+
+// WriteSnapshot overwrites a range of a snapshot
+func (c *ReplicaClient) WriteSnapshot(snapshot string, offset int64, data []byte) error {
+	return c.post(c.address+"/replicas/1?action=writesnapshot", &rest.SnapshotData{
+		Snapshot: snapshot,
+		Offset:   offset,
+		Length:   int64(len(data)),
+		Data:     data,
+	}, nil)
+}
+
 func (c *ReplicaClient) UpdateCloneInfo(snapName, revCount string) (rest.Replica, error) {
 	var replica rest.Replica
 
@@ -518,7 +575,14 @@ func (c *ReplicaClient) post(path string, req, resp interface{}) error {
 
 	logrus.Debugf("POST %s", url)
 
-	httpResp, err := c.httpClient.Post(url, bodyType, bytes.NewBuffer(b))
+	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", bodyType)
+	c.setEpochHeaders(httpReq)
+
+	httpResp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return err
 	}
@@ -27,7 +27,6 @@ import (
 	fibmap "github.com/frostschutz/go-fibmap"
 	inject "github.com/openebs/jiva/error-inject"
 	"github.com/openebs/jiva/types"
-	"github.com/openebs/sparse-tools/sparse"
 )
 
 type fileType struct {
@@ -38,7 +37,7 @@ type diffDisk struct {
 	rmLock *sync.Mutex
 	// mapping of sector to index in the files array. a value of 0
 	// is special meaning we don't know the location yet.
-	location          []uint16
+	location          *locationMap
 	UsedLogicalBlocks int64
 	UsedBlocks        int64
 	// list of files in grandparent, parent, child order
@@ -57,6 +56,10 @@ type diffDisk struct {
 	// Index of latest user created snapshot
 	SnapIndx   int
 	sectorSize int64
+	// readOnly is set for the disks of a read only replica, such as a
+	// snapshot view, whose files are never modified. Their duplicate
+	// blocks are left to the live replica to punch.
+	readOnly bool
 }
 
 // RemoveIndex removes the index from list of files
@@ -81,12 +84,7 @@ func (d *diffDisk) RemoveIndex(index int) error {
 
 	// Indexes are from base to head,
 	// head index is the largest, base index is 1
-	for i := 0; i < len(d.location); i++ {
-		if d.location[i] >= uint16(index) {
-			// move index by one
-			d.location[i]--
-		}
-	}
+	d.location.removeIndex(uint32(index))
 
 	d.files = append(d.files[:index], d.files[index+1:]...)
 	d.UserCreatedSnap = append(d.UserCreatedSnap[:index], d.UserCreatedSnap[index+1:]...)
@@ -153,12 +151,17 @@ func (d *diffDisk) readModifyWrite(buf []byte, offset int64) (int, error) {
 }
 
 func (d *diffDisk) Sync() (int, error) {
-	target := uint16(len(d.files) - 1)
+	target := uint32(len(d.files) - 1)
 	fd := d.files[target].Fd()
 	err := syscall.Fsync(int(fd))
 	if err != nil {
 		return -1, err
 	}
+	if c, ok := d.files[target].(*checksumDisk); ok {
+		if err := c.syncSums(); err != nil {
+			return -1, err
+		}
+	}
 	return 0, err
 }
 
@@ -168,10 +171,7 @@ func (d *diffDisk) Unmap(offset int64, length int64) (int, error) {
 		if indx <= d.SnapIndx || file == nil {
 			continue
 		}
-		fd := file.Fd()
-		err = syscall.Fallocate(int(fd),
-			sparse.FALLOC_FL_KEEP_SIZE|sparse.FALLOC_FL_PUNCH_HOLE,
-			offset, length)
+		err = punchHole(file, offset, length)
 		if err != nil {
 			return -1, err
 		}
@@ -185,7 +185,7 @@ func (d *diffDisk) fullWriteAt(buf []byte, offset int64) (int, error) {
 		length   int64
 		lOffset  int64
 		file     types.DiffDisk
-		fileIndx uint16
+		fileIndx uint32
 	)
 	if int64(len(buf))%d.sectorSize != 0 || offset%d.sectorSize != 0 {
 		return 0, fmt.Errorf("Write len(%d), offset %d not a multiple of %d", len(buf), offset, d.sectorSize)
@@ -198,39 +198,44 @@ func (d *diffDisk) fullWriteAt(buf []byte, offset int64) (int, error) {
 	c, err := d.files[target].WriteAt(buf, offset)
 
 	// Regardless of err mark bytes as written
-	for i := int64(0); i < sectors; i++ {
-		offset = startSector + i
-		if val := d.location[startSector+i]; val == 0 {
-			d.UsedLogicalBlocks++
-			d.UsedBlocks++
-		} else if val != uint16(target) {
+	for sector := startSector; sector < startSector+sectors; {
+		val, next := d.location.extent(sector)
+		if next > startSector+sectors {
+			next = startSector + sectors
+		}
+		count := next - sector
+		if val == 0 {
+			d.UsedLogicalBlocks += count
+			d.UsedBlocks += count
+		} else if val != uint32(target) {
 			// d.UsedBlocks is being incremented and decremented to accomodate user
 			// created snapshots
 			// We are looking for continuous blocks over here.
-			// If the file of the next block is changed or offset not same,
+			// If the file of the next blocks is changed or offset not same,
 			// we punch a hole for the previous unpunched blocks,
-			// and reset the file and fileIndx pointed to by this block
-			if d.location[startSector+i] != fileIndx ||
-				startSector+i != lOffset+length {
+			// and reset the file and fileIndx pointed to by these blocks
+			if val != fileIndx ||
+				sector != lOffset+length {
 				if (file != nil) && (int(fileIndx) > d.SnapIndx) && shouldCreateHoles() && !inject.DisablePunchHoles() {
 					d.UsedBlocks -= length
 					sendToCreateHole(d.files[val], lOffset*d.sectorSize, length*d.sectorSize)
 				}
-				file = d.files[d.location[startSector+i]]
-				fileIndx = d.location[offset]
-				length = 1
-				lOffset = startSector + i
+				file = d.files[val]
+				fileIndx = val
+				length = count
+				lOffset = sector
 			} else {
-				//If this is the last block in the loop, hole for this
-				//block will be punched outside the loop
-				length++
+				//If these are the last blocks in the loop, hole for them
+				//will be punched outside the loop
+				length += count
 			}
-			d.UsedBlocks++
+			d.UsedBlocks += count
 		}
-		// Control will come over here if offsets are overwritten in the same
-		// file
-		d.location[startSector+i] = uint16(target)
+		sector = next
 	}
+	// Control will come over here if offsets are overwritten in the same
+	// file
+	d.location.set(startSector, sectors, uint32(target))
 	//This will take care of the case when the last call in the above loop
 	//enters if case
 	if (file != nil) && (int(fileIndx) > d.SnapIndx) && shouldCreateHoles() && !inject.DisablePunchHoles() {
@@ -293,90 +298,95 @@ func (d *diffDisk) fullReadAt(buf []byte, offset int64) (int, error) {
 	}
 
 	count := 0
-	sectors := int64(len(buf)) / d.sectorSize
-	readSectors := int64(1)
 	startSector := offset / d.sectorSize
-	target, err := d.lookup(startSector)
-	if err != nil {
-		return count, err
+	endSector := startSector + int64(len(buf))/d.sectorSize
+	// the runs of sectors in the same file are read at once
+	var target uint32
+	runStart := startSector
+	flush := func(end int64) error {
+		if target == 0 {
+			count += int((end - runStart) * d.sectorSize)
+			return nil
+		}
+		c, err = d.read(target, buf, offset, runStart-startSector, end-runStart)
+		count += c
+		return err
 	}
-
-	for i := int64(1); i < sectors; i++ {
-		newTarget, err := d.lookup(startSector + i)
+	for sector := startSector; sector < endSector; {
+		newTarget, next, err := d.lookup(sector, endSector)
 		if err != nil {
 			return count, err
 		}
-
-		if newTarget == target {
-			readSectors++
-		} else {
-			if target == 0 {
-				count += int(readSectors * d.sectorSize)
-			} else {
-				c, err = d.read(target, buf, offset, i-readSectors, readSectors)
-				count += c
-			}
-			if err != nil {
+		if sector > runStart && newTarget != target {
+			if err := flush(sector); err != nil {
 				return count, err
 			}
-			readSectors = 1
-			target = newTarget
+			runStart = sector
 		}
+		target = newTarget
+		sector = next
 	}
-
-	if readSectors > 0 {
-		if target == 0 {
-			count += int(readSectors * d.sectorSize)
-		} else {
-			c, err = d.read(target, buf, offset, sectors-readSectors, readSectors)
-			count += c
-		}
-		if err != nil {
-			return count, err
-		}
+	if err := flush(endSector); err != nil {
+		return count, err
 	}
 
 	return count, nil
 }
 
-func (d *diffDisk) read(target uint16, buf []byte, offset int64, startSector int64, sectors int64) (int, error) {
+func (d *diffDisk) read(target uint32, buf []byte, offset int64, startSector int64, sectors int64) (int, error) {
 	bufStart := startSector * d.sectorSize
 	bufEnd := sectors * d.sectorSize
 	newBuf := buf[bufStart : bufStart+bufEnd]
 	return d.files[target].ReadAt(newBuf, offset+bufStart)
 }
 
-func (d *diffDisk) lookup(sector int64) (uint16, error) {
-	if sector >= int64(len(d.location)) {
+// lookup returns the index of the file holding the sector, and the end of
+// the sectors following it in the same file, up to limit. The location of
+// the sectors not known yet is found in the extents of the files from the
+// newest one, and set at once for all of them.
+func (d *diffDisk) lookup(sector, limit int64) (uint32, int64, error) {
+	if sector >= d.location.len() {
 		// We know the IO will result in EOF
-		return uint16(len(d.files) - 1), nil
+		return uint32(len(d.files) - 1), limit, nil
 	}
 
 	// small optimization
 	if int64(len(d.files)) == 2 {
-		return 1, nil
+		return 1, limit, nil
 	}
 
-	target := uint16(d.location[sector])
-	if target == 0 {
-		for i := len(d.files) - 1; i > 0; i-- {
-			if i == 1 {
-				// This is important that for index 1 we don't check Fiemap because it may be a base image file
-				// Also the result has to be 1
-				d.location[sector] = uint16(i)
-				return uint16(i), nil
-			}
+	target, end := d.location.extent(sector)
+	if end > limit {
+		end = limit
+	}
+	if target != 0 {
+		return target, end, nil
+	}
+	for i := len(d.files) - 1; i > 0; i-- {
+		if i == 1 {
+			// This is important that for index 1 we don't check Fiemap because it may be a base image file
+			// Also the result has to be 1
+			d.location.set(sector, end-sector, 1)
+			return 1, end, nil
+		}
 
-			e, err := fibmap.Fiemap(d.files[i].Fd(), uint64(sector*d.sectorSize), uint64(d.sectorSize), 1)
-			if err != 0 {
-				return uint16(0), err
-			}
-			if len(e) > 0 {
-				d.location[sector] = uint16(i)
-				return uint16(i), nil
-			}
+		e, err := fibmap.Fiemap(d.files[i].Fd(), uint64(sector*d.sectorSize), uint64((end-sector)*d.sectorSize), 1)
+		if err != 0 {
+			return uint32(0), 0, err
+		}
+		if len(e) == 0 {
+			continue
+		}
+		if start := int64(e[0].Logical) / d.sectorSize; start > sector {
+			// the older files hold the sectors before the extent
+			end = start
+			continue
+		}
+		if extentEnd := int64(e[0].Logical+e[0].Length) / d.sectorSize; extentEnd < end {
+			end = extentEnd
 		}
-		return uint16(len(d.files) - 1), nil
+		d.location.set(sector, end-sector, uint32(i))
+		return uint32(i), end, nil
 	}
-	return target, nil
+	return uint32(len(d.files) - 1), sector + 1, nil
 }
@@ -0,0 +1,117 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+const controllerEpochFile = "controller.epoch"
+
+// ControllerEpoch identifies the controller owning the replica. The epoch
+// of a controller is greater than the ones of the replicas it claims, so
+// that a controller replaced by a newer one, e.g. after a reschedule,
+// can't write to them anymore.
+type ControllerEpoch struct {
+	Epoch      int64  `json:"epoch"`
+	Controller string `json:"controller"`
+}
+
+// ReadEpoch returns the epoch of the controller which claimed the replica
+// in dir last, it is 0 if none did.
+func ReadEpoch(dir string) (ControllerEpoch, error) {
+	var epoch ControllerEpoch
+	err := (&Replica{dir: dir}).unmarshalFile(controllerEpochFile, &epoch)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return epoch, err
+}
+
+// isStale returns true if the controller with the given epoch and ID has
+// been replaced by the owner of the replica.
+func (e ControllerEpoch) isStale(epoch int64, id string) bool {
+	return epoch < e.Epoch || (epoch == e.Epoch && id != e.Controller)
+}
+
+func (s *Server) loadEpoch() error {
+	if s.epochLoaded {
+		return nil
+	}
+	epoch, err := ReadEpoch(s.dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read the controller epoch, error: %v", err)
+	}
+	s.epoch, s.epochLoaded = epoch, true
+	return nil
+}
+
+// Claim makes the controller with the given epoch and ID the owner of the
+// replica, the epoch is persisted before the claim succeeds.
+func (s *Server) Claim(epoch int64, id string) error {
+	s.epochLock.Lock()
+	defer s.epochLock.Unlock()
+
+	if err := s.loadEpoch(); err != nil {
+		return err
+	}
+	if s.epoch.isStale(epoch, id) {
+		return fmt.Errorf("Stale controller epoch %d, replica is owned by controller %s with epoch %d",
+			epoch, s.epoch.Controller, s.epoch.Epoch)
+	}
+	if epoch == s.epoch.Epoch {
+		return nil
+	}
+	claimed := ControllerEpoch{Epoch: epoch, Controller: id}
+	if err := (&Replica{dir: s.dir}).encodeToFile(&claimed, controllerEpochFile); err != nil {
+		return fmt.Errorf("Failed to persist the controller epoch, error: %v", err)
+	}
+	logrus.Infof("Replica owned by controller %s with epoch %d instead of %s with epoch %d",
+		id, epoch, s.epoch.Controller, s.epoch.Epoch)
+	s.epoch = claimed
+	return nil
+}
+
+// CheckEpoch returns an error if the controller with the given epoch and
+// ID has been replaced by another one.
+func (s *Server) CheckEpoch(epoch int64, id string) error {
+	s.epochLock.Lock()
+	defer s.epochLock.Unlock()
+
+	if err := s.loadEpoch(); err != nil {
+		return err
+	}
+	if s.epoch.isStale(epoch, id) {
+		return fmt.Errorf("Stale controller epoch %d, replica is owned by controller %s with epoch %d",
+			epoch, s.epoch.Controller, s.epoch.Epoch)
+	}
+	return nil
+}
+
+// Epoch returns the epoch of the controller owning the replica
+func (s *Server) Epoch() ControllerEpoch {
+	s.epochLock.Lock()
+	defer s.epochLock.Unlock()
+
+	if err := s.loadEpoch(); err != nil {
+		logrus.Warning(err)
+	}
+	return s.epoch
+}
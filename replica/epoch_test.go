@@ -0,0 +1,53 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestClaimEpoch(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	server := &Server{dir: dir}
+	// never claimed, the controllers without epoch are served
+	c.Assert(server.CheckEpoch(0, ""), IsNil)
+
+	c.Assert(server.Claim(2, "a"), IsNil)
+	c.Assert(server.Claim(2, "a"), IsNil)
+	c.Assert(server.CheckEpoch(2, "a"), IsNil)
+	c.Assert(server.CheckEpoch(0, ""), NotNil)
+	// another controller with the same epoch
+	c.Assert(server.Claim(2, "b"), NotNil)
+
+	c.Assert(server.Claim(3, "b"), IsNil)
+	c.Assert(server.CheckEpoch(2, "a"), NotNil)
+	c.Assert(server.Claim(2, "a"), NotNil)
+
+	// the epoch is persisted across restarts
+	epoch, err := ReadEpoch(dir)
+	c.Assert(err, IsNil)
+	c.Assert(epoch, Equals, ControllerEpoch{Epoch: 3, Controller: "b"})
+	restarted := &Server{dir: dir}
+	c.Assert(restarted.CheckEpoch(2, "a"), NotNil)
+	c.Assert(restarted.CheckEpoch(3, "b"), IsNil)
+}
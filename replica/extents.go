@@ -58,7 +58,7 @@ func (u *UsedGenerator) findExtents(c chan<- int64) {
 	}
 
 	start := uint64(0)
-	end := uint64(len(u.d.location)) * uint64(u.d.sectorSize)
+	end := uint64(u.d.location.len()) * uint64(u.d.sectorSize)
 	for {
 		extents, errno := fibmap.Fiemap(fd, start, end-start, 1024)
 		if errno != 0 {
@@ -0,0 +1,544 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FsckIssue is a problem found in a replica directory by Fsck
+type FsckIssue struct {
+	// Check is the name of the check which found it
+	Check   string
+	Message string
+	// Warning is whether the replica can still be opened with it
+	Warning bool
+	// Repair describes the repair of the issue, empty if it can't be
+	// repaired safely
+	Repair   string
+	Repaired bool
+}
+
+// FsckReport is the result of Fsck
+type FsckReport struct {
+	Dir    string
+	Head   string
+	Chain  []string
+	Issues []FsckIssue
+}
+
+// Errors returns the number of issues, other than warnings, which weren't
+// repaired
+func (r *FsckReport) Errors() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Warning && !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// fsck checks the files of a replica directory, and repairs them if
+// repair is set. The files are read directly rather than opening the
+// replica, as opening fails on the issues it looks for.
+type fsck struct {
+	r      *Replica
+	repair bool
+	report *FsckReport
+	info   Info
+	// disks are the metadata of the disks of the directory, unreadable
+	// the ones whose metadata can't be read
+	disks      map[string]*disk
+	unreadable map[string]bool
+	// files are the names of the files of the directory
+	files map[string]os.FileInfo
+	// chainComplete is whether the chain was walked down to its base,
+	// the disks out of it are only known if it was
+	chainComplete bool
+}
+
+// Fsck checks the replica directory, which must not be in use. If repair
+// is set the issues which can be safely repaired are repaired, otherwise
+// the directory isn't modified.
+func Fsck(dir string, repair bool) (*FsckReport, error) {
+	f := &fsck{
+		r:      &Replica{dir: dir, readOnly: !repair},
+		repair: repair,
+		report: &FsckReport{Dir: dir},
+	}
+	if err := f.readDir(); err != nil {
+		return nil, err
+	}
+	if _, ok := f.files[volumeMetaData]; !ok {
+		return nil, fmt.Errorf("%s is not a replica directory, %s not found: %v", dir, volumeMetaData, os.ErrNotExist)
+	}
+
+	if !f.checkIntent() || !f.checkFormat() {
+		return f.report, nil
+	}
+	f.readDisks()
+	if !f.checkHead() {
+		return f.report, nil
+	}
+	f.checkChain()
+	if f.chainComplete {
+		f.checkChildren()
+	}
+	f.checkFiles()
+	f.checkRevisionCounter()
+	f.checkSizes()
+	return f.report, nil
+}
+
+// issue adds an issue to the report, and repairs it if a repair is given
+// and the repair is requested. It returns whether the issue was repaired.
+func (f *fsck) issue(issue FsckIssue, repair func() error) bool {
+	if repair == nil {
+		issue.Repair = ""
+	}
+	if f.repair && repair != nil {
+		if err := repair(); err != nil {
+			issue.Message = fmt.Sprintf("%s, repair failed: %v", issue.Message, err)
+		} else {
+			issue.Repaired = true
+			logrus.Infof("Repaired %s: %s", issue.Message, issue.Repair)
+		}
+	}
+	f.report.Issues = append(f.report.Issues, issue)
+	return issue.Repaired
+}
+
+func (f *fsck) readDir() error {
+	files, err := ioutil.ReadDir(f.r.dir)
+	if err != nil {
+		return err
+	}
+	f.files = make(map[string]os.FileInfo, len(files))
+	for _, file := range files {
+		f.files[file.Name()] = file
+	}
+	return nil
+}
+
+// fileNames returns the names of the files sorted, so that the issues
+// are reported in the same order
+func (f *fsck) fileNames() []string {
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isDiskFile(name string) bool {
+	return IsHeadDisk(name) || (strings.HasPrefix(name, diskPrefix) && strings.HasSuffix(name, diskSuffix))
+}
+
+// checkIntent checks for an operation on the chain interrupted by a
+// crash, which is recovered as when opening the replica if the metadata
+// isn't newer than this binary. The other checks are only run once it is
+// recovered.
+func (f *fsck) checkIntent() bool {
+	if _, ok := f.files[chainIntentFile]; !ok {
+		return true
+	}
+	if err := f.r.checkDirFormat(); err != nil {
+		f.issue(FsckIssue{Check: "format", Message: err.Error()}, nil)
+		return false
+	}
+	repaired := f.issue(FsckIssue{
+		Check:   "intent",
+		Message: "an operation on the chain was interrupted by a crash",
+		Warning: true,
+		Repair:  "finish or roll back the operation",
+	}, f.r.replayChainIntent)
+	if !repaired {
+		return false
+	}
+	return f.readDir() == nil
+}
+
+// checkFormat checks the version of the metadata, the other checks are
+// only run on metadata of the current version.
+func (f *fsck) checkFormat() bool {
+	version, err := f.r.readMetadataFormat()
+	if err != nil {
+		f.issue(FsckIssue{
+			Check:   "volume.meta",
+			Message: fmt.Sprintf("failed to read %s: %v", volumeMetaData, err),
+		}, nil)
+		return false
+	}
+	if err := checkMetadataFormat(version); err != nil {
+		f.issue(FsckIssue{Check: "format", Message: err.Error()}, nil)
+		return false
+	}
+	if version < MetadataFormatVersion {
+		upgrade := func() error {
+			_, err := f.r.upgradeMetadata()
+			return err
+		}
+		if !f.issue(FsckIssue{
+			Check:   "format",
+			Message: fmt.Sprintf("metadata format version %d is older than %d", version, MetadataFormatVersion),
+			Warning: true,
+			Repair:  "upgrade the metadata",
+		}, upgrade) {
+			return false
+		}
+	}
+	if err := f.r.unmarshalFile(volumeMetaData, &f.info); err != nil {
+		f.issue(FsckIssue{
+			Check:   "volume.meta",
+			Message: fmt.Sprintf("failed to read %s: %v", volumeMetaData, err),
+		}, nil)
+		return false
+	}
+	return true
+}
+
+// readDisks reads the metadata of the disks
+func (f *fsck) readDisks() {
+	f.disks = map[string]*disk{}
+	f.unreadable = map[string]bool{}
+	for _, name := range f.fileNames() {
+		if !strings.HasSuffix(name, metadataSuffix) {
+			continue
+		}
+		diskName := strings.TrimSuffix(name, metadataSuffix)
+		if !isDiskFile(diskName) {
+			continue
+		}
+		var d disk
+		if err := f.r.unmarshalFile(name, &d); err != nil {
+			f.issue(FsckIssue{
+				Check:   "files",
+				Message: fmt.Sprintf("failed to read %s: %v", name, err),
+			}, nil)
+			f.unreadable[diskName] = true
+			continue
+		}
+		d.Name = diskName
+		f.disks[diskName] = &d
+	}
+}
+
+func (f *fsck) writeInfo() error {
+	return f.r.encodeToFile(&f.info, volumeMetaData)
+}
+
+// checkHead checks that volume.meta agrees with the metadata of the head,
+// the chain is only checked once the head is known.
+func (f *fsck) checkHead() bool {
+	if _, ok := f.disks[f.info.Head]; !ok {
+		var heads []string
+		for name := range f.disks {
+			if IsHeadDisk(name) {
+				heads = append(heads, name)
+			}
+		}
+		issue := FsckIssue{
+			Check:   "head",
+			Message: fmt.Sprintf("head %q of %s has no metadata", f.info.Head, volumeMetaData),
+		}
+		var repair func() error
+		if len(heads) == 1 {
+			issue.Repair = fmt.Sprintf("set the head to %s, the only head found", heads[0])
+			repair = func() error {
+				f.info.Head = heads[0]
+				return f.writeInfo()
+			}
+		}
+		if !f.issue(issue, repair) {
+			return false
+		}
+	}
+	f.report.Head = f.info.Head
+
+	head := f.disks[f.info.Head]
+	if f.info.Parent != head.Parent {
+		f.issue(FsckIssue{
+			Check: "head",
+			Message: fmt.Sprintf("parent %q of %s differs from the parent %q of the head %s",
+				f.info.Parent, volumeMetaData, head.Parent, head.Name),
+			Repair: fmt.Sprintf("set the parent in %s to %q", volumeMetaData, head.Parent),
+		}, func() error {
+			f.info.Parent = head.Parent
+			return f.writeInfo()
+		})
+	}
+	if f.info.Size <= 0 || f.info.SectorSize <= 0 || f.info.Size%f.info.SectorSize != 0 {
+		f.issue(FsckIssue{
+			Check:   "head",
+			Message: fmt.Sprintf("invalid size %d with sector size %d", f.info.Size, f.info.SectorSize),
+		}, nil)
+	}
+	if f.info.Rebuilding {
+		f.issue(FsckIssue{
+			Check:   "head",
+			Message: "the replica was rebuilding, its data is incomplete until rebuilt again",
+			Warning: true,
+		}, nil)
+	}
+	return true
+}
+
+// checkChain walks the live chain from the head to the base snapshot
+func (f *fsck) checkChain() {
+	visited := map[string]bool{}
+	for cur := f.report.Head; cur != ""; {
+		if visited[cur] {
+			f.issue(FsckIssue{
+				Check:   "chain",
+				Message: fmt.Sprintf("the chain loops back to %s", cur),
+			}, nil)
+			return
+		}
+		visited[cur] = true
+		d, ok := f.disks[cur]
+		if !ok {
+			if !f.unreadable[cur] {
+				f.issue(FsckIssue{
+					Check:   "chain",
+					Message: fmt.Sprintf("%s of the chain has no metadata", cur),
+				}, nil)
+			}
+			return
+		}
+		if _, ok := f.files[cur]; !ok {
+			f.issue(FsckIssue{
+				Check:   "chain",
+				Message: fmt.Sprintf("%s of the chain has no image", cur),
+			}, nil)
+		}
+		f.report.Chain = append(f.report.Chain, cur)
+		cur = d.Parent
+	}
+	f.chainComplete = true
+	if len(f.report.Chain) > maximumChainLength {
+		f.issue(FsckIssue{
+			Check:   "chain",
+			Message: fmt.Sprintf("the chain is too long: %d", len(f.report.Chain)),
+		}, nil)
+	}
+}
+
+// rmDisk removes the files of a disk out of the live chain
+func (f *fsck) rmDisk(name string) func() error {
+	return func() error {
+		if err := f.r.rmDisk(name); err != nil {
+			return err
+		}
+		delete(f.disks, name)
+		for _, file := range []string{name, name + metadataSuffix, name + checksumSuffix} {
+			delete(f.files, file)
+		}
+		return nil
+	}
+}
+
+// checkChildren checks the disks out of the live chain, which are the
+// stale children of the disks of the chain as seen by diskChildrenMap.
+// The heads out of the chain are left by a crash and removed, while the
+// snapshots are kept as they can still be reverted to.
+func (f *fsck) checkChildren() {
+	inChain := map[string]bool{}
+	for _, name := range f.report.Chain {
+		inChain[name] = true
+	}
+	var names []string
+	for name := range f.disks {
+		if !inChain[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		d := f.disks[name]
+		msg := fmt.Sprintf("%s isn't in the live chain", name)
+		if inChain[d.Parent] {
+			msg = fmt.Sprintf("%s is a child of %s out of the live chain", name, d.Parent)
+		}
+		if _, ok := f.files[name]; !ok {
+			f.issue(FsckIssue{
+				Check:   "children",
+				Message: msg + ", without image",
+				Repair:  "remove its metadata",
+			}, f.rmDisk(name))
+			continue
+		}
+		if IsHeadDisk(name) {
+			f.issue(FsckIssue{
+				Check:   "children",
+				Message: msg + ", left by an interrupted snapshot or revert",
+				Repair:  "remove it",
+			}, f.rmDisk(name))
+			continue
+		}
+		f.issue(FsckIssue{
+			Check:   "children",
+			Message: msg,
+			Warning: true,
+		}, nil)
+	}
+}
+
+// checkFiles checks for the files which don't belong to any disk
+func (f *fsck) checkFiles() {
+	for _, name := range f.fileNames() {
+		remove := func() error {
+			if err := os.Remove(f.r.diskPath(name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			delete(f.files, name)
+			return f.r.syncDir()
+		}
+		switch {
+		case strings.HasSuffix(name, ".tmp"):
+			f.issue(FsckIssue{
+				Check:   "files",
+				Message: fmt.Sprintf("%s is a leftover temporary file", name),
+				Warning: true,
+				Repair:  "remove it",
+			}, remove)
+		case isDiskFile(name):
+			// the image of a disk of the chain is only missing its
+			// metadata if the chain is broken
+			if _, ok := f.disks[name]; !ok && f.chainComplete && !f.unreadable[name] {
+				f.issue(FsckIssue{
+					Check:   "files",
+					Message: fmt.Sprintf("%s has no metadata", name),
+					Repair:  "remove it",
+				}, remove)
+			}
+		case strings.HasSuffix(name, checksumSuffix):
+			if _, ok := f.files[strings.TrimSuffix(name, checksumSuffix)]; !ok {
+				f.issue(FsckIssue{
+					Check:   "files",
+					Message: fmt.Sprintf("%s has no image", name),
+					Warning: true,
+					Repair:  "remove it",
+				}, remove)
+			}
+		}
+	}
+}
+
+// checkRevisionCounter checks that the revision counter isn't behind the
+// ones recorded in the disks of the chain, and that these only grow from
+// the base to the head.
+func (f *fsck) checkRevisionCounter() {
+	latest := f.info.RevisionCounter
+	for i, name := range f.report.Chain {
+		d := f.disks[name]
+		if d.RevisionCounter > latest {
+			latest = d.RevisionCounter
+		}
+		if i > 0 && d.RevisionCounter > f.disks[f.report.Chain[i-1]].RevisionCounter {
+			f.issue(FsckIssue{
+				Check: "revision",
+				Message: fmt.Sprintf("revision counter %d of %s is greater than %d of its child %s",
+					d.RevisionCounter, name, f.disks[f.report.Chain[i-1]].RevisionCounter, f.report.Chain[i-1]),
+				Warning: true,
+			}, nil)
+		}
+	}
+
+	write := func(isCreate bool) func() error {
+		return func() error {
+			if err := f.r.openRevisionFile(isCreate); err != nil {
+				return err
+			}
+			defer f.r.revisionFile.Close()
+			if err := f.r.writeRevisionCounter(latest); err != nil {
+				return err
+			}
+			return f.r.syncDir()
+		}
+	}
+	if _, ok := f.files[revisionCounterFile]; !ok {
+		f.issue(FsckIssue{
+			Check:   "revision",
+			Message: fmt.Sprintf("%s not found", revisionCounterFile),
+			Repair:  fmt.Sprintf("create it with %d, the greatest revision counter of the chain", latest),
+		}, write(true))
+		return
+	}
+	if err := f.r.openRevisionFile(false); err != nil {
+		f.issue(FsckIssue{
+			Check:   "revision",
+			Message: fmt.Sprintf("failed to open %s: %v", revisionCounterFile, err),
+		}, nil)
+		return
+	}
+	counter, err := f.r.readRevisionCounter()
+	f.r.revisionFile.Close()
+	switch {
+	case err != nil:
+		f.issue(FsckIssue{
+			Check:   "revision",
+			Message: err.Error(),
+			Repair:  fmt.Sprintf("set it to %d, the greatest revision counter of the chain", latest),
+		}, write(false))
+	case counter < latest:
+		f.issue(FsckIssue{
+			Check:   "revision",
+			Message: fmt.Sprintf("revision counter %d is behind %d recorded in the chain", counter, latest),
+			Repair:  fmt.Sprintf("set it to %d", latest),
+		}, write(false))
+	}
+}
+
+// checkSizes checks the size of the images of the chain against the size
+// of the volume. The images shorter than the volume, left by an
+// interrupted resize, are extended as their missing sectors read as 0
+// anyway.
+func (f *fsck) checkSizes() {
+	for _, name := range f.report.Chain {
+		file, ok := f.files[name]
+		if !ok {
+			continue
+		}
+		size := file.Size()
+		switch {
+		case size < f.info.Size:
+			path := f.r.diskPath(name)
+			f.issue(FsckIssue{
+				Check:   "size",
+				Message: fmt.Sprintf("%s is %d bytes, smaller than the volume size %d", name, size, f.info.Size),
+				Repair:  fmt.Sprintf("extend it to %d bytes", f.info.Size),
+			}, func() error {
+				return syscall.Truncate(path, f.info.Size)
+			})
+		case size > f.info.Size:
+			f.issue(FsckIssue{
+				Check:   "size",
+				Message: fmt.Sprintf("%s is %d bytes, larger than the volume size %d, resize the volume", name, size, f.info.Size),
+			}, nil)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+)
+
+// fsckChecks returns the checks of the issues of the report, and whether
+// they were repaired
+func fsckChecks(report *FsckReport) map[string]bool {
+	checks := map[string]bool{}
+	for _, issue := range report.Issues {
+		checks[issue.Check+": "+issue.Message] = issue.Repaired
+	}
+	return checks
+}
+
+func (s *TestSuite) TestFsck(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.SetReplicaMode("RW"), IsNil)
+	buf := make([]byte, b)
+	for i, name := range []string{"000", "001", "002"} {
+		fill(buf, byte(i+1))
+		_, err = r.WriteAt(buf, int64(i)*b)
+		c.Assert(err, IsNil)
+		c.Assert(r.Snapshot(name, true, getNow()), IsNil)
+	}
+	chain, err := r.Chain()
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	report, err := Fsck(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Chain, DeepEquals, chain)
+	c.Assert(report.Issues, HasLen, 0)
+
+	// damage the replica as left by interrupted operations
+	info, err := ReadInfo(dir)
+	c.Assert(err, IsNil)
+	info.Parent = "volume-snap-000.img"
+	c.Assert((&Replica{dir: dir}).encodeToFile(&info, volumeMetaData), IsNil)
+	c.Assert(os.Remove(path.Join(dir, revisionCounterFile)), IsNil)
+	for _, name := range []string{"volume-head-009.img", "volume-head-009.img.meta.tmp", "volume-snap-gone.img.checksum"} {
+		c.Assert(ioutil.WriteFile(path.Join(dir, name), nil, 0600), IsNil)
+	}
+	c.Assert(syscall.Truncate(path.Join(dir, chain[3]), 2*b), IsNil)
+
+	issues := map[string]bool{
+		"head: parent \"volume-snap-000.img\" of volume.meta differs from the parent \"volume-snap-002.img\" of the head volume-head-003.img": false,
+		"files: volume-head-009.img has no metadata":                                  false,
+		"files: volume-head-009.img.meta.tmp is a leftover temporary file":            false,
+		"files: volume-snap-gone.img.checksum has no image":                           false,
+		"revision: revision.counter not found":                                        false,
+		"size: volume-snap-000.img is 8192 bytes, smaller than the volume size 16384": false,
+	}
+	// the dry run doesn't modify the replica
+	report, err = Fsck(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(fsckChecks(report), DeepEquals, issues)
+	report, err = Fsck(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(fsckChecks(report), DeepEquals, issues)
+
+	report, err = Fsck(dir, true)
+	c.Assert(err, IsNil)
+	for issue := range issues {
+		issues[issue] = true
+	}
+	c.Assert(fsckChecks(report), DeepEquals, issues)
+	c.Assert(report.Errors(), Equals, 0)
+
+	report, err = Fsck(dir, false)
+	c.Assert(err, IsNil)
+	c.Assert(report.Issues, HasLen, 0)
+	r, err = New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.Info().Parent, Equals, chain[1])
+	data := make([]byte, 3*b)
+	_, err = r.ReadAt(data, 0)
+	c.Assert(err, IsNil)
+	for i := range []string{"000", "001", "002"} {
+		fill(buf, byte(i+1))
+		c.Assert(data[int64(i)*b:int64(i+1)*b], DeepEquals, buf)
+	}
+	c.Assert(r.Close(), IsNil)
+
+	// a broken chain can't be repaired, and nothing is removed from it
+	c.Assert(os.Remove(path.Join(dir, chain[2]+metadataSuffix)), IsNil)
+	report, err = Fsck(dir, true)
+	c.Assert(err, IsNil)
+	c.Assert(fsckChecks(report), DeepEquals, map[string]bool{
+		"chain: volume-snap-001.img of the chain has no metadata": false,
+	})
+	c.Assert(report.Errors(), Equals, 1)
+	_, err = os.Stat(path.Join(dir, chain[2]))
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,212 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// chainIntentFile records the operation on the chain in progress, it is
+// replayed when the replica is opened after a crash in the middle of it.
+const chainIntentFile = "volume.intent"
+
+type chainOp string
+
+const (
+	// intentNewHead creates a new head, and a snapshot of the old one
+	// unless reverting. volume.meta pointing to the new head commits it.
+	intentNewHead = chainOp("newhead")
+	// intentRemoveDisk removes a snapshot from the chain. The metadata of
+	// its child not pointing to it anymore commits it.
+	intentRemoveDisk = chainOp("removedisk")
+	// intentReplaceDisk replaces the data of a snapshot with the one of
+	// its child, removed from the chain. It is always completed as the
+	// previous data of the snapshot may already be gone.
+	intentReplaceDisk = chainOp("replacedisk")
+)
+
+// chainIntent is an operation on the chain, only the files it names are
+// updated by it.
+type chainIntent struct {
+	Op chainOp `json:"op"`
+	// Head is the head replaced by NewHead
+	Head    string `json:"head,omitempty"`
+	NewHead string `json:"newHead,omitempty"`
+	// Disk is the snapshot created from Head, or the one removed
+	Disk string `json:"disk,omitempty"`
+	// Target is the snapshot getting the data of Disk when replacing it
+	Target string `json:"target,omitempty"`
+	// Child is the child of the disk removed, whose parent becomes
+	// Parent, the parent of the disk removed. Parent gets the revision
+	// counter of the disk removed.
+	Child           string `json:"child,omitempty"`
+	Parent          string `json:"parent,omitempty"`
+	RevisionCounter int64  `json:"revisionCounter,omitempty"`
+}
+
+// writeChainIntent records the operation before any file of the chain is
+// updated by it.
+func (r *Replica) writeChainIntent(intent chainIntent) error {
+	if err := r.encodeToFile(&intent, chainIntentFile); err != nil {
+		return fmt.Errorf("Failed to record %s of the chain, error: %v", intent.Op, err)
+	}
+	return nil
+}
+
+// clearChainIntent removes the operation once it completed. It is left
+// in place if the operation failed, so that the next open finishes or
+// rolls it back.
+func (r *Replica) clearChainIntent() error {
+	if err := os.Remove(r.diskPath(chainIntentFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.syncDir()
+}
+
+// removeIntent returns the intent of removing the disk from the chain, it
+// fails as removeDiskNode if the disk can't be removed.
+func (r *Replica) removeIntent(op chainOp, name string) (chainIntent, error) {
+	intent := chainIntent{Op: op, Disk: name}
+	d, exists := r.diskData[name]
+	if !exists {
+		return intent, nil
+	}
+	children := r.diskChildrenMap[name]
+	if len(children) > 1 {
+		return intent, fmt.Errorf("Cannot remove snapshot %v with %v children",
+			name, len(children))
+	}
+	for intent.Child = range children {
+	}
+	intent.Parent = d.Parent
+	intent.RevisionCounter = d.RevisionCounter
+	return intent, nil
+}
+
+// replayChainIntent finishes or rolls back the operation on the chain
+// interrupted by a crash, it is called before reading the metadata of the
+// chain.
+func (r *Replica) replayChainIntent() error {
+	var intent chainIntent
+	if err := r.unmarshalFile(chainIntentFile, &intent); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Failed to read %s, error: %v", chainIntentFile, err)
+	}
+
+	logrus.Warningf("Recovering %s of the chain interrupted by a crash: %+v", intent.Op, intent)
+	var err error
+	switch intent.Op {
+	case intentNewHead:
+		err = r.replayNewHead(intent)
+	case intentRemoveDisk:
+		err = r.replayRemoveDisk(intent)
+	case intentReplaceDisk:
+		err = r.replayReplaceDisk(intent)
+	default:
+		err = fmt.Errorf("unknown operation %q", intent.Op)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to recover %s of the chain, error: %v", intent.Op, err)
+	}
+	return r.clearChainIntent()
+}
+
+func (r *Replica) replayNewHead(intent chainIntent) error {
+	var info Info
+	if err := r.unmarshalFile(volumeMetaData, &info); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info.Head == intent.NewHead {
+		// committed, the old head was left behind, its data is in
+		// the snapshot linked to it
+		logrus.Infof("Completing creation of head %s", intent.NewHead)
+		return r.rmDisk(intent.Head)
+	}
+	logrus.Infof("Rolling back creation of head %s", intent.NewHead)
+	if err := r.rmDisk(intent.NewHead); err != nil {
+		return err
+	}
+	return r.rmDisk(intent.Disk)
+}
+
+func (r *Replica) replayRemoveDisk(intent chainIntent) error {
+	if intent.Child != "" {
+		var child disk
+		if err := r.unmarshalFile(intent.Child+metadataSuffix, &child); err != nil {
+			return err
+		}
+		if child.Parent == intent.Disk {
+			// nothing was updated yet
+			logrus.Infof("Rolling back removal of %s", intent.Disk)
+			return nil
+		}
+	}
+	logrus.Infof("Completing removal of %s", intent.Disk)
+	if err := r.replayRevisionCounter(intent); err != nil {
+		return err
+	}
+	return r.rmDisk(intent.Disk)
+}
+
+func (r *Replica) replayReplaceDisk(intent chainIntent) error {
+	logrus.Infof("Completing replacement of %s with %s", intent.Target, intent.Disk)
+	// the disk is removed last, the target may not have its data yet if
+	// it is still there
+	if _, err := os.Stat(r.diskPath(intent.Disk)); err == nil {
+		if err := r.hardlinkDisk(intent.Target, intent.Disk); err != nil {
+			return err
+		}
+	}
+	if intent.Child != "" {
+		var child disk
+		if err := r.unmarshalFile(intent.Child+metadataSuffix, &child); err != nil {
+			return err
+		}
+		if child.Parent == intent.Disk {
+			child.Parent = intent.Parent
+			if err := r.encodeToFile(&child, intent.Child+metadataSuffix); err != nil {
+				return err
+			}
+		}
+	}
+	if err := r.replayRevisionCounter(intent); err != nil {
+		return err
+	}
+	return r.rmDisk(intent.Disk)
+}
+
+// replayRevisionCounter sets the revision counter of the parent of the
+// disk removed as removeDiskNode does.
+func (r *Replica) replayRevisionCounter(intent chainIntent) error {
+	if intent.Parent == "" {
+		return nil
+	}
+	var parent disk
+	if err := r.unmarshalFile(intent.Parent+metadataSuffix, &parent); err != nil {
+		return err
+	}
+	if parent.RevisionCounter == intent.RevisionCounter {
+		return nil
+	}
+	parent.RevisionCounter = intent.RevisionCounter
+	return r.encodeToFile(&parent, intent.Parent+metadataSuffix)
+}
@@ -0,0 +1,174 @@
+// +build debug
+
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// chainOps are the operations on the chain of newChainCrashReplica, they
+// return the replica to use afterwards.
+var chainOps = map[string]func(r *Replica) (*Replica, error){
+	"snapshot": func(r *Replica) (*Replica, error) {
+		return r, r.Snapshot("003", true, "now")
+	},
+	"revert": func(r *Replica) (*Replica, error) {
+		return r.Revert("volume-snap-001.img", "now")
+	},
+	"remove": func(r *Replica) (*Replica, error) {
+		return r, r.RemoveDiffDisk("volume-snap-000.img")
+	},
+	"replace": func(r *Replica) (*Replica, error) {
+		return r, r.ReplaceDisk("volume-snap-001.img", "volume-snap-000.img")
+	},
+}
+
+// newChainCrashReplica creates a replica with a block written in each
+// snapshot of its chain, and closes it.
+func newChainCrashReplica(c *C) string {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	r, err := New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.SetReplicaMode("RW"), IsNil)
+	buf := make([]byte, b)
+	for i, name := range []string{"000", "001", "002"} {
+		fill(buf, byte(i+1))
+		_, err = r.WriteAt(buf, int64(i)*b)
+		c.Assert(err, IsNil)
+		c.Assert(r.Snapshot(name, true, "now"), IsNil)
+	}
+	c.Assert(r.Close(), IsNil)
+	return dir
+}
+
+// chainCrashState is the state of a replica once reopened
+type chainCrashState struct {
+	chain []string
+	data  []byte
+	files []string
+}
+
+// openChainCrashReplica opens the replica and returns its state
+func openChainCrashReplica(c *C, dir string) chainCrashState {
+	r, err := New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	_, err = os.Stat(r.diskPath(chainIntentFile))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	state := chainCrashState{data: make([]byte, 4*b)}
+	state.chain, err = r.Chain()
+	c.Assert(err, IsNil)
+	_, err = r.ReadAt(state.data, 0)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	for _, f := range files {
+		// the checkpoint is only written if the previous open was clean
+		if f.Name() != lunMapCheckpointFile {
+			state.files = append(state.files, f.Name())
+		}
+	}
+	return state
+}
+
+// TestChainCrashHelper runs the operation on the chain given by the env in
+// a process of its own, so that it can crash at CRASH_POINT.
+func TestChainCrashHelper(t *testing.T) {
+	dir := os.Getenv("CRASH_REPLICA_DIR")
+	if dir == "" {
+		return
+	}
+	r, err := New(false, 4*b, b, dir, nil, "Backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetReplicaMode("RW"); err != nil {
+		t.Fatal(err)
+	}
+	r.holeDrainer = func() {}
+	if _, err := chainOps[os.Getenv("CRASH_CHAIN_OP")](r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *TestSuite) TestChainIntentReplay(c *C) {
+	tests := []struct {
+		op    string
+		point string
+		// completed is whether the operation is completed by the replay
+		// rather than rolled back
+		completed bool
+	}{
+		{"snapshot", "newhead-created", false},
+		{"snapshot", "newhead-linked", false},
+		{"snapshot", "newhead-snapshot-updated", false},
+		{"snapshot", "newhead-committed", true},
+		{"revert", "revert-committed", true},
+		{"remove", "removedisk-child-updated", true},
+		{"remove", "removedisk-node-removed", true},
+		{"replace", "hardlink-target-removed", true},
+		{"replace", "replacedisk-linked", true},
+		{"replace", "removedisk-child-updated", true},
+	}
+	for _, test := range tests {
+		comment := Commentf("%s crashed at %s", test.op, test.point)
+
+		// the state of the replica before and after the operation
+		dir := newChainCrashReplica(c)
+		before := openChainCrashReplica(c, dir)
+		r, err := New(false, 4*b, b, dir, nil, "Backend")
+		c.Assert(err, IsNil)
+		c.Assert(r.SetReplicaMode("RW"), IsNil)
+		r.holeDrainer = func() {}
+		r, err = chainOps[test.op](r)
+		c.Assert(err, IsNil, comment)
+		c.Assert(r.Close(), IsNil)
+		after := openChainCrashReplica(c, dir)
+		os.RemoveAll(dir)
+
+		dir = newChainCrashReplica(c)
+		cmd := exec.Command(os.Args[0], "-test.run=^TestChainCrashHelper$")
+		cmd.Env = append(os.Environ(), "CRASH_REPLICA_DIR="+dir,
+			"CRASH_CHAIN_OP="+test.op, "CRASH_POINT="+test.point)
+		err = cmd.Run()
+		exitErr, ok := err.(*exec.ExitError)
+		c.Assert(ok, Equals, true, comment)
+		c.Assert(exitErr.ExitCode(), Equals, 2, comment)
+		_, err = os.Stat(dir + "/" + chainIntentFile)
+		c.Assert(err, IsNil, comment)
+
+		// no file is left behind by the operation
+		state := openChainCrashReplica(c, dir)
+		expected := before
+		if test.completed {
+			expected = after
+		}
+		c.Assert(state.chain, DeepEquals, expected.chain, comment)
+		c.Assert(state.files, DeepEquals, expected.files, comment)
+		c.Assert(state.data, DeepEquals, expected.data, comment)
+		os.RemoveAll(dir)
+	}
+}
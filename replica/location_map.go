@@ -0,0 +1,212 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// locationPageShift is the log2 of the number of sectors in a page of
+	// the location map, 256MiB of 4KiB sectors.
+	locationPageShift = 16
+	locationPageSize  = 1 << locationPageShift
+)
+
+// locationRun is a run of sectors of a page starting at start, whose
+// location is the file at index. It ends where the next run starts.
+type locationRun struct {
+	start uint16
+	index uint32
+}
+
+// locationPage holds the runs of a page, sorted by start. The first run
+// starts at 0 and two adjacent runs never have the same index. A page
+// without runs has all its sectors at index 0.
+type locationPage struct {
+	sync.RWMutex
+	runs []locationRun
+}
+
+// locationMap maps the sectors of the volume to the index of the file of
+// the chain holding them, 0 if the location isn't known yet. The sectors
+// are stored as runs of consecutive sectors in the same file, so the
+// memory used depends on the fragmentation of the volume rather than on
+// its size. The sectors are split in pages, each locked on its own so
+// that the I/Os to the different pages don't contend.
+type locationMap struct {
+	pages   []*locationPage
+	sectors int64
+}
+
+func newLocationMap(sectors int64) *locationMap {
+	m := &locationMap{}
+	m.grow(sectors)
+	return m
+}
+
+// len returns the number of sectors of the map
+func (m *locationMap) len() int64 {
+	return m.sectors
+}
+
+// grow extends the map up to sectors, the new sectors are at index 0
+func (m *locationMap) grow(sectors int64) {
+	for int64(len(m.pages))<<locationPageShift < sectors {
+		m.pages = append(m.pages, &locationPage{})
+	}
+	if sectors > m.sectors {
+		m.sectors = sectors
+	}
+}
+
+// get returns the index of the sector
+func (m *locationMap) get(sector int64) uint32 {
+	index, _ := m.extent(sector)
+	return index
+}
+
+// extent returns the index of the sector, and the end of the sectors
+// following it at the same index. The run may go on in the next page.
+func (m *locationMap) extent(sector int64) (uint32, int64) {
+	p := m.pages[sector>>locationPageShift]
+	base := sector &^ (locationPageSize - 1)
+	end := base + locationPageSize
+	off := int(sector - base)
+
+	var index uint32
+	p.RLock()
+	if len(p.runs) > 0 {
+		i := sort.Search(len(p.runs), func(i int) bool { return int(p.runs[i].start) > off })
+		index = p.runs[i-1].index
+		if i < len(p.runs) {
+			end = base + int64(p.runs[i].start)
+		}
+	}
+	p.RUnlock()
+	if end > m.sectors {
+		end = m.sectors
+	}
+	return index, end
+}
+
+// set sets the index of count sectors from sector
+func (m *locationMap) set(sector, count int64, index uint32) {
+	for count > 0 {
+		p := m.pages[sector>>locationPageShift]
+		start := int(sector & (locationPageSize - 1))
+		n := int64(locationPageSize - start)
+		if n > count {
+			n = count
+		}
+		p.Lock()
+		p.set(start, start+int(n), index)
+		p.Unlock()
+		sector += n
+		count -= n
+	}
+}
+
+// set sets the index of the sectors from start to end of the page
+func (p *locationPage) set(start, end int, index uint32) {
+	if len(p.runs) == 0 {
+		if index == 0 {
+			return
+		}
+		p.runs = []locationRun{{start: 0, index: 0}}
+	}
+	runs := p.runs
+	// the runs starting in [start, end) are replaced by the new one,
+	// followed by the rest of the run it overlaps if any
+	lo := sort.Search(len(runs), func(i int) bool { return int(runs[i].start) >= start })
+	hi := sort.Search(len(runs), func(i int) bool { return int(runs[i].start) >= end })
+	repl := []locationRun{{start: uint16(start), index: index}}
+	if end < locationPageSize && (hi == len(runs) || int(runs[hi].start) != end) {
+		repl = append(repl, locationRun{start: uint16(end), index: runs[hi-1].index})
+	}
+
+	size := lo + len(repl) + len(runs) - hi
+	if size > len(runs) {
+		runs = append(runs, make([]locationRun, size-len(runs))...)
+	}
+	copy(runs[lo+len(repl):], runs[hi:len(p.runs)])
+	copy(runs[lo:], repl)
+	runs = runs[:size]
+
+	// merge the runs around the new ones with the same index
+	first := lo
+	if first == 0 {
+		first = 1
+	}
+	for i := lo + len(repl); i >= first; i-- {
+		if i < len(runs) && runs[i].index == runs[i-1].index {
+			runs = append(runs[:i], runs[i+1:]...)
+		}
+	}
+	if len(runs) == 1 && runs[0].index == 0 {
+		runs = nil
+	}
+	p.runs = runs
+}
+
+// removeIndex moves the sectors of the files at index and above to the
+// previous file, when the file at index is removed from the chain.
+func (m *locationMap) removeIndex(index uint32) {
+	for _, p := range m.pages {
+		p.Lock()
+		runs := p.runs[:0]
+		for _, r := range p.runs {
+			if r.index >= index {
+				r.index--
+			}
+			if len(runs) > 0 && runs[len(runs)-1].index == r.index {
+				continue
+			}
+			runs = append(runs, r)
+		}
+		if len(runs) == 1 && runs[0].index == 0 {
+			runs = nil
+		}
+		// release the memory of the runs merged
+		if cap(runs) > 2*len(runs) {
+			runs = append([]locationRun(nil), runs...)
+		}
+		p.runs = runs
+		p.Unlock()
+	}
+}
+
+// clear sets all the sectors at index 0
+func (m *locationMap) clear() {
+	for _, p := range m.pages {
+		p.Lock()
+		p.runs = nil
+		p.Unlock()
+	}
+}
+
+// runs returns the number of runs of the map
+func (m *locationMap) runs() int {
+	n := 0
+	for _, p := range m.pages {
+		p.RLock()
+		n += len(p.runs)
+		p.RUnlock()
+	}
+	return n
+}
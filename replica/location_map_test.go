@@ -0,0 +1,177 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+
+	. "gopkg.in/check.v1"
+)
+
+// locationValues returns the index of each sector of the map
+func locationValues(m *locationMap) []uint32 {
+	values := make([]uint32, 0, m.len())
+	for sector := int64(0); sector < m.len(); {
+		index, next := m.extent(sector)
+		for ; sector < next; sector++ {
+			values = append(values, index)
+		}
+	}
+	return values
+}
+
+func (s *TestSuite) TestLocationMap(c *C) {
+	sectors := int64(2*locationPageSize + 100)
+	m := newLocationMap(sectors)
+	expected := make([]uint32, sectors)
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		switch op := rnd.Intn(100); {
+		case op < 90:
+			sector := rnd.Int63n(sectors)
+			count := rnd.Int63n(sectors-sector) + 1
+			if rnd.Intn(2) == 0 {
+				// most writes are small, and fragment the map
+				count = count%16 + 1
+				if sector+count > sectors {
+					count = sectors - sector
+				}
+			}
+			index := uint32(rnd.Intn(6))
+			m.set(sector, count, index)
+			for j := sector; j < sector+count; j++ {
+				expected[j] = index
+			}
+		case op < 99:
+			index := uint32(rnd.Intn(5) + 1)
+			m.removeIndex(index)
+			for j := range expected {
+				if expected[j] >= index {
+					expected[j]--
+				}
+			}
+		default:
+			m.clear()
+			expected = make([]uint32, sectors)
+		}
+	}
+	c.Assert(locationValues(m), DeepEquals, expected)
+
+	// the adjacent runs always have a different index
+	runs := 0
+	for j := range expected {
+		if j%locationPageSize == 0 || expected[j] != expected[j-1] {
+			runs++
+		}
+	}
+	for page := int64(0); page*locationPageSize < sectors; page++ {
+		if m.pages[page].runs == nil {
+			runs--
+		}
+	}
+	c.Assert(m.runs(), Equals, runs)
+
+	m.grow(sectors + 10)
+	c.Assert(m.len(), Equals, sectors+10)
+	c.Assert(m.get(sectors+5), Equals, uint32(0))
+	index, next := m.extent(sectors - 1)
+	c.Assert(index, Equals, expected[sectors-1])
+	c.Assert(next <= sectors+10, Equals, true)
+}
+
+func (s *TestSuite) TestLocationMapManyFiles(c *C) {
+	m := newLocationMap(16)
+	// a chain can have more files than an uint16 index
+	m.set(0, 8, 70000)
+	m.set(4, 4, 65536)
+	c.Assert(locationValues(m)[:9], DeepEquals, []uint32{70000, 70000, 70000, 70000, 65536, 65536, 65536, 65536, 0})
+	m.removeIndex(65536)
+	c.Assert(m.get(0), Equals, uint32(69999))
+	c.Assert(m.get(4), Equals, uint32(65535))
+}
+
+// fragmentedChain creates a replica of blocks blocks in dir, whose block i
+// is written in the snapshot i%snapshots, and again in the next one for
+// every third block, so that the LUN map has about a run per block. It
+// returns the data of the volume.
+func fragmentedChain(c *C, dir string, blocks, snapshots int) []byte {
+	r, err := New(false, int64(blocks)*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.SetReplicaMode("RW"), IsNil)
+	data := make([]byte, blocks*b)
+	for s := 0; s < snapshots; s++ {
+		for i := 0; i < blocks; i++ {
+			if i%snapshots != s && !(i%3 == 0 && (i+1)%snapshots == s) {
+				continue
+			}
+			buf := data[i*b : (i+1)*b]
+			fill(buf, byte(s*blocks+i))
+			_, err := r.WriteAt(buf, int64(i)*b)
+			c.Assert(err, IsNil)
+		}
+		c.Assert(r.Snapshot(fmt.Sprintf("%03d", s), true, getNow()), IsNil)
+	}
+	c.Assert(r.Close(), IsNil)
+	return data
+}
+
+func (s *TestSuite) TestLookupFragmented(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	data := fragmentedChain(c, dir, 64, 4)
+
+	r, err := New(true, 64*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	preloaded := locationValues(r.volume.location)
+	c.Assert(r.Close(), IsNil)
+
+	// without the LUN map, the reads look the sectors up in the files
+	c.Assert(os.Remove(path.Join(dir, lunMapCheckpointFile)), IsNil)
+	r, err = New(false, 64*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	c.Assert(r.volume.location.runs(), Equals, 0)
+	buf := make([]byte, len(data))
+	_, err = r.ReadAt(buf[:5*b], 0)
+	c.Assert(err, IsNil)
+	_, err = r.ReadAt(buf[5*b:], 5*b)
+	c.Assert(err, IsNil)
+	c.Assert(buf, DeepEquals, data)
+	c.Assert(locationValues(r.volume.location), DeepEquals, preloaded)
+}
+
+func (s *TestSuite) BenchmarkPreloadFragmented(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	fragmentedChain(c, dir, 4096, 8)
+
+	r, err := New(false, 4096*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		r.volume.location.clear()
+		c.Assert(preload(&r.volume), IsNil)
+	}
+}
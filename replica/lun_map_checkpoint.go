@@ -0,0 +1,236 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// lunMapCheckpointFile holds the LUN map of the replica written on a
+	// clean close, so that the next open doesn't have to preload it.
+	lunMapCheckpointFile    = "volume.lunmap"
+	lunMapCheckpointMagic   = "JIVALMAP"
+	lunMapCheckpointVersion = uint32(2)
+)
+
+// lunMapCheckpointHeader is the header of the checkpoint, followed by the
+// names of the files of the chain, by the runs of the map and by the
+// CRC32C of all of them.
+type lunMapCheckpointHeader struct {
+	Magic             [8]byte
+	Version           uint32
+	Sectors           int64
+	RevisionCounter   int64
+	UsedBlocks        int64
+	UsedLogicalBlocks int64
+	Files             uint32
+	Runs              uint64
+}
+
+// lunMapCheckpointRun is a run of sectors at the same index, the runs at
+// index 0 aren't written.
+type lunMapCheckpointRun struct {
+	Start int64
+	Count int64
+	Index uint32
+}
+
+// chainNames returns the names of the files of r.volume.files, the first
+// one being empty.
+func (r *Replica) chainNames() []string {
+	names := make([]string, len(r.activeDiskData))
+	for i, d := range r.activeDiskData {
+		if d != nil {
+			names[i] = d.Name
+		}
+	}
+	return names
+}
+
+// writeLunMapCheckpoint saves the LUN map to the checkpoint, it is called
+// on a clean close once the I/Os are stopped.
+func (r *Replica) writeLunMapCheckpoint() error {
+	location := r.volume.location
+	var runs []lunMapCheckpointRun
+	for sector := int64(0); sector < location.len(); {
+		index, next := location.extent(sector)
+		if index != 0 {
+			runs = append(runs, lunMapCheckpointRun{Start: sector, Count: next - sector, Index: index})
+		}
+		sector = next
+	}
+	names := r.chainNames()
+	header := lunMapCheckpointHeader{
+		Version:           lunMapCheckpointVersion,
+		Sectors:           location.len(),
+		RevisionCounter:   r.revisionCache,
+		UsedBlocks:        r.volume.UsedBlocks,
+		UsedLogicalBlocks: r.volume.UsedLogicalBlocks,
+		Files:             uint32(len(names)),
+		Runs:              uint64(len(runs)),
+	}
+	copy(header.Magic[:], lunMapCheckpointMagic)
+
+	tmp := r.diskPath(lunMapCheckpointFile + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	hash := crc32.New(crc32cTable)
+	w := bufio.NewWriter(io.MultiWriter(f, hash))
+	err = binary.Write(w, binary.LittleEndian, &header)
+	for _, name := range names {
+		if err == nil {
+			err = binary.Write(w, binary.LittleEndian, uint16(len(name)))
+		}
+		if err == nil {
+			_, err = w.WriteString(name)
+		}
+	}
+	for i := range runs {
+		if err == nil {
+			err = binary.Write(w, binary.LittleEndian, &runs[i])
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = binary.Write(f, binary.LittleEndian, hash.Sum32())
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, r.diskPath(lunMapCheckpointFile)); err != nil {
+		return err
+	}
+	logrus.Infof("Saved LUN map checkpoint with %d runs", location.runs())
+	return r.syncDir()
+}
+
+// loadLunMapCheckpoint loads the LUN map from the checkpoint if it is
+// valid for the chain opened, and returns whether it did. The checkpoint
+// is removed as the map changes once the replica is open, it is only
+// written again by a clean close.
+func (r *Replica) loadLunMapCheckpoint(dirty bool) (bool, error) {
+	path := r.diskPath(lunMapCheckpointFile)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return false, err
+	}
+	if err := r.syncDir(); err != nil {
+		return false, err
+	}
+
+	if dirty {
+		logrus.Warningf("Ignoring LUN map checkpoint, the replica wasn't closed cleanly")
+		return false, nil
+	}
+	if err := r.decodeLunMapCheckpoint(data); err != nil {
+		logrus.Warningf("Ignoring LUN map checkpoint, error: %v", err)
+		r.volume.location.clear()
+		return false, nil
+	}
+	logrus.Infof("Loaded LUN map checkpoint with %d runs", r.volume.location.runs())
+	return true, nil
+}
+
+func (r *Replica) decodeLunMapCheckpoint(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("checkpoint truncated")
+	}
+	sum := binary.LittleEndian.Uint32(data[len(data)-4:])
+	data = data[:len(data)-4]
+	if crc32.Checksum(data, crc32cTable) != sum {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	buf := bytes.NewReader(data)
+	var header lunMapCheckpointHeader
+	if err := binary.Read(buf, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	switch {
+	case string(header.Magic[:]) != lunMapCheckpointMagic:
+		return fmt.Errorf("invalid magic %q", header.Magic[:])
+	case header.Version != lunMapCheckpointVersion:
+		return fmt.Errorf("unsupported version %d", header.Version)
+	case header.Sectors != r.volume.location.len():
+		return fmt.Errorf("%d sectors, expected %d", header.Sectors, r.volume.location.len())
+	case header.RevisionCounter != r.revisionCache:
+		return fmt.Errorf("revision counter %d, expected %d", header.RevisionCounter, r.revisionCache)
+	}
+
+	names := r.chainNames()
+	if int(header.Files) != len(names) {
+		return fmt.Errorf("%d files in the chain, expected %d", header.Files, len(names))
+	}
+	for _, expected := range names {
+		var length uint16
+		if err := binary.Read(buf, binary.LittleEndian, &length); err != nil {
+			return err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(buf, name); err != nil {
+			return err
+		}
+		if string(name) != expected {
+			return fmt.Errorf("file %s in the chain, expected %s", name, expected)
+		}
+	}
+
+	for i := uint64(0); i < header.Runs; i++ {
+		var run lunMapCheckpointRun
+		if err := binary.Read(buf, binary.LittleEndian, &run); err != nil {
+			return err
+		}
+		if run.Start < 0 || run.Count <= 0 || run.Start+run.Count > header.Sectors ||
+			run.Index == 0 || int(run.Index) >= len(names) {
+			return fmt.Errorf("invalid run of %d sectors at %d in file %d", run.Count, run.Start, run.Index)
+		}
+		r.volume.location.set(run.Start, run.Count, run.Index)
+	}
+	if buf.Len() != 0 {
+		return fmt.Errorf("%d trailing bytes", buf.Len())
+	}
+	r.volume.UsedBlocks = header.UsedBlocks
+	r.volume.UsedLogicalBlocks = header.UsedLogicalBlocks
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLunMapCheckpoint(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	b := int64(4096)
+	r, err := New(true, 8*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.SetReplicaMode("RW"), IsNil)
+
+	buf := make([]byte, 4*b)
+	fill(buf, 1)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("000", true, getNow()), IsNil)
+	fill(buf[2*b:3*b], 2)
+	_, err = r.WriteAt(buf[2*b:3*b], 2*b)
+	c.Assert(err, IsNil)
+	location := locationValues(r.volume.location)
+	used, usedLogical := r.volume.UsedBlocks, r.volume.UsedLogicalBlocks
+	c.Assert(r.Close(), IsNil)
+
+	_, err = os.Stat(r.diskPath(lunMapCheckpointFile))
+	c.Assert(err, IsNil)
+
+	// the checkpoint is loaded even without preloading, and removed
+	r, err = New(false, 8*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.lunMapComplete, Equals, true)
+	c.Assert(locationValues(r.volume.location), DeepEquals, location)
+	c.Assert(r.volume.UsedBlocks, Equals, used)
+	c.Assert(r.volume.UsedLogicalBlocks, Equals, usedLogical)
+	_, err = os.Stat(r.diskPath(lunMapCheckpointFile))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(r.Close(), IsNil)
+
+	// a corrupted checkpoint is ignored
+	path := r.diskPath(lunMapCheckpointFile)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	data[len(data)/2] ^= 0xff
+	c.Assert(ioutil.WriteFile(path, data, 0600), IsNil)
+	r, err = New(false, 8*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.lunMapComplete, Equals, false)
+	c.Assert(r.volume.location.runs(), Equals, 0)
+	c.Assert(r.Close(), IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// as is the one of a replica not closed cleanly
+	r, err = New(true, 8*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	info := r.info
+	info.Dirty = true
+	c.Assert(r.encodeToFile(&info, volumeMetaData), IsNil)
+	r, err = New(false, 8*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.lunMapComplete, Equals, false)
+
+	readBuf := make([]byte, 4*b)
+	_, err = r.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	byteEquals(c, readBuf, buf)
+	c.Assert(r.Close(), IsNil)
+}
@@ -0,0 +1,178 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MetadataFormatVersion is the version of the format of the metadata of a
+// replica directory, volume.meta and the metadata of the disks, written by
+// this binary. It is stored in volume.meta, the replicas created before it
+// was versioned are at version 0.
+const MetadataFormatVersion = 1
+
+// metadataMigration upgrades the metadata of a replica directory from the
+// previous version to version. It must be idempotent, as it is run again
+// if the replica crashes before volume.meta is updated to version.
+type metadataMigration struct {
+	version     int
+	description string
+	migrate     func(r *Replica) error
+}
+
+// metadataMigrations are the upgrades of the metadata, sorted by version.
+// A change to Info or disk which isn't compatible with the metadata
+// already written comes with a new version and its migration.
+var metadataMigrations = []metadataMigration{
+	{
+		version:     1,
+		description: "record the format version in volume.meta",
+		migrate:     func(r *Replica) error { return nil },
+	},
+}
+
+// MetadataFormat is the format of the metadata of a replica directory
+type MetadataFormat struct {
+	Version int
+	// Supported is the version of this binary
+	Supported int
+	// Pending are the descriptions of the migrations to run on the
+	// directory to upgrade it to Supported
+	Pending []string
+}
+
+// GetMetadataFormat returns the format of the metadata of the replica
+// directory, os.ErrNotExist if it has no volume.meta.
+func GetMetadataFormat(dir string) (*MetadataFormat, error) {
+	r := &Replica{dir: dir, readOnly: true}
+	version, err := r.readMetadataFormat()
+	if err != nil {
+		return nil, err
+	}
+	format := &MetadataFormat{Version: version, Supported: MetadataFormatVersion}
+	for _, m := range metadataMigrations {
+		if m.version > version {
+			format.Pending = append(format.Pending, fmt.Sprintf("%d: %s", m.version, m.description))
+		}
+	}
+	return format, nil
+}
+
+// UpgradeMetadata upgrades the metadata of the replica directory to the
+// version of this binary, the replica must not be running. It returns the
+// version of the directory before the upgrade.
+func UpgradeMetadata(dir string) (int, error) {
+	r := &Replica{dir: dir}
+	if _, err := r.readMetadataFormat(); err != nil {
+		return 0, err
+	}
+	// the operation interrupted is recovered by the version which
+	// recorded it
+	if _, err := os.Stat(r.diskPath(chainIntentFile)); err == nil {
+		return 0, fmt.Errorf("%s has an interrupted operation on the chain, start the replica once to recover it", dir)
+	}
+	return r.upgradeMetadata()
+}
+
+// readMetadataFormat returns the version of the metadata of the replica,
+// only reading it from volume.meta so that it works for any version.
+func (r *Replica) readMetadataFormat() (int, error) {
+	var info struct {
+		FormatVersion int
+	}
+	if err := r.unmarshalFile(volumeMetaData, &info); err != nil {
+		return 0, err
+	}
+	return info.FormatVersion, nil
+}
+
+// checkMetadataFormat fails if the version of the metadata is newer than
+// the one of this binary, which would lose the fields it doesn't know.
+func checkMetadataFormat(version int) error {
+	if version > MetadataFormatVersion {
+		return fmt.Errorf("metadata format version %d is newer than the supported version %d, upgrade jiva",
+			version, MetadataFormatVersion)
+	}
+	return nil
+}
+
+// checkDirFormat fails if the metadata of the replica directory is newer
+// than the one of this binary, a directory without volume.meta is a new
+// replica. It is checked before recovering an interrupted operation on the
+// chain, which would rewrite the metadata.
+func (r *Replica) checkDirFormat() error {
+	version, err := r.readMetadataFormat()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata format, error: %v", err)
+	}
+	return checkMetadataFormat(version)
+}
+
+// upgradeMetadata runs the migrations of the metadata of the replica, it
+// is called when the replica is opened, before reading the metadata.
+func (r *Replica) upgradeMetadata() (int, error) {
+	version, err := r.readMetadataFormat()
+	if os.IsNotExist(err) {
+		// a new replica is created at the current version
+		return MetadataFormatVersion, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read metadata format, error: %v", err)
+	}
+	if err := checkMetadataFormat(version); err != nil {
+		return version, err
+	}
+
+	from := version
+	for _, m := range metadataMigrations {
+		if m.version <= version {
+			continue
+		}
+		logrus.Infof("Upgrading metadata of %s to version %d: %s", r.dir, m.version, m.description)
+		if err := m.migrate(r); err != nil {
+			return from, fmt.Errorf("Failed to upgrade metadata to version %d, error: %v", m.version, err)
+		}
+		if err := r.writeMetadataFormat(m.version); err != nil {
+			return from, fmt.Errorf("Failed to update metadata format to version %d, error: %v", m.version, err)
+		}
+		version = m.version
+	}
+	return from, nil
+}
+
+// writeMetadataFormat sets the version in volume.meta, keeping the other
+// fields as they are whatever their version.
+func (r *Replica) writeMetadataFormat(version int) error {
+	var info map[string]json.RawMessage
+	if err := r.unmarshalFile(volumeMetaData, &info); err != nil {
+		return err
+	}
+	data, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	info["FormatVersion"] = data
+	return r.encodeToFile(info, volumeMetaData)
+}
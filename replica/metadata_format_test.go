@@ -0,0 +1,110 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	. "gopkg.in/check.v1"
+)
+
+// setMetadataFormat rewrites the version of volume.meta, removing it for
+// version 0 as written before the metadata was versioned.
+func setMetadataFormat(c *C, dir string, version int) {
+	p := path.Join(dir, volumeMetaData)
+	data, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	var info map[string]interface{}
+	c.Assert(json.Unmarshal(data, &info), IsNil)
+	delete(info, "FormatVersion")
+	if version != 0 {
+		info["FormatVersion"] = version
+	}
+	data, err = json.Marshal(info)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(p, data, 0600), IsNil)
+}
+
+func (s *TestSuite) TestMetadataFormat(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	_, err = GetMetadataFormat(dir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// a new replica is at the current version
+	r, err := New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.Info().FormatVersion, Equals, MetadataFormatVersion)
+	head := r.Info().Head
+	c.Assert(r.Close(), IsNil)
+	format, err := GetMetadataFormat(dir)
+	c.Assert(err, IsNil)
+	c.Assert(format, DeepEquals, &MetadataFormat{Version: MetadataFormatVersion, Supported: MetadataFormatVersion})
+
+	// an unversioned replica is upgraded when opened
+	setMetadataFormat(c, dir, 0)
+	format, err = GetMetadataFormat(dir)
+	c.Assert(err, IsNil)
+	c.Assert(format.Version, Equals, 0)
+	c.Assert(format.Pending, HasLen, MetadataFormatVersion)
+	r, err = New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	c.Assert(r.Info().FormatVersion, Equals, MetadataFormatVersion)
+	c.Assert(r.Info().Head, Equals, head)
+	c.Assert(r.Close(), IsNil)
+
+	// or by UpgradeMetadata, keeping the other fields
+	setMetadataFormat(c, dir, 0)
+	from, err := UpgradeMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Assert(from, Equals, 0)
+	info, err := ReadInfo(dir)
+	c.Assert(err, IsNil)
+	c.Assert(info.FormatVersion, Equals, MetadataFormatVersion)
+	c.Assert(info.Head, Equals, head)
+	from, err = UpgradeMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Assert(from, Equals, MetadataFormatVersion)
+
+	// a newer version is refused
+	setMetadataFormat(c, dir, MetadataFormatVersion+1)
+	_, err = New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, ErrorMatches, "metadata format version .* is newer .*")
+	_, err = NewReadOnly(false, dir, head, nil)
+	c.Assert(err, ErrorMatches, "metadata format version .* is newer .*")
+	_, err = ReadInfo(dir)
+	c.Assert(err, NotNil)
+	_, err = UpgradeMetadata(dir)
+	c.Assert(err, NotNil)
+	format, err = GetMetadataFormat(dir)
+	c.Assert(err, IsNil)
+	c.Assert(format.Version, Equals, MetadataFormatVersion+1)
+
+	// the operation on the chain interrupted is left to the newer version
+	intent := []byte(`{"op":"newer"}`)
+	c.Assert(ioutil.WriteFile(path.Join(dir, chainIntentFile), intent, 0600), IsNil)
+	_, err = New(false, 4*b, b, dir, nil, "Backend")
+	c.Assert(err, ErrorMatches, "metadata format version .* is newer .*")
+	data, err := ioutil.ReadFile(path.Join(dir, chainIntentFile))
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, intent)
+}
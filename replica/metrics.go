@@ -0,0 +1,113 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"github.com/openebs/jiva/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// collector exports the state of the replica to Prometheus, it is read on
+// each scrape.
+type collector struct {
+	s *Server
+
+	mode              *prometheus.Desc
+	rebuilding        *prometheus.Desc
+	chainLength       *prometheus.Desc
+	remainSnapshots   *prometheus.Desc
+	usedBlocks        *prometheus.Desc
+	usedLogicalBlocks *prometheus.Desc
+	revisionCounter   *prometheus.Desc
+	locationRuns      *prometheus.Desc
+}
+
+// NewCollector returns the collector of the metrics of the replica served
+// by s.
+func NewCollector(s *Server) prometheus.Collector {
+	labels := prometheus.Labels{"replica": s.ReplicaAddress}
+	return &collector{
+		s: s,
+		mode: prometheus.NewDesc("openebs_jiva_replica_mode",
+			"1 for the mode the replica is in, RW, WO while rebuilding or ERR.", []string{"mode"}, labels),
+		rebuilding: prometheus.NewDesc("openebs_jiva_replica_rebuilding",
+			"1 if the replica is being rebuilt.", nil, labels),
+		chainLength: prometheus.NewDesc("openebs_jiva_replica_chain_length",
+			"Number of the disks in the chain of the replica, the head included.", nil, labels),
+		remainSnapshots: prometheus.NewDesc("openebs_jiva_replica_remaining_snapshots",
+			"Number of the snapshots which can still be taken.", nil, labels),
+		usedBlocks: prometheus.NewDesc("openebs_jiva_replica_used_blocks",
+			"Number of the blocks allocated by the disks of the replica.", nil, labels),
+		usedLogicalBlocks: prometheus.NewDesc("openebs_jiva_replica_used_logical_blocks",
+			"Number of the blocks of the volume written.", nil, labels),
+		revisionCounter: prometheus.NewDesc("openebs_jiva_replica_revision_counter",
+			"Revision counter of the replica.", nil, labels),
+		locationRuns: prometheus.NewDesc("openebs_jiva_replica_location_runs",
+			"Number of the runs of sectors in the same disk held by the location map, its memory is proportional to them.", nil, labels),
+	}
+}
+
+func (m *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.mode
+	ch <- m.rebuilding
+	ch <- m.chainLength
+	ch <- m.remainSnapshots
+	ch <- m.usedBlocks
+	ch <- m.usedLogicalBlocks
+	ch <- m.revisionCounter
+	ch <- m.locationRuns
+}
+
+func (m *collector) Collect(ch chan<- prometheus.Metric) {
+	m.s.RLock()
+	defer m.s.RUnlock()
+	r := m.s.r
+	if r == nil {
+		return
+	}
+
+	mode := types.Mode(r.GetReplicaMode())
+	for _, md := range []types.Mode{types.RW, types.WO, types.ERR} {
+		value := 0.0
+		if md == mode {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(m.mode, prometheus.GaugeValue, value, string(md))
+	}
+	rebuilding := 0.0
+	if r.Info().Rebuilding {
+		rebuilding = 1
+	}
+	ch <- prometheus.MustNewConstMetric(m.rebuilding, prometheus.GaugeValue, rebuilding)
+
+	if chain, err := r.Chain(); err == nil {
+		ch <- prometheus.MustNewConstMetric(m.chainLength, prometheus.GaugeValue, float64(len(chain)))
+	}
+	ch <- prometheus.MustNewConstMetric(m.remainSnapshots, prometheus.GaugeValue, float64(r.GetRemainSnapshotCounts()))
+	usage, err := r.GetUsage()
+	if err != nil {
+		logrus.Warningf("Failed to get the usage of the replica for the metrics, error: %v", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(m.usedBlocks, prometheus.GaugeValue, float64(usage.UsedBlocks))
+		ch <- prometheus.MustNewConstMetric(m.usedLogicalBlocks, prometheus.GaugeValue, float64(usage.UsedLogicalBlocks))
+	}
+	ch <- prometheus.MustNewConstMetric(m.revisionCounter, prometheus.CounterValue, float64(r.GetRevisionCounter()))
+	if r.volume.location != nil {
+		ch <- prometheus.MustNewConstMetric(m.locationRuns, prometheus.GaugeValue, float64(r.volume.location.runs()))
+	}
+}
@@ -33,6 +33,7 @@ import (
 	"time"
 
 	units "github.com/docker/go-units"
+	inject "github.com/openebs/jiva/error-inject"
 	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
 	"github.com/openebs/sparse-tools/sparse"
@@ -92,6 +93,9 @@ type Replica struct {
 	Clone         bool
 	// used for draining the HoleCreatorChan also useful for mocking
 	holeDrainer func()
+	// lunMapComplete is set when the LUN map holds all the sectors
+	// written, it is saved to the checkpoint on a clean close.
+	lunMapComplete bool
 }
 
 type Info struct {
@@ -106,6 +110,12 @@ type Info struct {
 	Checkpoint      string
 	BackingFile     *BackingFile `json:"-"`
 	RevisionCounter int64
+	// ReplicationFactor is the replication factor of the volume set by
+	// the controller, 0 if it was never set.
+	ReplicationFactor int
+	// FormatVersion is the version of the format of the metadata of the
+	// replica, see MetadataFormatVersion.
+	FormatVersion int
 }
 
 type disk struct {
@@ -173,19 +183,25 @@ func CreateTempServer() (*Server, error) {
 func ReadInfo(dir string) (Info, error) {
 	var info Info
 	err := (&Replica{dir: dir}).unmarshalFile(volumeMetaData, &info)
+	if err == nil {
+		err = checkMetadataFormat(info.FormatVersion)
+	}
 	return info, err
 }
 
 func New(preload bool, size, sectorSize int64, dir string, backingFile *BackingFile, replicaType string) (*Replica, error) {
-	return construct(preload, false, size, sectorSize, dir, "", backingFile, replicaType)
+	return construct(preload, false, false, size, sectorSize, dir, "", backingFile, replicaType)
 }
 
 func NewReadOnly(preload bool, dir, head string, backingFile *BackingFile) (*Replica, error) {
 	// size and sectorSize don't matter because they will be read from metadata
-	return construct(preload, true, 0, 512, dir, head, backingFile, "")
+	return construct(preload, true, false, 0, 512, dir, head, backingFile, "")
 }
 
-func construct(preload, readonly bool, size, sectorSize int64, dir, head string, backingFile *BackingFile, replicaType string) (*Replica, error) {
+// construct opens the replica in dir, reload is set when it is reopened by
+// the replica itself while open, which isn't a recovery from a crash even
+// if the replica is dirty.
+func construct(preload, readonly, reload bool, size, sectorSize int64, dir, head string, backingFile *BackingFile, replicaType string) (*Replica, error) {
 	if size%sectorSize != 0 {
 		return nil, fmt.Errorf("Size %d not a multiple of sector size %d", size, sectorSize)
 	}
@@ -201,6 +217,7 @@ func construct(preload, readonly bool, size, sectorSize int64, dir, head string,
 		diskData:        make(map[string]*disk),
 		diskChildrenMap: map[string]map[string]bool{},
 		mode:            types.INIT,
+		readOnly:        readonly,
 		holeDrainer: func() {
 			// this is just initializing function,
 			// actual excution will be done by r.holeDrainer()
@@ -215,6 +232,20 @@ func construct(preload, readonly bool, size, sectorSize int64, dir, head string,
 	}
 	r.volume.sectorSize = defaultSectorSize
 
+	if !readonly {
+		if err := r.checkDirFormat(); err != nil {
+			return nil, err
+		}
+		// finish or roll back the operation on the chain interrupted
+		// by a crash before reading the chain
+		if err := r.replayChainIntent(); err != nil {
+			return nil, err
+		}
+		if _, err := r.upgradeMetadata(); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := r.initRevisionCounter(); err != nil {
 		return nil, err
 	}
@@ -224,16 +255,24 @@ func construct(preload, readonly bool, size, sectorSize int64, dir, head string,
 	if err != nil {
 		return nil, err
 	}
+	if err := checkMetadataFormat(r.info.FormatVersion); err != nil {
+		return nil, err
+	}
+	if !exists {
+		r.info.FormatVersion = MetadataFormatVersion
+	}
+	dirty := r.info.Dirty
 	// Reference r.info.Size because it may have changed from reading
 	// metadata
 	locationSize := r.info.Size / r.volume.sectorSize
 	if size%defaultSectorSize != 0 {
 		locationSize++
 	}
-	r.volume.location = make([]uint16, locationSize)
+	r.volume.location = newLocationMap(locationSize)
 	r.volume.files = []types.DiffDisk{nil}
 	r.volume.UserCreatedSnap = []bool{false}
 	r.volume.rmLock = &sync.Mutex{}
+	r.volume.readOnly = r.readOnly
 
 	if r.readOnly && !exists {
 		return nil, os.ErrNotExist
@@ -243,6 +282,17 @@ func construct(preload, readonly bool, size, sectorSize int64, dir, head string,
 		r.info.Head = head
 	}
 
+	if exists && dirty && !readonly && !reload {
+		// The data and the checksums of the head aren't written
+		// atomically, the blocks being written when the replica
+		// crashed may be torn, so their checksums are recomputed
+		// rather than failing their reads.
+		logrus.Infof("Rebuilding checksums of %v after an unclean shutdown", r.info.Head)
+		if err := BuildChecksums(r.diskPath(r.info.Head)); err != nil {
+			return nil, err
+		}
+	}
+
 	if exists {
 		if err := r.openLiveChain(); err != nil {
 			return nil, err
@@ -258,10 +308,20 @@ func construct(preload, readonly bool, size, sectorSize int64, dir, head string,
 
 	r.insertBackingFile()
 	r.ReplicaType = replicaType
-	if preload {
+	// a new volume has no sector written yet
+	r.lunMapComplete = !exists
+	if exists && !r.readOnly {
+		loaded, err := r.loadLunMapCheckpoint(dirty)
+		if err != nil {
+			return nil, err
+		}
+		r.lunMapComplete = loaded
+	}
+	if preload && !r.lunMapComplete {
 		if err := PreloadLunMap(&r.volume); err != nil {
 			return r, fmt.Errorf("failed to load Lun map, error: %v", err)
 		}
+		r.lunMapComplete = true
 	}
 	return r, r.writeVolumeMetaData(true, r.info.Rebuilding)
 }
@@ -351,19 +411,21 @@ func (r *Replica) Resize(obj interface{}) error {
 			return err
 		}
 	}
-	byteArray := make([]uint16, (sizeInBytes-r.info.Size)/4096)
-	r.volume.location = append(r.volume.location, byteArray...)
+	r.volume.location.grow(r.volume.location.len() + (sizeInBytes-r.info.Size)/4096)
 	r.info.Size = sizeInBytes
 	return r.encodeToFile(&r.info, volumeMetaData)
 }
 
 func (r *Replica) Reload(preload bool) (*Replica, error) {
-	newReplica, err := New(preload, r.info.Size, r.info.SectorSize, r.dir, r.info.BackingFile, r.ReplicaType)
+	newReplica, err := construct(preload, false, true, r.info.Size, r.info.SectorSize, r.dir, "", r.info.BackingFile, r.ReplicaType)
 	if err != nil {
 		return nil, err
 	}
 	newReplica.mode = r.mode
 	newReplica.info.Dirty = r.info.Dirty
+	// the new replica owns the chain now, the map of this one must not
+	// be saved when it is closed
+	r.lunMapComplete = false
 	return newReplica, nil
 }
 
@@ -421,11 +483,23 @@ func (r *Replica) RemoveDiffDisk(name string) error {
 	// the file that is going to be deleted.
 	r.holeDrainer()
 
+	intent, err := r.removeIntent(intentRemoveDisk, name)
+	if err != nil {
+		return err
+	}
+	if err := r.writeChainIntent(intent); err != nil {
+		return err
+	}
+
 	if err := r.removeDiskNode(name); err != nil {
 		return err
 	}
+	inject.CrashAt("removedisk-node-removed")
 
-	return r.rmDisk(name)
+	if err := r.rmDisk(name); err != nil {
+		return err
+	}
+	return r.clearChainIntent()
 }
 
 func (r *Replica) hardlinkDisk(target, source string) error {
@@ -439,10 +513,19 @@ func (r *Replica) hardlinkDisk(target, source string) error {
 			return fmt.Errorf("Fail to remove %s: %v", target, err)
 		}
 	}
+	inject.CrashAt("hardlink-target-removed")
 
 	if err := os.Link(r.diskPath(source), r.diskPath(target)); err != nil {
 		return fmt.Errorf("Fail to link %s to %s", source, target)
 	}
+
+	// the checksums of the target are replaced along with its data
+	if err := os.Remove(r.diskPath(target + checksumSuffix)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Fail to remove %s: %v", target+checksumSuffix, err)
+	}
+	if err := os.Link(r.diskPath(source+checksumSuffix), r.diskPath(target+checksumSuffix)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Fail to link %s to %s", source+checksumSuffix, target+checksumSuffix)
+	}
 	return r.syncDir()
 }
 
@@ -469,9 +552,19 @@ func (r *Replica) ReplaceDisk(target, source string) error {
 	// the file that is going to be deleted.
 	r.holeDrainer()
 
+	intent, err := r.removeIntent(intentReplaceDisk, source)
+	if err != nil {
+		return err
+	}
+	intent.Target = target
+	if err := r.writeChainIntent(intent); err != nil {
+		return err
+	}
+
 	if err := r.hardlinkDisk(target, source); err != nil {
 		return err
 	}
+	inject.CrashAt("replacedisk-linked")
 
 	if err := r.removeDiskNode(source); err != nil {
 		return err
@@ -481,6 +574,9 @@ func (r *Replica) ReplaceDisk(target, source string) error {
 		logrus.Fatalf("Failed to remove disk: %v, err: %v", source, err)
 		return err
 	}
+	if err := r.clearChainIntent(); err != nil {
+		return err
+	}
 	// Since metafile has being removed
 	r.volume.UsedBlocks--
 
@@ -544,6 +640,7 @@ func (r *Replica) removeDiskNode(name string) error {
 		logrus.Fatalf("Failed to update parent disk: %v with child: %v", name, child)
 		return err
 	}
+	inject.CrashAt("removedisk-child-updated")
 	if err := r.updateParentRevisionCounter(name); err != nil {
 		logrus.Fatalf("Failed to update parent's revision counter: %v", name)
 		return err
@@ -738,6 +835,11 @@ func (r *Replica) closeAndSyncDir(f types.DiffDisk) error {
 }
 
 func (r *Replica) close() error {
+	if r.lunMapComplete && !r.readOnly {
+		if err := r.writeLunMapCheckpoint(); err != nil {
+			logrus.Warningf("Failed to save LUN map checkpoint, error: %v", err)
+		}
+	}
 	for i, f := range r.volume.files {
 		if f != nil && !r.isBackingFile(i) {
 			f.Close()
@@ -792,7 +894,16 @@ func (r *Replica) nextFile(parsePattern *regexp.Regexp, pattern, parent string)
 }
 
 func (r *Replica) openFile(name string, flag int) (types.DiffDisk, error) {
-	return sparse.NewDirectFileIoProcessor(r.diskPath(name), os.O_RDWR|flag, 06666, true)
+	f, err := sparse.NewDirectFileIoProcessor(r.diskPath(name), os.O_RDWR|flag, 06666, true)
+	if err != nil {
+		return nil, err
+	}
+	c, err := openChecksumDisk(f, r.diskPath(name), flag, r.readOnly)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
 }
 
 func (r *Replica) syncDir() error {
@@ -859,6 +970,10 @@ func (r *Replica) linkDisk(oldname, newname string) error {
 	if err := os.Link(r.diskPath(oldname+metadataSuffix), r.diskPath(newname+metadataSuffix)); err != nil {
 		return err
 	}
+
+	if err := os.Link(r.diskPath(oldname+checksumSuffix), r.diskPath(newname+checksumSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return r.syncDir()
 }
 
@@ -891,6 +1006,10 @@ func (r *Replica) rmDisk(name string) error {
 		return err
 	}
 
+	if err := os.Remove(r.diskPath(name + checksumSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	return r.syncDir()
 }
 
@@ -906,6 +1025,10 @@ func (r *Replica) revertDisk(parent, created string) (*Replica, error) {
 	}
 	defer f.Close()
 
+	if err := r.writeChainIntent(chainIntent{Op: intentNewHead, Head: oldHead, NewHead: newHeadDisk.Name}); err != nil {
+		return nil, err
+	}
+
 	info := r.info
 	info.Head = newHeadDisk.Name
 	info.Dirty = true
@@ -915,11 +1038,15 @@ func (r *Replica) revertDisk(parent, created string) (*Replica, error) {
 		r.encodeToFile(&r.info, volumeMetaData)
 		return nil, err
 	}
+	inject.CrashAt("revert-committed")
 
 	// Need to execute before r.Reload() to update r.diskChildrenMap
 	if err := r.rmDisk(oldHead); err != nil {
 		return nil, err
 	}
+	if err := r.clearChainIntent(); err != nil {
+		return nil, err
+	}
 	// preload is needed since one of the files has been removed from the chain
 	rNew, err := r.Reload(true)
 	if err != nil {
@@ -959,6 +1086,11 @@ func (r *Replica) createDisk(name string, userCreated bool, created string) erro
 		return err
 	}
 
+	// a crash from now on is recovered by replaying the intent, the new
+	// head and snapshot are removed unless volume.meta points to the new
+	// head, in which case the old head is.
+	intentErr := r.writeChainIntent(chainIntent{Op: intentNewHead, Head: oldHead, NewHead: newHeadDisk.Name, Disk: newSnapName})
+
 	defer func() {
 		if !done {
 			if err := r.rmDisk(newHeadDisk.Name); err != nil {
@@ -970,20 +1102,28 @@ func (r *Replica) createDisk(name string, userCreated bool, created string) erro
 			if err := f.Close(); err != nil {
 				logrus.Errorf("Failed to close file: %v in defer, err: %v", newHeadDisk.Name, err)
 			} // rm only unlink the file since fd is still open
+			if err := r.clearChainIntent(); err != nil {
+				logrus.Errorf("Failed to clear intent in defer, err: %v", err)
+			}
 			return
 		}
-		// crash at this point leads to stale old head and its meta file will remain
-		// which will not be part of the chain as its already updated below.
 		if err := r.rmDisk(oldHead); err != nil {
 			logrus.Errorf("Failed to remove disk: %v in defer, err: %v", oldHead, err)
+			return
+		}
+		if err := r.clearChainIntent(); err != nil {
+			logrus.Errorf("Failed to clear intent in defer, err: %v", err)
 		}
 	}()
+	if intentErr != nil {
+		return intentErr
+	}
+	inject.CrashAt("newhead-created")
 
 	if err := r.linkDisk(r.info.Head, newSnapName); err != nil {
 		return err
 	}
-
-	// crash at this point leads to stale snapshot files
+	inject.CrashAt("newhead-linked")
 
 	r.diskData[newHeadDisk.Name] = &newHeadDisk
 	if newSnapName != "" {
@@ -998,10 +1138,13 @@ func (r *Replica) createDisk(name string, userCreated bool, created string) erro
 		if err := r.encodeToFile(r.diskData[newSnapName], newSnapName+metadataSuffix); err != nil {
 			return err
 		}
-		// crash here will leave stale snapshot files
+		inject.CrashAt("newhead-snapshot-updated")
 		r.volume.UsedBlocks++ // This is for metadata file
 		r.updateChildDisk(oldHead, newSnapName)
 		r.activeDiskData[len(r.activeDiskData)-1].Name = newSnapName
+		if c, ok := r.volume.files[len(r.volume.files)-1].(*checksumDisk); ok {
+			c.name = r.diskPath(newSnapName)
+		}
 	}
 	delete(r.diskData, oldHead)
 
@@ -1028,7 +1171,7 @@ func (r *Replica) createDisk(name string, userCreated bool, created string) erro
 		return err
 	}
 
-	// crash at this point leads to stale old head and it meta file
+	inject.CrashAt("newhead-committed")
 	done = true
 	// update in memory info as its persisted on the disk
 	r.info = info
@@ -1286,6 +1429,16 @@ func (r *Replica) Delete() error {
 		logrus.Error("Error in removing revision counter file, error : ", err.Error())
 		return err
 	}
+	err = os.Remove(r.diskPath(lunMapCheckpointFile))
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Error("Error in removing LUN map checkpoint, error : ", err.Error())
+		return err
+	}
+	err = os.Remove(r.diskPath(chainIntentFile))
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Error("Error in removing chain intent, error : ", err.Error())
+		return err
+	}
 	return r.syncDir()
 }
 
@@ -1485,3 +1638,13 @@ func (r *Replica) SetCheckpoint(snapshotName string) error {
 
 	return r.encodeToFile(&r.info, volumeMetaData)
 }
+
+// SetReplicationFactor persists the replication factor of the volume, it
+// is sent to the controller when the replica registers.
+func (r *Replica) SetReplicationFactor(rf int) error {
+	r.Lock()
+	defer r.Unlock()
+	r.info.ReplicationFactor = rf
+
+	return r.encodeToFile(&r.info, volumeMetaData)
+}
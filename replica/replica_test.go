@@ -364,7 +364,7 @@ func byteEquals(c *C, expected, obtained []byte) {
 	}
 }
 
-func byteEqualsLocation(c *C, expected, obtained []uint16) {
+func byteEqualsLocation(c *C, expected, obtained []uint32) {
 	c.Assert(len(expected), Equals, len(obtained))
 
 	for i := range expected {
@@ -465,10 +465,10 @@ func (s *TestSuite) TestSnapshotReadWrite(c *C) {
 
 	readBuf := make([]byte, 3*b)
 	_, err = r.ReadAt(readBuf, 0)
-	c.Logf("%v", r.volume.location)
+	c.Logf("%v", locationValues(r.volume.location))
 	c.Assert(err, IsNil)
 	byteEquals(c, readBuf, buf)
-	byteEqualsLocation(c, r.volume.location, []uint16{3, 2, 1})
+	byteEqualsLocation(c, locationValues(r.volume.location), []uint32{3, 2, 1})
 
 	r, err = r.Reload(true)
 	c.Assert(err, IsNil)
@@ -476,7 +476,7 @@ func (s *TestSuite) TestSnapshotReadWrite(c *C) {
 	_, err = r.ReadAt(readBuf, 0)
 	c.Assert(err, IsNil)
 	byteEquals(c, readBuf, buf)
-	byteEqualsLocation(c, r.volume.location, []uint16{3, 2, 1})
+	byteEqualsLocation(c, locationValues(r.volume.location), []uint32{3, 2, 1})
 }
 
 func (s *TestSuite) TestBackingFile(c *C) {
@@ -599,7 +599,7 @@ func (s *TestSuite) testPartialRead(c *C, totalLength int64, readBuf []byte, off
 		c.Assert(err, IsNil)
 	}
 
-	fmt.Println("Starting partialRead", r.volume.location)
+	fmt.Println("Starting partialRead", locationValues(r.volume.location))
 	return r.ReadAt(readBuf, offset)
 }
 
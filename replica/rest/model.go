@@ -23,6 +23,7 @@ import (
 	"strconv"
 
 	"github.com/openebs/jiva/replica"
+	"github.com/openebs/jiva/rpc"
 	"github.com/openebs/jiva/types"
 	"github.com/openebs/jiva/util"
 	"github.com/rancher/go-rancher/api"
@@ -92,6 +93,38 @@ type VolUsage struct {
 	SectorSize        string `json:"sectorSize"`
 }
 
+// ScrubOutput is the status of the last scrub of the replica
+type ScrubOutput struct {
+	client.Resource
+	types.ScrubStatus
+}
+
+// HashInput is the range of a snapshot, or of the head if Snapshot is
+// empty, whose blocks are hashed.
+type HashInput struct {
+	client.Resource
+	Snapshot  string `json:"snapshot"`
+	Offset    int64  `json:"offset"`
+	Length    int64  `json:"length"`
+	BlockSize int64  `json:"blockSize"`
+}
+
+// HashOutput holds the hashes of the blocks of the range
+type HashOutput struct {
+	client.Resource
+	Hashes []string `json:"hashes"`
+}
+
+// SnapshotData is a range of the data of a snapshot, or of the head if
+// Snapshot is empty.
+type SnapshotData struct {
+	client.Resource
+	Snapshot string `json:"snapshot"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Data     []byte `json:"data,omitempty"`
+}
+
 type RebuildInfoOutput struct {
 	client.Resource
 	SyncInfo types.SyncInfo `json:"syncInfo,omitempty"`
@@ -131,6 +164,12 @@ type Checkpoint struct {
 	SnapshotName string `json:"snapshotName"`
 }
 
+// ReplicationFactorInput is the input of the setreplicationfactor action
+type ReplicationFactorInput struct {
+	client.Resource
+	ReplicationFactor int `json:"replicationFactor"`
+}
+
 type RevisionCounter struct {
 	client.Resource
 	Counter string `json:"counter"`
@@ -185,6 +224,11 @@ func NewReplica(context *api.ApiContext, state replica.State, info replica.Info,
 		actions["updatecloneinfo"] = true
 		actions["setreplicacounter"] = true
 		actions["setcheckpoint"] = true
+		actions["setreplicationfactor"] = true
+		actions["scrub"] = true
+		actions["hash"] = true
+		actions["readsnapshot"] = true
+		actions["writesnapshot"] = true
 	case replica.Closed:
 		actions["start"] = true
 		actions["open"] = true
@@ -212,6 +256,11 @@ func NewReplica(context *api.ApiContext, state replica.State, info replica.Info,
 		actions["setreplicacounter"] = true
 		actions["updatecloneinfo"] = true
 		actions["setcheckpoint"] = true
+		actions["setreplicationfactor"] = true
+		actions["scrub"] = true
+		actions["hash"] = true
+		actions["readsnapshot"] = true
+		actions["writesnapshot"] = true
 	case replica.Rebuilding:
 		actions["setrebuilding"] = true
 		actions["setlogging"] = true
@@ -222,6 +271,8 @@ func NewReplica(context *api.ApiContext, state replica.State, info replica.Info,
 		actions["setreplicacounter"] = true
 		actions["updatecloneinfo"] = true
 		actions["setcheckpoint"] = true
+		actions["setreplicationfactor"] = true
+		actions["writesnapshot"] = true
 	case replica.Error:
 	}
 
@@ -235,6 +286,8 @@ func NewReplica(context *api.ApiContext, state replica.State, info replica.Info,
 	r.Parent = info.Parent
 	r.SectorSize = info.SectorSize
 	r.Checkpoint = info.Checkpoint
+	r.ReplicationFactor = info.ReplicationFactor
+	r.RPCVersion = rpc.MagicVersion
 	r.Size = strconv.FormatInt(info.Size, 10)
 	r.RevisionCounter = strconv.FormatInt(info.RevisionCounter, 10)
 	r.UsedBlocks, r.UsedLogicalBlocks = "0", "0" // replica must be initializing
@@ -299,10 +352,27 @@ func setReplicaResourceActions(replica *client.Schema) {
 		"setreplicacounter": {
 			Input: "replicaCounter",
 		},
+		"setreplicationfactor": {
+			Input: "replicationFactorInput",
+		},
 		"replacedisk": {
 			Input:  "replacediskinput",
 			Output: "replica",
 		},
+		"scrub": {
+			Output: "scrubStatus",
+		},
+		"hash": {
+			Input:  "hashInput",
+			Output: "hashOutput",
+		},
+		"readsnapshot": {
+			Input:  "snapshotData",
+			Output: "snapshotData",
+		},
+		"writesnapshot": {
+			Input: "snapshotData",
+		},
 	}
 }
 
@@ -323,7 +393,15 @@ func NewSchema() *client.Schemas {
 	schemas.AddType("replicaMode", ReplicaMode{})
 	schemas.AddType("revisionCounter", RevisionCounter{})
 	schemas.AddType("replicaCounter", ReplicaCounter{})
+	schemas.AddType("replicationFactorInput", ReplicationFactorInput{})
 	schemas.AddType("replacediskInput", ReplaceDiskInput{})
+	schemas.AddType("hashInput", HashInput{})
+	schemas.AddType("hashOutput", HashOutput{})
+	schemas.AddType("snapshotData", SnapshotData{})
+
+	scrub := schemas.AddType("scrubStatus", ScrubOutput{})
+	scrub.PluralName = ""
+	scrub.ResourceMethods = []string{"GET"}
 
 	rebuild := schemas.AddType("rebuildinfo", RebuildInfoOutput{})
 	rebuild.PluralName = ""
@@ -49,7 +49,9 @@ func (s *Server) Replica(apiContext *api.ApiContext) *Replica {
 	defer s.s.RUnlock()
 	state, info := s.s.Status()
 	info.RevisionCounter, _ = s.s.GetRevisionCounter()
-	return NewReplica(apiContext, state, info, s.s.Replica())
+	r := NewReplica(apiContext, state, info, s.s.Replica())
+	r.Epoch = s.s.Epoch().Epoch
+	return r
 }
 
 func (s *Server) GetReplica(rw http.ResponseWriter, req *http.Request) error {
@@ -135,6 +137,93 @@ func (s *Server) GetRebuildInfo(rw http.ResponseWriter, req *http.Request) error
 	return nil
 }
 
+func (s *Server) writeScrubStatus(apiContext *api.ApiContext) {
+	apiContext.Write(&ScrubOutput{
+		Resource: client.Resource{
+			Type:    "scrubStatus",
+			Id:      "1",
+			Actions: map[string]string{},
+			Links:   map[string]string{},
+		},
+		ScrubStatus: s.s.ScrubStatus(),
+	})
+}
+
+// GetScrubStatus returns the progress and the result of the last scrub
+func (s *Server) GetScrubStatus(rw http.ResponseWriter, req *http.Request) error {
+	s.writeScrubStatus(api.GetApiContext(req))
+	return nil
+}
+
+// Scrub starts verifying the data of the replica against its checksums
+func (s *Server) Scrub(rw http.ResponseWriter, req *http.Request) error {
+	logrus.Infof("Got signal: 'scrub', start scrubbing replica")
+	if err := s.s.StartScrub(); err != nil {
+		logrus.Errorf("Error %v in scrub", err)
+		return err
+	}
+	s.writeScrubStatus(api.GetApiContext(req))
+	return nil
+}
+
+// Hash returns the hashes of the blocks of a range of a snapshot
+func (s *Server) Hash(rw http.ResponseWriter, req *http.Request) error {
+	var input HashInput
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	hashes, err := s.s.HashData(input.Snapshot, input.Offset, input.Length, input.BlockSize)
+	if err != nil {
+		logrus.Errorf("Error %v in hash", err)
+		return err
+	}
+	apiContext.Write(&HashOutput{
+		Resource: client.Resource{
+			Type: "hashOutput",
+			Id:   "1",
+		},
+		Hashes: hashes,
+	})
+	return nil
+}
+
+// ReadSnapshot returns a range of the data of a snapshot
+func (s *Server) ReadSnapshot(rw http.ResponseWriter, req *http.Request) error {
+	var input SnapshotData
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	data, err := s.s.ReadSnapshotAt(input.Snapshot, input.Offset, input.Length)
+	if err != nil {
+		logrus.Errorf("Error %v in readsnapshot", err)
+		return err
+	}
+	apiContext.Write(&SnapshotData{
+		Resource: client.Resource{
+			Type: "snapshotData",
+			Id:   "1",
+		},
+		Snapshot: input.Snapshot,
+		Offset:   input.Offset,
+		Length:   int64(len(data)),
+		Data:     data,
+	})
+	return nil
+}
+
+// WriteSnapshot overwrites a range of the data of a snapshot
+func (s *Server) WriteSnapshot(rw http.ResponseWriter, req *http.Request) error {
+	var input SnapshotData
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil {
+		return err
+	}
+	logrus.Infof("Got signal: 'writesnapshot', repair %d bytes at offset %d of %s", len(input.Data), input.Offset, input.Snapshot)
+	return s.doOp(req, s.s.WriteSnapshotAt(input.Snapshot, input.Data, input.Offset))
+}
+
 func (s *Server) doOp(req *http.Request, err error) error {
 	if err != nil {
 		logrus.Errorf("Error %v in doOp: %v", err, req.RequestURI)
@@ -362,6 +451,22 @@ func (s *Server) SetCheckpoint(rw http.ResponseWriter, req *http.Request) error
 	return s.doOp(req, s.s.SetCheckpoint(checkpoint.SnapshotName))
 }
 
+// SetReplicationFactor is the handler of the setreplicationfactor action,
+// the replication factor must be positive.
+func (s *Server) SetReplicationFactor(rw http.ResponseWriter, req *http.Request) error {
+	var input ReplicationFactorInput
+	apiContext := api.GetApiContext(req)
+	if err := apiContext.Read(&input); err != nil && err != io.EOF {
+		logrus.Errorf("Err %v during read in setReplicationFactor", err)
+		return err
+	}
+	if input.ReplicationFactor <= 0 {
+		return fmt.Errorf("Invalid replication factor %d", input.ReplicationFactor)
+	}
+	logrus.Infof("SetReplicationFactor to %v", input.ReplicationFactor)
+	return s.doOp(req, s.s.SetReplicationFactor(input.ReplicationFactor))
+}
+
 func (s *Server) SetRevisionCounter(rw http.ResponseWriter, req *http.Request) error {
 	var input RevisionCounter
 	apiContext := api.GetApiContext(req)
@@ -20,13 +20,16 @@
 package rest
 
 import (
+	"fmt"
 	"net/http"
 	_ "net/http/pprof" /* for profiling */
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
+	"github.com/sirupsen/logrus"
 )
 
 func HandleError(s *client.Schemas, t func(http.ResponseWriter, *http.Request) error) http.Handler {
@@ -38,6 +41,46 @@ func HandleError(s *client.Schemas, t func(http.ResponseWriter, *http.Request) e
 	}))
 }
 
+const (
+	// EpochHeader and ControllerHeader carry the epoch and the ID of the
+	// controller sending a request.
+	EpochHeader      = "X-Controller-Epoch"
+	ControllerHeader = "X-Controller-Id"
+)
+
+// unclaimedActions are the actions a controller sends to a replica before
+// claiming it, and the ones which don't change the replica.
+var unclaimedActions = map[string]bool{
+	"start":        true,
+	"open":         true,
+	"hash":         true,
+	"readsnapshot": true,
+}
+
+// checkEpoch rejects the requests of a controller which has been replaced
+// by a newer one. Once a controller has claimed the replica, the requests
+// without epoch, e.g. the ones of a controller which hasn't claimed it, are
+// rejected as well, except the unclaimedActions.
+func checkEpoch(s *Server, t func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	return func(rw http.ResponseWriter, req *http.Request) error {
+		var epoch int64
+		if value := req.Header.Get(EpochHeader); value != "" {
+			var err error
+			if epoch, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("Invalid controller epoch %q", value)
+			}
+		} else if req.Method == "POST" && unclaimedActions[req.URL.Query().Get("action")] {
+			return t(rw, req)
+		}
+		// a request without epoch is stale once the replica is claimed
+		if err := s.s.CheckEpoch(epoch, req.Header.Get(ControllerHeader)); err != nil {
+			logrus.Warningf("Rejecting %s %s: %v", req.Method, req.URL, err)
+			return err
+		}
+		return t(rw, req)
+	}
+}
+
 func checkAction(s *Server, t func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
 	return func(rw http.ResponseWriter, req *http.Request) error {
 		replica := s.Replica(api.GetApiContext(req))
@@ -74,34 +117,40 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods("GET").Path("/v1/replicas").Handler(f(schemas, s.ListReplicas))
 	router.Methods("GET").Path("/v1/replicas/{id}").Handler(f(schemas, s.GetReplica))
 	router.Methods("GET").Path("/v1/replicas/{id}/volusage").Handler(f(schemas, s.GetVolUsage))
-	router.Methods("DELETE").Path("/v1/replicas/{id}").Handler(f(schemas, s.DeleteReplica))
+	router.Methods("GET").Path("/v1/replicas/{id}/scrub").Handler(f(schemas, s.GetScrubStatus))
+	router.Methods("DELETE").Path("/v1/replicas/{id}").Handler(f(schemas, checkEpoch(s, s.DeleteReplica)))
 
-	router.Methods("DELETE").Path("/v1/delete").Handler(f(schemas, s.DeleteVolume))
+	router.Methods("DELETE").Path("/v1/delete").Handler(f(schemas, checkEpoch(s, s.DeleteVolume)))
 	router.Handle("/metrics", promhttp.Handler())
 
 	// Actions
 	actions := map[string]func(http.ResponseWriter, *http.Request) error{
-		"start":              s.StartReplica,
-		"reload":             s.ReloadReplica,
-		"updatecloneinfo":    s.UpdateCloneInfo,
-		"snapshot":           s.SnapshotReplica,
-		"open":               s.OpenReplica,
-		"close":              s.CloseReplica,
-		"resize":             s.Resize,
-		"removedisk":         s.RemoveDisk,
-		"replacedisk":        s.ReplaceDisk,
-		"setrebuilding":      s.SetRebuilding,
-		"setlogging":         s.SetLogging,
-		"create":             s.Create,
-		"revert":             s.RevertReplica,
-		"prepareremovedisk":  s.PrepareRemoveDisk,
-		"setrevisioncounter": s.SetRevisionCounter,
-		"setreplicamode":     s.SetReplicaMode,
-		"setcheckpoint":      s.SetCheckpoint,
+		"start":                s.StartReplica,
+		"reload":               s.ReloadReplica,
+		"updatecloneinfo":      s.UpdateCloneInfo,
+		"snapshot":             s.SnapshotReplica,
+		"open":                 s.OpenReplica,
+		"close":                s.CloseReplica,
+		"resize":               s.Resize,
+		"removedisk":           s.RemoveDisk,
+		"replacedisk":          s.ReplaceDisk,
+		"setrebuilding":        s.SetRebuilding,
+		"setlogging":           s.SetLogging,
+		"create":               s.Create,
+		"revert":               s.RevertReplica,
+		"prepareremovedisk":    s.PrepareRemoveDisk,
+		"setrevisioncounter":   s.SetRevisionCounter,
+		"setreplicamode":       s.SetReplicaMode,
+		"setcheckpoint":        s.SetCheckpoint,
+		"setreplicationfactor": s.SetReplicationFactor,
+		"scrub":                s.Scrub,
+		"hash":                 s.Hash,
+		"readsnapshot":         s.ReadSnapshot,
+		"writesnapshot":        s.WriteSnapshot,
 	}
 
 	for name, action := range actions {
-		router.Methods("POST").Path("/v1/replicas/{id}").Queries("action", name).Handler(f(schemas, checkAction(s, action)))
+		router.Methods("POST").Path("/v1/replicas/{id}").Queries("action", name).Handler(f(schemas, checkEpoch(s, checkAction(s, action))))
 	}
 	router.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
 
@@ -25,6 +25,7 @@ import (
 
 	"github.com/openebs/jiva/replica"
 	"github.com/openebs/jiva/rpc"
+	"github.com/openebs/jiva/util"
 	"github.com/sirupsen/logrus"
 )
 
@@ -71,7 +72,17 @@ func (s *Server) ListenAndServe() error {
 
 		logrus.Infof("New connection from: %v", conn.RemoteAddr())
 
-		server := rpc.NewServer(conn, s.s)
+		var dataConn net.Conn = conn
+		if config := util.ServerTLSConfig(); config != nil {
+			dataConn, err = rpc.TLSServer(conn, config)
+			if err != nil {
+				logrus.Errorf("Refusing connection from %v, TLS handshake failed: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				continue
+			}
+		}
+
+		server := rpc.NewServer(dataConn, s.s)
 		if err := server.Handle(); err != nil {
 			// ignore err for below operations,as connection may be
 			// closed from the other side and also files may have
@@ -0,0 +1,215 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/openebs/jiva/types"
+	"github.com/openebs/jiva/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// scrubBatchBlocks is the number of blocks verified at once, IOs
+	// are blocked while a batch is verified.
+	scrubBatchBlocks = 256
+	// maxScrubMismatches is the number of corrupted blocks listed in
+	// the scrub status.
+	maxScrubMismatches = 1024
+)
+
+// scrubber holds the status of the last scrub of a replica
+type scrubber struct {
+	sync.Mutex
+	status types.ScrubStatus
+}
+
+func (s *scrubber) start() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.status.State == types.ScrubInProgress {
+		return fmt.Errorf("Scrub already in progress since %v", s.status.StartTime)
+	}
+	s.status = types.ScrubStatus{
+		State:     types.ScrubInProgress,
+		StartTime: util.Now(),
+	}
+	return nil
+}
+
+func (s *scrubber) add(checked, unchecked int64, mismatches []types.ScrubMismatch) {
+	s.Lock()
+	defer s.Unlock()
+	s.status.CheckedBlocks += checked
+	s.status.UncheckedBlocks += unchecked
+	s.status.MismatchedBlocks += int64(len(mismatches))
+	for _, m := range mismatches {
+		if len(s.status.Mismatches) >= maxScrubMismatches {
+			break
+		}
+		s.status.Mismatches = append(s.status.Mismatches, m)
+	}
+}
+
+func (s *scrubber) finish(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.status.EndTime = util.Now()
+	if err != nil {
+		s.status.State = types.ScrubFailed
+		s.status.Error = err.Error()
+		logrus.Errorf("Scrub failed after verifying %d blocks, error: %v", s.status.CheckedBlocks, err)
+		return
+	}
+	s.status.State = types.ScrubCompleted
+	logrus.Infof("Scrub completed, verified: %d blocks, unverified: %d blocks, corrupted: %d blocks",
+		s.status.CheckedBlocks, s.status.UncheckedBlocks, s.status.MismatchedBlocks)
+}
+
+func (s *scrubber) get() types.ScrubStatus {
+	s.Lock()
+	defer s.Unlock()
+	status := s.status
+	status.Mismatches = append([]types.ScrubMismatch(nil), s.status.Mismatches...)
+	return status
+}
+
+type scrubDisk struct {
+	name string
+	disk *checksumDisk
+}
+
+// scrub verifies the data of the disks of the chain against their
+// checksums, walking the extents of each disk.
+func (r *Replica) scrub(sc *scrubber) error {
+	var disks []scrubDisk
+	r.RLock()
+	for i, f := range r.volume.files {
+		if c, ok := f.(*checksumDisk); ok {
+			disks = append(disks, scrubDisk{
+				name: r.activeDiskData[i].Name,
+				disk: c,
+			})
+		}
+	}
+	r.RUnlock()
+
+	for _, d := range disks {
+		logrus.Infof("Scrubbing disk %v", d.name)
+		if err := r.scrubDisk(d, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replica) scrubDisk(d scrubDisk, sc *scrubber) error {
+	var (
+		start, count int64
+		skip         bool
+		err          error
+	)
+	buf := make([]byte, scrubBatchBlocks*checksumBlockSize)
+	flush := func() {
+		if count == 0 || skip || err != nil {
+			return
+		}
+		skip, err = r.scrubBlocks(d, buf[:count*checksumBlockSize], start*checksumBlockSize, sc)
+		count = 0
+	}
+
+	// the generator must be drained even if the disk is skipped
+	generator := newGenerator(&r.volume, d.disk)
+	for block := range generator.Generate() {
+		if count > 0 && block == start+count && count < scrubBatchBlocks {
+			count++
+			continue
+		}
+		flush()
+		start, count = block, 1
+	}
+	flush()
+
+	if err != nil {
+		return err
+	}
+	if skip {
+		logrus.Infof("Skip scrubbing disk %v, it has been modified", d.name)
+		return nil
+	}
+	return generator.Err()
+}
+
+// scrubBlocks verifies the blocks at offset, it returns true if the disk
+// can't be verified anymore as it has left the chain or is coalesced.
+func (r *Replica) scrubBlocks(d scrubDisk, buf []byte, offset int64, sc *scrubber) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.mode == types.CLOSED {
+		return false, fmt.Errorf("Replica closed while scrubbing %v", d.name)
+	}
+	if !r.isScrubbable(d) {
+		return true, nil
+	}
+
+	n, err := d.disk.DiffDisk.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("Failed to read %v at offset %d, error: %v", d.name, offset, err)
+	}
+	offsets, unchecked, err := d.disk.verify(buf[:n-n%checksumBlockSize], offset)
+	if err != nil {
+		return false, err
+	}
+
+	mismatches := make([]types.ScrubMismatch, len(offsets))
+	for i, off := range offsets {
+		logrus.Errorf("Checksum mismatch in %v at offset %d", d.name, off)
+		mismatches[i] = types.ScrubMismatch{Disk: d.name, Offset: off}
+	}
+	sc.add(int64(n/checksumBlockSize)-unchecked, unchecked, mismatches)
+	return false, nil
+}
+
+// isScrubbable returns true if the disk is still in the chain and isn't
+// being coalesced, the data and the checksums of a disk are updated
+// separately by the sync agent while it is coalesced into its parent.
+func (r *Replica) isScrubbable(d scrubDisk) bool {
+	index := r.findDisk(d.name)
+	if index <= 0 || r.volume.files[index] != types.DiffDisk(d.disk) {
+		return false
+	}
+	return !r.isRemoving(d.name)
+}
+
+// isRemoving returns true if the disk or one of its children is marked
+// as removed, it is then going to be coalesced or replaced.
+func (r *Replica) isRemoving(name string) bool {
+	data, ok := r.diskData[name]
+	if !ok || data.Removed {
+		return true
+	}
+	for child := range r.diskChildrenMap[name] {
+		if c, ok := r.diskData[child]; ok && c.Removed {
+			return true
+		}
+	}
+	return false
+}
@@ -61,6 +61,15 @@ type Server struct {
 	MonitorChannel chan struct{}
 	//closeSync      chan struct{}
 	preload bool
+	scrub   scrubber
+	// views are the snapshots being read while verifying the replicas or
+	// exporting the snapshots
+	views snapshotViews
+	// epoch is the one of the controller owning the replica, it is
+	// loaded from the replica directory on first use.
+	epochLock   sync.Mutex
+	epoch       ControllerEpoch
+	epochLoaded bool
 }
 
 func NewServer(address, dir string, sectorSize int64, serverType string) *Server {
@@ -410,7 +419,7 @@ func (s *Server) UpdateLUNMap() error {
 		return fmt.Errorf("UpdateLUNMap failed, s.r not set")
 	}
 	volume := s.r.volume
-	volume.location = make([]uint16, len(s.r.volume.location))
+	volume.location = newLocationMap(s.r.volume.location.len())
 	volume.UsedBlocks = 0
 	volume.UsedLogicalBlocks = 0
 	s.Unlock()
@@ -423,16 +432,15 @@ func (s *Server) UpdateLUNMap() error {
 	s.Lock()
 	var (
 		holeLength          int64
-		holeOffset          int
-		fileIndx            uint16
-		prevHoleFileIndx    uint16
-		offset              int
-		userCreatedSnapIndx uint16
+		holeOffset          int64
+		fileIndx            uint32
+		prevHoleFileIndx    uint32
+		userCreatedSnapIndx uint32
 	)
 	// userCreatedSnapIndx holds the latest user created snapshot index
 	for i, isUserCreated := range volume.UserCreatedSnap {
 		if isUserCreated {
-			userCreatedSnapIndx = uint16(i)
+			userCreatedSnapIndx = uint32(i)
 		}
 	}
 
@@ -443,50 +451,62 @@ func (s *Server) UpdateLUNMap() error {
 	// If offsets are present in both LunMaps and are different,
 	// hole is punched in the file at that offset contained in Preloaded LunMap.
 	// Sequesnce of holes are being punched at once.
+	// Both LunMaps are walked by runs of offsets at the same index in each.
 	var extraUsedBlocks, extraLogicalBlocks int64
 
-	for offset, fileIndx = range volume.location {
+	for offset := int64(0); offset < volume.location.len(); {
+		var next, origNext int64
+		var origFileIndx uint32
+		fileIndx, next = volume.location.extent(offset)
+		origFileIndx, origNext = s.r.volume.location.extent(offset)
+		if origNext < next {
+			next = origNext
+		}
+		count := next - offset
 		if fileIndx == 0 {
-			if s.r.volume.location[offset] != 0 {
-				extraUsedBlocks++
-				extraLogicalBlocks++
+			if origFileIndx != 0 {
+				extraUsedBlocks += count
+				extraLogicalBlocks += count
 			}
+			offset = next
 			continue
 		}
-		if s.r.volume.location[offset] > fileIndx {
+		if origFileIndx > fileIndx {
 			// It is being incremented and decremented to accomodate user
 			// created snapshots
-			if prevHoleFileIndx != fileIndx || int64(offset) != int64(holeOffset)+holeLength {
+			if prevHoleFileIndx != fileIndx || offset != holeOffset+holeLength {
 				if prevHoleFileIndx > userCreatedSnapIndx && shouldCreateHoles() && prevHoleFileIndx != 0 {
 					extraUsedBlocks -= holeLength
-					sendToCreateHole(volume.files[prevHoleFileIndx], int64(holeOffset)*volume.sectorSize, holeLength*volume.sectorSize)
+					sendToCreateHole(volume.files[prevHoleFileIndx], holeOffset*volume.sectorSize, holeLength*volume.sectorSize)
 				}
-				holeLength = 1
+				holeLength = count
 				holeOffset = offset
 				prevHoleFileIndx = fileIndx
 			} else {
-				holeLength++
+				holeLength += count
 			}
-			extraUsedBlocks++
+			extraUsedBlocks += count
 		} else {
 			// No hole drilling over here as that offset is empty or belongs to
 			// same file
-			s.r.volume.location[offset] = volume.location[offset]
+			s.r.volume.location.set(offset, count, fileIndx)
 			if prevHoleFileIndx > userCreatedSnapIndx && shouldCreateHoles() && prevHoleFileIndx != 0 {
 				extraUsedBlocks -= holeLength
-				sendToCreateHole(volume.files[prevHoleFileIndx], int64(holeOffset)*volume.sectorSize, holeLength*volume.sectorSize)
+				sendToCreateHole(volume.files[prevHoleFileIndx], holeOffset*volume.sectorSize, holeLength*volume.sectorSize)
 			}
 			holeOffset = 0
 			holeLength = 0
 			prevHoleFileIndx = 0
 		}
+		offset = next
 	}
 	if prevHoleFileIndx > userCreatedSnapIndx && shouldCreateHoles() && prevHoleFileIndx != 0 {
 		extraUsedBlocks -= holeLength
-		sendToCreateHole(volume.files[prevHoleFileIndx], int64(holeOffset)*volume.sectorSize, holeLength*volume.sectorSize)
+		sendToCreateHole(volume.files[prevHoleFileIndx], holeOffset*volume.sectorSize, holeLength*volume.sectorSize)
 	}
 	s.r.volume.UsedLogicalBlocks = volume.UsedLogicalBlocks + extraLogicalBlocks
 	s.r.volume.UsedBlocks = volume.UsedBlocks + extraUsedBlocks + int64(len(s.r.volume.files)-1) + 2 // For Metadata files, volume.meta, revisionCounter
+	s.r.lunMapComplete = true
 
 	s.Unlock()
 	return nil
@@ -600,6 +620,10 @@ func (s *Server) Close() error {
 		return nil
 	}
 
+	s.views.Lock()
+	s.views.close()
+	s.views.Unlock()
+
 	// r.holeDrainer is initialized at construct
 	// function in replica.go
 	s.r.holeDrainer()
@@ -680,6 +704,18 @@ func (s *Server) SetCheckpoint(snapshotName string) error {
 	return s.r.SetCheckpoint(snapshotName)
 }
 
+// SetReplicationFactor persists the replication factor of the volume in
+// the metadata of the open replica.
+func (s *Server) SetReplicationFactor(rf int) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.r == nil {
+		return fmt.Errorf("SetReplicationFactor failed, s.r not set")
+	}
+	return s.r.SetReplicationFactor(rf)
+}
+
 func (s *Server) SetRevisionCounter(counter int64) error {
 	s.Lock()
 	defer s.Unlock()
@@ -690,6 +726,32 @@ func (s *Server) SetRevisionCounter(counter int64) error {
 	return s.r.SetRevisionCounter(counter)
 }
 
+// StartScrub verifies the data of the replica against its checksums in
+// the background, the result is returned by ScrubStatus.
+func (s *Server) StartScrub() error {
+	s.RLock()
+	r := s.r
+	s.RUnlock()
+
+	if r == nil {
+		return fmt.Errorf("Scrub failed, s.r not set")
+	}
+	if err := s.scrub.start(); err != nil {
+		return err
+	}
+
+	logrus.Infof("Starting scrub")
+	go func() {
+		s.scrub.finish(r.scrub(&s.scrub))
+	}()
+	return nil
+}
+
+// ScrubStatus returns the progress and the result of the last scrub
+func (s *Server) ScrubStatus() types.ScrubStatus {
+	return s.scrub.get()
+}
+
 func (s *Server) PingResponse() error {
 	state, _ := s.Status()
 	if state != Open && state != Dirty && state != Rebuilding {
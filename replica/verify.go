@@ -0,0 +1,274 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MaxVerifyLength is the largest range hashed, read or written at once
+// while verifying the replicas.
+const MaxVerifyLength = 64 << 20
+
+// maxSnapshotViews is the number of snapshots kept open at once, the
+// snapshots being verified and the ones exported read only.
+const maxSnapshotViews = 4
+
+// snapshotView is a read only replica whose head is a snapshot, it reads
+// the data of the volume as it was when the snapshot was taken. It is kept
+// open between the requests of a verification or of an export as long as
+// the chain of the replica doesn't change.
+type snapshotView struct {
+	parent *Replica
+	disk   string
+	chain  []string
+	r      *Replica
+}
+
+func (v *snapshotView) open(parent *Replica, dir string, backing *BackingFile, disk string) (*Replica, error) {
+	chain, err := parent.Chain()
+	if err != nil {
+		return nil, err
+	}
+	if v.r != nil && v.parent == parent && v.disk == disk && reflect.DeepEqual(v.chain, chain) {
+		return v.r, nil
+	}
+	v.close()
+
+	found := false
+	for _, name := range chain[1:] {
+		if name == disk {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Failed to find snapshot %s in chain", disk)
+	}
+
+	r, err := NewReadOnly(true, dir, disk, backing)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open snapshot %s, error: %v", disk, err)
+	}
+	v.parent, v.disk, v.chain, v.r = parent, disk, chain, r
+	return r, nil
+}
+
+func (v *snapshotView) close() {
+	if v.r == nil {
+		return
+	}
+	if err := v.r.Close(); err != nil {
+		logrus.Warningf("Failed to close snapshot %s, error: %v", v.disk, err)
+	}
+	v.parent, v.disk, v.chain, v.r = nil, "", nil, nil
+}
+
+// snapshotViews are the open snapshots, the most recently read first
+type snapshotViews struct {
+	sync.Mutex
+	views []*snapshotView
+}
+
+// open returns the view of the snapshot, the least recently read one is
+// closed if too many snapshots are open.
+func (s *snapshotViews) open(parent *Replica, dir string, backing *BackingFile, disk string) (*Replica, error) {
+	var v *snapshotView
+	for i := range s.views {
+		if s.views[i].disk == disk {
+			v = s.views[i]
+			s.views = append(s.views[:i], s.views[i+1:]...)
+			break
+		}
+	}
+	if v == nil {
+		v = &snapshotView{}
+		if len(s.views) >= maxSnapshotViews {
+			s.views[len(s.views)-1].close()
+			s.views = s.views[:len(s.views)-1]
+		}
+	}
+
+	r, err := v.open(parent, dir, backing, disk)
+	if err != nil {
+		v.close()
+		return nil, err
+	}
+	s.views = append([]*snapshotView{v}, s.views...)
+	return r, nil
+}
+
+func (s *snapshotViews) close() {
+	for _, v := range s.views {
+		v.close()
+	}
+	s.views = nil
+}
+
+// withSnapshot calls fn with a replica reading the data of the snapshot,
+// or with the replica itself if snapshot is empty.
+func (s *Server) withSnapshot(snapshot string, fn func(r *Replica) error) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.r == nil {
+		return fmt.Errorf("Volume no longer exist")
+	}
+	if snapshot == "" {
+		return fn(s.r)
+	}
+
+	s.views.Lock()
+	defer s.views.Unlock()
+	r, err := s.views.open(s.r, s.dir, s.backing, snapshot)
+	if err != nil {
+		return err
+	}
+	return fn(r)
+}
+
+func checkVerifyRange(r *Replica, offset, length int64) error {
+	if offset < 0 || length <= 0 || length > MaxVerifyLength ||
+		offset%defaultSectorSize != 0 || length%defaultSectorSize != 0 {
+		return fmt.Errorf("Invalid range, offset: %d, length: %d", offset, length)
+	}
+	if offset+length > r.info.Size {
+		return fmt.Errorf("Range at offset %d of %d bytes is beyond volume size %d", offset, length, r.info.Size)
+	}
+	return nil
+}
+
+// HashData returns the SHA256 of the blocks of blockSize bytes of a range
+// of the snapshot, or of the head if snapshot is empty. The hash of a
+// block which can't be read, such as a block failing its checksum, is
+// empty.
+func (s *Server) HashData(snapshot string, offset, length, blockSize int64) ([]string, error) {
+	var hashes []string
+	err := s.withSnapshot(snapshot, func(r *Replica) error {
+		if err := checkVerifyRange(r, offset, length); err != nil {
+			return err
+		}
+		if blockSize <= 0 || blockSize%defaultSectorSize != 0 || length%blockSize != 0 {
+			return fmt.Errorf("Invalid block size %d for a range of %d bytes", blockSize, length)
+		}
+
+		buf := make([]byte, blockSize)
+		hashes = make([]string, 0, length/blockSize)
+		for off := offset; off < offset+length; off += blockSize {
+			if _, err := r.ReadAt(buf, off); err != nil {
+				logrus.Warningf("Failed to read %d bytes at offset %d of %q, error: %v", blockSize, off, snapshot, err)
+				hashes = append(hashes, "")
+				continue
+			}
+			sum := sha256.Sum256(buf)
+			hashes = append(hashes, hex.EncodeToString(sum[:]))
+		}
+		return nil
+	})
+	return hashes, err
+}
+
+// ReadSnapshotAt reads a range of the snapshot, or of the head if snapshot
+// is empty.
+func (s *Server) ReadSnapshotAt(snapshot string, offset, length int64) ([]byte, error) {
+	var buf []byte
+	err := s.withSnapshot(snapshot, func(r *Replica) error {
+		if err := checkVerifyRange(r, offset, length); err != nil {
+			return err
+		}
+		buf = make([]byte, length)
+		_, err := r.ReadAt(buf, offset)
+		return err
+	})
+	return buf, err
+}
+
+// WriteSnapshotAt overwrites a range of a snapshot to repair it
+func (s *Server) WriteSnapshotAt(snapshot string, buf []byte, offset int64) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.r == nil {
+		return fmt.Errorf("Volume no longer exist")
+	}
+	if err := checkVerifyRange(s.r, offset, int64(len(buf))); err != nil {
+		return err
+	}
+
+	// the open views may have cached the location of the sectors
+	s.views.Lock()
+	s.views.close()
+	s.views.Unlock()
+
+	return s.r.WriteSnapshotAt(snapshot, buf, offset)
+}
+
+// WriteSnapshotAt writes the data in the snapshot disk, the sectors whose
+// data was read from the disks below the snapshot are now read from it.
+func (r *Replica) WriteSnapshotAt(disk string, buf []byte, offset int64) error {
+	if r.readOnly {
+		return fmt.Errorf("Can not write on read-only replica")
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	index := r.findDisk(disk)
+	if index <= 0 || index == len(r.volume.files)-1 || r.isBackingFile(index) {
+		return fmt.Errorf("Failed to find snapshot %s in chain", disk)
+	}
+	if r.isRemoving(disk) {
+		return fmt.Errorf("Can not write snapshot %s, it is being removed", disk)
+	}
+
+	f := r.volume.files[index]
+	if _, err := f.WriteAt(buf, offset); err != nil {
+		return fmt.Errorf("Failed to write snapshot %s at offset %d, error: %v", disk, offset, err)
+	}
+	if err := syscall.Fsync(int(f.Fd())); err != nil {
+		return fmt.Errorf("Failed to sync snapshot %s, error: %v", disk, err)
+	}
+	if c, ok := f.(*checksumDisk); ok {
+		if err := c.syncSums(); err != nil {
+			return err
+		}
+	}
+
+	start := offset / r.volume.sectorSize
+	end := (offset + int64(len(buf))) / r.volume.sectorSize
+	if end > r.volume.location.len() {
+		end = r.volume.location.len()
+	}
+	for sector := start; sector < end; {
+		val, next := r.volume.location.extent(sector)
+		if next > end {
+			next = end
+		}
+		if val != 0 && val < uint32(index) {
+			r.volume.location.set(sector, next-sector, uint32(index))
+		}
+		sector = next
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package replica
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/openebs/jiva/types"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestWriteSnapshotAt(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+
+	buf := make([]byte, 2*b)
+	fill(buf, 1)
+	_, err = r.WriteAt(buf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(r.Snapshot("000", true, getNow()), IsNil)
+	c.Assert(r.Snapshot("001", true, getNow()), IsNil)
+	fill(buf[:b], 2)
+	_, err = r.WriteAt(buf[:b], b)
+	c.Assert(err, IsNil)
+
+	// repair the block 0 in the snapshot 001, which didn't hold it
+	repair := make([]byte, 2*b)
+	fill(repair, 3)
+	c.Assert(r.WriteSnapshotAt("volume-snap-001.img", repair, 0), IsNil)
+	c.Assert(r.WriteSnapshotAt("volume-head-002.img", repair, 0), ErrorMatches, "Failed to find snapshot .*")
+
+	// the head still overrides the snapshot
+	readBuf := make([]byte, 2*b)
+	_, err = r.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(readBuf[:b], DeepEquals, repair[:b])
+	c.Assert(readBuf[b:], DeepEquals, buf[:b])
+
+	// the snapshot and its checksums hold the repaired data
+	view, err := NewReadOnly(true, dir, "volume-snap-001.img", nil)
+	c.Assert(err, IsNil)
+	defer view.Close()
+	_, err = view.ReadAt(readBuf, 0)
+	c.Assert(err, IsNil)
+	c.Assert(readBuf, DeepEquals, repair)
+	status := scrubReplica(c, r)
+	c.Assert(status.MismatchedBlocks, Equals, int64(0))
+	c.Assert(status.CheckedBlocks, Equals, int64(5))
+}
+
+func (s *TestSuite) TestReadSnapshotViews(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+	server := &Server{r: r, dir: dir}
+	defer server.views.close()
+
+	buf := make([]byte, b)
+	snapshots := maxSnapshotViews + 2
+	for i := 0; i < snapshots; i++ {
+		fill(buf, byte(i+1))
+		_, err = r.WriteAt(buf, 0)
+		c.Assert(err, IsNil)
+		c.Assert(r.Snapshot(fmt.Sprintf("%03d", i), true, getNow()), IsNil)
+	}
+
+	// more snapshots are read than kept open, and read again once closed
+	for round := 0; round < 2; round++ {
+		for i := 0; i < snapshots; i++ {
+			data, err := server.ReadSnapshotAt(GenerateSnapshotDiskName(fmt.Sprintf("%03d", i)), 0, b)
+			c.Assert(err, IsNil)
+			fill(buf, byte(i+1))
+			c.Assert(data, DeepEquals, buf)
+			c.Assert(len(server.views.views) <= maxSnapshotViews, Equals, true)
+		}
+	}
+	_, err = server.ReadSnapshotAt("volume-snap-missing.img", 0, b)
+	c.Assert(err, ErrorMatches, "Failed to find snapshot .*")
+}
+
+func (s *TestSuite) TestReadSnapshotViewsKeepDuplicates(c *C) {
+	dir, err := ioutil.TempDir("", "replica")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r, err := New(true, 10*b, b, dir, nil, "Backend")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	err = r.SetReplicaMode("RW")
+	c.Assert(err, IsNil)
+	server := &Server{r: r, dir: dir}
+	defer server.views.close()
+
+	// the block 0 is written in two auto-created snapshots
+	buf := make([]byte, b)
+	for i := 0; i < 2; i++ {
+		fill(buf, byte(i+1))
+		_, err = r.WriteAt(buf, 0)
+		c.Assert(err, IsNil)
+		c.Assert(r.Snapshot(fmt.Sprintf("%03d", i), false, getNow()), IsNil)
+	}
+
+	punchHoles := types.ShouldPunchHoles
+	types.ShouldPunchHoles = true
+	defer func() { types.ShouldPunchHoles = punchHoles }()
+	holes := len(HoleCreatorChan)
+	data, err := server.ReadSnapshotAt(GenerateSnapshotDiskName("001"), 0, b)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, buf)
+	hashes, err := server.HashData(GenerateSnapshotDiskName("001"), 0, b, b)
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, 1)
+
+	// the views don't punch the files of the live replica
+	c.Assert(len(HoleCreatorChan), Equals, holes)
+	old := make([]byte, b)
+	_, err = r.volume.files[1].ReadAt(old, 0)
+	c.Assert(err, IsNil)
+	fill(buf, 1)
+	c.Assert(old, DeepEquals, buf)
+}
@@ -73,6 +73,8 @@ const (
 	OpSync
 	//OpUnmap unmap replica
 	OpUnmap
+	//OpHandshake claim replica
+	OpHandshake
 )
 
 //Client replica client
@@ -87,13 +89,16 @@ type Client struct {
 	wire      *Wire
 	peerAddr  string
 	err       error
+	version   uint16
 }
 
-//NewClient replica client
-func NewClient(conn net.Conn, closeChan chan struct{}) *Client {
+//NewClient replica client, version is the protocol negotiated with the
+//replica using NegotiateVersion
+func NewClient(conn net.Conn, closeChan chan struct{}, version uint16) *Client {
 	c := &Client{
 		wire:      NewWire(conn),
 		peerAddr:  conn.RemoteAddr().String(),
+		version:   version,
 		end:       make(chan struct{}, 1024),
 		requests:  make(chan *Message, 1024),
 		send:      make(chan *Message, 1024),
@@ -159,7 +164,19 @@ func (c *Client) Ping() error {
 	return err
 }
 
-func (c *Client) operation(op uint32, buf []byte, offset int64, length int64) (int, error) {
+//Handshake claims the replica for the controller with the given epoch and
+//ID, the replica rejects the messages of the controllers with an older
+//epoch from then on.
+func (c *Client) Handshake(epoch int64, id string) error {
+	_, err := c.operation(TypeHandshake, []byte(id), epoch, int64(len(id)))
+	return err
+}
+
+func (c *Client) operation(op uint32, buf []byte, offset int64, length int64) (n int, err error) {
+	start := time.Now()
+	defer func() {
+		observeOperation(c.peerAddr, op, start, err)
+	}()
 	retry := 0
 	for {
 		msg := Message{
@@ -169,7 +186,7 @@ func (c *Client) operation(op uint32, buf []byte, offset int64, length int64) (i
 			Size:     int64(length),
 			Data:     nil,
 		}
-		if op == TypeWrite {
+		if op == TypeWrite || op == TypeHandshake {
 			msg.Data = buf
 		}
 
@@ -209,6 +226,11 @@ func (c *Client) operation(op uint32, buf []byte, offset int64, length int64) (i
 					msg.Seq, op, c.peerAddr)
 				return 0, errors.New(string(msg.Data))
 			}
+			if msg.Type == TypeChecksumError {
+				logrus.Errorf("replying TypeChecksumError for seq %v of type %v on addr %s",
+					msg.Seq, op, c.peerAddr)
+				return 0, &types.ChecksumError{Msg: string(msg.Data)}
+			}
 			if msg.Type == TypeEOF {
 				logrus.Errorf("replying TypeEOF for seq %v of type %v on addr %s",
 					msg.Seq, op, c.peerAddr)
@@ -259,6 +281,7 @@ func (c *Client) Close() error {
 		}
 		time.Sleep(2 * time.Second)
 	}
+	deleteOperationMetrics(c.peerAddr)
 	return c.wire.Close()
 }
 
@@ -313,13 +336,15 @@ func (c *Client) handleRequest(req *Message) {
 		req.ID = journal.InsertPendingOp(time.Now(), c.TargetID(), journal.SampleOp(OpPing), 0)
 	case TypeUpdate:
 		req.ID = journal.InsertPendingOp(time.Now(), c.TargetID(), journal.SampleOp(OpUpdate), 0)
+	case TypeHandshake:
+		req.ID = journal.InsertPendingOp(time.Now(), c.TargetID(), journal.SampleOp(OpHandshake), 0)
 	}
 	if c.err != nil {
 		c.replyError(req)
 		return
 	}
 
-	req.MagicVersion = MagicVersion
+	req.MagicVersion = c.version
 	req.Seq = c.nextSeq()
 	c.messages[req.Seq] = req
 	c.send <- req
@@ -370,6 +395,9 @@ func (c *Client) write() {
 func (c *Client) read() {
 	for {
 		msg, err := c.wire.Read()
+		// A corrupted response can't be trusted to carry the right
+		// seq, fail the connection so every pending I/O errors out and
+		// the replica is set to ERR.
 		if err != nil {
 			logrus.Errorf("Error reading from wire: %v, RemoteAddr: %v", err, c.peerAddr)
 			c.SetError(err)
@@ -0,0 +1,79 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rpc
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// rpcDuration is the latency of the operations sent to the replicas,
+	// by replica and operation.
+	rpcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "openebs_jiva_replica_rpc_duration_seconds",
+			Help:    "Latency of the operations sent to the replicas.",
+			Buckets: []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"replica", "op"},
+	)
+	// rpcErrors counts the operations which failed or timed out
+	rpcErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "openebs_jiva_replica_rpc_errors_total",
+			Help: "Number of the operations sent to the replicas which failed.",
+		},
+		[]string{"replica", "op"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rpcDuration)
+	prometheus.MustRegister(rpcErrors)
+}
+
+// opNames are the names of the operations in the metrics
+var opNames = map[uint32]string{
+	TypeRead:      "read",
+	TypeWrite:     "write",
+	TypePing:      "ping",
+	TypeSync:      "sync",
+	TypeUnmap:     "unmap",
+	TypeHandshake: "handshake",
+}
+
+func observeOperation(replica string, op uint32, start time.Time, err error) {
+	name, ok := opNames[op]
+	if !ok {
+		return
+	}
+	rpcDuration.WithLabelValues(replica, name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		rpcErrors.WithLabelValues(replica, name).Inc()
+	}
+}
+
+// deleteOperationMetrics removes the metrics of a replica once it is
+// disconnected, so that they don't outlive it.
+func deleteOperationMetrics(replica string) {
+	for _, name := range opNames {
+		rpcDuration.DeleteLabelValues(replica, name)
+		rpcErrors.DeleteLabelValues(replica, name)
+	}
+}
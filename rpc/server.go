@@ -34,6 +34,17 @@ import (
 
 type operation func(*Message)
 
+// Fencer is implemented by the DataProcessors which reject the messages
+// of a controller once a newer one has claimed them.
+type Fencer interface {
+	// Claim records the controller with the given epoch and ID as the
+	// owner, it fails if the epoch is stale.
+	Claim(epoch int64, id string) error
+	// CheckEpoch returns an error if the controller with the given epoch
+	// and ID isn't the owner.
+	CheckEpoch(epoch int64, id string) error
+}
+
 type Server struct {
 	wire        *Wire
 	responses   chan *Message
@@ -45,6 +56,11 @@ type Server struct {
 	// is received from client.
 	pingRecvd time.Time
 	rwExit    bool
+	// epoch and controllerID are the ones of the controller which
+	// claimed the data on this connection.
+	epoch        int64
+	controllerID string
+	fenced       bool
 }
 
 func NewServer(conn net.Conn, data types.DataProcessor) *Server {
@@ -122,6 +138,15 @@ func (s *Server) readWrite(ret chan<- error) {
 	for {
 		inject.AddPingTimeout()
 		msg, err := s.wire.Read()
+		if err == ErrChecksumMismatch {
+			// The seq of a corrupted request can't be trusted to
+			// reply on, close the connection so that the controller
+			// fails every pending I/O and sets this replica to ERR.
+			logrus.Errorf("Closing connection on corrupted request: %v", err)
+			_ = s.wire.Close()
+			ret <- err
+			break
+		}
 		if err == io.EOF {
 			logrus.Errorf("Received EOF: %v", err)
 			ret <- err
@@ -134,7 +159,7 @@ func (s *Server) readWrite(ret chan<- error) {
 			// such as Prometheus which by default scraps from all the open
 			// ports.
 			if msg != nil {
-				if msg.MagicVersion != MagicVersion {
+				if !IsSupportedVersion(msg.MagicVersion) {
 					logrus.Warningf("Failed to serve client: %v, rejecting request, invalid client", s.wire.conn.RemoteAddr())
 				}
 			}
@@ -142,22 +167,27 @@ func (s *Server) readWrite(ret chan<- error) {
 			break
 		}
 
-		switch msg.Type {
-		case TypeRead:
-			timed(s.handleRead, msg)
-		case TypeWrite:
-			timed(s.handleWrite, msg)
-		case TypePing:
-			timed(s.handlePing, msg)
-		case TypeSync:
-			timed(s.handleSync, msg)
-		case TypeUnmap:
-			timed(s.handleUnmap, msg)
-			/*
-				case TypeUpdate:
-					go s.handleUpdate(msg)
-			*/
-			break
+		if err := s.checkEpoch(msg); err != nil {
+			s.createResponse(0, msg, err)
+		} else {
+			switch msg.Type {
+			case TypeRead:
+				timed(s.handleRead, msg)
+			case TypeWrite:
+				timed(s.handleWrite, msg)
+			case TypePing:
+				timed(s.handlePing, msg)
+			case TypeSync:
+				timed(s.handleSync, msg)
+			case TypeUnmap:
+				timed(s.handleUnmap, msg)
+			case TypeHandshake:
+				s.handleHandshake(msg)
+				/*
+					case TypeUpdate:
+						go s.handleUpdate(msg)
+				*/
+			}
 		}
 
 		if err := s.write(msg); err != nil {
@@ -245,6 +275,38 @@ func (s *Server) handleUnmap(msg *Message) {
 	}
 }
 
+// checkEpoch rejects the messages of a controller which has been replaced
+// by a newer one. The connections without handshake are the ones of the
+// controllers which don't know about epochs, they are served as long as
+// no controller claimed the data.
+func (s *Server) checkEpoch(msg *Message) error {
+	fencer, ok := s.data.(Fencer)
+	if !ok || msg.Type == TypeHandshake {
+		return nil
+	}
+	err := fencer.CheckEpoch(s.epoch, s.controllerID)
+	if err != nil && !s.fenced {
+		logrus.Warningf("Rejecting the messages from %v: %v", s.wire.conn.RemoteAddr(), err)
+		s.fenced = true
+	}
+	return err
+}
+
+func (s *Server) handleHandshake(msg *Message) {
+	epoch, id := msg.Offset, string(msg.Data)
+	msg.Data = nil
+	if fencer, ok := s.data.(Fencer); ok {
+		if err := fencer.Claim(epoch, id); err != nil {
+			logrus.Errorf("Failed to claim for controller %s with epoch %d, error: %v", id, epoch, err)
+			s.createResponse(0, msg, err)
+			return
+		}
+	}
+	logrus.Infof("Claimed by controller %s with epoch %d", id, epoch)
+	s.epoch, s.controllerID, s.fenced = epoch, id, false
+	s.createResponse(0, msg, nil)
+}
+
 /*
 func (s *Server) handleUpdate(msg *Message) {
 	err := s.data.Update()
@@ -252,8 +314,10 @@ func (s *Server) handleUpdate(msg *Message) {
 }
 */
 
+// createResponse turns msg into the response to it, it keeps the
+// MagicVersion of the request so that clients which don't know about
+// the checksums can still be served.
 func (s *Server) createResponse(count int, msg *Message, err error) {
-	msg.MagicVersion = MagicVersion
 	msg.Size = int64(len(msg.Data))
 	if msg.Type == TypeWrite {
 		msg.Data = nil
@@ -267,6 +331,9 @@ func (s *Server) createResponse(count int, msg *Message, err error) {
 		msg.Size = int64(len(msg.Data))
 	} else if err != nil {
 		msg.Type = TypeError
+		if types.IsChecksumError(err) && msg.MagicVersion == MagicVersion {
+			msg.Type = TypeChecksumError
+		}
 		msg.Data = []byte(err.Error())
 		msg.Size = int64(len(msg.Data))
 	}
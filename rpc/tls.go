@@ -0,0 +1,66 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rpc
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsConn is a TLS connection which can still be half closed like the
+// TCP connection it runs on, Wire relies on it to stop its reader and
+// writer.
+type tlsConn struct {
+	*tls.Conn
+	tcp *net.TCPConn
+}
+
+func (c *tlsConn) CloseRead() error {
+	return c.tcp.CloseRead()
+}
+
+func (c *tlsConn) CloseWrite() error {
+	return c.tcp.CloseWrite()
+}
+
+func handshake(conn *tls.Conn, tcp *net.TCPConn) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout)); err != nil {
+		return nil, err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return &tlsConn{Conn: conn, tcp: tcp}, nil
+}
+
+// TLSClient runs the TLS handshake of the controller on conn, it fails
+// if the replica can't be authenticated.
+func TLSClient(conn *net.TCPConn, config *tls.Config) (net.Conn, error) {
+	return handshake(tls.Client(conn, config), conn)
+}
+
+// TLSServer runs the TLS handshake of the replica on conn, it fails if
+// the controller can't be authenticated.
+func TLSServer(conn *net.TCPConn, config *tls.Config) (net.Conn, error) {
+	return handshake(tls.Server(conn, config), conn)
+}
@@ -32,16 +32,48 @@ const (
 	TypeUpdate
 	TypeSync
 	TypeUnmap
+	// TypeHandshake claims the replica for the epoch of the controller,
+	// given by the offset, and its ID in the data.
+	TypeHandshake
+	// TypeChecksumError is the reply to a read of corrupted data, it is
+	// only sent to the clients of MagicVersion, the others get a
+	// TypeError.
+	TypeChecksumError
 
 	messageSize     = (32 + 32 + 32 + 64) / 8 //TODO: unused?
 	readBufferSize  = 8096
 	writeBufferSize = 8096
+	// maxDataSize bounds the data of a message, so that a corrupted
+	// length isn't allocated.
+	maxDataSize = 64 << 20
 )
 
 const (
-	MagicVersion = uint16(0x1b03) // Jiva03
+	// MagicVersion is the current protocol, every message carries a
+	// CRC32C of its header and data.
+	MagicVersion = uint16(0x1b04) // Jiva04
+	// MagicVersionNoChecksum is the protocol of the replicas which don't
+	// checksum the messages, it is still spoken with them during a
+	// rolling upgrade.
+	MagicVersionNoChecksum = uint16(0x1b03) // Jiva03
 )
 
+// IsSupportedVersion returns true if messages of the given version can be
+// read and written.
+func IsSupportedVersion(version uint16) bool {
+	return version == MagicVersion || version == MagicVersionNoChecksum
+}
+
+// NegotiateVersion returns the protocol to use with a peer supporting up
+// to peerVersion, peers which don't advertise it only know
+// MagicVersionNoChecksum.
+func NegotiateVersion(peerVersion uint16) uint16 {
+	if peerVersion >= MagicVersion {
+		return MagicVersion
+	}
+	return MagicVersionNoChecksum
+}
+
 type Message struct {
 	Complete chan struct{}
 
@@ -22,7 +22,9 @@ package rpc
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net"
 	"sync"
@@ -47,6 +49,14 @@ func NewWire(conn net.Conn) *Wire {
 	}
 }
 
+// ErrChecksumMismatch is returned by Read when the CRC32C of a message
+// doesn't match its content. Nothing in the message can be trusted, a
+// corrupted length leaves the stream out of sync, so the connection must
+// be closed.
+var ErrChecksumMismatch = errors.New("RPC message checksum mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 func (w *Wire) Write(msg *Message) error {
 	w.WriteLock.Lock()
 	defer w.WriteLock.Unlock()
@@ -54,32 +64,47 @@ func (w *Wire) Write(msg *Message) error {
 		logrus.Errorf("Write MAgicVersion failed, Error: %v", err)
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, msg.Seq); err != nil {
+
+	// Everything after the magic version goes through the checksum
+	var (
+		writer io.Writer = w.writer
+		crc              = crc32.New(crc32cTable)
+	)
+	if msg.MagicVersion == MagicVersion {
+		writer = io.MultiWriter(w.writer, crc)
+	}
+	if err := binary.Write(writer, binary.LittleEndian, msg.Seq); err != nil {
 		logrus.Errorf("Write msg.Seq failed, Error: %v", err)
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, msg.Type); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, msg.Type); err != nil {
 		logrus.Errorf("Write msg.Type failed, Error: %v", err)
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, msg.Offset); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, msg.Offset); err != nil {
 		logrus.Errorf("Write msg.Offset failed, Error: %v", err)
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, msg.Size); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, msg.Size); err != nil {
 		logrus.Errorf("Write msg.Size failed, Error: %v", err)
 		return err
 	}
-	if err := binary.Write(w.writer, binary.LittleEndian, uint32(len(msg.Data))); err != nil {
+	if err := binary.Write(writer, binary.LittleEndian, uint32(len(msg.Data))); err != nil {
 		logrus.Errorf("Write len(msg.Data) failed, Error: %v", err)
 		return err
 	}
 	if len(msg.Data) > 0 {
-		if _, err := w.writer.Write(msg.Data); err != nil {
+		if _, err := writer.Write(msg.Data); err != nil {
 			logrus.Errorf("Write msg.Data failed, Error: %v", err)
 			return err
 		}
 	}
+	if msg.MagicVersion == MagicVersion {
+		if err := binary.Write(w.writer, binary.LittleEndian, crc.Sum32()); err != nil {
+			logrus.Errorf("Write checksum failed, Error: %v", err)
+			return err
+		}
+	}
 	return w.writer.Flush()
 }
 
@@ -95,45 +120,76 @@ func (w *Wire) Read() (*Message, error) {
 		logrus.Errorf("Read msg.Version failed, Error: %v", err)
 		return nil, err
 	}
-	if msg.MagicVersion != MagicVersion {
-		return &msg, fmt.Errorf("Wrong API version received: 0x%x", &msg.MagicVersion)
+	if !IsSupportedVersion(msg.MagicVersion) {
+		return &msg, fmt.Errorf("Wrong API version received: 0x%x", msg.MagicVersion)
 	}
-	if err := binary.Read(w.reader, binary.LittleEndian, &msg.Seq); err != nil {
+
+	var (
+		reader = w.reader
+		crc    = crc32.New(crc32cTable)
+	)
+	if msg.MagicVersion == MagicVersion {
+		reader = io.TeeReader(w.reader, crc)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &msg.Seq); err != nil {
 		logrus.Errorf("Read msg.Seq failed, Error: %v", err)
 		return nil, err
 	}
 
-	if err := binary.Read(w.reader, binary.LittleEndian, &msg.Type); err != nil {
+	if err := binary.Read(reader, binary.LittleEndian, &msg.Type); err != nil {
 		logrus.Errorf("Read msg.Type failed, Error: %v", err)
 		return nil, err
 	}
 
-	if err := binary.Read(w.reader, binary.LittleEndian, &msg.Offset); err != nil {
+	if err := binary.Read(reader, binary.LittleEndian, &msg.Offset); err != nil {
 		logrus.Errorf("Read msg.Offset failed, Error: %v", err)
 		return nil, err
 	}
-	if err := binary.Read(w.reader, binary.LittleEndian, &msg.Size); err != nil {
+	if err := binary.Read(reader, binary.LittleEndian, &msg.Size); err != nil {
 		logrus.Errorf("Read msg.Size failed, Error: %v", err)
 		return nil, err
 	}
 
-	if err := binary.Read(w.reader, binary.LittleEndian, &length); err != nil {
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
 		logrus.Errorf("Read length failed, Error: %v", err)
 		return nil, err
 	}
+	if length > maxDataSize {
+		logrus.Errorf("Read length %v greater than %v for seq %v", length, maxDataSize, msg.Seq)
+		return nil, fmt.Errorf("RPC message length %v greater than %v", length, maxDataSize)
+	}
 	if length > 0 {
 		msg.Data = make([]byte, length)
-		if _, err := io.ReadFull(w.reader, msg.Data); err != nil {
+		if _, err := io.ReadFull(reader, msg.Data); err != nil {
 			logrus.Errorf("Read msg.Data failed, Error: %v", err)
 			return nil, err
 		}
 	}
 
+	if msg.MagicVersion == MagicVersion {
+		var sum uint32
+		if err := binary.Read(w.reader, binary.LittleEndian, &sum); err != nil {
+			logrus.Errorf("Read checksum failed, Error: %v", err)
+			return nil, err
+		}
+		if sum != crc.Sum32() {
+			logrus.Errorf("Checksum mismatch for seq %v of type %v: got 0x%x, expected 0x%x",
+				msg.Seq, msg.Type, crc.Sum32(), sum)
+			return &msg, ErrChecksumMismatch
+		}
+	}
+
 	return &msg, nil
 }
 
+// halfCloser is implemented by *net.TCPConn and *tlsConn
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
 func (w *Wire) CloseRead() error {
-	if conn, ok := w.conn.(*net.TCPConn); ok {
+	if conn, ok := w.conn.(halfCloser); ok {
 		logrus.Info("Closing read on RPC connection")
 		return conn.CloseRead()
 	}
@@ -141,7 +197,7 @@ func (w *Wire) CloseRead() error {
 }
 
 func (w *Wire) CloseWrite() error {
-	if conn, ok := w.conn.(*net.TCPConn); ok {
+	if conn, ok := w.conn.(halfCloser); ok {
 		logrus.Info("Closing write on RPC connection")
 		return conn.CloseWrite()
 	}
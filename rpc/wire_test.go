@@ -0,0 +1,297 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/openebs/jiva/types"
+)
+
+func newBufferWire(buf *bytes.Buffer) *Wire {
+	return &Wire{
+		writer: bufio.NewWriter(buf),
+		reader: buf,
+	}
+}
+
+func testMessage(version uint16) *Message {
+	return &Message{
+		MagicVersion: version,
+		Seq:          7,
+		Type:         TypeWrite,
+		Offset:       4096,
+		Size:         8,
+		Data:         []byte("jivadata"),
+	}
+}
+
+func TestWireRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   uint16
+		frameSize int
+	}{
+		{"checksum", MagicVersion, 2 + 4 + 4 + 8 + 8 + 4 + 8 + 4},
+		{"no checksum", MagicVersionNoChecksum, 2 + 4 + 4 + 8 + 8 + 4 + 8},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			w := newBufferWire(buf)
+			sent := testMessage(tt.version)
+			if err := w.Write(sent); err != nil {
+				t.Fatalf("Write() failed: %v", err)
+			}
+			if buf.Len() != tt.frameSize {
+				t.Errorf("frame is %d bytes, expected %d", buf.Len(), tt.frameSize)
+			}
+			got, err := w.Read()
+			if err != nil {
+				t.Fatalf("Read() failed: %v", err)
+			}
+			if got.MagicVersion != sent.MagicVersion || got.Seq != sent.Seq || got.Type != sent.Type ||
+				got.Offset != sent.Offset || got.Size != sent.Size || !bytes.Equal(got.Data, sent.Data) {
+				t.Errorf("Read() = %+v, expected %+v", got, sent)
+			}
+		})
+	}
+}
+
+func TestWireChecksumMismatch(t *testing.T) {
+	// corrupt the header and the data of a message
+	for _, index := range []int{6, 30} {
+		buf := &bytes.Buffer{}
+		w := newBufferWire(buf)
+		if err := w.Write(testMessage(MagicVersion)); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+		buf.Bytes()[index] ^= 0xff
+
+		if _, err := w.Read(); err != ErrChecksumMismatch {
+			t.Errorf("Read() of message corrupted at %d returned %v, expected %v", index, err, ErrChecksumMismatch)
+		}
+	}
+}
+
+func TestWireCorruptedLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newBufferWire(buf)
+	if err := w.Write(testMessage(MagicVersion)); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	// the high byte of the length, which isn't allocated
+	buf.Bytes()[29] ^= 0xff
+	if _, err := w.Read(); err == nil || err == ErrChecksumMismatch {
+		t.Errorf("Read() of a message with a corrupted length returned %v", err)
+	}
+}
+
+func TestWireUnsupportedVersion(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newBufferWire(buf)
+	if err := w.Write(testMessage(0x1b02)); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if _, err := w.Read(); err == nil {
+		t.Errorf("Read() of an unsupported version should fail")
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		peer uint16
+		want uint16
+	}{
+		{0, MagicVersionNoChecksum},
+		{MagicVersionNoChecksum, MagicVersionNoChecksum},
+		{MagicVersion, MagicVersion},
+		{MagicVersion + 1, MagicVersion},
+	}
+	for _, tt := range tests {
+		if got := NegotiateVersion(tt.peer); got != tt.want {
+			t.Errorf("NegotiateVersion(0x%x) = 0x%x, expected 0x%x", tt.peer, got, tt.want)
+		}
+	}
+}
+
+// memData is an in memory types.DataProcessor
+type memData struct {
+	sync.Mutex
+	data []byte
+}
+
+func (m *memData) ReadAt(buf []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(buf, m.data[off:]), nil
+}
+
+func (m *memData) WriteAt(buf []byte, off int64) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return copy(m.data[off:], buf), nil
+}
+
+func (m *memData) Close() error                            { return nil }
+func (m *memData) Sync() (int, error)                      { return 0, nil }
+func (m *memData) Unmap(off int64, len int64) (int, error) { return 0, nil }
+func (m *memData) PingResponse() error                     { return nil }
+
+// corruptingConn flips the last data byte of the frames it writes once
+// corrupt is set.
+type corruptingConn struct {
+	net.Conn
+	sync.Mutex
+	corrupt bool
+}
+
+func (c *corruptingConn) Write(b []byte) (int, error) {
+	c.Lock()
+	if c.corrupt && len(b) > 4 {
+		b = append([]byte(nil), b...)
+		b[len(b)-5] ^= 0xff
+	}
+	c.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestClientServer(t *testing.T) {
+	for _, version := range []uint16{MagicVersion, MagicVersionNoChecksum} {
+		clientConn, serverConn := net.Pipe()
+		conn := &corruptingConn{Conn: clientConn}
+		data := &memData{data: make([]byte, 4096)}
+		go NewServer(serverConn, data).Handle()
+		client := NewClient(conn, make(chan struct{}, 5), version)
+
+		buf := []byte("jivadata")
+		if _, err := client.WriteAt(buf, 512); err != nil {
+			t.Fatalf("WriteAt() with version 0x%x failed: %v", version, err)
+		}
+		readBuf := make([]byte, len(buf))
+		if _, err := client.ReadAt(readBuf, 512); err != nil {
+			t.Fatalf("ReadAt() with version 0x%x failed: %v", version, err)
+		}
+		if !bytes.Equal(readBuf, buf) {
+			t.Errorf("ReadAt() with version 0x%x = %q, expected %q", version, readBuf, buf)
+		}
+
+		if version != MagicVersion {
+			clientConn.Close()
+			continue
+		}
+		conn.Lock()
+		conn.corrupt = true
+		conn.Unlock()
+		if _, err := client.WriteAt([]byte("baddata!"), 0); err == nil {
+			t.Errorf("WriteAt() of a corrupted message should fail")
+		}
+		if !bytes.Equal(data.data[:8], make([]byte, 8)) {
+			t.Errorf("corrupted write has been applied: %q", data.data[:8])
+		}
+		clientConn.Close()
+	}
+}
+
+// fencedData is a memData owned by the controller with the last epoch
+type fencedData struct {
+	memData
+	epoch int64
+}
+
+func (f *fencedData) Claim(epoch int64, id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if epoch < f.epoch {
+		return errors.New("stale epoch")
+	}
+	f.epoch = epoch
+	return nil
+}
+
+func (f *fencedData) CheckEpoch(epoch int64, id string) error {
+	f.Lock()
+	defer f.Unlock()
+	if epoch < f.epoch {
+		return errors.New("stale epoch")
+	}
+	return nil
+}
+
+func TestHandshake(t *testing.T) {
+	data := &fencedData{memData: memData{data: make([]byte, 4096)}}
+	connect := func() *Client {
+		clientConn, serverConn := net.Pipe()
+		go NewServer(serverConn, data).Handle()
+		return NewClient(clientConn, make(chan struct{}, 5), MagicVersion)
+	}
+
+	old := connect()
+	if err := old.Handshake(1, "old"); err != nil {
+		t.Fatalf("Handshake() with epoch 1 failed: %v", err)
+	}
+	if _, err := old.WriteAt([]byte("old"), 0); err != nil {
+		t.Fatalf("WriteAt() with epoch 1 failed: %v", err)
+	}
+
+	replacement := connect()
+	if err := replacement.Handshake(2, "new"); err != nil {
+		t.Fatalf("Handshake() with epoch 2 failed: %v", err)
+	}
+	if _, err := old.WriteAt([]byte("old"), 0); err == nil {
+		t.Errorf("WriteAt() with a stale epoch should fail")
+	}
+	if err := old.Handshake(1, "old"); err == nil {
+		t.Errorf("Handshake() with a stale epoch should fail")
+	}
+	if _, err := replacement.WriteAt([]byte("new"), 0); err != nil {
+		t.Errorf("WriteAt() with epoch 2 failed: %v", err)
+	}
+}
+
+// corruptedData is a memData whose reads find corrupted blocks
+type corruptedData struct {
+	memData
+}
+
+func (d *corruptedData) ReadAt(buf []byte, off int64) (int, error) {
+	return 0, &types.ChecksumError{Msg: "Checksum mismatch in volume-head-001.img at offset 0"}
+}
+
+func TestChecksumErrorReply(t *testing.T) {
+	for _, version := range []uint16{MagicVersion, MagicVersionNoChecksum} {
+		clientConn, serverConn := net.Pipe()
+		go NewServer(serverConn, &corruptedData{}).Handle()
+		client := NewClient(clientConn, make(chan struct{}, 5), version)
+
+		_, err := client.ReadAt(make([]byte, 8), 0)
+		if err == nil {
+			t.Fatalf("ReadAt() of corrupted data with version 0x%x should fail", version)
+		}
+		// the clients of the older version only get the message
+		if types.IsChecksumError(err) != (version == MagicVersion) {
+			t.Errorf("ReadAt() with version 0x%x returned %T: %v", version, err, err)
+		}
+		clientConn.Close()
+	}
+}
@@ -32,7 +32,9 @@ import (
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/gorilla/mux"
+	"github.com/openebs/jiva/replica"
 	"github.com/openebs/jiva/types"
+	"github.com/openebs/jiva/util"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
 	"github.com/sirupsen/logrus"
@@ -195,6 +197,12 @@ func (s *Server) launchFold(p *Process) error {
 		return err
 	}
 
+	if err := replica.FoldChecksums(p.SrcFile, p.DestFile); err != nil {
+		logrus.Errorf("Error folding checksums of %v into %v: %v", p.SrcFile, p.DestFile, err)
+		p.ExitCode = 1
+		return err
+	}
+
 	p.ExitCode = 0
 	logrus.Infof("Done running %s %v", "sfold", cmd.Args)
 	return nil
@@ -208,6 +216,13 @@ func binName() (string, error) {
 }
 
 func (s *Server) launchSync(p *Process) error {
+	if util.TLSEnabled() {
+		if p.SrcFile == "" {
+			return s.receiveFileTLS(p)
+		}
+		return s.sendFileTLS(p)
+	}
+
 	args := []string{"ssync"}
 	if p.Host != "" {
 		args = append(args, "-host", p.Host)
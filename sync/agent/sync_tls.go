@@ -0,0 +1,226 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/openebs/jiva/util"
+	"github.com/sirupsen/logrus"
+)
+
+// ssync only speaks plain HTTP, so when TLS is enabled the files are sent
+// by the sync agents themselves over a TLS connection. The body of the
+// request is the size of the file followed by its data extents, each as
+// its offset, its length and its data. The extents are written at the
+// same offsets of an emptied file, so that the holes of the source, which
+// let the reads fall through to the parent snapshot, are kept.
+const (
+	syncFilePath = "/v1-sync/file"
+	// syncConnectTimeout is the time given to the receiver to listen,
+	// the same as the one given to the ssync client.
+	syncConnectTimeout = 7 * time.Second
+	syncBufferSize     = 1 << 20
+
+	seekData = 3
+	seekHole = 4
+)
+
+// receiveFileTLS serves a single sync of p.DestFile on p.Port
+func (s *Server) receiveFileTLS(p *Process) error {
+	done := make(chan error, 1)
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", p.Port),
+		TLSConfig: util.ServerTLSConfig(),
+	}
+	srv.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != syncFilePath {
+			http.NotFound(rw, req)
+			return
+		}
+		err := receiveFile(p.DestFile, req.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+		}
+		select {
+		case done <- err:
+		default:
+		}
+	})
+	go func() {
+		if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			select {
+			case done <- err:
+			default:
+			}
+		}
+	}()
+
+	logrus.Infof("Receiving %v over TLS on port %v", p.DestFile, p.Port)
+	err := <-done
+	_ = srv.Shutdown(context.Background())
+	if err != nil {
+		logrus.Errorf("Error receiving %v on port %v: %v", p.DestFile, p.Port, err)
+		p.ExitCode = 1
+		return err
+	}
+	p.ExitCode = 0
+	logrus.Infof("Done receiving %v on port %v", p.DestFile, p.Port)
+	return nil
+}
+
+func receiveFile(path string, body io.Reader) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(body, syncBufferSize)
+	var size int64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return fmt.Errorf("Failed to read the size of %v, error: %v", path, err)
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syncBufferSize)
+	for {
+		var extent [2]int64
+		if err := binary.Read(r, binary.LittleEndian, &extent); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Failed to read extent of %v, error: %v", path, err)
+		}
+		offset, length := extent[0], extent[1]
+		if offset < 0 || length < 0 || offset+length > size {
+			return fmt.Errorf("Invalid extent [%v, %v) of %v of size %v", offset, offset+length, path, size)
+		}
+		for length > 0 {
+			n := int64(len(buf))
+			if length < n {
+				n = length
+			}
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return fmt.Errorf("Failed to read data of %v, error: %v", path, err)
+			}
+			if _, err := f.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+			offset += n
+			length -= n
+		}
+	}
+	return f.Sync()
+}
+
+// sendFileTLS sends p.SrcFile to the receiver at p.Host:p.Port
+func (s *Server) sendFileTLS(p *Process) error {
+	address := fmt.Sprintf("%s:%d", p.Host, p.Port)
+	logrus.Infof("Sending %v to %v over TLS", p.SrcFile, address)
+	if err := sendFile(p.SrcFile, address); err != nil {
+		logrus.Errorf("Error sending %v to %v: %v", p.SrcFile, address, err)
+		p.ExitCode = 1
+		return err
+	}
+	p.ExitCode = 0
+	logrus.Infof("Done sending %v to %v", p.SrcFile, address)
+	return nil
+}
+
+func sendFile(path, address string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the receiver is launched just before, wait for it to listen
+	deadline := time.Now().Add(syncConnectTimeout)
+	for {
+		conn, err := tls.Dial("tcp", address, util.ClientTLSConfig())
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeExtents(f, pw))
+	}()
+	resp, err := util.NewHTTPClient(0).Post(util.HTTPScheme()+"://"+address+syncFilePath, "application/octet-stream", pr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Bad response: %d %s: %s", resp.StatusCode, resp.Status, content)
+	}
+	return nil
+}
+
+// writeExtents writes the size of f and its data extents to w
+func writeExtents(f *os.File, w io.Writer) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	bw := bufio.NewWriterSize(w, syncBufferSize)
+	if err := binary.Write(bw, binary.LittleEndian, size); err != nil {
+		return err
+	}
+
+	for offset := int64(0); offset < size; {
+		start, err := f.Seek(offset, seekData)
+		if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENXIO {
+			// no data after offset
+			break
+		} else if err != nil {
+			return err
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return err
+		}
+		if err := binary.Write(bw, binary.LittleEndian, [2]int64{start, end - start}); err != nil {
+			return err
+		}
+		if _, err := io.Copy(bw, io.NewSectionReader(f, start, end-start)); err != nil {
+			return err
+		}
+		offset = end
+	}
+	return bw.Flush()
+}
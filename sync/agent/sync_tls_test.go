@@ -0,0 +1,91 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSendExtents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := os.Create(path.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	size := int64(8 << 20)
+	if err := src.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	// a zeroed block is data which hides the parent, unlike a hole
+	data := bytes.Repeat([]byte{1}, 4096)
+	if _, err := src.WriteAt(data, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.WriteAt(make([]byte, 4096), 4<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := path.Join(dir, "dest")
+	// the receiver replaces a partially synced file
+	if err := ioutil.WriteFile(dest, bytes.Repeat([]byte{2}, 16384), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeExtents(src, pw))
+	}()
+	if err := receiveFile(dest, pr); err != nil {
+		t.Fatalf("receiveFile() failed: %v", err)
+	}
+
+	expected, err := ioutil.ReadFile(src.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("received file differs from the sent one")
+	}
+
+	d, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for _, offset := range []int64{1 << 20, 4 << 20} {
+		if start, err := d.Seek(offset, seekData); err != nil || start != offset {
+			t.Errorf("offset %d of the received file isn't data: %v, %v", offset, start, err)
+		}
+	}
+	if start, err := d.Seek(0, seekData); err != nil || start != 1<<20 {
+		t.Errorf("received file has data before the first extent: %v, %v", start, err)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sync
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	cleanerDeleted = "deleted"
+	cleanerFailed  = "failed"
+	cleanerSkipped = "skipped"
+)
+
+// snapshotCleanerRuns counts the runs of the internal snapshot cleaner by
+// result: a snapshot deleted, a deletion failed, or nothing to delete.
+var snapshotCleanerRuns = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "openebs_jiva_snapshot_cleaner_runs_total",
+		Help: "Number of the runs of the internal snapshot cleaner by result, deleted, failed or skipped.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(snapshotCleanerRuns)
+}
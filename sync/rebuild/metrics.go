@@ -0,0 +1,72 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rebuild
+
+import (
+	"path"
+
+	"github.com/openebs/jiva/replica"
+	"github.com/openebs/jiva/types"
+	"github.com/openebs/jiva/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	snapshotsDesc = prometheus.NewDesc("openebs_jiva_replica_rebuild_snapshots",
+		"Number of the snapshots of the last rebuild of the replica by status, Pending, InProgress or Completed.",
+		[]string{"status"}, nil)
+	syncedBytesDesc = prometheus.NewDesc("openebs_jiva_replica_rebuild_synced_bytes",
+		"Bytes of the snapshots synced to the replica by the last rebuild.", nil, nil)
+)
+
+// collector exports the progress of the rebuild of the replica, nothing
+// is exported until a rebuild starts.
+type collector struct{}
+
+func init() {
+	prometheus.MustRegister(collector{})
+}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotsDesc
+	ch <- syncedBytesDesc
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	info := Info
+	if info == nil {
+		return
+	}
+	counts := map[string]int{
+		types.RebuildPending:    0,
+		types.RebuildInProgress: 0,
+		types.RebuildCompleted:  0,
+	}
+	var synced int64
+	for _, snap := range info.Snapshots {
+		if _, ok := counts[snap.Status]; ok {
+			counts[snap.Status]++
+		}
+		if size := util.GetFileActualSize(path.Join(replica.Dir, snap.Name)); size > 0 {
+			synced += size
+		}
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(snapshotsDesc, prometheus.GaugeValue, float64(n), status)
+	}
+	ch <- prometheus.MustNewConstMetric(syncedBytesDesc, prometheus.GaugeValue, float64(synced))
+}
@@ -20,7 +20,9 @@
 package sync
 
 import (
+	"bytes"
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -40,6 +42,9 @@ import (
 
 const (
 	SnapshotDeletionInterval = 60 * time.Second
+	// deltaSyncChunkSize is the size of the data copied at once while
+	// syncing the regions written while a replica was degraded.
+	deltaSyncChunkSize = 4 << 20
 )
 
 var (
@@ -116,7 +121,7 @@ Register:
 		replicaType := "quorum"
 		upTime := time.Since(Replica.ReplicaStartTime)
 		state, _ := server.PrevStatus()
-		_ = t.client.Register(parts[0], revisionCount, replicaType, upTime, string(state))
+		_ = t.client.Register(parts[0], revisionCount, replicaType, upTime, string(state), 0, 0)
 		select {
 		case <-ticker.C:
 			goto Register
@@ -237,7 +242,7 @@ func (t *Task) AddReplica(replicaAddress string, s *replica.Server) error {
 	}
 	types.ShouldPunchHoles = false
 	logrus.Infof("Addreplica %v", replicaAddress)
-	repClient, err := replicaClient.NewReplicaClient(replicaAddress)
+	repClient, err := t.newReplicaClient(replicaAddress)
 	if err != nil {
 		return err
 	}
@@ -265,8 +270,10 @@ Register:
 		replicaType := "Backend"
 		upTime := time.Since(Replica.ReplicaStartTime)
 		state, _ := server.PrevStatus()
+		// the metadata doesn't exist until the replica is created
+		info, _ := replica.ReadInfo(replica.Dir)
 		logrus.Infof("Register replica at controller")
-		err := t.client.Register(parts[0], revisionCount, replicaType, upTime, string(state))
+		err := t.client.Register(parts[0], revisionCount, replicaType, upTime, string(state), info.ReplicationFactor, s.Epoch().Epoch)
 		if err != nil {
 			logrus.Errorf("Error in sending register command, error: %s", err)
 		}
@@ -331,10 +338,16 @@ Register:
 	}
 
 	if !ok {
-		logrus.Infof("syncFiles from:%v to:%v", fromClient, toClient)
-		if err = t.syncFiles(fromClient, toClient, chain); err != nil {
+		synced, err := t.syncDirtyRegions(replicaAddress, fromClient, toClient)
+		if err != nil {
 			return err
 		}
+		if !synced {
+			logrus.Infof("syncFiles from:%v to:%v", fromClient, toClient)
+			if err = t.syncFiles(fromClient, toClient, chain); err != nil {
+				return err
+			}
+		}
 	}
 
 	logrus.Infof("reloadAndVerify %v", replicaAddress)
@@ -378,18 +391,8 @@ func (t *Task) isRevisionCountAndChainSame(fromClient, toClient *replicaClient.R
 	// If indxOf(WOCheckpoint) < indxOf(RWCheckpoint), all the snapshots are verified from WOCheckpoint till latest snapshot,
 	// this could have been avoided but to be on the safer side, it is done (also required in case of replica replacement)
 	if curReplica.Checkpoint != "" {
-		for indx, snapshot := range curReplica.Chain {
-			if snapshot == curReplica.Checkpoint {
-				curReplica.Chain = curReplica.Chain[:indx]
-				break
-			}
-		}
-		for indx, snapshot := range rwReplica.Chain {
-			if snapshot == curReplica.Checkpoint {
-				rwReplica.Chain = rwReplica.Chain[:indx]
-				break
-			}
-		}
+		curReplica.Chain = chainAfterCheckpoint(curReplica.Chain, curReplica.Checkpoint)
+		rwReplica.Chain = chainAfterCheckpoint(rwReplica.Chain, curReplica.Checkpoint)
 		logrus.Infof(
 			"Comparable chains, CurReplica: %v RWReplica: %v Checkpoint: %v",
 			curReplica.Chain, rwReplica.Chain, curReplica.Checkpoint,
@@ -407,6 +410,126 @@ func (t *Task) isRevisionCountAndChainSame(fromClient, toClient *replicaClient.R
 	return false, rwReplica.Chain[1:], nil
 }
 
+// chainAfterCheckpoint returns the disks of the chain newer than the
+// checkpoint.
+func chainAfterCheckpoint(chain []string, checkpoint string) []string {
+	for indx, snapshot := range chain {
+		if snapshot == checkpoint {
+			return chain[:indx]
+		}
+	}
+	return chain
+}
+
+// syncDirtyRegions copies the regions written while the replica was
+// degraded from the snapshots of the RW replica, instead of the whole
+// snapshots. It returns false if the replica needs a full sync, which is
+// the case if the controller doesn't know these regions, e.g. it has been
+// restarted, or if the replica missed more than these writes.
+func (t *Task) syncDirtyRegions(address string, fromClient, toClient *replicaClient.ReplicaClient) (bool, error) {
+	dirty, err := t.client.DirtyRegions(rest.EncodeID(address))
+	if err != nil {
+		logrus.Warningf("Failed to get the regions written while %v was degraded, error: %v", address, err)
+		return false, nil
+	}
+
+	rwReplica, err := fromClient.GetReplica()
+	if err != nil {
+		return false, err
+	}
+	curReplica, err := toClient.GetReplica()
+	if err != nil {
+		return false, err
+	}
+	curCounter, err := strconv.ParseInt(curReplica.RevisionCounter, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	rwCounter, err := strconv.ParseInt(rwReplica.RevisionCounter, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	if curCounter+dirty.Writes < rwCounter {
+		logrus.Warningf("Replica %v missed more writes than tracked, RevisionCount: %v, RW replica: %v, tracked writes: %v",
+			address, curReplica.RevisionCounter, rwReplica.RevisionCounter, dirty.Writes)
+		return false, nil
+	}
+	// snapshots taken while the replica was degraded aren't in its chain
+	rwChain := chainAfterCheckpoint(rwReplica.Chain, curReplica.Checkpoint)
+	curChain := chainAfterCheckpoint(curReplica.Chain, curReplica.Checkpoint)
+	if len(rwChain) == 0 || !reflect.DeepEqual(rwChain[1:], curChain[1:]) {
+		logrus.Warningf("Replica %v's chain %v not equal to RW replica's chain %v", address, curChain, rwChain)
+		return false, nil
+	}
+
+	disks := rwChain[1:]
+	// the checkpoint is the same on both replicas, it is the layer the
+	// regions of the oldest snapshot are compared to
+	base := ""
+	if len(rwChain) < len(rwReplica.Chain) {
+		base = curReplica.Checkpoint
+	}
+	var size int64
+	for _, region := range dirty.Regions {
+		size += region.Length
+	}
+	logrus.Infof("Syncing %d regions, %d bytes, of snapshots %v written while %v was degraded",
+		len(dirty.Regions), size, disks, address)
+	if err := t.initalizeSyncProgress(fromClient, toClient, disks); err != nil {
+		return false, err
+	}
+	for _, disk := range disks {
+		rebuild.SetStatus(disk, types.RebuildInProgress)
+	}
+	for _, region := range dirty.Regions {
+		if err := copyRegion(fromClient, toClient, base, disks, region); err != nil {
+			logrus.Warningf("Failed to sync the regions of %v written while %v was degraded, error: %v",
+				disks, address, err)
+			return false, nil
+		}
+	}
+	for _, disk := range disks {
+		rebuild.SetStatus(disk, types.RebuildCompleted)
+	}
+	return true, nil
+}
+
+// copyRegion copies each chunk of the region into the snapshots whose
+// data differs from the snapshot below, i.e. the snapshots in which the
+// chunk was written, rather than into all of them. disks are sorted from
+// the newest, base is the snapshot below the oldest one, or empty if the
+// data below isn't known.
+func copyRegion(fromClient, toClient *replicaClient.ReplicaClient, base string, disks []string, region types.Region) error {
+	for off := region.Offset; off < region.Offset+region.Length; off += deltaSyncChunkSize {
+		length := region.Offset + region.Length - off
+		if length > deltaSyncChunkSize {
+			length = deltaSyncChunkSize
+		}
+		var below []byte
+		if base != "" {
+			data, err := fromClient.ReadSnapshot(base, off, length)
+			if err != nil {
+				return err
+			}
+			below = data
+		}
+		for i := len(disks) - 1; i >= 0; i-- {
+			data, err := fromClient.ReadSnapshot(disks[i], off, length)
+			if err != nil {
+				return err
+			}
+			if below != nil && bytes.Equal(data, below) {
+				continue
+			}
+			if err := toClient.WriteSnapshot(disks[i], off, data); err != nil {
+				return err
+			}
+			below = data
+		}
+	}
+	return nil
+}
+
 // checkAndResetFailedRebuild set the rebuilding to false if
 // it is true.This is required since volume.meta files
 // may not be updated with it's correct rebuilding state.
@@ -564,6 +687,10 @@ func (t *Task) syncFiles(fromClient, toClient *replicaClient.ReplicaClient, disk
 		if err := t.syncFile(disk, "", fromClient, toClient); err != nil {
 			return err
 		}
+		// the checksums of the received data are computed locally
+		if err := replica.BuildChecksums(filepath.Join(replica.Dir, disk)); err != nil {
+			return err
+		}
 		if err := t.syncFile(disk+".meta", "", fromClient, toClient); err != nil {
 			return err
 		}
@@ -601,7 +728,7 @@ func (t *Task) getTransferClients(address string) (*replicaClient.ReplicaClient,
 	}
 	logrus.Infof("Using replica %s as the source for rebuild ", from.Address)
 
-	fromClient, err := replicaClient.NewReplicaClient(from.Address)
+	fromClient, err := t.newReplicaClient(from.Address)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -612,7 +739,7 @@ func (t *Task) getTransferClients(address string) (*replicaClient.ReplicaClient,
 	}
 	logrus.Infof("Using replica %s as the target for rebuild ", to.Address)
 
-	toClient, err := replicaClient.NewReplicaClient(to.Address)
+	toClient, err := t.newReplicaClient(to.Address)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -620,6 +747,24 @@ func (t *Task) getTransferClients(address string) (*replicaClient.ReplicaClient,
 	return fromClient, toClient, nil
 }
 
+// newReplicaClient returns a client of a replica of the volume sending the
+// epoch of the controller with its requests, the replica rejects the ones
+// without epoch once the controller has claimed it.
+func (t *Task) newReplicaClient(address string) (*replicaClient.ReplicaClient, error) {
+	repClient, err := replicaClient.NewReplicaClient(address)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := t.client.GetVolume()
+	if err != nil {
+		return nil, err
+	}
+	if volume.ControllerID != "" {
+		repClient.SetEpoch(volume.Epoch, volume.ControllerID)
+	}
+	return repClient, nil
+}
+
 func (t *Task) getFromReplica() (rest.Replica, error) {
 	replicas, err := t.client.ListReplicas()
 	if err != nil {
@@ -668,6 +813,7 @@ func (t *Task) InternalSnapshotCleaner(s *replica.Server, repClient *replicaClie
 		}
 		snapshot, err := t.client.GetCheckpoint()
 		if err != nil || snapshot == "" {
+			snapshotCleanerRuns.WithLabelValues(cleanerSkipped).Inc()
 			continue
 		}
 		if snapshot != s.Replica().Info().Checkpoint {
@@ -680,20 +826,24 @@ func (t *Task) InternalSnapshotCleaner(s *replica.Server, repClient *replicaClie
 			if contMismatchCount == 3 {
 				logrus.Fatalf("Checkpoint mismatched 3 times continuously")
 			}
+			snapshotCleanerRuns.WithLabelValues(cleanerSkipped).Inc()
 			continue
 		}
 		contMismatchCount = 0
 		sortedSnapshotList, _ := GetDeleteCandidateChain(s.Replica(), snapshot)
 		if len(sortedSnapshotList) < SnapshotRetentionCount {
+			snapshotCleanerRuns.WithLabelValues(cleanerSkipped).Inc()
 			continue
 		}
 		if sortedSnapshotList[0] == "" {
 			logrus.Errorf("Empty snapshot name received in sortedSnapshotList")
+			snapshotCleanerRuns.WithLabelValues(cleanerFailed).Inc()
 			continue
 		}
 		ops, err := s.PrepareRemoveDisk(sortedSnapshotList[0])
 		if err != nil {
 			logrus.Errorf("PrepareRemoveDisk failed, err: %v", err)
+			snapshotCleanerRuns.WithLabelValues(cleanerFailed).Inc()
 			continue
 		}
 		for _, op := range ops {
@@ -714,6 +864,11 @@ func (t *Task) InternalSnapshotCleaner(s *replica.Server, repClient *replicaClie
 				break
 			}
 		}
+		if err != nil {
+			snapshotCleanerRuns.WithLabelValues(cleanerFailed).Inc()
+		} else {
+			snapshotCleanerRuns.WithLabelValues(cleanerDeleted).Inc()
+		}
 	}
 }
 
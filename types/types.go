@@ -37,6 +37,9 @@ const (
 	RebuildPending           = "Pending"
 	RebuildInProgress        = "InProgress"
 	RebuildCompleted         = "Completed"
+	ScrubInProgress          = "InProgress"
+	ScrubCompleted           = "Completed"
+	ScrubFailed              = "Failed"
 	SyncHTTPClientTimeoutKey = "SYNC_HTTP_CLIENT_TIMEOUT"
 )
 
@@ -105,6 +108,9 @@ type Backend interface {
 	GetVolUsage() (VolUsage, error)
 	SetReplicaMode(mode Mode) error
 	SetRevisionCounter(counter int64) error
+	SetReplicationFactor(rf int) error
+	GetEpoch() (int64, error)
+	Claim(epoch int64, id string) error
 	SetRebuilding(rebuilding bool) error
 	GetMonitorChannel() MonitorChannel
 	StopMonitoring()
@@ -175,6 +181,114 @@ type SyncInfo struct {
 	WOSnapshotsTotalSize string `json:"woreplicatotalsize,omitempty"`
 }
 
+// ScrubStatus holds the progress and the result of the last scrub of
+// a replica.
+type ScrubStatus struct {
+	// State is empty if no scrub has been started
+	State     string `json:"state,omitempty"`
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	// CheckedBlocks is the number of blocks verified against their
+	// checksums and UncheckedBlocks the number of blocks with no
+	// known checksum.
+	CheckedBlocks   int64 `json:"checkedBlocks"`
+	UncheckedBlocks int64 `json:"uncheckedBlocks"`
+	// MismatchedBlocks is the number of corrupted blocks found, only
+	// the first of them are listed in Mismatches.
+	MismatchedBlocks int64           `json:"mismatchedBlocks"`
+	Mismatches       []ScrubMismatch `json:"mismatches,omitempty"`
+	Error            string          `json:"error,omitempty"`
+}
+
+// ScrubMismatch is a block whose data doesn't match its checksum
+type ScrubMismatch struct {
+	Disk   string `json:"disk"`
+	Offset int64  `json:"offset"`
+}
+
+// ChecksumError is returned by the reads of the blocks whose data doesn't
+// match their checksum. It is carried over the RPC by its own message
+// type, so that the controller can repair the blocks from another replica.
+type ChecksumError struct {
+	Msg string
+}
+
+func (e *ChecksumError) Error() string {
+	return e.Msg
+}
+
+// IsChecksumError returns true if err is a ChecksumError
+func IsChecksumError(err error) bool {
+	_, ok := err.(*ChecksumError)
+	return ok
+}
+
+// VerifyResult is the result of the comparison of the data of the RW
+// replicas of a volume.
+type VerifyResult struct {
+	Replicas []string `json:"replicas"`
+	// Snapshots are the snapshots compared, the head is compared last
+	Snapshots     []string        `json:"snapshots"`
+	VerifiedBytes int64           `json:"verifiedBytes"`
+	Diverged      []DivergedRange `json:"diverged,omitempty"`
+}
+
+// DivergedRange is a range of a snapshot, or of the head if Snapshot is
+// empty, whose data isn't the same on all the replicas.
+type DivergedRange struct {
+	Snapshot string `json:"snapshot,omitempty"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	// Replicas are the replicas which don't have the data of the
+	// majority, or all of them if there is no majority.
+	Replicas   []string `json:"replicas"`
+	NoMajority bool     `json:"noMajority,omitempty"`
+	Repaired   bool     `json:"repaired,omitempty"`
+}
+
+// DirtyRegions are the regions of the volume written since a replica left
+// RW mode, Writes is the number of writes done meanwhile.
+type DirtyRegions struct {
+	RegionSize int64    `json:"regionSize"`
+	Writes     int64    `json:"writes"`
+	Regions    []Region `json:"regions"`
+}
+
+// Region is a range of the volume
+type Region struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// EventType is the kind of change of the state of a volume
+type EventType string
+
+const (
+	EventReplicaAdded     = EventType("replica.added")
+	EventReplicaRemoved   = EventType("replica.removed")
+	EventReplicaMode      = EventType("replica.mode")
+	EventVolumeMode       = EventType("volume.mode")
+	EventCheckpoint       = EventType("checkpoint")
+	EventRebuildStarted   = EventType("rebuild.started")
+	EventRebuildCompleted = EventType("rebuild.completed")
+	// EventReplicaDegraded is sent when a replica is removed from the
+	// read set for being slower than its peers, and EventReplicaReinstated
+	// once it is read from again.
+	EventReplicaDegraded   = EventType("replica.degraded")
+	EventReplicaReinstated = EventType("replica.reinstated")
+)
+
+// Event is a change of the state of a volume. Mode is the one of the
+// replica, or RO or RW for the volume.
+type Event struct {
+	ID         int64     `json:"id"`
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	Replica    string    `json:"replica,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	Checkpoint string    `json:"checkpoint,omitempty"`
+}
+
 type ReplicaInfo struct {
 	Dirty             bool                `json:"dirty"`
 	Rebuilding        bool                `json:"rebuilding"`
@@ -192,6 +306,12 @@ type ReplicaInfo struct {
 	UsedBlocks        string              `json:"usedblocks"`
 	CloneStatus       string              `json:"clonestatus"`
 	Checkpoint        string              `json:"checkpoint"`
+	ReplicationFactor int                 `json:"replicationFactor"`
+	// Epoch is the one of the controller owning the replica
+	Epoch int64 `json:"epoch"`
+	// RPCVersion is the latest data protocol supported by the replica,
+	// it isn't set by replicas which only support rpc.MagicVersionNoChecksum
+	RPCVersion uint16 `json:"rpcVersion,omitempty"`
 }
 
 type Replica struct {
@@ -205,6 +325,11 @@ type RegReplica struct {
 	RevCount int64
 	RepType  string
 	RepState string
+	// ReplicationFactor is the replication factor persisted by the
+	// replica, 0 if it was never set.
+	ReplicationFactor int
+	// Epoch is the one of the controller which claimed the replica last
+	Epoch int64
 }
 
 type IOStats struct {
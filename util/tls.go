@@ -0,0 +1,179 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// TLSFiles are the paths of the CA certificate used to authenticate the
+// peers and of the certificate and key presented to them.
+type TLSFiles struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+var (
+	tlsServerConfig *tls.Config
+	tlsClientConfig *tls.Config
+)
+
+// SetupTLS enables mutual TLS on the controller endpoint, on the replica
+// endpoints (control, data, sync agent and file sync) and on the clients
+// connecting to them. It is a no-op if none of the files is given.
+//
+// Peers are authenticated by the CA only, their certificate isn't checked
+// against the address they are reached at since replicas are usually
+// addressed by IPs which aren't known when issuing the certificates.
+// The certificate is used both as server and as client, so it must allow
+// both usages if it restricts them.
+func SetupTLS(files TLSFiles) error {
+	if files.CAFile == "" && files.CertFile == "" && files.KeyFile == "" {
+		return nil
+	}
+	if files.CAFile == "" || files.CertFile == "" || files.KeyFile == "" {
+		return fmt.Errorf("CA, certificate and key files are all required to enable TLS")
+	}
+
+	ca, err := ioutil.ReadFile(files.CAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return fmt.Errorf("No valid certificate found in CA file %v", files.CAFile)
+	}
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	tlsServerConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	tlsClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// The default verification also checks the host name,
+		// the chain is verified by verifyServerCertificate instead.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerCertificate(pool, rawCerts)
+		},
+	}
+	return nil
+}
+
+func verifyServerCertificate(roots *x509.CertPool, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("No certificate presented by the server")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// TLSEnabled returns true if SetupTLS has been called with certificates
+func TLSEnabled() bool {
+	return tlsServerConfig != nil
+}
+
+// ServerTLSConfig returns the configuration of the controller and replica
+// endpoints, nil if TLS isn't enabled.
+func ServerTLSConfig() *tls.Config {
+	return tlsServerConfig
+}
+
+// ClientTLSConfig returns the configuration of the connections to the
+// controller and the replicas, nil if TLS isn't enabled.
+func ClientTLSConfig() *tls.Config {
+	return tlsClientConfig
+}
+
+// HTTPScheme returns the scheme of the URLs of the controller and replica
+// endpoints
+func HTTPScheme() string {
+	if TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+// NewHTTPClient returns a client for the controller and replica endpoints
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+	if TLSEnabled() {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsClientConfig,
+		}
+	}
+	return client
+}
+
+// ControllerURL returns the URL of the controller endpoint at the given
+// IP.
+func ControllerURL(ip string) string {
+	return HTTPScheme() + "://" + ip + ":9501"
+}
+
+// ListenAndServeHTTP serves the controller or replica endpoint, over TLS
+// if enabled
+func ListenAndServeHTTP(address string, handler http.Handler) error {
+	if !TLSEnabled() {
+		return http.ListenAndServe(address, handler)
+	}
+	server := &http.Server{
+		Addr: address,
+		// The links of the API resources are built with http
+		// unless the request is forwarded from https.
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			req.Header.Set("X-Forwarded-Proto", "https")
+			handler.ServeHTTP(rw, req)
+		}),
+		TLSConfig: tlsServerConfig,
+	}
+	return server.ListenAndServeTLS("", "")
+}
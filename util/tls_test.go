@@ -0,0 +1,159 @@
+/*
+ Copyright © 2020 The OpenEBS Authors
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate creates a certificate signed by parent, or self signed
+// if parent is nil, and writes it and its key in dir.
+func writeCertificate(t *testing.T, dir, name string, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDer)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetupTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		tlsServerConfig, tlsClientConfig = nil, nil
+	}()
+
+	ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	writeCertificate(t, dir, "replica", ca, caKey)
+	// a certificate which isn't signed by the CA
+	writeCertificate(t, dir, "other", nil, nil)
+
+	files := func(ca, cert string) TLSFiles {
+		return TLSFiles{
+			CAFile:   filepath.Join(dir, ca+".crt"),
+			CertFile: filepath.Join(dir, cert+".crt"),
+			KeyFile:  filepath.Join(dir, cert+".key"),
+		}
+	}
+
+	if err := SetupTLS(TLSFiles{CAFile: files("ca", "replica").CAFile}); err == nil {
+		t.Errorf("SetupTLS() without certificate should fail")
+	}
+	if err := SetupTLS(TLSFiles{}); err != nil || TLSEnabled() {
+		t.Fatalf("SetupTLS() without files should leave TLS disabled, got %v", err)
+	}
+	if err := SetupTLS(files("ca", "replica")); err != nil {
+		t.Fatalf("SetupTLS() failed: %v", err)
+	}
+	if HTTPScheme() != "https" {
+		t.Errorf("HTTPScheme() = %v, expected https", HTTPScheme())
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = ServerTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	// the replica certificate authenticates both sides
+	resp, err := NewHTTPClient(5 * time.Second).Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with a valid certificate failed: %v", err)
+	}
+	resp.Body.Close()
+
+	tests := []struct {
+		name   string
+		config *tls.Config
+	}{
+		{"no client certificate", &tls.Config{InsecureSkipVerify: true}},
+		{"client certificate of another CA", func() *tls.Config {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "other.crt"), filepath.Join(dir, "other.key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}}
+		}()},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tt.config}}
+		if resp, err := client.Get(server.URL); err == nil {
+			resp.Body.Close()
+			t.Errorf("request with %s should be refused", tt.name)
+		}
+	}
+
+	// the client refuses a server which isn't signed by the CA
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+	if resp, err := NewHTTPClient(5 * time.Second).Get(other.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("request to a server of another CA should fail")
+	}
+}